@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// adaptiveResolution serves a lower resolution than requested while the
+	// average encode time exceeds adaptiveLatency.
+	adaptiveResolution bool
+	adaptiveLatency    = 20 * time.Second
+
+	// resolutionLadder lists the output heights to step down through, highest first.
+	resolutionLadder = []int64{1080, 720, 480, 360, 240}
+
+	// clampToSource lowers requested resolutions above the source's own.
+	clampToSource = true
+)
+
+const encodeLatencySamples = 20
+
+// latencyWindow keeps a rolling average over the most recent encode durations.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [encodeLatencySamples]time.Duration
+	count   int
+	next    int
+}
+
+func (l *latencyWindow) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+	if l.count < len(l.samples) {
+		l.count++
+	}
+}
+
+func (l *latencyWindow) average() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range l.samples[:l.count] {
+		total += d
+	}
+	return total / time.Duration(l.count)
+}
+
+// downshift returns the next lower rung of the resolution ladder when the
+// average encode time is above adaptiveLatency, otherwise res itself.
+func downshift(res int64, average time.Duration) int64 {
+	if average <= adaptiveLatency {
+		return res
+	}
+	for _, rung := range resolutionLadder {
+		if rung < res {
+			return rung
+		}
+	}
+	return res
+}
+
+// nativeResolution is the source size in the dimension scaleFilter scales to
+// res: the displayed width of portrait videos, the height of others.
+func nativeResolution(info *videoInfo) int64 {
+	w, h := info.DisplaySize()
+	if info.IsPortrait() {
+		return int64(w)
+	}
+	return int64(h)
+}
+
+// clampResolution lowers res to the source's native resolution, so sources
+// are never upscaled. It reports whether res was changed.
+func clampResolution(res int64, info *videoInfo) (int64, bool) {
+	native := nativeResolution(info) &^ 1 // Encoders need even sizes
+	if native <= 0 || res <= native {
+		return res, false
+	}
+	return native, true
+}
+
+// asyncColdSegments answers requests for uncached segments with 202 and a
+// Retry-After instead of holding them open until the encode finishes.
+var asyncColdSegments bool
+
+// coldRetryAfter is the Retry-After in seconds for an uncached segment, the
+// average encode latency or the segment length before anything was encoded.
+func coldRetryAfter(latency time.Duration) int {
+	if latency <= 0 {
+		return int(hlsSegmentLength)
+	}
+	return int(math.Ceil(latency.Seconds()))
+}
+
+// releaseWhenEncoded calls release once the encode of an answered cold
+// segment request completes, fails or times out, so its slots keep counting
+// against the encode caps while it runs.
+func releaseWhenEncoded(er EncodingRequest, release func()) {
+	select {
+	case <-er.data:
+	case <-er.err:
+	case <-time.After(encodeTimeout(er.res)):
+	}
+	release()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLatencyWindowAverage(t *testing.T) {
+	var l latencyWindow
+	if avg := l.average(); avg != 0 {
+		t.Errorf("empty average %v, want 0", avg)
+	}
+	l.add(2 * time.Second)
+	l.add(4 * time.Second)
+	if avg := l.average(); avg != 3*time.Second {
+		t.Errorf("average %v, want 3s", avg)
+	}
+	for i := 0; i < encodeLatencySamples; i++ {
+		l.add(30 * time.Second)
+	}
+	if avg := l.average(); avg != 30*time.Second {
+		t.Errorf("average %v after the window rolled over, want 30s", avg)
+	}
+}
+
+func TestDownshiftUnderLoad(t *testing.T) {
+	var l latencyWindow
+	for i := 0; i < encodeLatencySamples; i++ {
+		l.add(adaptiveLatency + 5*time.Second)
+	}
+	tests := []struct {
+		res, want int64
+	}{
+		{1080, 720},
+		{720, 480},
+		{600, 480},
+		{240, 240},
+	}
+	for _, test := range tests {
+		if res := downshift(test.res, l.average()); res != test.want {
+			t.Errorf("downshift(%v) = %v, want %v", test.res, res, test.want)
+		}
+	}
+}
+
+func TestDownshiftFastEncodes(t *testing.T) {
+	if res := downshift(720, adaptiveLatency); res != 720 {
+		t.Errorf("downshift at the threshold = %v, want 720", res)
+	}
+	if res := downshift(720, time.Second); res != 720 {
+		t.Errorf("downshift of fast encodes = %v, want 720", res)
+	}
+}
+
+func TestClampResolution(t *testing.T) {
+	tests := []struct {
+		info    videoInfo
+		res     int64
+		want    int64
+		clamped bool
+	}{
+		{videoInfo{Width: 1280, Height: 720}, 1080, 720, true},
+		{videoInfo{Width: 1280, Height: 720}, 720, 720, false},
+		{videoInfo{Width: 1280, Height: 720}, 480, 480, false},
+		{videoInfo{Width: 640, Height: 361}, 480, 360, true},
+		{videoInfo{Width: 720, Height: 1280}, 1080, 720, true},
+		{videoInfo{Width: 1280, Height: 720, Rotation: 90}, 1080, 720, true},
+		{videoInfo{Width: 1920, Height: 1080, Rotation: 90}, 720, 720, false},
+		{videoInfo{}, 1080, 1080, false},
+	}
+	for _, test := range tests {
+		res, clamped := clampResolution(test.res, &test.info)
+		if res != test.want || clamped != test.clamped {
+			t.Errorf("clampResolution(%v, %+v) = %v, %v, want %v, %v", test.res, test.info, res, clamped, test.want, test.clamped)
+		}
+	}
+}
+
+func TestColdRetryAfter(t *testing.T) {
+	if got := coldRetryAfter(0); got != int(hlsSegmentLength) {
+		t.Errorf("retry before any encode = %v, want the segment length %v", got, hlsSegmentLength)
+	}
+	if got := coldRetryAfter(2500 * time.Millisecond); got != 3 {
+		t.Errorf("retry for 2.5s latency = %v, want 3", got)
+	}
+}
+
+func withAsyncCold(t *testing.T, perFile int) {
+	savedAsync, savedPerFile := asyncColdSegments, perFileEncodes
+	asyncColdSegments, perFileEncodes = true, perFile
+	t.Cleanup(func() { asyncColdSegments, perFileEncodes = savedAsync, savedPerFile })
+}
+
+func TestAsyncColdSegment(t *testing.T) {
+	dir := withTestRoot(t)
+	withAsyncCold(t, 1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := encoder
+	requests := make(chan EncodingRequest, 1)
+	e.reqChan = requests
+
+	w := httptest.NewRecorder()
+	hls(w, httptest.NewRequest("GET", "/api/hls/segments/a.mp4/3.ts", nil), segmentParams("a.mp4/3.ts"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("cold segment: status %v, want %v", w.Code, http.StatusAccepted)
+	}
+	if got := w.Header().Get("Retry-After"); got != strconv.Itoa(int(hlsSegmentLength)) {
+		t.Errorf("Retry-After %q, want the segment length", got)
+	}
+
+	r := <-requests
+	// The slot of the background encode is held until it completes.
+	w = httptest.NewRecorder()
+	hls(w, httptest.NewRequest("GET", "/api/hls/segments/a.mp4/4.ts", nil), segmentParams("a.mp4/4.ts"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("second cold segment while encoding: status %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+
+	data := []byte("segment")
+	e.cacheSegment(r, data)
+	e.deliverData(r, &data)
+	if !e.isCached(r) {
+		t.Fatal("background encode did not populate the cache")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !e.inflight.acquire(fileHash(r.file), perFileEncodes) {
+		if time.Now().After(deadline) {
+			t.Fatal("slot not released after the encode completed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	e.inflight.release(fileHash(r.file))
+
+	w = httptest.NewRecorder()
+	hls(w, httptest.NewRequest("GET", "/api/hls/segments/a.mp4/3.ts", nil), segmentParams("a.mp4/3.ts"))
+	if w.Code != http.StatusOK || w.Body.String() != "segment" {
+		t.Errorf("retry: status %v body %q, want the cached segment", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	// adminToken may be sent as "Authorization: Bearer <token>" to use the
+	// admin endpoints.
+	adminToken string
+	// adminUser and adminPassword are the HTTP basic auth credentials of the
+	// admin endpoints.
+	adminUser     string
+	adminPassword string
+)
+
+func init() {
+	secretFlags["admin-token"] = true
+	secretFlags["admin-password"] = true
+}
+
+func validateAdminAuth() error {
+	if (adminUser == "") != (adminPassword == "") {
+		return errors.New("Admin basic auth needs both -admin-user and -admin-password")
+	}
+	return nil
+}
+
+// adminAuthConfigured reports whether any admin credentials are set.
+func adminAuthConfigured() bool {
+	return adminToken != "" || adminPassword != ""
+}
+
+func secretEqual(given string, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// isAdmin reports whether r carries the admin token or basic auth
+// credentials.
+func isAdmin(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		return secretEqual(user, adminUser) && secretEqual(password, adminPassword)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return secretEqual(strings.TrimPrefix(auth, "Bearer "), adminToken)
+	}
+	return false
+}
+
+// protectAdmin serves handle only to admins once admin credentials are
+// configured. Without any it serves everyone, as before they existed.
+func protectAdmin(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if adminAuthConfigured() && !isAdmin(r) {
+			w.Header()["Www-Authenticate"] = []string{`Basic realm="agentVideo admin"`}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handle(w, r, params)
+	}
+}
+
+// requireAdmin serves handle only to admins, and not at all while no admin
+// credentials are configured.
+func requireAdmin(handle httprouter.Handle) httprouter.Handle {
+	protected := protectAdmin(handle)
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if !adminAuthConfigured() {
+			http.NotFound(w, r)
+			return
+		}
+		protected(w, r, params)
+	}
+}
+
+// adminRoutes registers the routes of the admin group, all wrapped in
+// protectAdmin. Streaming routes are registered on the router directly and
+// stay open.
+type adminRoutes struct {
+	router *httprouter.Router
+}
+
+func (g adminRoutes) GET(path string, handle httprouter.Handle) {
+	g.router.GET(path, protectAdmin(handle))
+}
+
+func (g adminRoutes) POST(path string, handle httprouter.Handle) {
+	g.router.POST(path, protectAdmin(handle))
+}
+
+func (g adminRoutes) DELETE(path string, handle httprouter.Handle) {
+	g.router.DELETE(path, protectAdmin(handle))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func withAdminBasicAuth(t *testing.T, user, password string) {
+	savedUser, savedPassword := adminUser, adminPassword
+	adminUser, adminPassword = user, password
+	t.Cleanup(func() { adminUser, adminPassword = savedUser, savedPassword })
+}
+
+func TestAdminRoutesRequireCredentials(t *testing.T) {
+	withAdminBasicAuth(t, "admin", "secret")
+	withAdminToken(t, "token")
+	handle := protectAdmin(func(http.ResponseWriter, *http.Request, httprouter.Params) {})
+	serve := func(auth func(*http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/cache/stats", nil)
+		if auth != nil {
+			auth(r)
+		}
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		return w
+	}
+	basic := func(user, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, password) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	w := serve(nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("without credentials: %v %v", w.Code, w.Header())
+	}
+	for i, auth := range []func(*http.Request){basic("admin", "wrong"), basic("other", "secret"), basic("", ""), bearer("wrong")} {
+		if code := serve(auth).Code; code != http.StatusUnauthorized {
+			t.Errorf("wrong credentials %v: %v", i, code)
+		}
+	}
+	for i, auth := range []func(*http.Request){basic("admin", "secret"), bearer("token")} {
+		if code := serve(auth).Code; code != http.StatusOK {
+			t.Errorf("credentials %v: %v", i, code)
+		}
+	}
+
+	// Basic auth alone protects the group as well.
+	adminToken = ""
+	if code := serve(nil).Code; code != http.StatusUnauthorized {
+		t.Errorf("without credentials under basic auth only: %v", code)
+	}
+	if code := serve(bearer("")).Code; code != http.StatusUnauthorized {
+		t.Errorf("empty token under basic auth only: %v", code)
+	}
+	if code := serve(basic("admin", "secret")).Code; code != http.StatusOK {
+		t.Errorf("basic auth under basic auth only: %v", code)
+	}
+}
+
+func TestAdminRoutesOpenWithoutCredentials(t *testing.T) {
+	withAdminBasicAuth(t, "", "")
+	withAdminToken(t, "")
+	w := httptest.NewRecorder()
+	protectAdmin(func(http.ResponseWriter, *http.Request, httprouter.Params) {})(w, httptest.NewRequest("GET", "/api/cache/stats", nil), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin route without configured credentials: %v", w.Code)
+	}
+}
+
+func TestValidateAdminAuth(t *testing.T) {
+	for _, test := range []struct {
+		user, password string
+		valid          bool
+	}{
+		{"", "", true},
+		{"admin", "secret", true},
+		{"admin", "", false},
+		{"", "secret", false},
+	} {
+		withAdminBasicAuth(t, test.user, test.password)
+		if err := validateAdminAuth(); (err == nil) != test.valid {
+			t.Errorf("%q/%q: %v", test.user, test.password, err)
+		}
+	}
+}
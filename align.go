@@ -0,0 +1,27 @@
+package main
+
+// alignAudio pads and shifts the audio of every segment to the segment
+// boundaries, for sources whose audio starts later or ends earlier than the
+// video. Without it such segments start with a gap in the audio track,
+// which some players fill by drifting out of sync. Off by default since the
+// padding inserts silence.
+var alignAudio bool
+
+// alignFilter resamples audio to start at the first video timestamp,
+// inserting silence for missing samples, and pads its end with silence. ""
+// if alignment is off.
+func alignFilter() string {
+	if !alignAudio {
+		return ""
+	}
+	return "aresample=async=1:first_pts=0,apad"
+}
+
+// alignArgs end padded audio with the video. Audio only outputs are bounded
+// by -t alone.
+func alignArgs(video bool) []string {
+	if !alignAudio || !video {
+		return nil
+	}
+	return []string{"-shortest"}
+}
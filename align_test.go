@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func withAlignAudio(t *testing.T, align bool) {
+	old := alignAudio
+	alignAudio = align
+	t.Cleanup(func() { alignAudio = old })
+}
+
+func TestAlignArgs(t *testing.T) {
+	withAlignAudio(t, false)
+	withLoudnessTarget(t, 0)
+	r := NewWarmupEncodingRequest("/media/a.mp4", 1, 480)
+	plain := EncodingArgs(*r, nil)
+	plainKey := r.getCacheKey()
+	if containsArgs(plain, "-af") || containsArgs(plain, "-shortest") {
+		t.Errorf("audio aligned while alignment is off: %v", plain)
+	}
+
+	alignAudio = true
+	args := EncodingArgs(*r, nil)
+	if !containsArgs(args, "-af", "aresample=async=1:first_pts=0,apad", "-shortest") {
+		t.Errorf("alignment args missing: %v", args)
+	}
+	if i, j := argIndex(args, "-af"), argIndex(args, "-acodec"); i < 0 || i > j {
+		t.Errorf("audio filter not before the codec: %v", args)
+	}
+	if len(args) != len(plain)+3 {
+		t.Errorf("alignment added %v arguments, want 3", len(args)-len(plain))
+	}
+	if r.getCacheKey() == plainKey {
+		t.Error("alignment does not change the cache key")
+	}
+
+	// Padded audio only renditions are bounded by -t, not a video stream.
+	r.audioTrack = 0
+	if args := EncodingArgs(*r, nil); !containsArgs(args, "-af", "aresample=async=1:first_pts=0,apad") || containsArgs(args, "-shortest") {
+		t.Errorf("audio only alignment args %v", args)
+	}
+}
+
+func TestAlignWithLoudness(t *testing.T) {
+	withAlignAudio(t, true)
+	withLoudnessTarget(t, -16)
+	want := []string{"-af", "aresample=async=1:first_pts=0,apad,loudnorm=I=-16:TP=-1.5:LRA=11", "-shortest"}
+	if args := audioFilterArgs(true); !reflect.DeepEqual(args, want) {
+		t.Errorf("audio filter args %v, want %v", args, want)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sourceAnalysis is everything the encoder derives from probing a source. A
+// single ffprobe of its streams fills it; the values that need another probe,
+// such as the keyframe interval which needs a scan of the packets, are added
+// the first time they are needed.
+type sourceAnalysis struct {
+	modTime time.Time
+	// media reports whether the source has a video or audio stream.
+	media bool
+	// failed reports that ffprobe could not read the source. Such an
+	// analysis is not cached, the source may still be being written.
+	failed bool
+	// info describes the first video stream, nil if there is none.
+	info *videoInfo
+	// tracks are the audio streams.
+	tracks []audioTrack
+	// keyframes reports whether info.KeyframeInterval has been probed.
+	keyframes bool
+	// duration is the length in seconds, valid if hasDuration.
+	duration    float64
+	hasDuration bool
+	// boundaries are the segment boundaries with variableSegments, chapters
+	// the chapters and validation the result of validateHandler, each nil
+	// until needed.
+	boundaries []float64
+	chapters   []chapter
+	validation *validation
+	// probed is when the analysis was made.
+	probed time.Time
+}
+
+// analysisCacheSize bounds the sources whose analysis is kept. The least
+// recently probed one is forgotten first.
+const analysisCacheSize = 4096
+
+var analysisCache = struct {
+	sync.Mutex
+	entries map[string]*sourceAnalysis
+}{entries: make(map[string]*sourceAnalysis)}
+
+// probeSource runs one ffprobe over all streams of path. It returns an error
+// only if ffprobe could not be run; a file ffprobe cannot read is analysed
+// as failed and not being media.
+func probeSource(path string) (*sourceAnalysis, error) {
+	out, err := exec.Command(FFPROBEPath,
+		"-v", "error",
+		"-show_entries", "stream=codec_type,width,height,field_order:stream_tags=rotate,language,title:stream_disposition=default:stream_side_data=rotation",
+		"-of", "json",
+		path).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &sourceAnalysis{failed: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Probe source error:%v", err)
+	}
+	var probe ffprobeStreams
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("Parse source streams error:%v", err)
+	}
+	a := &sourceAnalysis{}
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" || s.CodecType == "audio" {
+			a.media = true
+		}
+	}
+	a.info, _ = videoInfoFrom(probe)
+	a.tracks = audioTracksFrom(probe)
+	return a, nil
+}
+
+// probeAnalysis analyses a source, replaceable in tests.
+var probeAnalysis = probeSource
+
+// analyzeSource returns the analysis of path, probing it only when it
+// changed since the last call. Failed analyses are not kept.
+func analyzeSource(path string, stat os.FileInfo) (*sourceAnalysis, error) {
+	analysisCache.Lock()
+	a, ok := analysisCache.entries[path]
+	analysisCache.Unlock()
+	if ok && a.modTime.Equal(stat.ModTime()) {
+		return a, nil
+	}
+
+	a, err := probeAnalysis(path)
+	if err != nil {
+		return nil, err
+	}
+	a.modTime, a.probed = stat.ModTime(), time.Now()
+	if a.failed {
+		return a, nil
+	}
+
+	analysisCache.Lock()
+	if _, ok := analysisCache.entries[path]; !ok && len(analysisCache.entries) >= analysisCacheSize {
+		var oldest string
+		for p, entry := range analysisCache.entries {
+			if oldest == "" || entry.probed.Before(analysisCache.entries[oldest].probed) {
+				oldest = p
+			}
+		}
+		delete(analysisCache.entries, oldest)
+	}
+	analysisCache.entries[path] = a
+	analysisCache.Unlock()
+	return a, nil
+}
+
+// forgetAnalysis drops the analysis of path, e.g. once it changed or was
+// deleted.
+func forgetAnalysis(path string) {
+	analysisCache.Lock()
+	delete(analysisCache.entries, path)
+	analysisCache.Unlock()
+}
+
+// forgetAnalysisBelow drops the analyses of the sources inside dir.
+func forgetAnalysisBelow(dir string) {
+	analysisCache.Lock()
+	for path := range analysisCache.entries {
+		if isBelow(dir, path) {
+			delete(analysisCache.entries, path)
+		}
+	}
+	analysisCache.Unlock()
+}
+
+// localAnalysis returns the analysis of file, or nil if it cannot be
+// analysed, e.g. a remote source, which callers then probe every time.
+func localAnalysis(file string) *sourceAnalysis {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	a, err := analyzeSource(file, stat)
+	if err != nil || a.failed {
+		return nil
+	}
+	return a
+}
+
+// checkMedia returns ErrNotMedia if path holds no video or audio stream. It
+// returns nil if ffprobe could not be run, leaving that to the encode to
+// report.
+func checkMedia(path string, stat os.FileInfo) error {
+	a, err := analyzeSource(path, stat)
+	if err != nil || a.media {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrNotMedia, path)
+}
+
+// sourceVideoInfo returns the video stream of file as the encoder needs it,
+// including the keyframe interval if needsKeyframeInterval. Local files are
+// analysed once per modification; remote ones are probed every time.
+func sourceVideoInfo(file string) (*videoInfo, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return probeVideoInfo(file)
+	}
+	a, err := analyzeSource(file, stat)
+	if err != nil {
+		return nil, err
+	}
+	if a.info == nil {
+		return nil, fmt.Errorf("No video stream found")
+	}
+
+	analysisCache.Lock()
+	keyframes := a.keyframes
+	analysisCache.Unlock()
+	if !keyframes && needsKeyframeInterval() {
+		if interval, err := probeKeyframeInterval(file); err != nil {
+			log.Warnf("Could not probe keyframes of %v: %v", file, err)
+		} else {
+			analysisCache.Lock()
+			a.info.KeyframeInterval, a.keyframes = interval, true
+			analysisCache.Unlock()
+		}
+	}
+
+	analysisCache.Lock()
+	info := *a.info
+	analysisCache.Unlock()
+	return &info, nil
+}
+
+// sourceAudioTracks returns the audio streams of file.
+func sourceAudioTracks(file string) ([]audioTrack, error) {
+	if a := localAnalysis(file); a != nil {
+		return a.tracks, nil
+	}
+	return probeAudioTracks(file)
+}
+
+// sourceDuration returns the duration of file from the index, probing it
+// once per modification if it is not indexed.
+func sourceDuration(file string) (float64, error) {
+	if d, ok := libraryIndex.lookup(file); ok {
+		return d, nil
+	}
+	a := localAnalysis(file)
+	if a == nil {
+		return probeDuration(file)
+	}
+
+	analysisCache.Lock()
+	duration, ok := a.duration, a.hasDuration
+	analysisCache.Unlock()
+	if ok {
+		return duration, nil
+	}
+	duration, err := probeDuration(file)
+	if err != nil {
+		return 0, err
+	}
+	analysisCache.Lock()
+	a.duration, a.hasDuration = duration, true
+	analysisCache.Unlock()
+	return duration, nil
+}
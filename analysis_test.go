@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countProbes replaces ffprobe with an analysis of a 1920x1080 video and
+// returns the number of probes run.
+func countProbes(t *testing.T) *int {
+	saved := probeAnalysis
+	probes := new(int)
+	probeAnalysis = func(string) (*sourceAnalysis, error) {
+		*probes++
+		return &sourceAnalysis{media: true, info: &videoInfo{Width: 1920, Height: 1080}}, nil
+	}
+	t.Cleanup(func() { probeAnalysis = saved })
+	return probes
+}
+
+func TestAnalysisCacheReuse(t *testing.T) {
+	probes := countProbes(t)
+	file := filepath.Join(t.TempDir(), "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { forgetAnalysis(file) })
+
+	for i := 0; i < 3; i++ {
+		info, err := sourceVideoInfo(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Height != 1080 {
+			t.Errorf("info %+v", info)
+		}
+		stat, _ := os.Stat(file)
+		if err := checkMedia(file, stat); err != nil {
+			t.Errorf("media check: %v", err)
+		}
+	}
+	if *probes != 1 {
+		t.Errorf("%v probes for repeated lookups, want 1", *probes)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sourceVideoInfo(file); err != nil {
+		t.Fatal(err)
+	}
+	if *probes != 2 {
+		t.Errorf("%v probes after the source changed, want 2", *probes)
+	}
+
+	forgetAnalysis(file)
+	if _, err := sourceVideoInfo(file); err != nil {
+		t.Fatal(err)
+	}
+	if *probes != 3 {
+		t.Errorf("%v probes after forgetting the analysis, want 3", *probes)
+	}
+}
+
+func TestAnalysisCacheBounded(t *testing.T) {
+	countProbes(t)
+	analysisCache.Lock()
+	saved := analysisCache.entries
+	analysisCache.entries = make(map[string]*sourceAnalysis)
+	analysisCache.Unlock()
+	t.Cleanup(func() {
+		analysisCache.Lock()
+		analysisCache.entries = saved
+		analysisCache.Unlock()
+	})
+
+	file := filepath.Join(t.TempDir(), "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := "/media/0.mp4"
+	for i := 0; i <= analysisCacheSize; i++ {
+		if _, err := analyzeSource(fmt.Sprintf("/media/%v.mp4", i), stat); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Make the first analysis the oldest regardless of clock resolution.
+			analysisCache.Lock()
+			analysisCache.entries[first].probed = time.Time{}
+			analysisCache.Unlock()
+		}
+	}
+	analysisCache.Lock()
+	n := len(analysisCache.entries)
+	_, kept := analysisCache.entries[first]
+	analysisCache.Unlock()
+	if n != analysisCacheSize || kept {
+		t.Errorf("%v analyses kept with the oldest one %v, want %v without it", n, kept, analysisCacheSize)
+	}
+}
+
+func TestFailedAnalysisNotCached(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { forgetAnalysis(file) })
+	saved := probeAnalysis
+	t.Cleanup(func() { probeAnalysis = saved })
+	probeAnalysis = func(string) (*sourceAnalysis, error) { return &sourceAnalysis{failed: true}, nil }
+
+	if err := checkMedia(file, stat); !errors.Is(err, ErrNotMedia) {
+		t.Errorf("unreadable source: %v, want ErrNotMedia", err)
+	}
+	probes := countProbes(t)
+	if err := checkMedia(file, stat); err != nil {
+		t.Errorf("source readable on the next probe: %v", err)
+	}
+	if *probes != 1 {
+		t.Errorf("%v probes after a failed one, want 1", *probes)
+	}
+}
+
+func TestAnalysisDerivedValues(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tracks := []audioTrack{{Index: 0, Language: "eng", Default: true}}
+	analyzed(t, file, sourceAnalysis{media: true, tracks: tracks})
+	oldProbe := probeDuration
+	t.Cleanup(func() { probeDuration = oldProbe })
+	probes := 0
+	probeDuration = func(string) (float64, error) {
+		probes++
+		return 42, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if d, err := sourceDuration(file); err != nil || d != 42 {
+			t.Errorf("duration %v, %v", d, err)
+		}
+		if got, err := sourceAudioTracks(file); err != nil || len(got) != 1 || got[0] != tracks[0] {
+			t.Errorf("audio tracks %+v, %v", got, err)
+		}
+	}
+	if probes != 1 {
+		t.Errorf("%v duration probes, want 1", probes)
+	}
+
+	// The derived values go with the analysis.
+	countProbes(t)
+	forgetAnalysis(file)
+	if _, err := sourceDuration(file); err != nil {
+		t.Fatal(err)
+	}
+	if probes != 2 {
+		t.Errorf("%v duration probes after forgetting the analysis, want 2", probes)
+	}
+}
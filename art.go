@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// coverArtArgs copy the attached picture stream of file, e.g. the cover of
+// an audio file, without decoding it. -0:V drops the regular video streams.
+func coverArtArgs(file string) []string {
+	return []string{
+		"-y",
+		"-hide_banner",
+		"-loglevel", ffmpegLogLevel,
+		"-i", file,
+		"-map", "0:v",
+		"-map", "-0:V",
+		"-frames:v", "1",
+		"-c", "copy",
+		"-f", "image2",
+		"pipe:1",
+	}
+}
+
+// coverArtCacheFile is where the cover art of the given version of file is
+// stored.
+func coverArtCacheFile(file string, stat os.FileInfo) string {
+	h := sha1.New()
+	h.Write([]byte(file))
+	return filepath.Join(root, HomeDir, thumbsDirName, fmt.Sprintf("%x.%v.art", h.Sum(nil), stat.ModTime().UnixNano()))
+}
+
+// getCoverArt returns the embedded cover art of file extracted with run, or
+// a thumbnail of its first frame if it has none. Either is cached until file
+// changes.
+func getCoverArt(file string, run executor) ([]byte, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %v", ErrSourceNotFound, file)
+		}
+		return nil, err
+	}
+	cachePath := coverArtCacheFile(file, stat)
+	if data, err := ioutil.ReadFile(cachePath); err == nil {
+		return data, nil
+	}
+
+	data, err := run(FFMPEGPath, coverArtArgs(file))
+	if err != nil || len(data) == 0 {
+		log.Debugf("No cover art in %v, using a thumbnail: %v", file, err)
+		if data, err = getThumbnail(file, 0); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun || noCache {
+		return data, nil
+	}
+	if err := writeCacheFile(cachePath, data); err != nil {
+		log.Errorf("Could not cache cover art %v: %v", cachePath, err)
+	}
+	return data, nil
+}
+
+// coverArt serves the cover art of a file, see getCoverArt.
+func coverArt(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("Cover art request: %v", r.URL.Path)
+	file, err := resolveMediaPath(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	data, err := getCoverArt(file, execute)
+	if err != nil {
+		log.Errorf("Error extracting cover art %v", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header()["Content-Type"] = []string{http.DetectContentType(data)}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.Write(data)
+}
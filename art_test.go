@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoverArtArgs(t *testing.T) {
+	args := coverArtArgs("/media/a.mp3")
+	if !containsArgs(args, "-i", "/media/a.mp3", "-map", "0:v", "-map", "-0:V") || !containsArgs(args, "-c", "copy") {
+		t.Errorf("args do not copy the attached picture: %v", args)
+	}
+}
+
+func TestCoverArtExtracted(t *testing.T) {
+	dir := withTestRoot(t)
+	file := filepath.Join(dir, "a.mp3")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runs := 0
+	run := func(cmd string, args []string) ([]byte, error) {
+		runs++
+		return []byte("cover"), nil
+	}
+	for i := 0; i < 2; i++ {
+		data, err := getCoverArt(file, run)
+		if err != nil || string(data) != "cover" {
+			t.Fatalf("cover art %q, %v", data, err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("cover art extracted %v times, want once and then cached", runs)
+	}
+}
+
+func TestCoverArtFallsBackToThumbnail(t *testing.T) {
+	dir := withTestRoot(t)
+	file := filepath.Join(dir, "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCacheFile(thumbnailCacheFile(file, stat, 0), []byte("thumbnail")); err != nil {
+		t.Fatal(err)
+	}
+	for name, run := range map[string]executor{
+		"failed": func(string, []string) ([]byte, error) { return nil, errors.New("no attached picture") },
+		"empty":  func(string, []string) ([]byte, error) { return nil, nil },
+	} {
+		os.Remove(coverArtCacheFile(file, stat))
+		if data, err := getCoverArt(file, run); err != nil || string(data) != "thumbnail" {
+			t.Errorf("%v extraction: %q, %v, want the thumbnail", name, data, err)
+		}
+	}
+
+	if _, err := getCoverArt(filepath.Join(dir, "missing.mp3"), nil); !errors.Is(err, ErrSourceNotFound) {
+		t.Errorf("missing file: %v, want ErrSourceNotFound", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// audioCodecPreference lists the audio encoders to use in order of
+// preference. The first one the ffmpeg build supports is picked at startup.
+var audioCodecPreference = "libfdk_aac,aac,ac3"
+
+// defaultAudioCodec is used until audioCodec is resolved, and is left out of
+// cache keys so caches from before the preference list stay valid.
+const defaultAudioCodec = "libfdk_aac"
+
+// audioCodec is the audio encoder of every segment.
+var audioCodec = defaultAudioCodec
+
+// selectAudioCodec returns the first of preference for which available is
+// true.
+func selectAudioCodec(preference []string, available func(codec string) bool) (string, error) {
+	for _, codec := range preference {
+		if available(codec) {
+			return codec, nil
+		}
+	}
+	return "", fmt.Errorf("None of the audio encoders %v is available", strings.Join(preference, ","))
+}
+
+// probeEncoders lists the encoders ffmpeg was built with.
+func probeEncoders() (map[string]bool, error) {
+	out, err := exec.Command(FFMPEGPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Probe encoders error:%v", err)
+	}
+	return parseEncoders(out), nil
+}
+
+// listEncoders is how resolveAudioCodec learns the available encoders.
+var listEncoders = probeEncoders
+
+// parseEncoders reads the names from ffmpeg -encoders output, whose rows
+// are a flags column followed by the name. The legend above the "------"
+// line is skipped.
+func parseEncoders(data []byte) map[string]bool {
+	encoders := make(map[string]bool)
+	listing := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			if len(fields) == 1 && strings.HasPrefix(fields[0], "---") {
+				listing = true
+			}
+			continue
+		}
+		if listing {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+// resolveAudioCodec sets audioCodec to the first available encoder of
+// audioCodecPreference. If ffmpeg cannot be asked it keeps the first one.
+func resolveAudioCodec() error {
+	var preference []string
+	for _, codec := range strings.Split(audioCodecPreference, ",") {
+		if codec = strings.TrimSpace(codec); codec != "" {
+			preference = append(preference, codec)
+		}
+	}
+	if len(preference) == 0 {
+		return fmt.Errorf("No audio encoders given")
+	}
+	encoders, err := listEncoders()
+	if err != nil {
+		log.Warnf("Could not list ffmpeg encoders, using %v: %v", preference[0], err)
+		audioCodec = preference[0]
+		return nil
+	}
+	codec, err := selectAudioCodec(preference, func(c string) bool { return encoders[c] })
+	if err != nil {
+		return err
+	}
+	audioCodec = codec
+	log.Infof("Encoding audio with %v", audioCodec)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+const encodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D ac3                  ATSC A/52A (AC-3)
+`
+
+func TestParseEncoders(t *testing.T) {
+	encoders := parseEncoders([]byte(encodersOutput))
+	if len(encoders) != 3 || !encoders["libx264"] || !encoders["aac"] || !encoders["ac3"] {
+		t.Errorf("parsed %v", encoders)
+	}
+	if encoders["Video"] || encoders["="] {
+		t.Errorf("legend parsed as encoders: %v", encoders)
+	}
+}
+
+func TestSelectAudioCodec(t *testing.T) {
+	preference := []string{"libfdk_aac", "aac", "ac3"}
+	for _, test := range []struct {
+		available []string
+		want      string
+	}{
+		{[]string{"libfdk_aac", "aac", "ac3"}, "libfdk_aac"},
+		{[]string{"aac", "ac3"}, "aac"},
+		{[]string{"ac3", "libmp3lame"}, "ac3"},
+	} {
+		available := make(map[string]bool)
+		for _, codec := range test.available {
+			available[codec] = true
+		}
+		got, err := selectAudioCodec(preference, func(codec string) bool { return available[codec] })
+		if err != nil || got != test.want {
+			t.Errorf("available %v: selected %v, %v, want %v", test.available, got, err, test.want)
+		}
+	}
+	if _, err := selectAudioCodec(preference, func(string) bool { return false }); err == nil {
+		t.Error("selected an encoder while none is available")
+	}
+}
+
+// withEncoders makes resolveAudioCodec see the encoders of output, or err.
+func withEncoders(t *testing.T, output string, err error) {
+	saved := listEncoders
+	listEncoders = func() (map[string]bool, error) {
+		if err != nil {
+			return nil, err
+		}
+		return parseEncoders([]byte(output)), nil
+	}
+	t.Cleanup(func() { listEncoders = saved })
+}
+
+func withAudioCodec(t *testing.T, preference string) {
+	savedPreference, savedCodec := audioCodecPreference, audioCodec
+	audioCodecPreference = preference
+	t.Cleanup(func() { audioCodecPreference, audioCodec = savedPreference, savedCodec })
+}
+
+func TestResolveAudioCodec(t *testing.T) {
+	withEncoders(t, encodersOutput, nil)
+	withAudioCodec(t, "libfdk_aac, aac,ac3")
+	if err := resolveAudioCodec(); err != nil || audioCodec != "aac" {
+		t.Errorf("resolved %v, %v, want aac", audioCodec, err)
+	}
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest("GET", "/healthz", nil), nil)
+	var health map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil || health["audioCodec"] != "aac" {
+		t.Errorf("healthz reports %v, %v", health, err)
+	}
+
+	audioCodecPreference = "libfdk_aac,libopus"
+	if err := resolveAudioCodec(); err == nil {
+		t.Error("resolved without an available encoder")
+	}
+	audioCodecPreference = " , "
+	if err := resolveAudioCodec(); err == nil {
+		t.Error("resolved an empty preference list")
+	}
+}
+
+func TestResolveAudioCodecWithoutFFmpeg(t *testing.T) {
+	withEncoders(t, "", errors.New("executable file not found"))
+	withAudioCodec(t, "aac,ac3")
+	if err := resolveAudioCodec(); err != nil || audioCodec != "aac" {
+		t.Errorf("resolved %v, %v, want the first preference", audioCodec, err)
+	}
+}
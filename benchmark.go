@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxBenchmarkIterations caps the encodes one benchmark request runs.
+const maxBenchmarkIterations = 50
+
+type benchmarkResult struct {
+	Iterations int     `json:"iterations"`
+	Resolution int64   `json:"resolution"`
+	Preset     string  `json:"preset"`
+	Average    float64 `json:"averageMs"`
+	Min        float64 `json:"minMs"`
+	Max        float64 `json:"maxMs"`
+	P50        float64 `json:"p50Ms"`
+	P90        float64 `json:"p90Ms"`
+	P99        float64 `json:"p99Ms"`
+	// Speed is the seconds of video encoded per second, 1 being realtime.
+	Speed float64 `json:"speed"`
+}
+
+// benchmarkArgs encode one segment of a generated test pattern at res with
+// the video and audio settings of regular segments.
+func benchmarkArgs(res int64) []string {
+	r := NewEncodingRequest("", 0, res)
+	args := []string{
+		"-y",
+		"-hide_banner",
+		"-loglevel", ffmpegLogLevel,
+		"-f", "lavfi", "-i", "testsrc2=size=1920x1080:rate=30",
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000",
+		"-t", fmt.Sprintf("%.2f", hlsSegmentLength),
+	}
+	args = append(args, threadArgs()...)
+	args = append(args,
+		"-vf", scaleFilter(res, nil),
+		"-vcodec", "libx264",
+		"-preset", r.presetFor(),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%.2f)", hlsSegmentLength),
+	)
+	args = append(args, colorArgs()...)
+	return append(args, "-acodec", audioCodec, "-f", "mpegts", "pipe:1")
+}
+
+// runBenchmark encodes the test pattern iterations times with run and
+// aggregates how long the encodes took.
+func runBenchmark(iterations int, res int64, run executor) (*benchmarkResult, error) {
+	args := benchmarkArgs(res)
+	latencies := make([]float64, 0, iterations)
+	var total time.Duration
+	for i := 0; i < iterations; i++ {
+		started := time.Now()
+		if _, err := run(FFMPEGPath, args); err != nil {
+			return nil, fmt.Errorf("Benchmark encode %v failed:%v", i+1, err)
+		}
+		elapsed := time.Since(started)
+		total += elapsed
+		latencies = append(latencies, float64(elapsed)/float64(time.Millisecond))
+	}
+	sort.Float64s(latencies)
+	return &benchmarkResult{
+		Iterations: iterations,
+		Resolution: res,
+		Preset:     NewEncodingRequest("", 0, res).presetFor(),
+		Average:    float64(total) / float64(time.Millisecond) / float64(iterations),
+		Min:        latencies[0],
+		Max:        latencies[len(latencies)-1],
+		P50:        percentile(latencies, 50),
+		P90:        percentile(latencies, 90),
+		P99:        percentile(latencies, 99),
+		Speed:      float64(iterations) * hlsSegmentLength / total.Seconds(),
+	}, nil
+}
+
+// percentile returns the p-th percentile of sorted by the nearest rank.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// benchmarkHandler runs ?n= encodes (default 5) of the test pattern at ?res=
+// and reports their timing, for capacity planning. It bypasses the encoder
+// queue, so run it on an idle host.
+func benchmarkHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q := r.URL.Query()
+	iterations := 5
+	if value := q.Get("n"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxBenchmarkIterations {
+			http.Error(w, fmt.Sprintf("Invalid iteration count %v, expected 1 to %v", value, maxBenchmarkIterations), http.StatusBadRequest)
+			return
+		}
+		iterations = n
+	}
+	res, err := parseResolution(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := runBenchmark(iterations, res, execute)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(result)
+}
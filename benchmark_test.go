@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	runs := 0
+	run := func(cmd string, args []string) ([]byte, error) {
+		runs++
+		if !containsArgs(args, "-f", "lavfi", "-i", "testsrc2=size=1920x1080:rate=30") || !containsArgs(args, "-vf", scaleFilter(720, nil)) {
+			t.Errorf("benchmark args %v", args)
+		}
+		time.Sleep(time.Duration(runs) * time.Millisecond)
+		return nil, nil
+	}
+	result, err := runBenchmark(4, 720, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 4 || result.Iterations != 4 || result.Resolution != 720 {
+		t.Errorf("ran %v encodes, result %+v", runs, result)
+	}
+	if !(result.Min <= result.P50 && result.P50 <= result.P90 && result.P90 <= result.Max) || result.Min < 1 {
+		t.Errorf("latencies out of order: %+v", result)
+	}
+	if result.Average < result.Min || result.Average > result.Max || result.Speed <= 0 {
+		t.Errorf("aggregates %+v", result)
+	}
+
+	failing := func(string, []string) ([]byte, error) { return nil, errors.New("no encoder") }
+	if _, err := runBenchmark(3, 720, failing); err == nil {
+		t.Error("failed encode not reported")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for p, want := range map[float64]float64{0: 1, 50: 5, 90: 9, 99: 10, 100: 10} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("p%v = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func withAdminToken(t *testing.T, token string) {
+	saved := adminToken
+	adminToken = token
+	t.Cleanup(func() { adminToken = saved })
+}
+
+func TestBenchmarkRequiresAdmin(t *testing.T) {
+	ran := false
+	handle := requireAdmin(func(http.ResponseWriter, *http.Request, httprouter.Params) { ran = true })
+	get := func(token string) int {
+		r := httptest.NewRequest("POST", "/api/admin/benchmark", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handle(w, r, nil)
+		return w.Code
+	}
+
+	withAdminToken(t, "")
+	if code := get("anything"); code != http.StatusNotFound || ran {
+		t.Errorf("without admin credentials: status %v, ran %v", code, ran)
+	}
+	withAdminToken(t, "secret")
+	if code := get("wrong"); code != http.StatusUnauthorized || ran {
+		t.Errorf("wrong token: status %v, ran %v", code, ran)
+	}
+	if get("secret"); !ran {
+		t.Error("benchmark refused to the admin")
+	}
+}
+
+func TestBenchmarkIterations(t *testing.T) {
+	for _, n := range []string{"0", "-1", "x", "51"} {
+		w := httptest.NewRecorder()
+		benchmarkHandler(w, httptest.NewRequest("POST", "/api/admin/benchmark?n="+n, nil), nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("n=%v: status %v, want %v", n, w.Code, http.StatusBadRequest)
+		}
+	}
+}
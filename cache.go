@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cacheTempDir holds cache files while they are written, os.TempDir() if
+// empty. Keeping it off the cache volume reduces I/O contention there.
+var cacheTempDir string
+
+// exactDurations makes playlists list the measured duration of segments
+// that are already cached instead of the nominal segment length.
+var exactDurations bool
+
+// measuredDurations memoizes tsDuration per cache file; cache files never
+// change once written.
+var measuredDurations sync.Map
+
+type cacheStats struct {
+	Size   int64      `json:"size"`
+	Files  int        `json:"files"`
+	Hits   uint64     `json:"hits"`
+	Misses uint64     `json:"misses"`
+	Oldest *time.Time `json:"oldest,omitempty"`
+	Newest *time.Time `json:"newest,omitempty"`
+}
+
+func (e *Encoder) cacheDirPath() string {
+	return filepath.Join(root, HomeDir, e.cacheDir)
+}
+
+// Stats scans the cache directory and combines it with the hit and miss
+// counts of segment requests since startup.
+func (e *Encoder) Stats() (*cacheStats, error) {
+	stats := &cacheStats{
+		Hits:   e.hits.Load(),
+		Misses: e.misses.Load(),
+	}
+	err := e.walkCache(func(p string, f os.FileInfo) {
+		if strings.HasSuffix(f.Name(), ".tmp") || strings.HasSuffix(f.Name(), sourceExt) {
+			return
+		}
+		stats.Size += f.Size()
+		stats.Files++
+		mod := f.ModTime()
+		if stats.Oldest == nil || mod.Before(*stats.Oldest) {
+			stats.Oldest = &mod
+		}
+		if stats.Newest == nil || mod.After(*stats.Newest) {
+			stats.Newest = &mod
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// writeCacheFile atomically stores data at path. The data is written to a
+// temp file in cacheTempDir unique to this process and write, then moved
+// into place, so concurrent writers of the same key never see each other's
+// partial output. Nothing is written if path already exists.
+func writeCacheFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	dir, base := filepath.Split(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("Could not create cache dir %v: %v", dir, err)
+	}
+	tempDir := cacheTempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	tmp, err := ioutil.TempFile(tempDir, fmt.Sprintf("%v.%v.*.tmp", base, os.Getpid()))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = moveFile(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// renameFile is os.Rename, replaceable to simulate cross-device moves.
+var renameFile = os.Rename
+
+// moveFile renames src to dst. When they are on different filesystems src
+// is copied next to dst first, so dst still appears atomically.
+func moveFile(src string, dst string) error {
+	err := renameFile(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dir, base := filepath.Split(dst)
+	out, err := ioutil.TempFile(dir, base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
+// measureDurations replaces the estimated durations of the segments of r's
+// stream that are cached with their measured durations. Segments that are
+// not cached yet keep their estimate.
+func (e *Encoder) measureDurations(r EncodingRequest, durations []float64) {
+	for i := range durations {
+		r.segment = int64(i)
+		cachePath := e.GetCacheFile(r)
+		if d, ok := measuredDurations.Load(cachePath); ok {
+			durations[i] = d.(float64)
+			continue
+		}
+		data, err := ioutil.ReadFile(cachePath)
+		if err != nil {
+			continue
+		}
+		if d, ok := tsDuration(data); ok {
+			measuredDurations.Store(cachePath, d)
+			durations[i] = d
+		}
+	}
+}
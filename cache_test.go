@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	withTestRoot(t)
+	e := encoder
+	e.hits.Add(3)
+	e.misses.Add(1)
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	files := map[string]int{"a.480.0": 100, "a.480.1": 50, "b.720.0": 7}
+	for name, size := range files {
+		if err := writeCacheFile(e.cacheFilePath(name), make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Chtimes(e.cacheFilePath("b.720.0"), old, old)
+	// Source records and temp files are not cache entries.
+	writeCacheFile(e.cacheFilePath("a"+sourceExt), []byte("/data/a.mp4"))
+	os.WriteFile(filepath.Join(e.cacheDirPath(), "c.480.0.1.tmp"), make([]byte, 1000), 0666)
+
+	stats, err := e.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 3 || stats.Size != 157 {
+		t.Errorf("%v files of %v bytes, want 3 of 157", stats.Files, stats.Size)
+	}
+	if stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("%v hits and %v misses, want 3 and 1", stats.Hits, stats.Misses)
+	}
+	if stats.Oldest == nil || !stats.Oldest.Equal(old) {
+		t.Errorf("oldest %v, want %v", stats.Oldest, old)
+	}
+	if stats.Newest == nil || !stats.Newest.After(old) {
+		t.Errorf("newest %v is not after %v", stats.Newest, old)
+	}
+}
+
+func TestStatsEmpty(t *testing.T) {
+	withTestRoot(t)
+	stats, err := encoder.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 0 || stats.Size != 0 || stats.Oldest != nil {
+		t.Errorf("empty cache reported %+v", stats)
+	}
+}
+
+func TestWriteCacheFileConcurrent(t *testing.T) {
+	temp := t.TempDir()
+	old := cacheTempDir
+	cacheTempDir = temp
+	t.Cleanup(func() { cacheTempDir = old })
+
+	path := filepath.Join(t.TempDir(), "segments", "a.480.0")
+	contents := [][]byte{bytes.Repeat([]byte{1}, 1<<20), bytes.Repeat([]byte{2}, 1<<20)}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			if err := writeCacheFile(path, data); err != nil {
+				t.Error(err)
+			}
+		}(contents[i%2])
+	}
+	wg.Wait()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, contents[0]) && !bytes.Equal(data, contents[1]) {
+		t.Error("cache file mixes the output of concurrent writers")
+	}
+	if left, _ := ioutil.ReadDir(temp); len(left) != 0 {
+		t.Errorf("%v temp files left behind", len(left))
+	}
+}
+
+func TestWriteCacheFileKeepsExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.480.0")
+	if err := writeCacheFile(path, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCacheFile(path, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "first" {
+		t.Errorf("cache file %q, want the first write kept", data)
+	}
+}
+
+func TestMeasureDurations(t *testing.T) {
+	withTestRoot(t)
+	r := *NewEncodingRequest("/media/a.mp4", 1, 480)
+	segment := tsPackets(
+		testPAT(),
+		testPMT(),
+		tsPacket(testVideoPID, true, true, testPES(0)),
+		tsPacket(testVideoPID, true, false, testPES(3600)),
+		tsPacket(testVideoPID, true, false, testPES(7200)),
+	)
+	path := encoder.GetCacheFile(r)
+	if err := writeCacheFile(path, segment); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { measuredDurations.Delete(path) })
+
+	durations := []float64{10, 10, 10}
+	encoder.measureDurations(r, durations)
+	if want := []float64{10, 0.12, 10}; !reflect.DeepEqual(durations, want) {
+		t.Errorf("durations %v, want the measured one for the cached segment only: %v", durations, want)
+	}
+}
+
+func TestMoveFileCrossDevice(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "a.480.0.tmp")
+	dst := filepath.Join(t.TempDir(), "a.480.0")
+	if err := ioutil.WriteFile(src, []byte("segment"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { renameFile = os.Rename })
+	renameFile = func(from, to string) error {
+		if from == src {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+		}
+		return os.Rename(from, to)
+	}
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(dst); err != nil || string(data) != "segment" {
+		t.Errorf("destination %q %v, want the copied data", data, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source kept after the copy")
+	}
+	if left, _ := ioutil.ReadDir(filepath.Dir(dst)); len(left) != 1 {
+		t.Errorf("%v files next to the destination, want only it", len(left))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// readableCacheNames prefixes cache files with a label made from their
+// source name, so the cache directory can be inspected by eye. The hash
+// still follows the label and keeps names unique.
+var readableCacheNames bool
+
+// cacheLabelLength is the maximum length of a cache file label.
+const cacheLabelLength = 32
+
+// cacheLabel is the label of cache files of source: its base name without
+// extension, with everything but letters, digits and underscores replaced
+// by underscores and truncated to cacheLabelLength. Labels never contain
+// the '.' and '-' that separate the parts of a cache file name.
+func cacheLabel(source string) string {
+	base := path.Base(source)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	label := []byte(base)
+	for i, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			label[i] = '_'
+		}
+	}
+	if len(label) > cacheLabelLength {
+		label = label[:cacheLabelLength]
+	}
+	return string(label)
+}
+
+// cacheGroupName is the name of the cache group of source with the given
+// hash.
+func cacheGroupName(source string, hash []byte) string {
+	if readableCacheNames {
+		return fmt.Sprintf("%v-%x", cacheLabel(source), hash)
+	}
+	return fmt.Sprintf("%x", hash)
+}
+
+// cacheHash strips the label off the cache file name, leaving the part
+// starting with the hash.
+func cacheHash(name string) string {
+	if i := strings.IndexByte(name, '-'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
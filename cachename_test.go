@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withReadableCacheNames(t *testing.T, readable bool) {
+	old := readableCacheNames
+	readableCacheNames = readable
+	t.Cleanup(func() { readableCacheNames = old })
+}
+
+func TestCacheLabel(t *testing.T) {
+	for source, want := range map[string]string{
+		"/media/movie.mp4":                           "movie",
+		"/media/My Show - S01E02.mkv":                "My_Show___S01E02",
+		"/media/a.b.c.mp4":                           "a_b_c",
+		"/media/Überfahrt.mp4":                       "__berfahrt",
+		"/media/" + strings.Repeat("x", 40) + ".mp4": strings.Repeat("x", cacheLabelLength),
+		"https://cdn.example.com/v/clip.mp4":         "clip",
+	} {
+		if got := cacheLabel(source); got != want {
+			t.Errorf("label of %v = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestReadableCacheNames(t *testing.T) {
+	r := NewEncodingRequest("/media/My Show.mp4", 3, 480)
+	withReadableCacheNames(t, false)
+	plain := r.getCacheKey()
+
+	readableCacheNames = true
+	key := r.getCacheKey()
+	if !strings.HasPrefix(key, "My_Show-") {
+		t.Errorf("key %v lacks the source label", key)
+	}
+	if cacheHash(key) != plain {
+		t.Errorf("hash of %v is %v, want %v", key, cacheHash(key), plain)
+	}
+	if cacheHash(plain) != plain {
+		t.Errorf("plain key %v changed by cacheHash", plain)
+	}
+	if other := NewEncodingRequest("/other/My Show.mp4", 3, 480).getCacheKey(); other == key {
+		t.Error("sources with the same label share a key")
+	}
+}
+
+func TestReadableCacheNamesRoundTrip(t *testing.T) {
+	dir := withTestRoot(t)
+	withReadableCacheNames(t, true)
+	withCacheShardDepth(t, 1)
+	r := *NewEncodingRequest(filepath.Join(dir, "a show.mp4"), 0, 480)
+	encoder.cacheSegment(r, []byte("ts"))
+
+	p := encoder.GetCacheFile(r)
+	name := filepath.Base(p)
+	if !strings.HasPrefix(name, "a_show-") {
+		t.Errorf("cache file %v lacks the source label", name)
+	}
+	// Files are sharded by their hash, not their label.
+	if shard := filepath.Base(filepath.Dir(p)); shard != cacheHash(name)[:2] {
+		t.Errorf("cache file %v in shard %v", name, shard)
+	}
+	if data, err := encoder.GetFromCache(r); err != nil || string(data) != "ts" {
+		t.Errorf("read back %q, %v", data, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+type chapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+}
+
+type ffprobeChapters struct {
+	Chapters []struct {
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+		Tags      struct {
+			Title string `json:"title"`
+		} `json:"tags"`
+	} `json:"chapters"`
+}
+
+// getChapters returns the chapters of path, probing it only when it changed
+// since the last call.
+func getChapters(path string) ([]chapter, error) {
+	a := localAnalysis(path)
+	if a == nil {
+		return probeChapters(path)
+	}
+	analysisCache.Lock()
+	chapters := a.chapters
+	analysisCache.Unlock()
+	if chapters != nil {
+		return chapters, nil
+	}
+
+	chapters, err := probeChapters(path)
+	if err != nil {
+		return nil, err
+	}
+	analysisCache.Lock()
+	a.chapters = chapters
+	analysisCache.Unlock()
+	return chapters, nil
+}
+
+func probeChapters(path string) ([]chapter, error) {
+	out, err := exec.Command(FFPROBEPath, "-v", "error", "-show_chapters", "-of", "json", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Probe chapters error:%v", err)
+	}
+	return parseChapters(out)
+}
+
+func parseChapters(data []byte) ([]chapter, error) {
+	var probe ffprobeChapters
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("Parse chapters error:%v", err)
+	}
+	chapters := make([]chapter, 0, len(probe.Chapters))
+	for i, c := range probe.Chapters {
+		start, _ := strconv.ParseFloat(c.StartTime, 64)
+		end, _ := strconv.ParseFloat(c.EndTime, 64)
+		title := c.Tags.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %v", i+1)
+		}
+		chapters = append(chapters, chapter{start, end, title})
+	}
+	return chapters, nil
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp (HH:MM:SS.mmm).
+func vttTimestamp(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// chaptersVTT renders chapters as a WebVTT chapters track.
+func chaptersVTT(chapters []chapter) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for i, c := range chapters {
+		fmt.Fprintf(&buf, "\n%v\n%v --> %v\n%v\n", i+1, vttTimestamp(c.Start), vttTimestamp(c.End), c.Title)
+	}
+	return buf.Bytes()
+}
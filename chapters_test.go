@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const chaptersProbe = `{
+    "chapters": [
+        {
+            "id": 0,
+            "time_base": "1/1000",
+            "start": 0,
+            "start_time": "0.000000",
+            "end": 90500,
+            "end_time": "90.500000",
+            "tags": {
+                "title": "Opening"
+            }
+        },
+        {
+            "id": 1,
+            "time_base": "1/1000",
+            "start": 90500,
+            "start_time": "90.500000",
+            "end": 3725250,
+            "end_time": "3725.250000",
+            "tags": {}
+        }
+    ]
+}`
+
+func TestParseChapters(t *testing.T) {
+	chapters, err := parseChapters([]byte(chaptersProbe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []chapter{{0, 90.5, "Opening"}, {90.5, 3725.25, "Chapter 2"}}
+	if !reflect.DeepEqual(chapters, want) {
+		t.Errorf("chapters %+v, want %+v", chapters, want)
+	}
+}
+
+func TestParseChaptersEmpty(t *testing.T) {
+	chapters, err := parseChapters([]byte(`{"chapters": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapters == nil || len(chapters) != 0 {
+		t.Errorf("chapters %#v, want an empty list", chapters)
+	}
+}
+
+func TestChaptersVTT(t *testing.T) {
+	chapters, _ := parseChapters([]byte(chaptersProbe))
+	want := "WEBVTT\n\n1\n00:00:00.000 --> 00:01:30.500\nOpening\n\n2\n00:01:30.500 --> 01:02:05.250\nChapter 2\n"
+	if got := string(chaptersVTT(chapters)); got != want {
+		t.Errorf("VTT\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestChaptersRejectsTraversal(t *testing.T) {
+	w := httptest.NewRecorder()
+	chaptersHandler(w, httptest.NewRequest("GET", "/api/chapters/../../etc/passwd", nil), httprouter.Params{{Key: "filename", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// cmafParts encodes each fMP4 segment of an LL-HLS stream as one fragment per
+// part, so playlists can address the parts of cached segments as byte ranges
+// of the segment instead of separate requests.
+var cmafParts bool
+
+// byteRange is a slice of a segment file.
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// partRangesCache memoizes fragmentRanges per cache file; cache files never
+// change once written.
+var partRangesCache sync.Map
+
+// usesCMAFParts reports whether r is encoded with a fragment per part.
+func (r *EncodingRequest) usesCMAFParts() bool {
+	return cmafParts && llhls && r.container == containerFMP4 && r.part == wholeSegment
+}
+
+// keyframeSpacing is the distance in seconds of the keyframes forced into
+// r's segment of the given length: one per part for CMAF parts, so every
+// part is independent.
+func (r *EncodingRequest) keyframeSpacing(length float64) float64 {
+	if r.usesCMAFParts() {
+		return length / float64(llhlsParts)
+	}
+	return length
+}
+
+// fragmentArgs make the fMP4 muxer cut a fragment at every forced keyframe.
+func (r *EncodingRequest) fragmentArgs(length float64) []string {
+	if !r.usesCMAFParts() {
+		return nil
+	}
+	return []string{"-frag_duration", fmt.Sprintf("%d", int64(r.keyframeSpacing(length)*1e6))}
+}
+
+// fragmentRanges returns the byte range of every moof box of a fragmented
+// MP4 segment together with the boxes up to the next moof, i.e. its mdat.
+// The ftyp and moov boxes heading the segment belong to no fragment.
+func fragmentRanges(data []byte) []byteRange {
+	var ranges []byteRange
+	for offset := 0; offset+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < 8 || offset+size > len(data) {
+			break
+		}
+		switch string(data[offset+4 : offset+8]) {
+		case "moof":
+			ranges = append(ranges, byteRange{int64(offset), int64(size)})
+		case "ftyp", "moov":
+		default:
+			if n := len(ranges); n > 0 {
+				ranges[n-1].length += int64(size)
+			}
+		}
+		offset += size
+	}
+	return ranges
+}
+
+// partRanges returns the byte ranges of the parts of r's segment if it is
+// cached, nil otherwise.
+func (e *Encoder) partRanges(r EncodingRequest) []byteRange {
+	if !r.usesCMAFParts() {
+		return nil
+	}
+	cachePath := e.GetCacheFile(r)
+	if ranges, ok := partRangesCache.Load(cachePath); ok {
+		return ranges.([]byteRange)
+	}
+	data, err := e.GetFromCache(r)
+	if err != nil || data == nil {
+		return nil
+	}
+	ranges := fragmentRanges(data)
+	partRangesCache.Store(cachePath, ranges)
+	return ranges
+}
+
+// segmentPartRanges returns the part byte ranges of each cached segment of
+// stream among the count segments from first.
+func (e *Encoder) segmentPartRanges(stream EncodingRequest, first int, count int) map[int64][]byteRange {
+	if !stream.usesCMAFParts() {
+		return nil
+	}
+	ranges := make(map[int64][]byteRange)
+	for i := first; i < first+count; i++ {
+		stream.segment = int64(i)
+		if r := e.partRanges(stream); r != nil {
+			ranges[int64(i)] = r
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func withCMAFParts(t *testing.T) {
+	withLLHLS(t)
+	cmafParts = true
+	t.Cleanup(func() { cmafParts = false })
+}
+
+// cmafSegment returns a segment with a fragment per payload.
+func cmafSegment(payloads ...string) []byte {
+	segment := append(mp4Box("ftyp", "iso5"), mp4Box("moov", "tracks")...)
+	for _, payload := range payloads {
+		segment = append(segment, mp4Box("moof", "frag")...)
+		segment = append(segment, mp4Box("mdat", payload)...)
+	}
+	return segment
+}
+
+func TestFragmentRanges(t *testing.T) {
+	// ftyp 12 and moov 14 bytes, then moof 12 with mdat 8+len bytes each.
+	got := fragmentRanges(cmafSegment("aaaa", "bb", "cccccc"))
+	want := []byteRange{{26, 24}, {50, 22}, {72, 26}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ranges %v, want %v", got, want)
+	}
+	if ranges := fragmentRanges(append(cmafSegment("aaaa"), 0, 0, 1)); !reflect.DeepEqual(ranges, want[:1]) {
+		t.Errorf("truncated box changes the ranges: %v", ranges)
+	}
+	if ranges := fragmentRanges(cmafSegment()); ranges != nil {
+		t.Errorf("ranges %v of a segment without fragments", ranges)
+	}
+}
+
+func TestCMAFPartArgs(t *testing.T) {
+	r := NewEncodingRequest("/media/a.mp4", 0, 480)
+	r.container = containerFMP4
+	if r.usesCMAFParts() || r.keyframeSpacing(10) != 10 || r.fragmentArgs(10) != nil {
+		t.Error("fragments per part without -cmaf-parts")
+	}
+	plainKey := r.getCacheKey()
+
+	withCMAFParts(t)
+	if !r.usesCMAFParts() {
+		t.Fatal("fMP4 segment not encoded with parts")
+	}
+	if spacing := r.keyframeSpacing(10); spacing != 2 {
+		t.Errorf("keyframe spacing %v, want a part length of 2", spacing)
+	}
+	if args := r.fragmentArgs(10); !reflect.DeepEqual(args, []string{"-frag_duration", "2000000"}) {
+		t.Errorf("fragment args %v", args)
+	}
+	if !containsArgs(r.muxerArgs(0, 10), "-frag_duration", "2000000") {
+		t.Errorf("muxer args lack the fragment duration: %v", r.muxerArgs(0, 10))
+	}
+	if r.getCacheKey() == plainKey {
+		t.Error("parts do not change the cache key")
+	}
+
+	ts := NewEncodingRequest("/media/a.mp4", 0, 480)
+	part := *r
+	part.part = 1
+	if ts.usesCMAFParts() || part.usesCMAFParts() {
+		t.Error("MPEG-TS segment or single part encoded with parts")
+	}
+}
+
+func TestCMAFPlaylistByteRanges(t *testing.T) {
+	dir := withTestRoot(t)
+	withCMAFParts(t)
+	stream := *NewEncodingRequest(filepath.Join(dir, "a.mp4"), 0, 480)
+	stream.container = containerFMP4
+	cached := stream
+	cached.segment = 1
+	encoder.cacheSegment(cached, cmafSegment("aaaa", "bb", "cccccc", "d", "ee"))
+
+	ranges := encoder.segmentPartRanges(stream, 0, 3)
+	if len(ranges) != 1 || len(ranges[1]) != 5 {
+		t.Fatalf("part ranges %v, want those of the cached segment", ranges)
+	}
+	var buffer bytes.Buffer
+	writePlaylist(&buffer, "http://h/s", "http://h/init", "", 0, []float64{10, 10, 10}, nil, ranges, "VOD", true)
+	out := buffer.String()
+	for part, r := range ranges[1] {
+		tag := fmt.Sprintf(`URI="http://h/s/1.ts",BYTERANGE="%v@%v"`, r.length, r.offset)
+		if !strings.Contains(out, tag) {
+			t.Errorf("part %v not listed as %v:\n%v", part, tag, out)
+		}
+	}
+	// Uncached segments keep their separately encoded parts.
+	if !strings.Contains(out, `URI="http://h/s/0.4.ts"`) || !strings.Contains(out, `URI="http://h/s/2.0.ts"`) {
+		t.Errorf("parts of uncached segments not listed:\n%v", out)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// coalesceResolutions encodes queued requests for the same segment at other
+// resolutions together with the one a worker picks up, in one ffmpeg
+// process that decodes the source once. Unlike multiResolution it only
+// encodes what players are actually waiting for.
+var coalesceResolutions bool
+
+// coalescable reports whether r can share an encode with requests for its
+// segment at other resolutions. Only live requests are coalesced; warmups
+// are cheap to encode whenever their turn comes.
+func coalescable(r EncodingRequest) bool {
+	return coalesceResolutions && !dryRun && r.data != nil && r.part == wholeSegment && r.audioTrack == -1 && r.container == "" && r.watermark == "" && !r.timecode
+}
+
+// coalesceKey identifies r's segment regardless of its resolution.
+func coalesceKey(r EncodingRequest) string {
+	return fmt.Sprintf("%v:%v:%v:%v", r.file, r.audio, r.quality, r.segment)
+}
+
+// queuedResolutions tracks the coalescable requests in the encoder queue by
+// segment and resolution. Pending encodes are joined per cache key, so there
+// is at most one of each. Claimed requests are remembered by their data
+// channel until a worker takes them off the queue.
+type queuedResolutions struct {
+	mu      sync.Mutex
+	entries map[string]map[int64]EncodingRequest
+	claimed map[chan *[]byte]bool
+}
+
+// add registers r before it is queued.
+func (q *queuedResolutions) add(r EncodingRequest) {
+	if !coalescable(r) {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.entries == nil {
+		q.entries = make(map[string]map[int64]EncodingRequest)
+	}
+	key := coalesceKey(r)
+	if q.entries[key] == nil {
+		q.entries[key] = make(map[int64]EncodingRequest)
+	}
+	q.entries[key][r.res] = r
+}
+
+// take unregisters r as a worker picks it up. It returns false if r was
+// already encoded together with another request and must be skipped.
+func (q *queuedResolutions) take(r EncodingRequest) bool {
+	if !coalescable(r) {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.claimed[r.data] {
+		delete(q.claimed, r.data)
+		return false
+	}
+	key := coalesceKey(r)
+	if queued, ok := q.entries[key][r.res]; ok && queued.data == r.data {
+		delete(q.entries[key], r.res)
+		if len(q.entries[key]) == 0 {
+			delete(q.entries, key)
+		}
+	}
+	return true
+}
+
+// claim unregisters and returns the queued requests for r's segment at
+// other resolutions, which the caller encodes and delivers along with r.
+func (q *queuedResolutions) claim(r EncodingRequest) []EncodingRequest {
+	if !coalescable(r) {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := coalesceKey(r)
+	if q.claimed == nil {
+		q.claimed = make(map[chan *[]byte]bool)
+	}
+	var claimed []EncodingRequest
+	for res, queued := range q.entries[key] {
+		if res != r.res {
+			claimed = append(claimed, queued)
+			q.claimed[queued.data] = true
+		}
+	}
+	delete(q.entries, key)
+	return claimed
+}
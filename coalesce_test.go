@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func withCoalesceResolutions(t *testing.T) {
+	saved := coalesceResolutions
+	coalesceResolutions = true
+	t.Cleanup(func() { coalesceResolutions = saved })
+}
+
+func TestQueuedResolutions(t *testing.T) {
+	withCoalesceResolutions(t)
+	var q queuedResolutions
+	r480 := *NewEncodingRequest("/media/a.mp4", 3, 480)
+	r720 := *NewEncodingRequest("/media/a.mp4", 3, 720)
+	r1080 := *NewEncodingRequest("/media/a.mp4", 3, 1080)
+	otherSegment := *NewEncodingRequest("/media/a.mp4", 4, 720)
+	warmup := *NewWarmupEncodingRequest("/media/a.mp4", 3, 360)
+	for _, r := range []EncodingRequest{r480, r720, r1080, otherSegment, warmup} {
+		q.add(r)
+	}
+
+	claimed := q.claim(r480)
+	if len(claimed) != 2 {
+		t.Fatalf("claimed %v requests, want the 720 and 1080 ones", len(claimed))
+	}
+	for _, c := range claimed {
+		if c.segment != 3 || (c.res != 720 && c.res != 1080) {
+			t.Errorf("claimed %v:%v at %v", c.file, c.segment, c.res)
+		}
+	}
+	if !q.take(r480) {
+		t.Error("the claiming request is skipped")
+	}
+	if q.take(r720) || q.take(r1080) {
+		t.Error("claimed request encoded again")
+	}
+	// Claims are forgotten once the claimed request is taken.
+	if !q.take(r720) {
+		t.Error("claim kept after the request was skipped")
+	}
+	if !q.take(otherSegment) || !q.take(warmup) {
+		t.Error("unclaimed requests skipped")
+	}
+	if claimed := q.claim(otherSegment); len(claimed) != 0 {
+		t.Errorf("claimed %v requests of a taken segment", len(claimed))
+	}
+}
+
+func TestCoalescable(t *testing.T) {
+	r := *NewEncodingRequest("/media/a.mp4", 0, 480)
+	if coalescable(r) {
+		t.Error("coalesced while disabled")
+	}
+	withCoalesceResolutions(t)
+	if !coalescable(r) {
+		t.Error("live whole segment not coalesced")
+	}
+	part, audio, fmp4 := r, r, r
+	part.part = 1
+	audio.audioTrack = 0
+	fmp4.container = containerFMP4
+	warmup := *NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	for name, r := range map[string]EncodingRequest{"part": part, "audio": audio, "fmp4": fmp4, "warmup": warmup} {
+		if coalescable(r) {
+			t.Errorf("%v request coalesced", name)
+		}
+	}
+}
+
+func TestEncodeFailedRecordsFailure(t *testing.T) {
+	e := &Encoder{}
+	r := *NewEncodingRequest("/media/a.mp4", 5, 720)
+	events, done := e.progress.subscribe(r.file)
+	defer done()
+
+	e.encodeFailed(r, errors.New("exit status 1"))
+	if e.failures.count(r) != 1 {
+		t.Errorf("%v failures recorded, want 1", e.failures.count(r))
+	}
+	if records := e.errors.get(r.file); len(records) != 1 || records[0].Segment != 5 || records[0].Message != "exit status 1" {
+		t.Errorf("error history %+v", records)
+	}
+	if ev := <-events; ev.Status != progressFailed || ev.Resolution != 720 || ev.Error != "exit status 1" {
+		t.Errorf("progress event %+v", ev)
+	}
+	var encodeErr *EncodeError
+	if err := <-r.err; !errors.As(err, &encodeErr) || encodeErr.Segment != 5 {
+		t.Errorf("delivered %v, want an EncodeError for segment 5", err)
+	}
+}
+
+func TestEncodeDone(t *testing.T) {
+	withTestRoot(t)
+	e := &Encoder{cacheDir: "segments"}
+	r := *NewEncodingRequest("/media/a.mp4", 5, 720)
+	e.failures.record(r)
+	events, done := e.progress.subscribe(r.file)
+	defer done()
+
+	e.encodeDone(r, []byte("ts"))
+	if e.failures.count(r) != 0 {
+		t.Error("failures kept after a successful encode")
+	}
+	if ev := <-events; ev.Status != progressDone {
+		t.Errorf("progress event %+v", ev)
+	}
+	if data := <-r.data; string(*data) != "ts" {
+		t.Errorf("delivered %q", *data)
+	}
+	if data, err := ioutil.ReadFile(e.GetCacheFile(r)); err != nil || string(data) != "ts" {
+		t.Errorf("cached %q, %v", data, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// h264Profile is the libx264 profile that encodes pixelFormat, and its
+// profile_idc.
+func h264Profile() (string, int) {
+	switch pixelFormat {
+	case "yuv420p10le":
+		return "high10", 0x6e
+	case "yuv422p", "yuv422p10le":
+		return "high422", 0x7a
+	case "yuv444p", "yuv444p10le":
+		return "high444", 0xf4
+	}
+	return "high", 0x64
+}
+
+// h264Level is the H.264 level of an output res lines high at up to 30
+// frames per second, times ten as in level_idc.
+func h264Level(res int64) int {
+	switch {
+	case res <= 480:
+		return 30
+	case res <= 720:
+		return 31
+	case res <= 1080:
+		return 41
+	case res <= 1440:
+		return 50
+	case res <= 2160:
+		return 51
+	}
+	return 60
+}
+
+// profileArgs pins the profile and level of an fMP4 output of res lines, so
+// its codecs string is known without probing the segments.
+func profileArgs(res int64) []string {
+	profile, _ := h264Profile()
+	level := h264Level(res)
+	return []string{"-profile:v", profile, "-level", fmt.Sprintf("%v.%v", level/10, level%10)}
+}
+
+// videoCodecs is the RFC 6381 codecs string of an fMP4 output of res lines.
+func videoCodecs(res int64) string {
+	_, idc := h264Profile()
+	return fmt.Sprintf("avc1.%02X00%02X", idc, h264Level(res))
+}
+
+// audioCodecs is the RFC 6381 codecs string of audioCodec.
+func audioCodecs() string {
+	if audioCodec == "ac3" {
+		return "ac-3"
+	}
+	return "mp4a.40.2"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVideoCodecs(t *testing.T) {
+	for _, test := range []struct {
+		pixelFormat string
+		res         int64
+		want        string
+	}{
+		{"yuv420p", 480, "avc1.64001E"},
+		{"yuv420p", 720, "avc1.64001F"},
+		{"yuv420p", 1080, "avc1.640029"},
+		{"yuv420p", 2160, "avc1.640033"},
+		{"yuv420p10le", 1080, "avc1.6E0029"},
+		{"yuv444p", 720, "avc1.F4001F"},
+	} {
+		withPixelFormat(t, test.pixelFormat)
+		if got := videoCodecs(test.res); got != test.want {
+			t.Errorf("%v at %vp: codecs %v, want %v", test.pixelFormat, test.res, got, test.want)
+		}
+	}
+}
+
+func TestProfileArgs(t *testing.T) {
+	withPixelFormat(t, "yuv420p")
+	if got, want := profileArgs(1080), []string{"-profile:v", "high", "-level", "4.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profile args %v, want %v", got, want)
+	}
+
+	r := NewEncodingRequest("/media/a.mp4", 0, 720)
+	if containsArgs(EncodingArgs(*r, nil), "-profile:v") {
+		t.Error("MPEG-TS segment pins the profile")
+	}
+	ts := r.getCacheKey()
+	r.container = containerFMP4
+	if !containsArgs(EncodingArgs(*r, nil), "-profile:v", "high", "-level", "3.1") {
+		t.Errorf("fMP4 segment does not pin the profile: %v", EncodingArgs(*r, nil))
+	}
+	if r.getCacheKey() == ts {
+		t.Error("fMP4 and MPEG-TS segments share a cache key")
+	}
+}
+
+func TestAudioCodecs(t *testing.T) {
+	saved := audioCodec
+	t.Cleanup(func() { audioCodec = saved })
+	for codec, want := range map[string]string{"libfdk_aac": "mp4a.40.2", "aac": "mp4a.40.2", "ac3": "ac-3"} {
+		audioCodec = codec
+		if got := audioCodecs(); got != want {
+			t.Errorf("%v: codecs %v, want %v", codec, got, want)
+		}
+	}
+}
+
+func withPixelFormat(t *testing.T, format string) {
+	saved := pixelFormat
+	pixelFormat = format
+	t.Cleanup(func() { pixelFormat = saved })
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// pixelFormat is the pixel format of encoded video.
+	pixelFormat = "yuv420p"
+	// colorRange, colorPrimaries, colorTransfer and colorSpace tag the output
+	// with its color properties. Empty values leave ffmpeg's defaults.
+	colorRange     string
+	colorPrimaries string
+	colorTransfer  string
+	colorSpace     string
+)
+
+// Values libx264 and the ffmpeg color options accept.
+var (
+	pixelFormats       = []string{"yuv420p", "yuvj420p", "yuv422p", "yuv444p", "nv12", "yuv420p10le", "yuv422p10le", "yuv444p10le"}
+	colorRanges        = []string{"tv", "pc"}
+	colorPrimaryValues = []string{"bt709", "bt470bg", "smpte170m", "bt2020"}
+	colorTransfers     = []string{"bt709", "smpte170m", "bt2020-10", "smpte2084", "arib-std-b67"}
+	colorSpaces        = []string{"bt709", "bt470bg", "smpte170m", "bt2020nc"}
+)
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validateColor rejects unknown values and combinations that fail to
+// encode or display, such as HDR transfers on 8 bit output.
+func validateColor() error {
+	for _, c := range []struct {
+		name, value string
+		values      []string
+	}{
+		{"pixel format", pixelFormat, pixelFormats},
+		{"color range", colorRange, colorRanges},
+		{"color primaries", colorPrimaries, colorPrimaryValues},
+		{"color transfer", colorTransfer, colorTransfers},
+		{"color space", colorSpace, colorSpaces},
+	} {
+		if c.value != "" && !oneOf(c.value, c.values) {
+			return fmt.Errorf("Unsupported %v %v, expected one of %v", c.name, c.value, strings.Join(c.values, ","))
+		}
+	}
+	if pixelFormat == "" {
+		return fmt.Errorf("A pixel format is required")
+	}
+	tenBit := strings.HasSuffix(pixelFormat, "10le")
+	if (colorTransfer == "smpte2084" || colorTransfer == "arib-std-b67" || colorTransfer == "bt2020-10") && !tenBit {
+		return fmt.Errorf("Color transfer %v requires a 10 bit pixel format", colorTransfer)
+	}
+	if pixelFormat == "yuvj420p" && colorRange == "tv" {
+		return fmt.Errorf("Pixel format yuvj420p is always full range")
+	}
+	return nil
+}
+
+// colorArgs are the ffmpeg output options for the pixel format and color
+// properties.
+func colorArgs() []string {
+	args := []string{"-pix_fmt", pixelFormat}
+	for _, c := range [][2]string{
+		{"-color_range", colorRange},
+		{"-color_primaries", colorPrimaries},
+		{"-color_trc", colorTransfer},
+		{"-colorspace", colorSpace},
+	} {
+		if c[1] != "" {
+			args = append(args, c[0], c[1])
+		}
+	}
+	return args
+}
+
+// colorKey identifies non-default color settings in cache keys, and is
+// empty for the defaults so existing caches stay valid.
+func colorKey() string {
+	if pixelFormat == "yuv420p" && colorRange == "" && colorPrimaries == "" && colorTransfer == "" && colorSpace == "" {
+		return ""
+	}
+	return strings.Join([]string{pixelFormat, colorRange, colorPrimaries, colorTransfer, colorSpace}, ",")
+}
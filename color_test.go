@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// withColor sets the color options for the duration of a test.
+func withColor(t *testing.T, pix, rng, primaries, transfer, space string) {
+	old := [5]string{pixelFormat, colorRange, colorPrimaries, colorTransfer, colorSpace}
+	t.Cleanup(func() {
+		pixelFormat, colorRange, colorPrimaries, colorTransfer, colorSpace = old[0], old[1], old[2], old[3], old[4]
+	})
+	pixelFormat, colorRange, colorPrimaries, colorTransfer, colorSpace = pix, rng, primaries, transfer, space
+}
+
+func TestColorArgsDefault(t *testing.T) {
+	withColor(t, "yuv420p", "", "", "", "")
+	if args := colorArgs(); !reflect.DeepEqual(args, []string{"-pix_fmt", "yuv420p"}) {
+		t.Errorf("args %v", args)
+	}
+	if key := colorKey(); key != "" {
+		t.Errorf("default color key %q, want empty", key)
+	}
+}
+
+func TestColorArgsHDR(t *testing.T) {
+	withColor(t, "yuv420p10le", "tv", "bt2020", "smpte2084", "bt2020nc")
+	want := []string{
+		"-pix_fmt", "yuv420p10le",
+		"-color_range", "tv",
+		"-color_primaries", "bt2020",
+		"-color_trc", "smpte2084",
+		"-colorspace", "bt2020nc",
+	}
+	if args := colorArgs(); !reflect.DeepEqual(args, want) {
+		t.Errorf("args %v, want %v", args, want)
+	}
+	if err := validateColor(); err != nil {
+		t.Error(err)
+	}
+	if key := colorKey(); key != "yuv420p10le,tv,bt2020,smpte2084,bt2020nc" {
+		t.Errorf("color key %q", key)
+	}
+	args := EncodingArgs(*NewWarmupEncodingRequest("/media/a.mp4", 0, 480), nil)
+	if !containsArgs(args, "-pix_fmt", "yuv420p10le") || !containsArgs(args, "-color_trc", "smpte2084") {
+		t.Errorf("encoding args %v lack the color options", args)
+	}
+}
+
+func TestValidateColor(t *testing.T) {
+	tests := []struct {
+		pix, rng, transfer string
+		ok                 bool
+	}{
+		{"yuv420p", "", "", true},
+		{"yuv420p", "pc", "bt709", true},
+		{"rgb24", "", "", false},
+		{"", "", "", false},
+		{"yuv420p", "full", "", false},
+		{"yuv420p", "", "smpte2084", false},
+		{"yuv420p10le", "", "arib-std-b67", true},
+		{"yuvj420p", "tv", "", false},
+	}
+	for _, test := range tests {
+		withColor(t, test.pix, test.rng, "", test.transfer, "")
+		if err := validateColor(); (err == nil) != test.ok {
+			t.Errorf("%+v: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// concatPathPrefix starts the segment paths of concatenated streams below
+// /api/hls, followed by the comma separated sources.
+const concatPathPrefix = "/concat/"
+
+// concatPart is one source of a concatenated stream.
+type concatPart struct {
+	file      string
+	durations []float64
+	// first is the global index of the part's first segment.
+	first int64
+}
+
+// resolveConcat resolves the comma separated source names of a concatenated
+// stream.
+func resolveConcat(names string) ([]string, error) {
+	var files []string
+	for _, name := range strings.Split(names, ",") {
+		if name == "" {
+			return nil, fmt.Errorf("Empty source in %v", names)
+		}
+		file, err := resolveSource(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// concatParts segments every one of files on its own and numbers the
+// segments of all of them consecutively.
+func concatParts(files []string) ([]concatPart, error) {
+	parts := make([]concatPart, 0, len(files))
+	var next int64
+	for _, file := range files {
+		duration, err := sourceDuration(file)
+		if err != nil {
+			return nil, err
+		}
+		durations := segmentDurations(file, duration)
+		parts = append(parts, concatPart{file, durations, next})
+		next += int64(len(durations))
+	}
+	return parts, nil
+}
+
+// locateSegment maps the global segment index of a concatenated stream to
+// its source and the segment within that source.
+func locateSegment(parts []concatPart, segment int64) (string, int64, bool) {
+	for _, p := range parts {
+		if segment >= p.first && segment < p.first+int64(len(p.durations)) {
+			return p.file, segment - p.first, true
+		}
+	}
+	return "", 0, false
+}
+
+// resolveConcatSegment returns the source and local segment of segment of
+// the concatenated stream of names.
+func resolveConcatSegment(names string, segment int64) (string, int64, error) {
+	files, err := resolveConcat(names)
+	if err != nil {
+		return "", 0, err
+	}
+	parts, err := concatParts(files)
+	if err != nil {
+		return "", 0, err
+	}
+	file, local, ok := locateSegment(parts, segment)
+	if !ok {
+		return "", 0, fmt.Errorf("Segment %v is beyond the end of %v", segment, names)
+	}
+	return file, local, nil
+}
+
+// writeConcatPlaylist writes a VOD playlist of parts served below
+// segmentsURL, with a discontinuity at every join. initURLs are the
+// EXT-X-MAP of every part's fMP4 segments, nil for MPEG-TS; each part has its
+// own, so it is repeated after every discontinuity.
+func writeConcatPlaylist(w *bytes.Buffer, segmentsURL string, initURLs []string, query string, parts []concatPart) {
+	var all []float64
+	for _, p := range parts {
+		all = append(all, p.durations...)
+	}
+	p := newM3U8()
+	p.tag("#EXT-X-MEDIA-SEQUENCE:0")
+	p.tag("#EXT-X-TARGETDURATION:%.f", targetDuration(all))
+	p.tag("#EXT-X-PLAYLIST-TYPE:VOD")
+	for i, part := range parts {
+		for j, d := range part.durations {
+			if j == 0 {
+				if i > 0 {
+					p.tag("#EXT-X-DISCONTINUITY")
+				}
+				if initURLs != nil {
+					p.tag("#EXT-X-MAP:URI=\"%v\"", initURLs[i])
+				}
+			}
+			p.tag("#EXTINF:%f,", d)
+			p.uri("%v/%v%v", segmentsURL, segmentName(part.first+int64(j), wholeSegment), query)
+		}
+	}
+	p.tag("#EXT-X-ENDLIST")
+	p.WriteTo(w)
+}
+
+// concatPlaylist serves the playlist of several sources played back to back,
+// given as a comma separated list. It takes the stream options of playlist.
+func concatPlaylist(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	names := strings.TrimPrefix(params.ByName("files"), "/")
+	log.Debugf("Concat playlist request: %v", names)
+	files, err := resolveConcat(names)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	for _, file := range files {
+		if !checkMediaExtension(w, file) {
+			return
+		}
+		if err := checkSource(file); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	stream := NewEncodingRequest("", 0, defaultResolution)
+	if err := parseStreamOptions(r.URL.Query(), stream); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := urlEncoded(names)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	parts, err := concatParts(files)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var query string
+	if r.URL.RawQuery != "" {
+		query = "?" + r.URL.RawQuery
+	}
+
+	var initURLs []string
+	if stream.container == containerFMP4 {
+		for _, name := range strings.Split(names, ",") {
+			partID, err := urlEncoded(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, initURL := streamURLs(r.Host, partID, query)
+			initURLs = append(initURLs, initURL)
+		}
+	}
+
+	var buffer bytes.Buffer
+	writeConcatPlaylist(&buffer, fmt.Sprintf("http://%v/api/hls%v%v", r.Host, concatPathPrefix, id), initURLs, query, parts)
+	w.Header()["Content-Type"] = []string{"application/vnd.apple.mpegurl"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	servePlaylist(w, r, buffer.Bytes())
+}
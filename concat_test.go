@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// concatSources writes the files of durations below dir, analysed as media,
+// and returns the probes of their durations.
+func concatSources(t *testing.T, dir string, durations map[string]float64) *int {
+	for name := range durations {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		analyzed(t, file, sourceAnalysis{media: true})
+	}
+	libraryIndex.mu.Lock()
+	saved := libraryIndex.entries
+	libraryIndex.entries = nil
+	libraryIndex.mu.Unlock()
+	oldProbe := probeDuration
+	var mu sync.Mutex
+	probes := new(int)
+	probeDuration = func(p string) (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		*probes++
+		return durations[filepath.Base(p)], nil
+	}
+	t.Cleanup(func() {
+		probeDuration = oldProbe
+		libraryIndex.mu.Lock()
+		libraryIndex.entries = saved
+		libraryIndex.mu.Unlock()
+	})
+	return probes
+}
+
+func TestLocateSegment(t *testing.T) {
+	parts := []concatPart{
+		{"/media/a.mp4", []float64{10, 10, 10}, 0},
+		{"/media/b.mp4", []float64{10}, 3},
+		{"/media/c.mp4", []float64{10, 4}, 4},
+	}
+	for _, test := range []struct {
+		segment int64
+		file    string
+		local   int64
+	}{
+		{0, "/media/a.mp4", 0},
+		{2, "/media/a.mp4", 2},
+		{3, "/media/b.mp4", 0},
+		{4, "/media/c.mp4", 0},
+		{5, "/media/c.mp4", 1},
+	} {
+		file, local, ok := locateSegment(parts, test.segment)
+		if !ok || file != test.file || local != test.local {
+			t.Errorf("segment %v at %v:%v, %v, want %v:%v", test.segment, file, local, ok, test.file, test.local)
+		}
+	}
+	for _, segment := range []int64{-1, 6} {
+		if _, _, ok := locateSegment(parts, segment); ok {
+			t.Errorf("segment %v located", segment)
+		}
+	}
+}
+
+func TestResolveConcatSegment(t *testing.T) {
+	dir := withTestRoot(t)
+	probes := concatSources(t, dir, map[string]float64{"a.mp4": 30, "b.mp4": 20})
+	a, b := filepath.Join(dir, "a.mp4"), filepath.Join(dir, "b.mp4")
+
+	for segment, want := range map[int64]struct {
+		file  string
+		local int64
+	}{0: {a, 0}, 2: {a, 2}, 3: {b, 0}, 4: {b, 1}} {
+		file, local, err := resolveConcatSegment("a.mp4,b.mp4", segment)
+		if err != nil || file != want.file || local != want.local {
+			t.Errorf("segment %v at %v:%v, %v, want %v:%v", segment, file, local, err, want.file, want.local)
+		}
+	}
+	if file, local, err := resolveConcatSegment("b.mp4,a.mp4", 2); err != nil || file != a || local != 0 {
+		t.Errorf("reordered parts: segment 2 at %v:%v, %v", file, local, err)
+	}
+	if _, _, err := resolveConcatSegment("a.mp4,b.mp4", 5); err == nil {
+		t.Error("segment beyond the last part resolved")
+	}
+	for _, names := range []string{"a.mp4,", "a.mp4,../b.mp4"} {
+		if _, _, err := resolveConcatSegment(names, 0); err == nil {
+			t.Errorf("%v resolved", names)
+		}
+	}
+
+	// Durations are kept in the library index and probed again only once
+	// a part changes.
+	if *probes != 2 {
+		t.Errorf("probed %v times, want once per part", *probes)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(b, later, later); err != nil {
+		t.Fatal(err)
+	}
+	resolveConcatSegment("a.mp4,b.mp4", 0)
+	if *probes != 3 {
+		t.Errorf("probed %v times, want the changed part again", *probes)
+	}
+}
+
+func concatPlaylistBody(t *testing.T, query string) string {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://h/api/concat/a.mp4,b.mp4,c.mp4"+query, nil)
+	concatPlaylist(w, r, httprouter.Params{{Key: "files", Value: "/a.mp4,b.mp4,c.mp4"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v: %v", w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func TestConcatPlaylist(t *testing.T) {
+	dir := withTestRoot(t)
+	concatSources(t, dir, map[string]float64{"a.mp4": 20, "b.mp4": 10, "c.mp4": 20})
+
+	out := concatPlaylistBody(t, "")
+	for _, line := range []string{
+		"http://h/api/hls/concat/a.mp4,b.mp4,c.mp4/0.ts",
+		"http://h/api/hls/concat/a.mp4,b.mp4,c.mp4/4.ts",
+		"#EXT-X-ENDLIST",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("playlist lacks %v:\n%v", line, out)
+		}
+	}
+	if n := strings.Count(out, "#EXT-X-DISCONTINUITY"); n != 2 {
+		t.Errorf("%v discontinuities, want one per join:\n%v", n, out)
+	}
+	if strings.Index(out, "#EXT-X-DISCONTINUITY") < strings.Index(out, "/1.ts") || strings.Index(out, "#EXT-X-DISCONTINUITY") > strings.Index(out, "/2.ts") {
+		t.Errorf("first discontinuity not between the parts:\n%v", out)
+	}
+	if strings.Contains(out, "#EXT-X-MAP") {
+		t.Errorf("MPEG-TS playlist has an init segment:\n%v", out)
+	}
+}
+
+func TestConcatPlaylistFMP4(t *testing.T) {
+	dir := withTestRoot(t)
+	concatSources(t, dir, map[string]float64{"a.mp4": 20, "b.mp4": 10, "c.mp4": 20})
+
+	lines := strings.Split(concatPlaylistBody(t, "?container=fmp4"), "\n")
+	var maps []string
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "#EXT-X-MAP:") {
+			continue
+		}
+		maps = append(maps, line)
+		if len(maps) > 1 && (i == 0 || lines[i-1] != "#EXT-X-DISCONTINUITY") {
+			t.Errorf("%v does not follow a discontinuity", line)
+		}
+	}
+	want := []string{
+		`#EXT-X-MAP:URI="http://h/api/init/a.mp4?container=fmp4"`,
+		`#EXT-X-MAP:URI="http://h/api/init/b.mp4?container=fmp4"`,
+		`#EXT-X-MAP:URI="http://h/api/init/c.mp4?container=fmp4"`,
+	}
+	if strings.Join(maps, "\n") != strings.Join(want, "\n") {
+		t.Errorf("init segments %v, want %v", maps, want)
+	}
+}
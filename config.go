@@ -0,0 +1,42 @@
+package main
+
+import "flag"
+
+const redacted = "<redacted>"
+
+// secretFlags names flags whose values must never be reported.
+var secretFlags = map[string]bool{}
+
+type runtimeConfig struct {
+	Root          string            `json:"root"`
+	CacheDir      string            `json:"cacheDir"`
+	FFmpeg        string            `json:"ffmpeg"`
+	FFprobe       string            `json:"ffprobe"`
+	SegmentLength float64           `json:"segmentLength"`
+	AudioCodec    string            `json:"audioCodec"`
+	Flags         map[string]string `json:"flags"`
+}
+
+// resolvedConfig reports the effective configuration, including the value
+// of every command line flag whether set or defaulted.
+func resolvedConfig(flags *flag.FlagSet) runtimeConfig {
+	c := runtimeConfig{
+		Root:          root,
+		FFmpeg:        FFMPEGPath,
+		FFprobe:       FFPROBEPath,
+		SegmentLength: hlsSegmentLength,
+		AudioCodec:    audioCodec,
+		Flags:         make(map[string]string),
+	}
+	if encoder != nil {
+		c.CacheDir = encoder.cacheDirPath()
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = redacted
+		}
+		c.Flags[f.Name] = value
+	})
+	return c
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestResolvedConfig(t *testing.T) {
+	dir := withTestRoot(t)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("workers", 2, "")
+	flags.String("key", "", "")
+	flags.String("empty-key", "", "")
+	if err := flags.Parse([]string{"-workers", "4", "-key", "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	secretFlags["key"], secretFlags["empty-key"] = true, true
+	t.Cleanup(func() {
+		delete(secretFlags, "key")
+		delete(secretFlags, "empty-key")
+	})
+
+	c := resolvedConfig(flags)
+	if c.Root != dir {
+		t.Errorf("root %q, want %q", c.Root, dir)
+	}
+	if c.SegmentLength != hlsSegmentLength || c.FFmpeg != FFMPEGPath || c.AudioCodec != audioCodec {
+		t.Errorf("config %+v does not match the globals", c)
+	}
+	if c.CacheDir == "" {
+		t.Error("cache directory missing")
+	}
+	want := map[string]string{"workers": "4", "key": redacted, "empty-key": ""}
+	for name, value := range want {
+		if c.Flags[name] != value {
+			t.Errorf("flag %v = %q, want %q", name, c.Flags[name], value)
+		}
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	withTestRoot(t)
+	w := httptest.NewRecorder()
+	configHandler(w, httptest.NewRequest("GET", "/api/config", nil), httprouter.Params{})
+	var c map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"root", "cacheDir", "ffmpeg", "ffprobe", "segmentLength", "audioCodec", "flags"} {
+		if _, ok := c[field]; !ok {
+			t.Errorf("config lacks %v: %v", field, w.Body.String())
+		}
+	}
+}
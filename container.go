@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	containerTS   = "ts"
+	containerFMP4 = "fmp4"
+)
+
+const (
+	timestampsAbsolute = "absolute"
+	timestampsRelative = "relative"
+)
+
+// fmp4Timestamps selects the baseMediaDecodeTime of fMP4 segments: absolute
+// continues the source timeline, so segment n starts at its position in the
+// stream; relative starts every segment at zero.
+var fmp4Timestamps = timestampsAbsolute
+
+func validateFMP4Timestamps(mode string) error {
+	switch mode {
+	case timestampsAbsolute, timestampsRelative:
+		return nil
+	}
+	return fmt.Errorf("Unknown fMP4 timestamp mode %v, expected absolute or relative", mode)
+}
+
+// containers maps the ?container values to their response content type.
+// Both carry the H.264 and AAC streams the encoder produces.
+var containers = map[string]string{
+	containerTS:   "video/MP2T",
+	containerFMP4: "video/mp4",
+}
+
+// parseContainer returns the requested segment container, or "" for MPEG-TS.
+func parseContainer(q url.Values) (string, error) {
+	value := q.Get("container")
+	if value == "" || value == containerTS {
+		return "", nil
+	}
+	if _, ok := containers[value]; !ok {
+		return "", fmt.Errorf("Unsupported container %v, expected ts or fmp4", value)
+	}
+	return value, nil
+}
+
+// contentType is the Content-Type of r's segment.
+func (r *EncodingRequest) contentType() string {
+	if r.container == "" {
+		return containers[containerTS]
+	}
+	return containers[r.container]
+}
+
+// muxerArgs are the output options writing r's segment to stdout.
+func (r *EncodingRequest) muxerArgs(startTime float64, length float64) []string {
+	if r.container == containerFMP4 {
+		return r.muxerArgsTo(startTime, length, "pipe:1")
+	}
+	return r.muxerArgsTo(startTime, length, "pipe:out%03d.ts")
+}
+
+// muxerArgsTo are the output options writing r's segment to output, a file
+// name pattern for MPEG-TS. Fragmented MP4 segments start with their own
+// moov box so each plays on its own, and are offset to their start time
+// unless fmp4Timestamps is relative.
+func (r *EncodingRequest) muxerArgsTo(startTime float64, length float64, output string) []string {
+	if r.container == containerFMP4 {
+		args := []string{
+			"-f", "mp4",
+			"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		}
+		args = append(args, r.fragmentArgs(length)...)
+		if fmp4Timestamps == timestampsAbsolute {
+			args = append(args, "-output_ts_offset", fmt.Sprintf("%.2f", startTime))
+		}
+		return append(args, output)
+	}
+	return []string{
+		"-f", "ssegment",
+		"-segment_time", fmt.Sprintf("%.2f", length),
+		"-initial_offset", fmt.Sprintf("%.2f", startTime),
+		output,
+	}
+}
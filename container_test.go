@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseContainer(t *testing.T) {
+	for value, want := range map[string]string{"": "", "ts": "", "fmp4": containerFMP4} {
+		if got, err := parseContainer(url.Values{"container": {value}}); err != nil || got != want {
+			t.Errorf("container %q = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"webm", "mkv", "FMP4"} {
+		if _, err := parseContainer(url.Values{"container": {value}}); err == nil {
+			t.Errorf("container %q accepted", value)
+		}
+	}
+}
+
+func TestContainerArgs(t *testing.T) {
+	r := NewEncodingRequest("/media/a.mp4", 2, 480)
+	args := EncodingArgs(*r, nil)
+	if !containsArgs(args, "-f", "ssegment") || args[len(args)-1] != "pipe:out%03d.ts" || r.contentType() != "video/MP2T" {
+		t.Errorf("MPEG-TS segment args %v, content type %v", args, r.contentType())
+	}
+
+	plainKey := r.getCacheKey()
+	r.container = containerFMP4
+	args = EncodingArgs(*r, nil)
+	if !containsArgs(args, "-f", "mp4", "-movflags", "frag_keyframe+empty_moov+default_base_moof") || args[len(args)-1] != "pipe:1" {
+		t.Errorf("fMP4 segment args %v", args)
+	}
+	if containsArgs(args, "-f", "ssegment") {
+		t.Errorf("fMP4 segment still uses the segment muxer: %v", args)
+	}
+	if r.contentType() != "video/mp4" {
+		t.Errorf("fMP4 content type %v", r.contentType())
+	}
+	if r.getCacheKey() == plainKey {
+		t.Error("container does not change the cache key")
+	}
+}
+
+func withFMP4Timestamps(t *testing.T, mode string) {
+	old := fmp4Timestamps
+	fmp4Timestamps = mode
+	t.Cleanup(func() { fmp4Timestamps = old })
+}
+
+func TestFMP4TimestampArgs(t *testing.T) {
+	r := NewEncodingRequest("/media/a.mp4", 3, 480)
+	r.container = containerFMP4
+	withFMP4Timestamps(t, timestampsAbsolute)
+	if args := r.muxerArgs(30, 10); !containsArgs(args, "-output_ts_offset", "30.00", "pipe:1") {
+		t.Errorf("absolute timestamps args %v", args)
+	}
+	absoluteKey := r.getCacheKey()
+
+	fmp4Timestamps = timestampsRelative
+	if args := r.muxerArgs(30, 10); containsArgs(args, "-output_ts_offset") || args[len(args)-1] != "pipe:1" {
+		t.Errorf("relative timestamps args %v", args)
+	}
+	if r.getCacheKey() == absoluteKey {
+		t.Error("timestamp mode does not change the fMP4 cache key")
+	}
+
+	// MPEG-TS segments are unaffected.
+	ts := NewEncodingRequest("/media/a.mp4", 3, 480)
+	relativeKey := ts.getCacheKey()
+	fmp4Timestamps = timestampsAbsolute
+	if ts.getCacheKey() != relativeKey || !containsArgs(ts.muxerArgs(30, 10), "-initial_offset", "30.00") {
+		t.Error("timestamp mode changes MPEG-TS segments")
+	}
+}
+
+func TestValidateFMP4Timestamps(t *testing.T) {
+	for _, mode := range []string{timestampsAbsolute, timestampsRelative} {
+		if err := validateFMP4Timestamps(mode); err != nil {
+			t.Errorf("%v: %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"", "zero"} {
+		if validateFMP4Timestamps(mode) == nil {
+			t.Errorf("mode %q accepted", mode)
+		}
+	}
+}
+
+func TestSegmentRejectsUnknownContainer(t *testing.T) {
+	withTestRoot(t)
+	w := httptest.NewRecorder()
+	hls(w, httptest.NewRequest("GET", "/api/hls/segments/a.mp4/0.ts?container=webm", nil), segmentParams("a.mp4/0.ts"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown container: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
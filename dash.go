@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// streamURLs are the base URL of the segments of the source id and the URL
+// of its fMP4 init segment. The HLS playlist and the DASH manifest both
+// reference these, so one set of CMAF segments serves both protocols.
+func streamURLs(host, id, query string) (segmentsURL, initURL string) {
+	return fmt.Sprintf("http://%v/api/hls/segments/%v", host, id), fmt.Sprintf("http://%v/api/init/%v%v", host, id, query)
+}
+
+type mpd struct {
+	XMLName                   xml.Name           `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Type                      string             `xml:"type,attr"`
+	Profiles                  string             `xml:"profiles,attr"`
+	MinBufferTime             string             `xml:"minBufferTime,attr"`
+	MediaPresentationDuration string             `xml:"mediaPresentationDuration,attr"`
+	AdaptationSets            []mpdAdaptationSet `xml:"Period>AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	MimeType         string `xml:"mimeType,attr"`
+	Lang             string `xml:"lang,attr,omitempty"`
+	SegmentAlignment bool   `xml:"segmentAlignment,attr"`
+	Representation   struct {
+		ID          string `xml:"id,attr"`
+		Codecs      string `xml:"codecs,attr"`
+		Bandwidth   int64  `xml:"bandwidth,attr"`
+		SegmentList struct {
+			Timescale      int `xml:"timescale,attr"`
+			Initialization struct {
+				SourceURL string `xml:"sourceURL,attr"`
+			} `xml:"Initialization"`
+			Timeline []mpdTimelineEntry `xml:"SegmentTimeline>S"`
+			URLs     []mpdSegmentURL    `xml:"SegmentURL"`
+		} `xml:"SegmentList"`
+	} `xml:"Representation"`
+}
+
+type mpdTimelineEntry struct {
+	Duration int64 `xml:"d,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// mpdTimescale is the number of ticks per second of segment durations.
+const mpdTimescale = 1000
+
+// audioBandwidth is the bandwidth advertised for an audio adaptation set.
+const audioBandwidth = 128000
+
+// dashStream is an adaptation set of a DASH manifest: the video of stream
+// without its audio, or the audio track stream.audioTrack alone.
+type dashStream struct {
+	stream EncodingRequest
+	// values are the stream options of its segment URLs.
+	values url.Values
+	lang   string
+}
+
+// dashStreams splits stream into a video adaptation set and one per audio
+// track, or only the one ?audiotrack selected.
+func dashStreams(stream EncodingRequest, values url.Values, info *videoInfo, tracks []audioTrack) []dashStream {
+	with := func(track int) url.Values {
+		v := url.Values{}
+		for key, value := range values {
+			v[key] = value
+		}
+		v.Set("audiotrack", formatAudioTrack(track))
+		return v
+	}
+	var streams []dashStream
+	if info != nil && stream.audioTrack < 0 {
+		video := stream
+		video.audioTrack = noAudioTrack
+		streams = append(streams, dashStream{video, with(noAudioTrack), ""})
+	}
+	for _, t := range tracks {
+		if stream.audioTrack == -1 || stream.audioTrack == t.Index {
+			audio := stream
+			audio.audioTrack = t.Index
+			streams = append(streams, dashStream{audio, with(t.Index), t.Language})
+		}
+	}
+	return streams
+}
+
+// writeMPD writes a static DASH manifest of the fMP4 segments of streams of
+// the source id with the given durations. The media segments are those of
+// the HLS playlist of each stream, served without their init boxes.
+func writeMPD(w *bytes.Buffer, host, id string, streams []dashStream, durations []float64) error {
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+	m := mpd{
+		Type:                      "static",
+		Profiles:                  "urn:mpeg:dash:profile:isoff-main:2011",
+		MinBufferTime:             fmt.Sprintf("PT%.fS", hlsSegmentLength),
+		MediaPresentationDuration: fmt.Sprintf("PT%.3fS", total),
+	}
+	for _, s := range streams {
+		var as mpdAdaptationSet
+		as.SegmentAlignment = true
+		as.Lang = s.lang
+		rep := &as.Representation
+		if s.stream.audioTrack >= 0 {
+			as.MimeType = "audio/mp4"
+			rep.ID = fmt.Sprintf("audio%v", s.stream.audioTrack)
+			rep.Codecs = audioCodecs()
+			rep.Bandwidth = audioBandwidth
+		} else {
+			as.MimeType = "video/mp4"
+			rep.ID = fmt.Sprintf("%v", s.stream.res)
+			rep.Codecs = videoCodecs(s.stream.res)
+			rep.Bandwidth = estimateBandwidth(s.stream.res)
+		}
+
+		segmentsURL, initURL := streamURLs(host, id, "?"+s.values.Encode())
+		media := url.Values{"boxes": {"media"}}
+		for key, value := range s.values {
+			media[key] = value
+		}
+		query := "?" + media.Encode()
+		list := &rep.SegmentList
+		list.Timescale = mpdTimescale
+		list.Initialization.SourceURL = initURL
+		for i, d := range durations {
+			list.Timeline = append(list.Timeline, mpdTimelineEntry{int64(d*mpdTimescale + 0.5)})
+			list.URLs = append(list.URLs, mpdSegmentURL{fmt.Sprintf("%v/%v%v", segmentsURL, segmentName(int64(i), wholeSegment), query)})
+		}
+		m.AdaptationSets = append(m.AdaptationSets, as)
+	}
+
+	w.WriteString(xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	w.WriteString("\n")
+	return nil
+}
+
+// dashManifest serves the DASH manifest of a source, with the video and each
+// audio track in an adaptation set of its own. It takes the stream options
+// of playlist and always references fMP4 segments, the same ones a playlist
+// requested with container=fmp4 and the audiotrack of the set lists, so a
+// dual protocol deployment encodes and caches every segment once.
+func dashManifest(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("DASH manifest request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+	// Relative timestamps restart every segment, which the continuous DASH
+	// timeline cannot describe.
+	if fmp4Timestamps != timestampsAbsolute {
+		http.Error(w, "DASH needs absolute fMP4 timestamps", http.StatusConflict)
+		return
+	}
+	id, err := sourceID(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stream := NewWarmupEncodingRequest(file, 0, defaultResolution)
+	values, err := parseStreamQuery(r.URL.Query(), stream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The adaptation sets carry the audio tracks of the source.
+	if stream.audio != "" {
+		http.Error(w, "DASH serves the audio tracks of the source, not ?audio", http.StatusBadRequest)
+		return
+	}
+	stream.container = containerFMP4
+	values.Set("container", containerFMP4)
+
+	if err := checkSource(file); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	duration, err := sourceDuration(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	limited, ok := limitDuration(w, duration)
+	if !ok {
+		return
+	}
+	info, _ := sourceVideoInfo(file)
+	tracks, err := sourceAudioTracks(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	streams := dashStreams(*stream, values, info, tracks)
+	if len(streams) == 0 {
+		http.Error(w, "The source has no such stream", http.StatusNotFound)
+		return
+	}
+	for _, s := range streams {
+		pinned.pin(s.stream)
+	}
+
+	var buffer bytes.Buffer
+	if err := writeMPD(&buffer, r.Host, id, streams, segmentDurations(file, limited)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/dash+xml"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	servePlaylist(w, r, buffer.Bytes())
+}
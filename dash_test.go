@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var dashParams = httprouter.Params{{Key: "filename", Value: "/a.mp4"}}
+
+// dashSource analyses the 25 second a.mp4 in dir as a 720p video with an
+// English and a German audio track.
+func dashSource(t *testing.T, dir string, info *videoInfo) {
+	file := indexDuration(t, dir, 25)
+	analyzed(t, file, sourceAnalysis{media: true, info: info, tracks: []audioTrack{
+		{Index: 0, Language: "eng", Default: true},
+		{Index: 1, Language: "deu"},
+	}})
+}
+
+func dashRequest(t *testing.T, query string) (*httptest.ResponseRecorder, mpd) {
+	w := httptest.NewRecorder()
+	dashManifest(w, httptest.NewRequest("GET", "http://h/api/dash/a.mp4"+query, nil), dashParams)
+	var m mpd
+	if w.Code == http.StatusOK {
+		if err := xml.Unmarshal(w.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, m
+}
+
+func TestDASHAndHLSShareSegments(t *testing.T) {
+	dir := withTestRoot(t)
+	dashSource(t, dir, &videoInfo{Width: 1280, Height: 720})
+
+	w := httptest.NewRecorder()
+	playlist(w, httptest.NewRequest("GET", "http://h/api/playlist/a.mp4?container=fmp4&res=720&audiotrack=none", nil), dashParams)
+	if w.Code != http.StatusOK {
+		t.Fatalf("playlist status %v: %v", w.Code, w.Body)
+	}
+	var hlsInit string
+	var hlsSegments []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:URI="):
+			hlsInit = strings.Trim(strings.TrimPrefix(line, "#EXT-X-MAP:URI="), `"`)
+		case line != "" && !strings.HasPrefix(line, "#"):
+			hlsSegments = append(hlsSegments, line)
+		}
+	}
+
+	w, m := dashRequest(t, "?res=720")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/dash+xml" {
+		t.Fatalf("manifest status %v, type %v: %v", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if len(m.AdaptationSets) != 3 {
+		t.Fatalf("%v adaptation sets, want the video and two audio tracks", len(m.AdaptationSets))
+	}
+	video := m.AdaptationSets[0]
+	list := video.Representation.SegmentList
+	// The DASH segments are the HLS ones without their init boxes.
+	var dashSegments []string
+	for _, u := range list.URLs {
+		if !strings.Contains(u.Media, "boxes=media") {
+			t.Errorf("segment %v is served with its init boxes", u.Media)
+		}
+		dashSegments = append(dashSegments, strings.Replace(u.Media, "boxes=media&", "", 1))
+	}
+
+	if hlsInit == "" || list.Initialization.SourceURL != hlsInit {
+		t.Errorf("DASH init %v, HLS init %v", list.Initialization.SourceURL, hlsInit)
+	}
+	if len(hlsSegments) != 3 || !reflect.DeepEqual(dashSegments, hlsSegments) {
+		t.Errorf("DASH segments %v, HLS segments %v", dashSegments, hlsSegments)
+	}
+	if d := list.Timeline; len(d) != 3 || d[0].Duration != 10000 || d[2].Duration != 5000 {
+		t.Errorf("timeline %v", d)
+	}
+	if m.MediaPresentationDuration != "PT25.000S" || video.Representation.ID != "720" {
+		t.Errorf("manifest %+v", m)
+	}
+}
+
+func TestDASHCodecs(t *testing.T) {
+	dir := withTestRoot(t)
+	dashSource(t, dir, &videoInfo{Width: 1920, Height: 1080})
+
+	w, m := dashRequest(t, "?res=720")
+	if w.Code != http.StatusOK || len(m.AdaptationSets) != 3 {
+		t.Fatalf("status %v, %v adaptation sets: %v", w.Code, len(m.AdaptationSets), w.Body)
+	}
+	for i, want := range []struct {
+		mimeType, codecs, lang, audiotrack string
+	}{
+		{"video/mp4", "avc1.64001F", "", "audiotrack=none"},
+		{"audio/mp4", "mp4a.40.2", "eng", "audiotrack=0"},
+		{"audio/mp4", "mp4a.40.2", "deu", "audiotrack=1"},
+	} {
+		as := m.AdaptationSets[i]
+		if as.MimeType != want.mimeType || as.Representation.Codecs != want.codecs || as.Lang != want.lang {
+			t.Errorf("adaptation set %v: %v, codecs %q, lang %q, want %v, %q, %q", i, as.MimeType, as.Representation.Codecs, as.Lang, want.mimeType, want.codecs, want.lang)
+		}
+		list := as.Representation.SegmentList
+		if !strings.Contains(list.Initialization.SourceURL, want.audiotrack) || !strings.Contains(list.URLs[0].Media, want.audiotrack) {
+			t.Errorf("adaptation set %v does not reference %v: %v", i, want.audiotrack, list.URLs[0].Media)
+		}
+	}
+}
+
+func TestDASHStreamSelection(t *testing.T) {
+	dir := withTestRoot(t)
+	dashSource(t, dir, nil)
+
+	if w, m := dashRequest(t, ""); w.Code != http.StatusOK || len(m.AdaptationSets) != 2 || m.AdaptationSets[0].MimeType != "audio/mp4" {
+		t.Errorf("audio only source: status %v, sets %+v", w.Code, m.AdaptationSets)
+	}
+	if w, m := dashRequest(t, "?audiotrack=1"); w.Code != http.StatusOK || len(m.AdaptationSets) != 1 || m.AdaptationSets[0].Lang != "deu" {
+		t.Errorf("selected track: status %v, sets %+v", w.Code, m.AdaptationSets)
+	}
+	if w, _ := dashRequest(t, "?audiotrack=none"); w.Code != http.StatusNotFound {
+		t.Errorf("video of an audio only source: status %v, want %v", w.Code, http.StatusNotFound)
+	}
+	if w, _ := dashRequest(t, "?audio=b.mp4"); w.Code != http.StatusBadRequest {
+		t.Errorf("external audio: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDASHNeedsAbsoluteTimestamps(t *testing.T) {
+	dir := withTestRoot(t)
+	indexDuration(t, dir, 25)
+	withFMP4Timestamps(t, timestampsRelative)
+	w := httptest.NewRecorder()
+	dashManifest(w, httptest.NewRequest("GET", "http://h/api/dash/a.mp4", nil), dashParams)
+	if w.Code != http.StatusConflict {
+		t.Errorf("relative timestamps: status %v, want %v", w.Code, http.StatusConflict)
+	}
+}
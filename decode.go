@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	// encodeWorkers is the number of segments encoded at once.
+	encodeWorkers = 1
+	// decodeBudget is the total decode weight of the encodes running at
+	// once, 0 for no limit. An encode weighs one per 1080p worth of source
+	// pixels, so a 4K source takes four times the budget of a 1080p one.
+	decodeBudget int64
+)
+
+// decodeUnit is the source pixel count weighing one.
+const decodeUnit = 1920 * 1080
+
+func validateEncodeWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("Encode worker count %v must be at least 1", workers)
+	}
+	return nil
+}
+
+// weightedSemaphore admits holders as long as their weights add up to no
+// more than its size.
+type weightedSemaphore struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int64
+	used int64
+}
+
+func newWeightedSemaphore(size int64) *weightedSemaphore {
+	s := &weightedSemaphore{size: size}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// acquire waits until n fits into the semaphore and takes it. n is capped
+// at the size, so a heavy holder waits for an empty semaphore instead of
+// blocking forever.
+func (s *weightedSemaphore) acquire(n int64) int64 {
+	if n > s.size {
+		n = s.size
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.used+n > s.size {
+		s.cond.Wait()
+	}
+	s.used += n
+	return n
+}
+
+// release returns n taken by acquire.
+func (s *weightedSemaphore) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= n
+	s.cond.Broadcast()
+}
+
+// decodeWeight is the share of decodeBudget an encode of a source described
+// by info takes, at least one. Sources that could not be probed weigh one.
+func decodeWeight(info *videoInfo) int64 {
+	if info == nil {
+		return 1
+	}
+	weight := (int64(info.Width)*int64(info.Height) + decodeUnit - 1) / decodeUnit
+	if weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// admitDecode waits until an encode of info into outputs resolutions fits
+// into decodeBudget. Each output weighs as much as encoding it on its own.
+// The returned function gives its share back.
+func (e *Encoder) admitDecode(info *videoInfo, outputs int) func() {
+	if e.decodes == nil {
+		return func() {}
+	}
+	n := e.decodes.acquire(decodeWeight(info) * int64(outputs))
+	return func() { e.decodes.release(n) }
+}
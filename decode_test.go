@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeWeight(t *testing.T) {
+	for _, tt := range []struct {
+		info *videoInfo
+		want int64
+	}{
+		{nil, 1},
+		{&videoInfo{Width: 640, Height: 360}, 1},
+		{&videoInfo{Width: 1920, Height: 1080}, 1},
+		{&videoInfo{Width: 2560, Height: 1440}, 2},
+		{&videoInfo{Width: 3840, Height: 2160}, 4},
+		{&videoInfo{}, 1},
+	} {
+		if got := decodeWeight(tt.info); got != tt.want {
+			t.Errorf("weight of %+v = %v, want %v", tt.info, got, tt.want)
+		}
+	}
+}
+
+// acquired reports whether acquire(n) on s returns within a short wait, and
+// the weight it took.
+func acquired(s *weightedSemaphore, n int64) (int64, bool) {
+	taken := make(chan int64, 1)
+	go func() { taken <- s.acquire(n) }()
+	select {
+	case got := <-taken:
+		return got, true
+	case <-time.After(50 * time.Millisecond):
+		go func() { s.release(<-taken) }()
+		return 0, false
+	}
+}
+
+func TestWeightedAdmission(t *testing.T) {
+	s := newWeightedSemaphore(4)
+	// Four 1080p encodes fit, a fifth waits.
+	for i := 0; i < 4; i++ {
+		if _, ok := acquired(s, 1); !ok {
+			t.Fatalf("1080p encode %v not admitted", i+1)
+		}
+	}
+	if _, ok := acquired(s, 1); ok {
+		t.Error("encode admitted beyond the budget")
+	}
+	for i := 0; i < 4; i++ {
+		s.release(1)
+	}
+
+	// A 4K encode takes the whole budget.
+	n, ok := acquired(s, 4)
+	if !ok {
+		t.Fatal("4K encode not admitted into an empty budget")
+	}
+	if _, ok := acquired(s, 1); ok {
+		t.Error("1080p encode admitted next to a 4K one")
+	}
+	s.release(n)
+
+	// Heavier than the budget waits for it to be empty instead of forever.
+	if n, ok := acquired(s, 9); !ok || n != 4 {
+		t.Errorf("8K encode took %v, %v, want the whole budget", n, ok)
+	}
+}
+
+func TestAdmitDecodeUnlimited(t *testing.T) {
+	e := &Encoder{}
+	for i := 0; i < 10; i++ {
+		e.admitDecode(&videoInfo{Width: 3840, Height: 2160}, 3)
+	}
+}
+
+func TestAdmitDecodeResolutions(t *testing.T) {
+	e := &Encoder{decodes: newWeightedSemaphore(4)}
+	// Three 1080p outputs of one decode weigh as much as three encodes.
+	release := e.admitDecode(&videoInfo{Width: 1920, Height: 1080}, 3)
+	if _, ok := acquired(e.decodes, 2); ok {
+		t.Error("encode admitted next to three resolutions beyond the budget")
+	}
+	n, ok := acquired(e.decodes, 1)
+	if !ok {
+		t.Error("encode not admitted into the rest of the budget")
+	}
+	e.decodes.release(n)
+	release()
+}
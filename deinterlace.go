@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+const (
+	deinterlaceAuto = "auto"
+	deinterlaceOn   = "on"
+	deinterlaceOff  = "off"
+)
+
+// deinterlace selects when yadif runs before scaling: for sources probed as
+// interlaced, always, or never.
+var deinterlace = deinterlaceAuto
+
+func validateDeinterlace(mode string) error {
+	switch mode {
+	case deinterlaceAuto, deinterlaceOn, deinterlaceOff:
+		return nil
+	}
+	return fmt.Errorf("Invalid deinterlace mode %v, expected auto, on or off", mode)
+}
+
+// deinterlaceFor reports whether a source described by info is deinterlaced.
+// info is nil if the source could not be probed.
+func deinterlaceFor(info *videoInfo) bool {
+	switch deinterlace {
+	case deinterlaceOn:
+		return true
+	case deinterlaceAuto:
+		return info != nil && info.IsInterlaced()
+	}
+	return false
+}
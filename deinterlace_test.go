@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+)
+
+func withDeinterlace(t *testing.T, mode string) {
+	old := deinterlace
+	deinterlace = mode
+	t.Cleanup(func() { deinterlace = old })
+}
+
+func TestVideoFilterDeinterlace(t *testing.T) {
+	interlaced := &videoInfo{Width: 720, Height: 576, FieldOrder: "tt"}
+	progressive := &videoInfo{Width: 1280, Height: 720, FieldOrder: "progressive"}
+	tests := []struct {
+		mode string
+		info *videoInfo
+		want string
+	}{
+		{deinterlaceAuto, interlaced, "yadif,scale=-2:480"},
+		{deinterlaceAuto, progressive, "scale=-2:480"},
+		{deinterlaceAuto, nil, "scale=-2:480"},
+		{deinterlaceOn, progressive, "yadif,scale=-2:480"},
+		{deinterlaceOff, interlaced, "scale=-2:480"},
+	}
+	for _, test := range tests {
+		withDeinterlace(t, test.mode)
+		if f := videoFilter(480, test.info); f != test.want {
+			t.Errorf("%v %+v: filter %q, want %q", test.mode, test.info, f, test.want)
+		}
+	}
+}
+
+func TestParseFieldOrder(t *testing.T) {
+	for order, interlaced := range map[string]bool{"tt": true, "bb": true, "tb": true, "bt": true, "progressive": false, "unknown": false, "": false} {
+		info, err := parseVideoInfo([]byte(fmt.Sprintf(`{"streams":[{"width":720,"height":576,"field_order":%q}]}`, order)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.FieldOrder != order || info.IsInterlaced() != interlaced {
+			t.Errorf("field order %q: parsed %q, interlaced %v", order, info.FieldOrder, info.IsInterlaced())
+		}
+	}
+}
+
+func TestCacheKeyDeinterlace(t *testing.T) {
+	r := NewWarmupEncodingRequest("/media/a.mp4", 2, 480)
+	want := fmt.Sprintf("%x.480.2", sha1.Sum([]byte("/media/a.mp4")))
+	if key := r.getCacheKey(); key != want {
+		t.Errorf("default cache key %q, want the unchanged %q", key, want)
+	}
+	withDeinterlace(t, deinterlaceOn)
+	if key := r.getCacheKey(); key == want {
+		t.Error("forced deinterlacing shares the default cache key")
+	}
+}
+
+func TestValidateDeinterlace(t *testing.T) {
+	for mode, ok := range map[string]bool{"auto": true, "on": true, "off": true, "yes": false, "": false} {
+		if err := validateDeinterlace(mode); (err == nil) != ok {
+			t.Errorf("validateDeinterlace(%q) = %v", mode, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// probeConcurrency bounds the ffmpeg processes durationsFor runs at once.
+var probeConcurrency = 4
+
+// probeDuration is getVideoDuration, replaceable in tests.
+var probeDuration = getVideoDuration
+
+// durationsFor reads the durations of paths with up to probeConcurrency
+// probes at a time. Paths that cannot be probed are logged and left out.
+func durationsFor(paths []string) map[string]float64 {
+	workers := probeConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan string)
+	var mu sync.Mutex
+	durations := make(map[string]float64, len(paths))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				duration, err := probeDuration(p)
+				if err != nil {
+					log.Errorf("Could not read duration of %v: %v", p, err)
+					continue
+				}
+				mu.Lock()
+				durations[p] = duration
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range paths {
+		jobs <- p
+	}
+	close(jobs)
+	wg.Wait()
+	return durations
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDurationsForConcurrencyLimit(t *testing.T) {
+	oldConcurrency, oldProbe := probeConcurrency, probeDuration
+	t.Cleanup(func() { probeConcurrency, probeDuration = oldConcurrency, oldProbe })
+	probeConcurrency = 3
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	probeDuration = func(p string) (float64, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if p == "/media/broken.mp4" {
+			return 0, errors.New("unreadable")
+		}
+		return float64(len(p)), nil
+	}
+
+	var paths []string
+	want := make(map[string]float64)
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("/media/%02d.mp4", i)
+		paths = append(paths, p)
+		want[p] = float64(len(p))
+	}
+	paths = append(paths, "/media/broken.mp4")
+
+	got := durationsFor(paths)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("durations %v, want %v", got, want)
+	}
+	if peak > probeConcurrency {
+		t.Errorf("%v probes ran at once, limit %v", peak, probeConcurrency)
+	}
+	if peak < 2 {
+		t.Errorf("probes did not run concurrently, peak %v", peak)
+	}
+}
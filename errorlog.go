@@ -0,0 +1,70 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// errorHistorySize is the number of encode errors kept per file.
+	errorHistorySize = 20
+	// errorHistoryFiles is the number of files errors are kept for; the
+	// files without recent errors are forgotten first.
+	errorHistoryFiles = 500
+)
+
+type encodeErrorRecord struct {
+	Time    time.Time `json:"time"`
+	Segment int64     `json:"segment"`
+	Message string    `json:"message"`
+}
+
+// errorHistory keeps the recent encode errors of each file.
+type errorHistory struct {
+	mu    sync.Mutex
+	files map[string]*list.Element
+	order *list.List // Of *fileErrors, most recently failed first
+}
+
+type fileErrors struct {
+	file    string
+	records []encodeErrorRecord
+}
+
+func (h *errorHistory) add(file string, segment int64, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.files == nil {
+		h.files = make(map[string]*list.Element)
+		h.order = list.New()
+	}
+	e, ok := h.files[file]
+	if ok {
+		h.order.MoveToFront(e)
+	} else {
+		e = h.order.PushFront(&fileErrors{file: file})
+		h.files[file] = e
+		if h.order.Len() > errorHistoryFiles {
+			oldest := h.order.Back()
+			h.order.Remove(oldest)
+			delete(h.files, oldest.Value.(*fileErrors).file)
+		}
+	}
+	fe := e.Value.(*fileErrors)
+	fe.records = append(fe.records, encodeErrorRecord{time.Now(), segment, err.Error()})
+	if over := len(fe.records) - errorHistorySize; over > 0 {
+		fe.records = fe.records[over:]
+	}
+}
+
+// get returns the recent errors of file, oldest first.
+func (h *errorHistory) get(file string) []encodeErrorRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := []encodeErrorRecord{}
+	if e, ok := h.files[file]; ok {
+		records = append(records, e.Value.(*fileErrors).records...)
+	}
+	return records
+}
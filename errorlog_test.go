@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestErrorHistoryPerFile(t *testing.T) {
+	var h errorHistory
+	if records := h.get("/media/a.mp4"); records == nil || len(records) != 0 {
+		t.Errorf("history of a file without errors %v, want empty", records)
+	}
+	h.add("/media/a.mp4", 3, errors.New("first"))
+	h.add("/media/b.mp4", 1, errors.New("other"))
+	h.add("/media/a.mp4", 4, errors.New("second"))
+
+	records := h.get("/media/a.mp4")
+	if len(records) != 2 || records[0].Segment != 3 || records[0].Message != "first" || records[1].Segment != 4 {
+		t.Errorf("history of a %+v, want its two errors oldest first", records)
+	}
+	if records[0].Time.IsZero() {
+		t.Error("error recorded without a time")
+	}
+}
+
+func TestErrorHistoryBounds(t *testing.T) {
+	var h errorHistory
+	for i := 0; i < errorHistorySize+5; i++ {
+		h.add("/media/a.mp4", int64(i), errors.New("failed"))
+	}
+	records := h.get("/media/a.mp4")
+	if len(records) != errorHistorySize || records[0].Segment != 5 {
+		t.Errorf("kept %v errors from segment %v, want the last %v", len(records), records[0].Segment, errorHistorySize)
+	}
+
+	for i := 0; i < errorHistoryFiles; i++ {
+		h.add(fmt.Sprintf("/media/%v.mp4", i), 0, errors.New("failed"))
+	}
+	if len(h.files) != errorHistoryFiles || len(h.get("/media/a.mp4")) != 0 {
+		t.Errorf("%v files kept, want %v without the least recently failed", len(h.files), errorHistoryFiles)
+	}
+	if len(h.get("/media/0.mp4")) != 1 {
+		t.Error("recently failed file forgotten")
+	}
+}
+
+func TestErrorsHandler(t *testing.T) {
+	dir := withTestRoot(t)
+	encoder.errors.add(filepath.Join(dir, "a.mp4"), 2, errors.New("broken"))
+
+	w := httptest.NewRecorder()
+	errorsHandler(w, httptest.NewRequest("GET", "/api/errors/a.mp4", nil), httprouter.Params{{Key: "filename", Value: "/a.mp4"}})
+	var records []encodeErrorRecord
+	if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Segment != 2 || records[0].Message != "broken" {
+		t.Errorf("errors %+v", records)
+	}
+
+	w = httptest.NewRecorder()
+	errorsHandler(w, httptest.NewRequest("GET", "/api/errors/b.mp4", nil), httprouter.Params{{Key: "filename", Value: "/b.mp4"}})
+	if body := w.Body.String(); body != "[]\n" {
+		t.Errorf("errors of a file without errors %q, want []", body)
+	}
+
+	w = httptest.NewRecorder()
+	errorsHandler(w, httptest.NewRequest("GET", "/api/errors/x", nil), httprouter.Params{{Key: "filename", Value: "/../a.mp4"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("traversal: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var (
+	ErrSourceNotFound    = errors.New("source not found")
+	ErrEncodeTimeout     = errors.New("encode timed out")
+	ErrFFmpegUnavailable = errors.New("ffmpeg is unavailable")
+	ErrEmptyOutput       = errors.New("encode produced no output")
+	ErrNotMedia          = errors.New("not a media file")
+	ErrQueueFull         = errors.New("encoder queue is full")
+)
+
+// EncodeError is the error the encoder sends back for a failed request.
+type EncodeError struct {
+	File    string
+	Segment int64
+	Err     error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("Encoding %v:%v failed:%v", e.File, e.Segment, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// checkSource returns ErrSourceNotFound if file does not exist and
+// ErrNotMedia if it has no video or audio stream. Remote sources are left
+// for ffmpeg to fetch.
+func checkSource(file string) error {
+	if isRemoteSource(file) {
+		return nil
+	}
+	stat, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %v", ErrSourceNotFound, file)
+	}
+	if err != nil || stat.IsDir() {
+		return nil
+	}
+	return checkMedia(file, stat)
+}
+
+// errorStatus maps an error to the response status of a handler.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrSourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrEncodeTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrFFmpegUnavailable), errors.Is(err, ErrQueueFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrEmptyOutput):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrNotMedia):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{ErrSourceNotFound, http.StatusNotFound},
+		{ErrEncodeTimeout, http.StatusGatewayTimeout},
+		{ErrFFmpegUnavailable, http.StatusServiceUnavailable},
+		{ErrQueueFull, http.StatusServiceUnavailable},
+		{ErrEmptyOutput, http.StatusBadGateway},
+		{ErrNotMedia, http.StatusUnsupportedMediaType},
+		{errors.New("exit status 1"), http.StatusInternalServerError},
+		{&EncodeError{"a.mp4", 3, ErrEncodeTimeout}, http.StatusGatewayTimeout},
+		{fmt.Errorf("%w: a.mp4", ErrSourceNotFound), http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if code := errorStatus(test.err); code != test.want {
+			t.Errorf("errorStatus(%v) = %v, want %v", test.err, code, test.want)
+		}
+	}
+}
+
+func TestEncodeErrorUnwrap(t *testing.T) {
+	err := error(&EncodeError{"a.mp4", 3, ErrEmptyOutput})
+	if !errors.Is(err, ErrEmptyOutput) {
+		t.Error("EncodeError does not wrap its cause")
+	}
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Segment != 3 {
+		t.Errorf("errors.As gave %+v", encodeErr)
+	}
+	if err.Error() != "Encoding a.mp4:3 failed:encode produced no output" {
+		t.Errorf("message %q", err.Error())
+	}
+}
+
+func TestCheckSourceMissing(t *testing.T) {
+	err := checkSource(filepath.Join(t.TempDir(), "missing.mp4"))
+	if !errors.Is(err, ErrSourceNotFound) {
+		t.Errorf("error %v, want ErrSourceNotFound", err)
+	}
+	if err := checkSource("http://example.com/a.mp4"); err != nil {
+		t.Errorf("remote source checked: %v", err)
+	}
+}
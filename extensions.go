@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// extensionList is a comma separated flag.Value of file extensions.
+type extensionList []string
+
+// allowedExtensions are the source file types the server will hand to
+// ffmpeg, video containers and audio files. An empty list allows every
+// file.
+var allowedExtensions = extensionList{
+	"mp4", "m4v", "mkv", "mov", "avi", "webm", "flv", "wmv", "mpg", "mpeg", "ts", "m2ts",
+	"mp3", "m4a", "aac", "flac", "wav", "ogg", "oga", "opus",
+}
+
+func (l *extensionList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *extensionList) Set(value string) error {
+	var exts extensionList
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	*l = exts
+	return nil
+}
+
+func (l extensionList) allows(name string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(sourcePath(name)), "."))
+	for _, allowed := range l {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMediaExtension answers 415 and returns false if file is not of an
+// allowed type.
+func checkMediaExtension(w http.ResponseWriter, file string) bool {
+	if allowedExtensions.allows(file) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("Unsupported media type %v", path.Ext(sourcePath(file))), http.StatusUnsupportedMediaType)
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowedExtensions(t *testing.T) {
+	for _, name := range []string{"/media/a.mp4", "/media/b.MKV", "/media/c.mov", "/media/song.mp3", "/media/song.m4a", "/media/song.flac"} {
+		if !allowedExtensions.allows(name) {
+			t.Errorf("%v rejected", name)
+		}
+	}
+	for _, name := range []string{"/etc/passwd", "/media/notes.txt", "/media/a.mp4.sh", "/media/mp4"} {
+		if allowedExtensions.allows(name) {
+			t.Errorf("%v allowed", name)
+		}
+	}
+}
+
+func TestExtensionListSet(t *testing.T) {
+	var l extensionList
+	if err := l.Set(" .MP4, mkv,,"); err != nil {
+		t.Fatal(err)
+	}
+	if l.String() != "mp4,mkv" {
+		t.Errorf("list %v, want mp4,mkv", l.String())
+	}
+	if !l.allows("a.Mp4") || l.allows("a.mov") {
+		t.Errorf("list %v allows the wrong files", l)
+	}
+	if !(extensionList{}).allows("anything") {
+		t.Error("empty list rejected a file")
+	}
+}
+
+func TestCheckMediaExtension(t *testing.T) {
+	w := httptest.NewRecorder()
+	if checkMediaExtension(w, "/media/a.txt") {
+		t.Fatal("text file accepted")
+	}
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status %v, want %v", w.Code, http.StatusUnsupportedMediaType)
+	}
+	if !checkMediaExtension(httptest.NewRecorder(), "/media/a.mp4") {
+		t.Error("mp4 rejected")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// gapAfterFailures is the number of failed encodes after which a segment is
+// given up on and marked with EXT-X-GAP, 0 to keep retrying forever.
+var gapAfterFailures = 3
+
+// failureCounter counts consecutive encode failures per cache key.
+type failureCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (f *failureCounter) record(r EncodingRequest) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+	}
+	key := r.getCacheKey()
+	f.counts[key]++
+	return f.counts[key]
+}
+
+func (f *failureCounter) clear(r EncodingRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.counts, r.getCacheKey())
+}
+
+func (f *failureCounter) count(r EncodingRequest) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.counts[r.getCacheKey()]
+}
+
+// isGap reports whether r failed to encode often enough to be skipped.
+func (e *Encoder) isGap(r EncodingRequest) bool {
+	return gapAfterFailures > 0 && e.failures.count(r) >= gapAfterFailures
+}
+
+// segmentGaps returns the segments of r's stream that are gaps.
+func (e *Encoder) segmentGaps(r EncodingRequest, segments int) map[int64]bool {
+	gaps := make(map[int64]bool)
+	for i := 0; i < segments; i++ {
+		r.segment = int64(i)
+		if e.isGap(r) {
+			gaps[r.segment] = true
+		}
+	}
+	return gaps
+}
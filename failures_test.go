@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGapAfterRepeatedFailures(t *testing.T) {
+	e := &Encoder{}
+	r := *NewWarmupEncodingRequest("/media/a.mp4", 4, 480)
+	for i := 1; i < gapAfterFailures; i++ {
+		e.failures.record(r)
+		if e.isGap(r) {
+			t.Fatalf("gap after %v failures, want %v", i, gapAfterFailures)
+		}
+	}
+	e.failures.record(r)
+	if !e.isGap(r) {
+		t.Fatalf("no gap after %v failures", gapAfterFailures)
+	}
+
+	other := r
+	other.res = 720
+	if e.isGap(other) {
+		t.Error("another resolution of the segment is a gap")
+	}
+	if gaps := e.segmentGaps(r, 6); !reflect.DeepEqual(gaps, map[int64]bool{4: true}) {
+		t.Errorf("gaps %v, want segment 4", gaps)
+	}
+
+	e.failures.clear(r)
+	if e.isGap(r) {
+		t.Error("gap kept after a successful encode")
+	}
+}
+
+func TestGapsDisabled(t *testing.T) {
+	old := gapAfterFailures
+	gapAfterFailures = 0
+	t.Cleanup(func() { gapAfterFailures = old })
+
+	e := &Encoder{}
+	r := *NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	for i := 0; i < 10; i++ {
+		e.failures.record(r)
+	}
+	if e.isGap(r) {
+		t.Error("gap although gaps are disabled")
+	}
+}
+
+func TestPlaylistMarksGaps(t *testing.T) {
+	var b bytes.Buffer
+	writePlaylist(&b, "http://h/api/hls/segments/a.mp4", "", "", 0, []float64{10, 10, 10}, map[int64]bool{1: true}, nil, "VOD", true)
+	out := b.String()
+	if !strings.Contains(out, "#EXTINF:10.000000,\n#EXT-X-GAP\nhttp://h/api/hls/segments/a.mp4/1.ts\n") {
+		t.Errorf("segment 1 not marked as a gap:\n%v", out)
+	}
+	if strings.Count(out, "#EXT-X-GAP") != 1 {
+		t.Errorf("want exactly one gap:\n%v", out)
+	}
+	if !strings.Contains(out, "#EXT-X-VERSION:8\n") {
+		t.Errorf("playlist with gaps does not declare version 8:\n%v", out)
+	}
+}
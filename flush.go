@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// flushChunkSize is the number of segment bytes written between flushes,
+// so clients start receiving a segment before all of it is written. 0
+// writes segments in one go.
+var flushChunkSize = 64 * 1024
+
+// writeSegment writes data to w, flushing after every flushChunkSize bytes
+// when w supports it.
+func writeSegment(w http.ResponseWriter, data []byte) error {
+	if flushChunkSize <= 0 {
+		_, err := w.Write(data)
+		return err
+	}
+	rc := http.NewResponseController(w)
+	for len(data) > 0 {
+		n := flushChunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if err := rc.Flush(); errors.Is(err, http.ErrNotSupported) {
+			_, err := w.Write(data)
+			return err
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+// flushRecorder records the writes and flushes of a response.
+type flushRecorder struct {
+	header  http.Header
+	body    bytes.Buffer
+	writes  int
+	flushes int
+}
+
+func (f *flushRecorder) Header() http.Header {
+	if f.header == nil {
+		f.header = make(http.Header)
+	}
+	return f.header
+}
+
+func (f *flushRecorder) Write(p []byte) (int, error) {
+	f.writes++
+	return f.body.Write(p)
+}
+
+func (f *flushRecorder) WriteHeader(int) {}
+
+func (f *flushRecorder) Flush() { f.flushes++ }
+
+// plainWriter is a ResponseWriter without Flush.
+type plainWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	writes int
+}
+
+func (p *plainWriter) Header() http.Header { return p.header }
+func (p *plainWriter) WriteHeader(int)     {}
+func (p *plainWriter) Write(b []byte) (int, error) {
+	p.writes++
+	return p.body.Write(b)
+}
+
+func withFlushChunkSize(t *testing.T, size int) {
+	old := flushChunkSize
+	flushChunkSize = size
+	t.Cleanup(func() { flushChunkSize = old })
+}
+
+func TestWriteSegmentFlushes(t *testing.T) {
+	withFlushChunkSize(t, 100)
+	data := bytes.Repeat([]byte{0x47}, 250)
+	w := &flushRecorder{}
+	if err := writeSegment(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if w.flushes != 3 || w.writes != 3 {
+		t.Errorf("%v writes and %v flushes, want 3 of each", w.writes, w.flushes)
+	}
+	if !bytes.Equal(w.body.Bytes(), data) {
+		t.Error("segment data changed")
+	}
+}
+
+func TestWriteSegmentWithoutFlusher(t *testing.T) {
+	withFlushChunkSize(t, 100)
+	data := bytes.Repeat([]byte{0x47}, 250)
+	w := &plainWriter{header: make(http.Header)}
+	if err := writeSegment(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 2 {
+		t.Errorf("%v writes, want the first chunk and then the rest", w.writes)
+	}
+	if !bytes.Equal(w.body.Bytes(), data) {
+		t.Error("segment data changed")
+	}
+}
+
+func TestWriteSegmentUnchunked(t *testing.T) {
+	withFlushChunkSize(t, 0)
+	w := &flushRecorder{}
+	if err := writeSegment(w, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if w.flushes != 0 || w.writes != 1 {
+		t.Errorf("%v writes and %v flushes, want one write", w.writes, w.flushes)
+	}
+}
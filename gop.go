@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// variableSegments moves each segment boundary to the source keyframe
+	// closest to where a fixed length segment would end, if one lies within
+	// segmentTimeDelta seconds. Source keyframes mostly sit on scene cuts,
+	// where starting a new segment costs the least bits.
+	variableSegments bool
+	segmentTimeDelta = 2.0
+)
+
+// probeKeyframeTimes lists the timestamps of all video keyframes of path. It
+// reads packet flags only, so nothing is decoded.
+func probeKeyframeTimes(path string) ([]float64, error) {
+	out, err := exec.Command(FFPROBEPath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0",
+		path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Probe keyframe times error:%v", err)
+	}
+	return parseKeyframeTimes(out), nil
+}
+
+func parseKeyframeTimes(data []byte) []float64 {
+	var times []float64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 2 || !strings.Contains(fields[1], "K") {
+			continue
+		}
+		if t, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// segmentBoundaries returns the start of every segment followed by
+// duration. keyframes must be sorted.
+func segmentBoundaries(keyframes []float64, duration float64) []float64 {
+	boundaries := []float64{0}
+	for last := 0.0; last+hlsSegmentLength < duration; {
+		target := last + hlsSegmentLength
+		next, distance := target, math.Inf(1)
+		for i := sort.SearchFloat64s(keyframes, target-segmentTimeDelta); i < len(keyframes) && keyframes[i] <= target+segmentTimeDelta; i++ {
+			if d := math.Abs(keyframes[i] - target); keyframes[i] > last && d < distance {
+				next, distance = keyframes[i], d
+			}
+		}
+		if next >= duration {
+			break
+		}
+		boundaries = append(boundaries, next)
+		last = next
+	}
+	return append(boundaries, duration)
+}
+
+func validateSegmentTimeDelta(delta float64) error {
+	if delta < 0 || delta >= hlsSegmentLength/2 {
+		return fmt.Errorf("Segment time delta %v must be at least 0 and less than half the segment length %v", delta, hlsSegmentLength)
+	}
+	return nil
+}
+
+// getSegmentBoundaries returns the segment boundaries of path, probing it
+// only when it changed since the last call. Sources that cannot be analysed
+// are segmented at fixed lengths.
+func getSegmentBoundaries(path string) ([]float64, error) {
+	a := localAnalysis(path)
+	if a == nil {
+		return nil, fmt.Errorf("Cannot analyse %v", path)
+	}
+	analysisCache.Lock()
+	boundaries := a.boundaries
+	analysisCache.Unlock()
+	if boundaries != nil {
+		return boundaries, nil
+	}
+
+	duration, err := sourceDuration(path)
+	if err != nil {
+		return nil, err
+	}
+	keyframes, err := probeKeyframeTimes(path)
+	if err != nil {
+		return nil, err
+	}
+	boundaries = segmentBoundaries(keyframes, duration)
+
+	analysisCache.Lock()
+	a.boundaries = boundaries
+	analysisCache.Unlock()
+	return boundaries, nil
+}
+
+// segmentDurations returns the segment durations of the first duration
+// seconds of file.
+func segmentDurations(file string, duration float64) []float64 {
+	if !variableSegments {
+		return mergeShortTail(file, estimatedDurations(duration))
+	}
+	boundaries, err := getSegmentBoundaries(file)
+	if err != nil {
+		return mergeShortTail(file, estimatedDurations(duration))
+	}
+	var durations []float64
+	for i := 0; i+1 < len(boundaries) && boundaries[i] < duration; i++ {
+		durations = append(durations, math.Min(boundaries[i+1], duration)-boundaries[i])
+	}
+	return mergeShortTail(file, durations)
+}
+
+// segmentSpan returns the start time and length in seconds of a segment.
+func segmentSpan(file string, segment int64) (float64, float64) {
+	start, length := float64(segment)*hlsSegmentLength, hlsSegmentLength
+	if variableSegments {
+		if boundaries, err := getSegmentBoundaries(file); err == nil && segment+1 < int64(len(boundaries)) {
+			start, length = boundaries[segment], boundaries[segment+1]-boundaries[segment]
+		}
+	}
+	return start, extendToShortTail(file, start, length)
+}
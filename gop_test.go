@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyframeTimes(t *testing.T) {
+	data := []byte("0.000000,K_\n0.040000,__\n9.480000,K_\nN/A,K_\n\n11.200000,K__\n")
+	if got, want := parseKeyframeTimes(data), []float64{0, 9.48, 11.2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keyframes %v, want %v", got, want)
+	}
+}
+
+func TestSegmentBoundaries(t *testing.T) {
+	keyframes := []float64{0, 4, 8.5, 11.5, 19, 21.5, 33, 41}
+	// 8.5 and 11.5 are equally close to 10 and the earlier one wins, 19 is
+	// closest to 18.5, and no keyframe lies within 2 s of 29.
+	want := []float64{0, 8.5, 19, 29, 35}
+	if got := segmentBoundaries(keyframes, 35); !reflect.DeepEqual(got, want) {
+		t.Errorf("boundaries %v, want %v", got, want)
+	}
+}
+
+func TestSegmentBoundariesWithoutKeyframes(t *testing.T) {
+	if got, want := segmentBoundaries(nil, 25), []float64{0, 10, 20, 25}; !reflect.DeepEqual(got, want) {
+		t.Errorf("boundaries %v, want %v", got, want)
+	}
+}
+
+func TestPlaylistNonUniformDurations(t *testing.T) {
+	var b bytes.Buffer
+	writePlaylist(&b, "http://h/api/hls/segments/a.mp4", "", "", 0, []float64{8.5, 10.5, 11.6, 4.4}, nil, nil, "VOD", true)
+	out := b.String()
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:12\n",
+		"#EXTINF:8.500000,\nhttp://h/api/hls/segments/a.mp4/0.ts\n",
+		"#EXTINF:10.500000,\nhttp://h/api/hls/segments/a.mp4/1.ts\n",
+		"#EXTINF:11.600000,\nhttp://h/api/hls/segments/a.mp4/2.ts\n",
+		"#EXTINF:4.400000,\nhttp://h/api/hls/segments/a.mp4/3.ts\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("playlist lacks %q:\n%v", want, out)
+		}
+	}
+}
+
+func TestValidateSegmentTimeDelta(t *testing.T) {
+	for delta, ok := range map[float64]bool{0: true, 2: true, 4.9: true, 5: false, -1: false} {
+		if err := validateSegmentTimeDelta(delta); (err == nil) != ok {
+			t.Errorf("validateSegmentTimeDelta(%v) = %v", delta, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// growingTimeout is how long after its last observed change a file is still
+// treated as growing, e.g. a recording in progress. 0 disables EVENT
+// playlists.
+var growingTimeout time.Duration
+
+type growthEntry struct {
+	size    int64
+	modTime time.Time
+	changed time.Time // When size or modTime last changed
+}
+
+// growthTracker remembers the size and modification time each file had when
+// last seen, to tell files that are still being written from finished ones.
+type growthTracker struct {
+	sync.Mutex
+	entries map[string]growthEntry
+}
+
+var growth = growthTracker{entries: make(map[string]growthEntry)}
+
+// observe records the current state of file and reports whether it changed
+// within growingTimeout. A file seen for the first time counts as growing if
+// it was modified within growingTimeout.
+func (t *growthTracker) observe(file string) bool {
+	if growingTimeout <= 0 || isRemoteSource(file) {
+		return false
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+
+	t.Lock()
+	defer t.Unlock()
+	entry, ok := t.entries[file]
+	if !ok {
+		entry.changed = stat.ModTime()
+	} else if entry.size != stat.Size() || !entry.modTime.Equal(stat.ModTime()) {
+		entry.changed = now
+	}
+	entry.size, entry.modTime = stat.Size(), stat.ModTime()
+	t.entries[file] = entry
+	return now.Sub(entry.changed) < growingTimeout
+}
+
+// isGrowing reports whether file was growing when last observed.
+func (t *growthTracker) isGrowing(file string) bool {
+	if growingTimeout <= 0 {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+	entry, ok := t.entries[file]
+	return ok && time.Since(entry.changed) < growingTimeout
+}
+
+// completeSegments drops the last of durations, which is still being
+// written in a growing file. Segments of an EVENT playlist must not change
+// once listed.
+func completeSegments(durations []float64) []float64 {
+	if len(durations) == 0 {
+		return durations
+	}
+	return durations[:len(durations)-1]
+}
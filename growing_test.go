@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withGrowingTimeout(t *testing.T, d time.Duration) {
+	saved := growingTimeout
+	growingTimeout = d
+	t.Cleanup(func() { growingTimeout = saved })
+}
+
+func TestGrowthObserve(t *testing.T) {
+	withGrowingTimeout(t, time.Minute)
+	tracker := growthTracker{entries: make(map[string]growthEntry)}
+	dir := t.TempDir()
+	recording, finished := filepath.Join(dir, "rec.ts"), filepath.Join(dir, "old.mp4")
+	for _, file := range []string{recording, finished} {
+		if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(finished, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tracker.observe(recording) || !tracker.isGrowing(recording) {
+		t.Error("freshly written file not growing")
+	}
+	if tracker.observe(finished) || tracker.isGrowing(finished) {
+		t.Error("file unchanged for an hour growing")
+	}
+
+	// A finished file that is appended to again grows.
+	f, err := os.OpenFile(finished, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("more")
+	f.Close()
+	if err := os.Chtimes(finished, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !tracker.observe(finished) {
+		t.Error("file whose size changed not growing")
+	}
+
+	growingTimeout = 0
+	if tracker.observe(recording) || tracker.isGrowing(recording) {
+		t.Error("growing file detected with EVENT playlists disabled")
+	}
+}
+
+func TestEventPlaylist(t *testing.T) {
+	durations := completeSegments([]float64{10, 10, 3.2})
+	if len(durations) != 2 {
+		t.Fatalf("durations %v, want the segment being written dropped", durations)
+	}
+	var b bytes.Buffer
+	writePlaylist(&b, "http://h/api/hls/segments/rec.ts", "", "", 0, durations, nil, nil, "EVENT", false)
+	playlist := b.String()
+	if !strings.Contains(playlist, "#EXT-X-PLAYLIST-TYPE:EVENT\n") || strings.Contains(playlist, "VOD") {
+		t.Errorf("playlist is not an event playlist:\n%v", playlist)
+	}
+	if strings.Contains(playlist, "#EXT-X-ENDLIST") {
+		t.Errorf("event playlist of a growing file is closed:\n%v", playlist)
+	}
+	if strings.Count(playlist, "#EXTINF:") != 2 {
+		t.Errorf("playlist does not list the complete segments:\n%v", playlist)
+	}
+}
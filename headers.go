@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerList is a flag.Value of "Name: value" response headers. Every use
+// of the flag adds one header.
+type headerList []header
+
+type header struct {
+	name  string
+	value string
+}
+
+// responseHeaders are added to every playlist and segment response.
+var responseHeaders headerList
+
+func (l *headerList) String() string {
+	headers := make([]string, len(*l))
+	for i, h := range *l {
+		headers[i] = h.name + ": " + h.value
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (l *headerList) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("Invalid header %q, expected Name: value", value)
+	}
+	*l = append(*l, header{http.CanonicalHeaderKey(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])})
+	return nil
+}
+
+// mediaPathPrefixes are the routes serving playlists and segments.
+var mediaPathPrefixes = []string{"/api/master/", "/api/playlist/", "/api/dash/", "/api/hls/", "/api/init/", "/api/concat/"}
+
+// addResponseHeaders sets responseHeaders on playlist and segment responses.
+// Handlers setting the same header override them.
+func addResponseHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range mediaPathPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				for _, h := range responseHeaders {
+					w.Header()[h.name] = []string{h.value}
+				}
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withResponseHeaders(t *testing.T, values ...string) {
+	saved := responseHeaders
+	responseHeaders = nil
+	t.Cleanup(func() { responseHeaders = saved })
+	for _, v := range values {
+		if err := responseHeaders.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHeaderListSet(t *testing.T) {
+	var l headerList
+	if err := l.Set("cache-control:  public, max-age=60 "); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set("X-Content-Type-Options: nosniff"); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.String(); got != "Cache-Control: public, max-age=60, X-Content-Type-Options: nosniff" {
+		t.Errorf("headers %q", got)
+	}
+	for _, value := range []string{"nosniff", ": nosniff", ""} {
+		if err := l.Set(value); err == nil {
+			t.Errorf("header %q accepted", value)
+		}
+	}
+}
+
+func TestAddResponseHeaders(t *testing.T) {
+	withResponseHeaders(t, "Cache-Control: max-age=60", "X-Content-Type-Options: nosniff")
+	handler := addResponseHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("override") != "" {
+			w.Header()["Cache-Control"] = []string{"no-cache"}
+		}
+	}))
+
+	for _, path := range []string{"/api/playlist/a.mp4", "/api/hls/segments/a.mp4/0.ts", "/api/master/a.mp4", "/api/init/a.mp4", "/api/concat/a.mp4,b.mp4"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Header().Get("Cache-Control") != "max-age=60" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%v: headers %v", path, w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/cache/stats", nil))
+	if len(w.Header()) != 0 {
+		t.Errorf("non-media response got headers %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/playlist/a.mp4?override=1", nil))
+	if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("handler header overridden by the configured one: %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ffmpegUnavailable is set while the last attempt to start ffmpeg failed.
+var ffmpegUnavailable atomic.Bool
+
+// checkStartError classifies an error starting a command. Missing or
+// unexecutable binaries mark ffmpeg unavailable until it starts again.
+func checkStartError(err error) error {
+	if err == nil {
+		ffmpegUnavailable.Store(false)
+		return nil
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		ffmpegUnavailable.Store(true)
+		return fmt.Errorf("%w: %v", ErrFFmpegUnavailable, err)
+	}
+	return fmt.Errorf("Error starting command: %v", err)
+}
+
+// healthz reports 503 while ffmpeg cannot be started.
+func healthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	status, code := "ok", http.StatusOK
+	if ffmpegUnavailable.Load() {
+		status, code = ErrFFmpegUnavailable.Error(), http.StatusServiceUnavailable
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status, "audioCodec": audioCodec})
+}
+
+// ready is set once checkReadiness succeeded.
+var ready atomic.Bool
+
+// checkReadiness verifies that ffmpeg can be found, the media root read and
+// the cache directory written.
+func checkReadiness(e *Encoder) error {
+	if _, err := exec.LookPath(FFMPEGPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrFFmpegUnavailable, err)
+	}
+	if _, err := os.ReadDir(root); err != nil {
+		return fmt.Errorf("Media root is not readable:%v", err)
+	}
+	dir := e.cacheDirPath()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("Cache dir is not writable:%v", err)
+	}
+	f, err := os.CreateTemp(dir, "ready.*.tmp")
+	if err != nil {
+		return fmt.Errorf("Cache dir is not writable:%v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// waitReady retries checkReadiness until it succeeds, then marks the server
+// ready.
+func waitReady(e *Encoder) {
+	for {
+		err := checkReadiness(e)
+		if err == nil {
+			break
+		}
+		log.Warnf("Not ready: %v", err)
+		time.Sleep(readyRetryInterval)
+	}
+	ready.Store(true)
+	log.Info("Ready")
+}
+
+const readyRetryInterval = 5 * time.Second
+
+// readyHandler reports 503 until startup checks have passed.
+func readyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	status, code := "ready", http.StatusOK
+	if !ready.Load() {
+		status, code = "starting", http.StatusServiceUnavailable
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
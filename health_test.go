@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func healthzStatus() int {
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest("GET", "/healthz", nil), nil)
+	return w.Code
+}
+
+func TestExecuteMissingBinary(t *testing.T) {
+	t.Cleanup(func() { ffmpegUnavailable.Store(false) })
+
+	_, err := execute(filepath.Join(t.TempDir(), "ffmpeg"), []string{"-version"})
+	if !errors.Is(err, ErrFFmpegUnavailable) {
+		t.Fatalf("error %v, want ErrFFmpegUnavailable", err)
+	}
+	if code := errorStatus(err); code != http.StatusServiceUnavailable {
+		t.Errorf("status %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	if code := healthzStatus(); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz %v while ffmpeg is missing, want %v", code, http.StatusServiceUnavailable)
+	}
+
+	if _, err := execute("/bin/sh", []string{"-c", "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if code := healthzStatus(); code != http.StatusOK {
+		t.Errorf("healthz %v once commands start again, want %v", code, http.StatusOK)
+	}
+}
+
+func TestCheckStartError(t *testing.T) {
+	t.Cleanup(func() { ffmpegUnavailable.Store(false) })
+
+	err := checkStartError(errors.New("too many open files"))
+	if errors.Is(err, ErrFFmpegUnavailable) || ffmpegUnavailable.Load() {
+		t.Errorf("other start error %v marked ffmpeg unavailable", err)
+	}
+	if err := checkStartError(nil); err != nil {
+		t.Errorf("checkStartError(nil) = %v", err)
+	}
+}
+
+func readyStatus() int {
+	w := httptest.NewRecorder()
+	readyHandler(w, httptest.NewRequest("GET", "/api/ready", nil), nil)
+	return w.Code
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Cleanup(func() { ready.Store(false) })
+
+	ready.Store(false)
+	if code := readyStatus(); code != http.StatusServiceUnavailable {
+		t.Errorf("status %v before startup checks, want %v", code, http.StatusServiceUnavailable)
+	}
+	ready.Store(true)
+	if code := readyStatus(); code != http.StatusOK {
+		t.Errorf("status %v after startup checks, want %v", code, http.StatusOK)
+	}
+}
+
+func TestCheckReadinessMissingRoot(t *testing.T) {
+	withTestRoot(t)
+	root = filepath.Join(root, "missing")
+	if err := checkReadiness(encoder); err == nil {
+		t.Error("ready without a media root")
+	}
+}
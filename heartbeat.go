@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// followPlayback warms the segments ahead of the playback position players
+// report to /api/heartbeat, or with ?position= on segment requests, instead
+// of those following every requested segment.
+var followPlayback bool
+
+// segmentAt returns the segment of file playing at t seconds.
+func segmentAt(file string, t float64) int64 {
+	if variableSegments {
+		if boundaries, err := getSegmentBoundaries(file); err == nil && len(boundaries) > 1 {
+			i := sort.SearchFloat64s(boundaries, t)
+			if i == len(boundaries) || boundaries[i] > t {
+				i--
+			}
+			if i > len(boundaries)-2 {
+				i = len(boundaries) - 2
+			}
+			return int64(i)
+		}
+	}
+	return int64(math.Floor(t / hlsSegmentLength))
+}
+
+// parsePosition parses a playback position in seconds. ok is false if value
+// is empty.
+func parsePosition(value string) (t float64, ok bool, err error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	t, err = strconv.ParseFloat(value, 64)
+	if err != nil || t < 0 || math.IsInf(t, 0) {
+		return 0, false, fmt.Errorf("Invalid position %v", value)
+	}
+	return t, true, nil
+}
+
+// warmAhead moves the read-ahead window of r's stream to r.segment and
+// queues warmups for the segments that entered it.
+func (e *Encoder) warmAhead(r EncodingRequest) {
+	for _, segment := range e.advanceWindow(r) {
+		warmup := NewWarmupEncodingRequest(r.file, segment, r.res)
+		warmup.audio = r.audio
+		warmup.audioTrack = r.audioTrack
+		warmup.quality = r.quality
+		warmup.container = r.container
+		warmup.watermark = r.watermark
+		warmup.timecode = r.timecode
+		if !e.enqueue(*warmup) {
+			log.Debugf("Encoder queue full, dropping warmups of %v", r.file)
+			return
+		}
+	}
+}
+
+// heartbeat takes the playback position ?t= of a stream, given with the
+// same options as its segment URLs, and warms the segments ahead of it.
+func heartbeat(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	t, ok, err := parsePosition(q.Get("t"))
+	if err == nil && !ok {
+		err = fmt.Errorf("Missing position")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	er := NewEncodingRequest(file, segmentAt(file, t), defaultResolution)
+	if err := parseStreamOptions(q, er); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if followPlayback {
+		go encoder.warmAhead(*er)
+	}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.WriteHeader(http.StatusNoContent)
+}
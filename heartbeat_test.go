@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func withFollowPlayback(t *testing.T, follow bool) {
+	saved := followPlayback
+	followPlayback = follow
+	t.Cleanup(func() { followPlayback = saved })
+}
+
+func TestParsePosition(t *testing.T) {
+	if _, ok, err := parsePosition(""); ok || err != nil {
+		t.Errorf("empty position: %v, %v", ok, err)
+	}
+	if p, ok, err := parsePosition("12.5"); p != 12.5 || !ok || err != nil {
+		t.Errorf("12.5 parsed as %v, %v, %v", p, ok, err)
+	}
+	for _, value := range []string{"-1", "abc", "Inf"} {
+		if _, _, err := parsePosition(value); err == nil {
+			t.Errorf("position %v accepted", value)
+		}
+	}
+}
+
+func TestSegmentAt(t *testing.T) {
+	for position, want := range map[float64]int64{0: 0, 9.9: 0, 10: 1, 35: 3} {
+		if got := segmentAt("/media/a.mp4", position); got != want {
+			t.Errorf("segment at %v = %v, want %v", position, got, want)
+		}
+	}
+}
+
+// warmups returns the segments queued for warmup on e within a short wait.
+func warmups(e *Encoder) []int64 {
+	var segments []int64
+	for {
+		select {
+		case r := <-e.reqChan:
+			segments = append(segments, r.segment)
+		case <-time.After(50 * time.Millisecond):
+			return segments
+		}
+	}
+}
+
+func TestHeartbeatAdvancesWarmWindow(t *testing.T) {
+	withTestRoot(t)
+	withFollowPlayback(t, true)
+	e := encoder
+	e.readAhead = 2
+	e.reqChan = make(chan EncodingRequest, 8)
+	params := httprouter.Params{{Key: "filename", Value: "/a.mp4"}}
+	beat := func(query string) int {
+		w := httptest.NewRecorder()
+		heartbeat(w, httptest.NewRequest("POST", "/api/heartbeat/a.mp4?"+query, nil), params)
+		return w.Code
+	}
+
+	for _, test := range []struct {
+		query string
+		want  []int64
+	}{
+		{"t=35", []int64{4, 5}},
+		{"t=41", []int64{6}},
+		{"t=44", nil},
+		{"t=2", []int64{1, 2}},
+		{"t=2&res=720", []int64{1, 2}},
+	} {
+		if code := beat(test.query); code != http.StatusNoContent {
+			t.Fatalf("%v: status %v", test.query, code)
+		}
+		if got := warmups(e); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v warmed %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{"", "t=abc", "t=-3"} {
+		if code := beat(query); code != http.StatusBadRequest {
+			t.Errorf("%q: status %v, want %v", query, code, http.StatusBadRequest)
+		}
+	}
+
+	followPlayback = false
+	beat("t=100")
+	if got := warmups(e); got != nil {
+		t.Errorf("heartbeat warmed %v without following playback", got)
+	}
+}
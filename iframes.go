@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// iframe is one entry of an I-frame playlist: a byte range of a segment
+// holding a keyframe, shown for duration seconds.
+type iframe struct {
+	duration float64
+	offset   int64
+	length   int64
+	uri      string
+}
+
+// keyframesCache memoizes tsKeyframes per cache file; cache files never
+// change once written.
+var keyframesCache sync.Map
+
+// segmentKeyframes returns the keyframes of r's cached segment, or nil if it
+// is not cached or cannot be parsed.
+func (e *Encoder) segmentKeyframes(r EncodingRequest) []tsKeyframe {
+	cachePath := e.GetCacheFile(r)
+	if keyframes, ok := keyframesCache.Load(cachePath); ok {
+		return keyframes.([]tsKeyframe)
+	}
+	data, err := e.GetFromCache(r)
+	if err != nil || data == nil {
+		return nil
+	}
+	keyframes, ok := tsKeyframes(data)
+	if !ok {
+		return nil
+	}
+	keyframesCache.Store(cachePath, keyframes)
+	return keyframes
+}
+
+// segmentIFrames lists the keyframes of a segment of the given duration. Each
+// is shown until the next one by PTS; the last one for the rest of the
+// segment.
+func segmentIFrames(keyframes []tsKeyframe, duration float64, uri string) []iframe {
+	iframes := make([]iframe, len(keyframes))
+	elapsed := 0.0
+	for i, k := range keyframes {
+		iframes[i] = iframe{offset: k.offset, length: k.length, uri: uri}
+		if i+1 < len(keyframes) {
+			d := float64(keyframes[i+1].pts-k.pts) / tsClockRate
+			if d < 0 || elapsed+d > duration {
+				d = 0
+			}
+			iframes[i].duration = d
+			elapsed += d
+		} else {
+			iframes[i].duration = duration - elapsed
+		}
+	}
+	return iframes
+}
+
+// writeIFramePlaylist writes an EXT-X-I-FRAMES-ONLY playlist for trick play.
+func writeIFramePlaylist(w io.Writer, iframes []iframe) {
+	p := newM3U8()
+	p.tag("#EXT-X-MEDIA-SEQUENCE:0")
+	p.tag("#EXT-X-TARGETDURATION:%.f", iframeTargetDuration(iframes))
+	p.tag("#EXT-X-PLAYLIST-TYPE:VOD")
+	p.tag("#EXT-X-I-FRAMES-ONLY")
+	for _, f := range iframes {
+		p.tag("#EXTINF:%f,", f.duration)
+		p.tag("#EXT-X-BYTERANGE:%v@%v", f.length, f.offset)
+		p.uri("%v", f.uri)
+	}
+	p.tag("#EXT-X-ENDLIST")
+	p.WriteTo(w)
+}
+
+func iframeTargetDuration(iframes []iframe) float64 {
+	target := float64(hlsSegmentLength)
+	for _, f := range iframes {
+		if f.duration > target {
+			target = f.duration
+		}
+	}
+	return target
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestSegmentIFrames(t *testing.T) {
+	keyframes := []tsKeyframe{
+		{offset: 0, length: 752, pts: 90000},
+		{offset: 940, length: 376, pts: 270000},
+	}
+	got := segmentIFrames(keyframes, 10, "seg")
+	want := []iframe{
+		{duration: 2, offset: 0, length: 752, uri: "seg"},
+		{duration: 8, offset: 940, length: 376, uri: "seg"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("iframes %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteIFramePlaylist(t *testing.T) {
+	var b bytes.Buffer
+	writeIFramePlaylist(&b, []iframe{
+		{duration: 2, offset: 0, length: 752, uri: "http://h/0.ts"},
+		{duration: 8, offset: 940, length: 376, uri: "http://h/0.ts"},
+		{duration: 12, offset: 0, length: 564, uri: "http://h/1.ts"},
+	})
+	out := b.String()
+	for _, want := range []string{
+		"#EXT-X-I-FRAMES-ONLY\n",
+		"#EXT-X-TARGETDURATION:12\n",
+		"#EXTINF:2.000000,\n#EXT-X-BYTERANGE:752@0\nhttp://h/0.ts\n",
+		"#EXTINF:8.000000,\n#EXT-X-BYTERANGE:376@940\nhttp://h/0.ts\n",
+		"#EXTINF:12.000000,\n#EXT-X-BYTERANGE:564@0\nhttp://h/1.ts\n",
+		"#EXT-X-ENDLIST\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("playlist lacks %q:\n%v", want, out)
+		}
+	}
+}
+
+func TestMasterPlaylistIFrameStreams(t *testing.T) {
+	var b bytes.Buffer
+	writeMasterPlaylist(&b, "http://h/api/playlist/id", "http://h/api/iframes/id", []int64{360, 720}, nil)
+	out := b.String()
+	for _, want := range []string{
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=77760,URI=\"http://h/api/iframes/id?res=360\"\n",
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=311040,URI=\"http://h/api/iframes/id?res=720\"\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("master playlist lacks %q:\n%v", want, out)
+		}
+	}
+}
+
+func TestIFramesRejectsTraversal(t *testing.T) {
+	w := httptest.NewRecorder()
+	iframesHandler(w, httptest.NewRequest("GET", "/api/iframes/../../etc/passwd", nil), httprouter.Params{{Key: "filename", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sync"
+)
+
+var (
+	// perFileEncodes caps the uncached segment requests of one source
+	// waiting on the encoder at once, so scrubbing through one file cannot
+	// monopolize it. 0 disables the cap.
+	perFileEncodes int
+	// perClientEncodes is the same cap per client, against players opening
+	// many concurrent streams over one HTTP/2 connection.
+	perClientEncodes int
+)
+
+// clientEncodes counts the uncached segment requests of each client IP.
+var clientEncodes inflightCounter
+
+// inflightCounter counts requests in progress per key.
+type inflightCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func fileHash(file string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(file)))
+}
+
+// acquire counts a request for key unless limit requests are already in
+// progress. Successful calls must be paired with release.
+func (c *inflightCounter) acquire(key string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit > 0 && c.counts[key] >= limit {
+		return false
+	}
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[key]++
+	return true
+}
+
+func (c *inflightCounter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[key]--; c.counts[key] <= 0 {
+		delete(c.counts, key)
+	}
+}
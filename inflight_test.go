@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInflightCapPerFile(t *testing.T) {
+	var c inflightCounter
+	busy, other := fileHash("/media/a.mp4"), fileHash("/media/b.mp4")
+	for i := 0; i < 2; i++ {
+		if !c.acquire(busy, 2) {
+			t.Fatalf("request %v of the busy file refused", i+1)
+		}
+	}
+	if c.acquire(busy, 2) {
+		t.Error("request beyond the cap admitted")
+	}
+	if !c.acquire(other, 2) {
+		t.Error("another file was refused")
+	}
+	c.release(busy)
+	if !c.acquire(busy, 2) {
+		t.Error("request refused after a release")
+	}
+}
+
+func TestInflightUncapped(t *testing.T) {
+	var c inflightCounter
+	for i := 0; i < 100; i++ {
+		if !c.acquire("a", 0) {
+			t.Fatal("request refused without a cap")
+		}
+	}
+}
+
+func TestInflightReleaseForgetsIdleKeys(t *testing.T) {
+	var c inflightCounter
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.acquire("a", 0) {
+				c.release("a")
+			}
+		}()
+	}
+	wg.Wait()
+	if len(c.counts) != 0 {
+		t.Errorf("counts %v left after every release", c.counts)
+	}
+}
+
+func TestPerClientEncodesUnderConcurrentRequests(t *testing.T) {
+	dir := withTestRoot(t)
+	saved := perClientEncodes
+	perClientEncodes = 2
+	t.Cleanup(func() { perClientEncodes = saved })
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	const clients = 10
+	requests := make(chan EncodingRequest, clients+1)
+	encoder.reqChan = requests
+
+	get := func(segment int, addr string) int {
+		name := fmt.Sprintf("a.mp4/%v.ts", segment)
+		r := httptest.NewRequest("GET", "/api/hls/segments/"+name, nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		hls(w, r, segmentParams(name))
+		return w.Code
+	}
+	var mu sync.Mutex
+	statuses := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			code := get(segment, "192.0.2.1:1234")
+			mu.Lock()
+			statuses[code]++
+			mu.Unlock()
+		}(i)
+	}
+
+	// The admitted requests wait for the encoder, the others are refused.
+	var admitted []EncodingRequest
+	for len(admitted) < perClientEncodes {
+		admitted = append(admitted, <-requests)
+	}
+	go func() {
+		data := []byte("segment")
+		other := <-requests
+		other.sendData(&data)
+	}()
+	if code := get(100, "198.51.100.7:1234"); code != http.StatusOK {
+		t.Errorf("another client: status %v, want %v", code, http.StatusOK)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for refused := 0; refused < clients-perClientEncodes; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v requests refused, want %v", refused, clients-perClientEncodes)
+		}
+		mu.Lock()
+		refused = statuses[http.StatusTooManyRequests]
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	for _, r := range admitted {
+		data := []byte("segment")
+		r.sendData(&data)
+	}
+	wg.Wait()
+	if statuses[http.StatusOK] != perClientEncodes || statuses[http.StatusTooManyRequests] != clients-perClientEncodes {
+		t.Errorf("statuses %v, want %v admitted and the rest refused", statuses, perClientEncodes)
+	}
+	if len(clientEncodes.counts) != 0 {
+		t.Errorf("slots %v held after every request finished", clientEncodes.counts)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// initCacheKey names the cached fMP4 init segment of r's stream at r.res.
+func (r *EncodingRequest) initCacheKey() string {
+	return fmt.Sprintf("%v.%v.init", cacheGroup(*r), r.res)
+}
+
+// initBoxes returns the ftyp and moov boxes heading a fragmented MP4
+// segment, which together form the init segment of its stream.
+func initBoxes(data []byte) ([]byte, error) {
+	var init []byte
+	hasMoov := false
+	for offset := 0; offset+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		box := string(data[offset+4 : offset+8])
+		if size < 8 || offset+size > len(data) {
+			break
+		}
+		switch box {
+		case "ftyp":
+			init = append(init, data[offset:offset+size]...)
+		case "moov":
+			init = append(init, data[offset:offset+size]...)
+			hasMoov = true
+		case "moof":
+			offset = len(data)
+			continue
+		}
+		offset += size
+	}
+	if !hasMoov {
+		return nil, errors.New("Segment has no moov box")
+	}
+	return init, nil
+}
+
+// mediaBoxes returns data without the ftyp and moov boxes heading a
+// fragmented MP4 segment, leaving the moof and mdat boxes of a media segment
+// whose init segment is served separately. Other data is returned as is.
+func mediaBoxes(data []byte) []byte {
+	offset := 0
+	for offset+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		box := string(data[offset+4 : offset+8])
+		if size < 8 || offset+size > len(data) || (box != "ftyp" && box != "moov") {
+			break
+		}
+		offset += size
+	}
+	return data[offset:]
+}
+
+// parseMediaOnly reports whether a segment URL asks for the media boxes of
+// an fMP4 segment only, with boxes=media.
+func parseMediaOnly(q url.Values) (bool, error) {
+	switch value := q.Get("boxes"); value {
+	case "":
+		return false, nil
+	case "media":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Invalid boxes %v", value)
+	}
+}
+
+// initSegment serves the fMP4 init segment referenced by EXT-X-MAP. It is
+// taken from the stream's first segment, so it always matches the media
+// segments the encoder produces.
+func initSegment(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+	er := NewEncodingRequest(file, 0, defaultResolution)
+	if err := parseStreamOptions(r.URL.Query(), er); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	er.container = containerFMP4
+
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	cachePath := encoder.cacheFilePath(er.initCacheKey())
+	init, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		encoder.Encode(*er)
+		select {
+		case data := <-er.data:
+			if init, err = initBoxes(*data); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			if noCache {
+				break
+			}
+			if err := writeCacheFile(cachePath, init); err != nil {
+				log.Errorf("Could not cache init segment of %v: %v", file, err)
+			}
+		case err := <-er.err:
+			log.Errorf("Error encoding %v", err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		case <-time.After(encodeTimeout(er.res)):
+			err := &EncodeError{er.file, er.segment, ErrEncodeTimeout}
+			log.Error(err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	w.Header()["Content-Type"] = []string{containers[containerFMP4]}
+	w.Write(init)
+}
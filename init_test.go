@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// mp4Box builds an MP4 box of type name around payload.
+func mp4Box(name string, payload string) []byte {
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box, uint32(8+len(payload)))
+	copy(box[4:], name)
+	return append(box, payload...)
+}
+
+func fmp4Segment() ([]byte, []byte) {
+	init := append(mp4Box("ftyp", "iso5"), mp4Box("moov", "tracks")...)
+	segment := append(append([]byte(nil), init...), mp4Box("moof", "fragment")...)
+	return init, append(segment, mp4Box("mdat", "samples")...)
+}
+
+func TestInitBoxes(t *testing.T) {
+	want, segment := fmp4Segment()
+	init, err := initBoxes(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(init, want) {
+		t.Errorf("init segment %q, want the ftyp and moov boxes %q", init, want)
+	}
+	if _, err := initBoxes(mp4Box("moof", "fragment")); err == nil {
+		t.Error("segment without moov accepted")
+	}
+}
+
+func TestInitCacheKey(t *testing.T) {
+	r := NewEncodingRequest("/media/a.mp4", 0, 480)
+	r.container = containerFMP4
+	key := r.initCacheKey()
+	if key != cacheGroup(*r)+".480.init" {
+		t.Errorf("init cache key %v", key)
+	}
+	other := *r
+	other.res = 720
+	if other.initCacheKey() == key {
+		t.Error("resolutions share an init segment")
+	}
+	other = *r
+	other.file = "/media/b.mp4"
+	if other.initCacheKey() == key {
+		t.Error("files share an init segment")
+	}
+	other = *r
+	other.audioTrack = 1
+	if other.initCacheKey() == key {
+		t.Error("streams with different tracks share an init segment")
+	}
+}
+
+func TestInitSegmentHandler(t *testing.T) {
+	dir := withTestRoot(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := encoder
+	requests := make(chan EncodingRequest, 1)
+	e.reqChan = requests
+	want, segment := fmp4Segment()
+	go func() {
+		r := <-requests
+		e.deliverData(r, &segment)
+	}()
+
+	params := httprouter.Params{{Key: "filename", Value: "/a.mp4"}}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		initSegment(w, httptest.NewRequest("GET", "/api/init/a.mp4", nil), params)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) {
+			t.Fatalf("request %v: status %v body %q", i+1, w.Code, w.Body.Bytes())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "video/mp4" {
+			t.Errorf("Content-Type %v, want video/mp4", ct)
+		}
+	}
+	if len(requests) != 0 {
+		t.Error("cached init segment encoded again")
+	}
+
+	w := httptest.NewRecorder()
+	initSegment(w, httptest.NewRequest("GET", "/api/init/notes.txt", nil), httprouter.Params{{Key: "filename", Value: "/notes.txt"}})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("non-media extension: status %v, want %v", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestMediaBoxes(t *testing.T) {
+	_, segment := fmp4Segment()
+	want := append(mp4Box("moof", "fragment"), mp4Box("mdat", "samples")...)
+	if got := mediaBoxes(segment); !bytes.Equal(got, want) {
+		t.Errorf("media boxes %q, want the moof and mdat boxes %q", got, want)
+	}
+	if got := mediaBoxes(want); !bytes.Equal(got, want) {
+		t.Errorf("media boxes of a media segment %q, want it unchanged", got)
+	}
+}
+
+func TestServeMediaBoxes(t *testing.T) {
+	dir := withTestRoot(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := encoder
+	requests := make(chan EncodingRequest, 1)
+	e.reqChan = requests
+	_, segment := fmp4Segment()
+	go func() {
+		r := <-requests
+		e.deliverData(r, &segment)
+	}()
+
+	w := httptest.NewRecorder()
+	hls(w, httptest.NewRequest("GET", "/api/hls/segments/a.mp4/0.ts?container=fmp4&boxes=media", nil), segmentParams("a.mp4/0.ts"))
+	want := append(mp4Box("moof", "fragment"), mp4Box("mdat", "samples")...)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) {
+		t.Errorf("status %v body %q, want the media boxes %q", w.Code, w.Body.Bytes(), want)
+	}
+
+	w = httptest.NewRecorder()
+	hls(w, httptest.NewRequest("GET", "/api/hls/segments/a.mp4/0.ts?container=fmp4&boxes=all", nil), segmentParams("a.mp4/0.ts"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown boxes: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
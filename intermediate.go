@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const intermediatesDirName = "intermediates"
+
+var (
+	// intermediateAfter is the number of encodes of a source at one
+	// resolution after which the source is scaled once to an intermediate
+	// file that later segments are cut from, trading disk for the CPU of
+	// decoding and scaling the full resolution source again for every
+	// segment. 0 disables intermediates.
+	intermediateAfter int
+	// intermediateMaxSize is the disk space in bytes intermediates may use.
+	// The least recently used ones are removed beyond it.
+	intermediateMaxSize int64 = 10 << 30
+	// intermediateCRF is the x264 quality of intermediates, high enough that
+	// the second encode adds little loss.
+	intermediateCRF = 16
+	// intermediateTimeLimit is how long building an intermediate may take
+	// before it is abandoned, 0 for no limit.
+	intermediateTimeLimit = time.Hour
+)
+
+// intermediateCountRetention is how long the encodes counted towards an
+// intermediate are remembered after the last one.
+const intermediateCountRetention = 24 * time.Hour
+
+// intermediateBuilds serializes the building of intermediates, so they do not
+// take more than one core's share away from the segment workers.
+var intermediateBuilds = make(chan struct{}, 1)
+
+type intermediateCount struct {
+	encodes int
+	seen    time.Time
+}
+
+// intermediateState counts the encodes of a source at a resolution and
+// remembers which intermediates are being built.
+var intermediateState = struct {
+	sync.Mutex
+	counts   map[string]intermediateCount
+	building map[string]bool
+	pruned   time.Time
+}{counts: make(map[string]intermediateCount), building: make(map[string]bool)}
+
+// intermediateCommand runs ffmpeg with args, replaceable in tests.
+var intermediateCommand = func(args []string) *exec.Cmd {
+	return exec.Command(FFMPEGPath, args...)
+}
+
+func intermediateDir() string {
+	return filepath.Join(root, HomeDir, intermediatesDirName)
+}
+
+// intermediateFile is where the intermediate of the given version of file at
+// res is stored. The name covers everything that changes the scaled video.
+func intermediateFile(file string, stat os.FileInfo, res int64) string {
+	h := sha1.New()
+	h.Write([]byte(file))
+	fmt.Fprintf(h, "\x00deinterlace=%v\x00scale=%v", deinterlace, scaleAlgorithm)
+	if sc := sidecarFor(file); sc != nil {
+		fmt.Fprintf(h, "\x00sidecar=%v", sc.hash)
+	}
+	return filepath.Join(intermediateDir(), fmt.Sprintf("%x.%v.%v.mkv", h.Sum(nil), stat.ModTime().UnixNano(), res))
+}
+
+// intermediateArgs scale the video of file to res once and copy all of its
+// audio streams, keyframing every second so segments can be cut from it
+// with a cheap input seek.
+func intermediateArgs(file string, res int64, info *videoInfo, out string) []string {
+	args := []string{
+		"-y",
+		"-hide_banner",
+		"-loglevel", ffmpegLogLevel,
+	}
+	if sc := sidecarFor(file); sc != nil {
+		args = append(args, sc.InputArgs...)
+	}
+	args = append(args,
+		"-i", file,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-vf", videoFilter(res, info),
+		"-vcodec", "libx264",
+		"-preset", "veryfast",
+		"-crf", fmt.Sprint(intermediateCRF),
+		"-force_key_frames", "expr:gte(t,n_forced*1)",
+		"-acodec", "copy",
+		"-f", "matroska",
+		out,
+	)
+	return args
+}
+
+// usesIntermediate reports whether r may be encoded from an intermediate:
+// a whole video segment of a local source with the video stream info.
+// Audio only sources have nothing to scale.
+func usesIntermediate(r EncodingRequest, info *videoInfo) bool {
+	return intermediateAfter > 0 && !dryRun && !noCache && info != nil && r.part == wholeSegment && r.audioTrack < 0 && !isRemoteSource(r.file)
+}
+
+// intermediateFor returns the intermediate r can be encoded from, or "" if
+// there is none yet. It counts the encode and starts building the
+// intermediate in the background once the source reaches intermediateAfter
+// encodes at r.res.
+func intermediateFor(r EncodingRequest, info *videoInfo) string {
+	if !usesIntermediate(r, info) {
+		return ""
+	}
+	stat, err := os.Stat(r.file)
+	if err != nil {
+		return ""
+	}
+	p := intermediateFile(r.file, stat, r.res)
+	if _, err := os.Stat(p); err == nil {
+		now := time.Now()
+		os.Chtimes(p, now, now)
+		return p
+	}
+
+	if countIntermediateEncode(p, time.Now()) {
+		go buildIntermediate(r.file, r.res, info, p)
+	}
+	return ""
+}
+
+// countIntermediateEncode counts an encode that the intermediate p would
+// have saved and reports whether it is to be built now. Counts that did not
+// grow for intermediateCountRetention are forgotten, so sources played
+// rarely, or replaced, do not pile up.
+func countIntermediateEncode(p string, now time.Time) bool {
+	intermediateState.Lock()
+	defer intermediateState.Unlock()
+	if now.Sub(intermediateState.pruned) > intermediateCountRetention {
+		for k, c := range intermediateState.counts {
+			if now.Sub(c.seen) > intermediateCountRetention {
+				delete(intermediateState.counts, k)
+			}
+		}
+		intermediateState.pruned = now
+	}
+	c := intermediateState.counts[p]
+	c.encodes++
+	c.seen = now
+	intermediateState.counts[p] = c
+	start := c.encodes >= intermediateAfter && !intermediateState.building[p]
+	if start {
+		intermediateState.building[p] = true
+	}
+	return start
+}
+
+// buildIntermediate writes the intermediate of file at res to p, then
+// evicts the least recently used intermediates beyond intermediateMaxSize.
+// Builds taking longer than intermediateTimeLimit are killed.
+func buildIntermediate(file string, res int64, info *videoInfo, p string) {
+	intermediateBuilds <- struct{}{}
+	defer func() {
+		<-intermediateBuilds
+		intermediateState.Lock()
+		delete(intermediateState.building, p)
+		delete(intermediateState.counts, p)
+		intermediateState.Unlock()
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		log.Errorf("Could not create intermediates dir: %v", err)
+		return
+	}
+	tmp := p + ".tmp"
+	log.Infof("Building %vp intermediate of %v", res, file)
+	cmd := intermediateCommand(intermediateArgs(file, res, info, tmp))
+	stderr := newTailWriter(stderrTailLines)
+	cmd.Stderr = stderr
+	if err := checkStartError(cmd.Start()); err != nil {
+		log.Errorf("Could not build intermediate of %v: %v", file, err)
+		return
+	}
+	if intermediateTimeLimit > 0 {
+		timer := time.AfterFunc(intermediateTimeLimit, func() {
+			log.Warnf("Building intermediate of %v took longer than %v, killing it", file, intermediateTimeLimit)
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+	if err := cmd.Wait(); err != nil {
+		os.Remove(tmp)
+		log.Errorf("Could not build intermediate of %v: %v: %v", file, err, stderr)
+		return
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		log.Errorf("Could not store intermediate of %v: %v", file, err)
+		return
+	}
+	if err := evictIntermediates(intermediateMaxSize); err != nil {
+		log.Errorf("Could not evict intermediates: %v", err)
+	}
+}
+
+// evictIntermediates removes the least recently used intermediates until
+// they take at most maxSize bytes. Intermediates being written are left
+// alone.
+func evictIntermediates(maxSize int64) error {
+	infos, err := ioutil.ReadDir(intermediateDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) != ".mkv" {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(intermediateDir(), info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Infof("Evicted intermediate %v", info.Name())
+		total -= info.Size()
+	}
+	return nil
+}
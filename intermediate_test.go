@@ -0,0 +1,267 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func withIntermediateAfter(t *testing.T, after int) {
+	saved := intermediateAfter
+	intermediateAfter = after
+	t.Cleanup(func() { intermediateAfter = saved })
+}
+
+// withIntermediateCommand makes intermediates be built by the shell script,
+// which gets the ffmpeg arguments, and returns the arguments of the builds.
+func withIntermediateCommand(t *testing.T, script string) func() [][]string {
+	saved := intermediateCommand
+	var mu sync.Mutex
+	var builds [][]string
+	intermediateCommand = func(args []string) *exec.Cmd {
+		mu.Lock()
+		builds = append(builds, args)
+		mu.Unlock()
+		return exec.Command("/bin/sh", append([]string{"-c", script, "sh"}, args...)...)
+	}
+	t.Cleanup(func() { intermediateCommand = saved })
+	return func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return builds
+	}
+}
+
+// writeLastArg writes "scaled" to the output file, the last argument.
+const writeLastArg = `for a; do out=$a; done; printf scaled > "$out"`
+
+// hdVideo is the video stream of a 1080p source.
+var hdVideo = &videoInfo{Width: 1920, Height: 1080}
+
+func intermediateSource(t *testing.T, dir string) string {
+	file := filepath.Join(dir, "a.mp4")
+	if err := ioutil.WriteFile(file, []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestIntermediateCreation(t *testing.T) {
+	dir := withTestRoot(t)
+	withIntermediateAfter(t, 2)
+	builds := withIntermediateCommand(t, writeLastArg)
+	r := *NewWarmupEncodingRequest(intermediateSource(t, dir), 0, 480)
+
+	if p := intermediateFor(r, hdVideo); p != "" {
+		t.Fatalf("intermediate %v before reaching the encode count", p)
+	}
+	var p string
+	deadline := time.Now().Add(5 * time.Second)
+	for p == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("intermediate not built")
+		}
+		time.Sleep(5 * time.Millisecond)
+		p = intermediateFor(r, hdVideo)
+	}
+
+	if got := builds(); len(got) != 1 {
+		t.Fatalf("%v builds, want 1", len(got))
+	}
+	args := builds()[0]
+	if !containsArgs(args, "-i", r.file) || !containsArgs(args, "-vf", "scale=-2:480") || !containsArgs(args, "-force_key_frames", "expr:gte(t,n_forced*1)") {
+		t.Errorf("intermediate args %v", args)
+	}
+	if out := args[len(args)-1]; out != p+".tmp" {
+		t.Errorf("intermediate written to %v, want a temporary file next to %v", out, p)
+	}
+	if data, err := ioutil.ReadFile(p); err != nil || string(data) != "scaled" {
+		t.Errorf("intermediate %q, %v", data, err)
+	}
+	if !strings.HasPrefix(p, intermediateDir()) {
+		t.Errorf("intermediate %v outside %v", p, intermediateDir())
+	}
+	// The build is done once it no longer counts as building.
+	for building := true; building; {
+		if time.Now().After(deadline) {
+			t.Fatal("intermediate still building")
+		}
+		intermediateState.Lock()
+		_, counted := intermediateState.counts[p]
+		building = intermediateState.building[p] || counted
+		intermediateState.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Other resolutions and changed sources count on their own.
+	other := r
+	other.res = 720
+	if intermediateFor(other, hdVideo) != "" {
+		t.Error("intermediate of another resolution used")
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(r.file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if intermediateFor(r, hdVideo) != "" {
+		t.Error("intermediate of a changed source used")
+	}
+}
+
+func TestNoIntermediateOfAudioOnlySource(t *testing.T) {
+	dir := withTestRoot(t)
+	withIntermediateAfter(t, 1)
+	builds := withIntermediateCommand(t, writeLastArg)
+	r := *NewWarmupEncodingRequest(intermediateSource(t, dir), 0, 480)
+	for i := 0; i < 3; i++ {
+		if p := intermediateFor(r, nil); p != "" {
+			t.Fatalf("intermediate %v of an audio only source", p)
+		}
+	}
+	stat, err := os.Stat(r.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateState.Lock()
+	_, counted := intermediateState.counts[intermediateFile(r.file, stat, r.res)]
+	intermediateState.Unlock()
+	if counted || len(builds()) != 0 {
+		t.Errorf("audio only source counted %v, %v intermediates built", counted, len(builds()))
+	}
+}
+
+func TestSegmentFromIntermediate(t *testing.T) {
+	withDeinterlace(t, deinterlaceAuto)
+	r := *NewWarmupEncodingRequest("/media/a.mp4", 2, 480)
+	key := r.getCacheKey()
+	r.intermediate = "/cache/intermediates/a.480.mkv"
+	args := EncodingArgs(r, &videoInfo{Width: 1920, Height: 1080, FieldOrder: "tt"})
+	if !containsArgs(args, "-ss", "20.00", "-i", r.intermediate) {
+		t.Errorf("segment not cut from the intermediate at its start: %v", args)
+	}
+	if containsArgs(args, "-i", r.file) {
+		t.Errorf("segment still decodes the source: %v", args)
+	}
+	if vf := args[argIndex(args, "-vf")+1]; strings.Contains(vf, "scale=") || strings.Contains(vf, "yadif") {
+		t.Errorf("intermediate scaled again: %v", vf)
+	}
+	if !containsArgs(args, "-force_key_frames", "expr:gte(t,n_forced*10.00)") {
+		t.Errorf("segment keyframes differ from those cut from the source: %v", args)
+	}
+	if r.getCacheKey() != key {
+		t.Error("segments cut from intermediates change the cache key")
+	}
+}
+
+func TestUsesIntermediate(t *testing.T) {
+	withIntermediateAfter(t, 0)
+	r := *NewWarmupEncodingRequest("/media/a.mp4", 2, 480)
+	if usesIntermediate(r, hdVideo) {
+		t.Error("intermediates used while disabled")
+	}
+	intermediateAfter = 3
+	if !usesIntermediate(r, hdVideo) {
+		t.Error("whole video segment does not use intermediates")
+	}
+	if usesIntermediate(r, nil) {
+		t.Error("audio only source uses intermediates")
+	}
+	part, audio, remote := r, r, r
+	part.part = 1
+	audio.audioTrack = 0
+	remote.file = "https://cdn.example.com/a.mp4"
+	for _, r := range []EncodingRequest{part, audio, remote} {
+		if usesIntermediate(r, hdVideo) {
+			t.Errorf("%v:%v part %v track %v uses intermediates", r.file, r.segment, r.part, r.audioTrack)
+		}
+	}
+}
+
+func TestIntermediateBuildTimeLimit(t *testing.T) {
+	dir := withTestRoot(t)
+	saved := intermediateTimeLimit
+	intermediateTimeLimit = 50 * time.Millisecond
+	t.Cleanup(func() { intermediateTimeLimit = saved })
+	withIntermediateCommand(t, `for a; do out=$a; done; printf partial > "$out"; exec sleep 10`)
+	file := intermediateSource(t, dir)
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := intermediateFile(file, stat, 480)
+
+	started := time.Now()
+	buildIntermediate(file, 480, nil, p)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("build ran for %v past its time limit", elapsed)
+	}
+	for _, name := range []string{p, p + ".tmp"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("abandoned build left %v", name)
+		}
+	}
+}
+
+func TestIntermediateCountsPruned(t *testing.T) {
+	withIntermediateAfter(t, 10)
+	intermediateState.Lock()
+	savedCounts, savedPruned := intermediateState.counts, intermediateState.pruned
+	intermediateState.counts, intermediateState.pruned = make(map[string]intermediateCount), time.Time{}
+	intermediateState.Unlock()
+	t.Cleanup(func() {
+		intermediateState.Lock()
+		intermediateState.counts, intermediateState.pruned = savedCounts, savedPruned
+		intermediateState.Unlock()
+	})
+
+	now := time.Now()
+	countIntermediateEncode("old", now.Add(-2*intermediateCountRetention))
+	countIntermediateEncode("recent", now.Add(-time.Hour))
+	countIntermediateEncode("recent", now.Add(-time.Hour))
+	countIntermediateEncode("new", now)
+	intermediateState.Lock()
+	defer intermediateState.Unlock()
+	if _, ok := intermediateState.counts["old"]; ok {
+		t.Error("stale count kept")
+	}
+	if c := intermediateState.counts["recent"]; c.encodes != 2 {
+		t.Errorf("recent count %v, want 2", c.encodes)
+	}
+}
+
+func TestEvictIntermediates(t *testing.T) {
+	withTestRoot(t)
+	if err := os.MkdirAll(intermediateDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, name := range []string{"oldest.mkv", "older.mkv", "newest.mkv", "building.mkv.tmp"} {
+		p := filepath.Join(intermediateDir(), name)
+		if err := ioutil.WriteFile(p, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(p, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := evictIntermediates(150); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ioutil.ReadDir(intermediateDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, info := range infos {
+		left = append(left, info.Name())
+	}
+	if strings.Join(left, ",") != "building.mkv.tmp,newest.mkv" {
+		t.Errorf("left %v, want the newest and the one being built", left)
+	}
+}
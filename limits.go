@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// maxDuration is the longest source in seconds that is served, 0 for no
+	// limit. Longer sources are refused, or capped to their first maxDuration
+	// seconds if truncateLongSources is set.
+	maxDuration         float64
+	truncateLongSources bool
+)
+
+// limitDuration applies maxDuration to a source of duration seconds. It
+// returns the duration to serve, or writes a 413 and returns false.
+func limitDuration(w http.ResponseWriter, duration float64) (float64, bool) {
+	if maxDuration <= 0 || duration <= maxDuration {
+		return duration, true
+	}
+	if !truncateLongSources {
+		http.Error(w, fmt.Sprintf("Duration %.f s exceeds the limit of %.f s", duration, maxDuration), http.StatusRequestEntityTooLarge)
+		return 0, false
+	}
+	w.Header()["X-Truncated-Duration"] = []string{strconv.FormatFloat(maxDuration, 'f', -1, 64)}
+	return maxDuration, true
+}
+
+// beyondDurationLimit reports whether segment starts past maxDuration.
+func beyondDurationLimit(segment int64) bool {
+	return maxDuration > 0 && float64(segment)*hlsSegmentLength >= maxDuration
+}
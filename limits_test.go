@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withDurationLimit sets the duration limit for the duration of a test.
+func withDurationLimit(t *testing.T, limit float64, truncate bool) {
+	oldLimit, oldTruncate := maxDuration, truncateLongSources
+	t.Cleanup(func() { maxDuration, truncateLongSources = oldLimit, oldTruncate })
+	maxDuration, truncateLongSources = limit, truncate
+}
+
+func TestLimitDurationUnlimited(t *testing.T) {
+	withDurationLimit(t, 0, false)
+	w := httptest.NewRecorder()
+	if d, ok := limitDuration(w, 1e6); !ok || d != 1e6 {
+		t.Errorf("limitDuration = %v, %v, want the full duration", d, ok)
+	}
+}
+
+func TestLimitDurationRefuses(t *testing.T) {
+	withDurationLimit(t, 3600, false)
+	w := httptest.NewRecorder()
+	if d, ok := limitDuration(w, 3600); !ok || d != 3600 {
+		t.Errorf("limitDuration at the limit = %v, %v", d, ok)
+	}
+	if _, ok := limitDuration(w, 3601); ok {
+		t.Fatal("source above the limit served")
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestLimitDurationTruncates(t *testing.T) {
+	withDurationLimit(t, 3600, true)
+	w := httptest.NewRecorder()
+	d, ok := limitDuration(w, 7200.5)
+	if !ok || d != 3600 {
+		t.Errorf("limitDuration = %v, %v, want 3600", d, ok)
+	}
+	if h := w.Header().Get("X-Truncated-Duration"); h != "3600" {
+		t.Errorf("X-Truncated-Duration %q, want 3600", h)
+	}
+}
+
+func TestBeyondDurationLimit(t *testing.T) {
+	withDurationLimit(t, 95, true)
+	for segment, want := range map[int64]bool{0: false, 9: false, 10: true, 11: true} {
+		if got := beyondDurationLimit(segment); got != want {
+			t.Errorf("beyondDurationLimit(%v) = %v, want %v", segment, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const wholeSegment = -1
+
+var (
+	// llhls enables low-latency HLS: segments are advertised as EXT-X-PART
+	// sub-segments which are encoded individually.
+	llhls bool
+	// llhlsParts is the number of parts each segment is split into. It must
+	// divide hlsSegmentLength evenly.
+	llhlsParts int64 = 5
+)
+
+func NewPartEncodingRequest(file string, segment int64, part int64, res int64) *EncodingRequest {
+	r := NewEncodingRequest(file, segment, res)
+	r.part = part
+	return r
+}
+
+// partLength is the duration of a single LL-HLS part in seconds.
+func partLength() int64 {
+	return int64(hlsSegmentLength) / llhlsParts
+}
+
+func validateLLHLSParts(parts int64) error {
+	if parts <= 0 || int64(hlsSegmentLength)%parts != 0 {
+		return fmt.Errorf("LL-HLS part count %v must evenly divide the segment length %v", parts, hlsSegmentLength)
+	}
+	return nil
+}
+
+// span returns the start time and length in seconds covered by r. Parts
+// split the segment in r.pieces, or in llhlsParts if that is unset.
+func (r *EncodingRequest) span() (float64, float64) {
+	start, length := segmentSpan(r.file, r.segment)
+	if r.part == wholeSegment {
+		return start, length
+	}
+	pieces := llhlsParts
+	if r.pieces > 0 {
+		pieces = r.pieces
+	}
+	length /= float64(pieces)
+	return start + float64(r.part)*length, length
+}
+
+func partURL(segmentsURL string, segment int64, part int64, query string) string {
+	return fmt.Sprintf("%v/%v%v", segmentsURL, segmentName(segment, part), query)
+}
+
+// writeLLHLSHeader writes the tags announcing partial segments.
+func writeLLHLSHeader(p *m3u8) {
+	target := float64(partLength())
+	p.tag("#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=%.3f", 3*target)
+	p.tag("#EXT-X-PART-INF:PART-TARGET=%.3f", target)
+}
+
+// writeLLHLSParts writes the EXT-X-PART tags for a segment of the given
+// duration. They precede the segment's own EXTINF line. If ranges has one
+// byte range per part, the parts are addressed as ranges of the segment.
+func writeLLHLSParts(p *m3u8, segmentsURL string, query string, segment int64, duration float64, ranges []byteRange) {
+	length := float64(partLength())
+	count := int64(math.Ceil(duration / length))
+	for part := int64(0); part < count; part++ {
+		d := math.Min(length, duration-float64(part)*length)
+		if int64(len(ranges)) == count {
+			p.tag("#EXT-X-PART:DURATION=%.3f,URI=\"%v\",BYTERANGE=\"%v@%v\",INDEPENDENT=YES", d, partURL(segmentsURL, segment, wholeSegment, query), ranges[part].length, ranges[part].offset)
+			continue
+		}
+		p.tag("#EXT-X-PART:DURATION=%.3f,URI=\"%v\",INDEPENDENT=YES", d, partURL(segmentsURL, segment, part, query))
+	}
+}
+
+// writeLLHLSPreloadHint announces the first part of segment, the one after
+// the last listed, so players can request it before it is published.
+func writeLLHLSPreloadHint(p *m3u8, segmentsURL string, query string, segment int64) {
+	p.tag("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%v\"", partURL(segmentsURL, segment, 0, query))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func withLLHLS(t *testing.T) {
+	llhls, llhlsParts = true, 5
+	t.Cleanup(func() { llhls, llhlsParts = false, 5 })
+}
+
+func TestLLHLSPlaylistParts(t *testing.T) {
+	withLLHLS(t)
+	var buffer bytes.Buffer
+	writePlaylist(&buffer, "http://h/api/hls/segments/a.mp4", "", "", 0, []float64{10, 5}, nil, nil, "VOD", true)
+	out := buffer.String()
+
+	for _, tag := range []string{
+		"#EXT-X-VERSION:6",
+		"#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=6.000",
+		"#EXT-X-PART-INF:PART-TARGET=2.000",
+		`#EXT-X-PART:DURATION=2.000,URI="http://h/api/hls/segments/a.mp4/0.0.ts",INDEPENDENT=YES`,
+		`#EXT-X-PART:DURATION=1.000,URI="http://h/api/hls/segments/a.mp4/1.2.ts",INDEPENDENT=YES`,
+		"#EXT-X-ENDLIST",
+	} {
+		if !strings.Contains(out, tag) {
+			t.Errorf("playlist lacks %v:\n%v", tag, out)
+		}
+	}
+	if n := strings.Count(out, "#EXT-X-PART:"); n != 8 {
+		t.Errorf("%v parts, want 8", n)
+	}
+	// Parts precede the EXTINF of their segment.
+	if strings.Index(out, "1.0.ts") > strings.Index(out, "#EXTINF:5.000000") {
+		t.Error("parts of segment 1 follow its EXTINF")
+	}
+	if strings.Contains(out, "#EXT-X-PRELOAD-HINT") {
+		t.Error("closed playlist has a preload hint")
+	}
+}
+
+func TestLLHLSPreloadHint(t *testing.T) {
+	withLLHLS(t)
+	var buffer bytes.Buffer
+	writePlaylist(&buffer, "http://h/api/hls/segments/a.mp4", "", "?res=720", 3, []float64{10, 10}, nil, nil, "EVENT", false)
+	out := buffer.String()
+
+	want := `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="http://h/api/hls/segments/a.mp4/5.0.ts?res=720"`
+	if !strings.HasSuffix(strings.TrimSpace(out), want) {
+		t.Errorf("playlist does not end with %v:\n%v", want, out)
+	}
+	if strings.Contains(out, "#EXT-X-ENDLIST") {
+		t.Error("growing playlist is closed")
+	}
+}
+
+func TestLLHLSByteRangeParts(t *testing.T) {
+	withLLHLS(t)
+	p := newM3U8()
+	ranges := []byteRange{{0, 100}, {100, 50}}
+	writeLLHLSParts(p, "http://h/s", "", 7, 4, ranges)
+	var buffer bytes.Buffer
+	p.WriteTo(&buffer)
+	if !strings.Contains(buffer.String(), `URI="http://h/s/7.ts",BYTERANGE="50@100"`) {
+		t.Errorf("parts are not byte ranges of the segment:\n%v", buffer.String())
+	}
+}
+
+func TestValidateLLHLSParts(t *testing.T) {
+	for parts, ok := range map[int64]bool{1: true, 2: true, 5: true, 3: false, 0: false, -1: false} {
+		if err := validateLLHLSParts(parts); (err == nil) != ok {
+			t.Errorf("validateLLHLSParts(%v) = %v", parts, err)
+		}
+	}
+}
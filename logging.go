@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// logOutput is where logs go: stderr, stdout or the path of a file,
+	// which is rotated like logFile.
+	logOutput        = "stderr"
+	logFile          string
+	logFileMaxSize   int64 = 100 << 20 // Bytes
+	logFileMaxBackup       = 5
+)
+
+// logDestination opens the writer logOutput names. Files are closed by the
+// returned function.
+func logDestination(output string) (io.Writer, func() error, error) {
+	switch output {
+	case "", "stderr":
+		return os.Stderr, func() error { return nil }, nil
+	case "stdout":
+		return os.Stdout, func() error { return nil }, nil
+	}
+	rf, err := newRotatingFile(output, logFileMaxSize, logFileMaxBackup)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rf, rf.Close, nil
+}
+
+// rotatingFile is an io.Writer appending to a file which is rotated once it
+// would grow past maxSize. Backups are named path.1 (newest) to path.N.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open log file %v: %v", rf.path, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = stat.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.file.Close()
+	if rf.maxBackups > 0 {
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%v.%v", rf.path, i), fmt.Sprintf("%v.%v", rf.path, i+1))
+		}
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog logs every request with its status and duration.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{w, http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.WithFields(log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   rec.status,
+			"duration": time.Since(start).String(),
+			"remote":   r.RemoteAddr,
+		}).Info("Request")
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 response and logs the
+// panic with its stack instead of dropping the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.WithFields(log.Fields{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"stack":  string(debug.Stack()),
+			}).Errorf("Panic serving request: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
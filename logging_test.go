@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestRotatingFileRotates(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "access.log")
+	rf, err := newRotatingFile(p, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(rf, "line %02d of the access log\n", i)
+	}
+	for _, name := range []string{p, p + ".1", p + ".2"} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if stat.Size() > 100 {
+			t.Errorf("%v has %v bytes, more than the maximum", name, stat.Size())
+		}
+	}
+	if _, err := os.Stat(p + ".3"); !os.IsNotExist(err) {
+		t.Error("more backups than configured were kept")
+	}
+	data, _ := os.ReadFile(p)
+	if !strings.Contains(string(data), "line 19") {
+		t.Errorf("current log lacks the last line: %q", data)
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "access.log")
+	os.WriteFile(p, []byte("existing\n"), 0644)
+	rf, err := newRotatingFile(p, 1000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("appended\n"))
+	rf.Close()
+	if data, _ := os.ReadFile(p); string(data) != "existing\nappended\n" {
+		t.Errorf("log %q", data)
+	}
+}
+
+func TestLogDestination(t *testing.T) {
+	for output, want := range map[string]*os.File{"": os.Stderr, "stderr": os.Stderr, "stdout": os.Stdout} {
+		w, closeOut, err := logDestination(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != want {
+			t.Errorf("%q logs to %v", output, w)
+		}
+		if err := closeOut(); err != nil {
+			t.Errorf("closing %q: %v", output, err)
+		}
+	}
+
+	p := filepath.Join(t.TempDir(), "server.log")
+	w, closeOut, err := logDestination(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(w, "to the file")
+	if err := closeOut(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(p); string(data) != "to the file\n" {
+		t.Errorf("log file %q", data)
+	}
+
+	if _, _, err := logDestination(filepath.Join(t.TempDir(), "missing", "server.log")); err == nil {
+		t.Error("unwritable log file accepted")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buffer bytes.Buffer
+	log.SetOutput(&buffer)
+	defer log.SetOutput(os.Stderr)
+
+	handler := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/playlist/a.mp4", nil))
+	for _, field := range []string{"status=418", "path=/api/playlist/a.mp4", "method=GET"} {
+		if !strings.Contains(buffer.String(), field) {
+			t.Errorf("access log lacks %v: %v", field, buffer.String())
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var matches []string
+		w.Write([]byte(matches[2]))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/hls/x", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("panicking handler: status %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+
+	aborting := recoverPanics(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want ErrAbortHandler passed on", err)
+		}
+	}()
+	aborting.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestMalformedSegmentPath(t *testing.T) {
+	withTestRoot(t)
+	w := httptest.NewRecorder()
+	recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hls(w, r, segmentParams("notasegment"))
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/api/hls/segments/notasegment", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("malformed segment path: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
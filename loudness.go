@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// loudnessTarget is the EBU R128 integrated loudness in LUFS audio is
+	// normalized to, 0 to leave it untouched. loudnorm runs in its single
+	// pass dynamic mode: each segment is encoded on its own, so the two pass
+	// mode, which measures the whole file first, is not available. The
+	// dynamic mode adapts its gain within the segment, which can make the
+	// volume step slightly at segment boundaries.
+	loudnessTarget float64
+	// loudnessTruePeak is the maximum true peak in dBTP.
+	loudnessTruePeak = -1.5
+	// loudnessRange is the loudness range target in LU.
+	loudnessRange = 11.0
+)
+
+func validateLoudness() error {
+	if loudnessTarget != 0 && (loudnessTarget < -70 || loudnessTarget > -5) {
+		return fmt.Errorf("Loudness target %v must be between -70 and -5 LUFS", loudnessTarget)
+	}
+	if loudnessTruePeak < -9 || loudnessTruePeak > 0 {
+		return fmt.Errorf("True peak %v must be between -9 and 0 dBTP", loudnessTruePeak)
+	}
+	if loudnessRange < 1 || loudnessRange > 50 {
+		return fmt.Errorf("Loudness range %v must be between 1 and 50 LU", loudnessRange)
+	}
+	return nil
+}
+
+// loudnessFilter is the loudnorm filter, "" if normalization is off.
+func loudnessFilter() string {
+	if loudnessTarget == 0 {
+		return ""
+	}
+	return fmt.Sprintf("loudnorm=I=%v:TP=%v:LRA=%v", loudnessTarget, loudnessTruePeak, loudnessRange)
+}
+
+// audioFilterArgs align and normalize the loudness of the output audio if
+// configured. video is whether the output has a video stream.
+func audioFilterArgs(video bool) []string {
+	var filters []string
+	for _, f := range []string{alignFilter(), loudnessFilter()} {
+		if f != "" {
+			filters = append(filters, f)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return append([]string{"-af", strings.Join(filters, ",")}, alignArgs(video)...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func withLoudnessTarget(t *testing.T, target float64) {
+	old := loudnessTarget
+	loudnessTarget = target
+	t.Cleanup(func() { loudnessTarget = old })
+}
+
+func TestLoudnessFilter(t *testing.T) {
+	withLoudnessTarget(t, 0)
+	if f := loudnessFilter(); f != "" {
+		t.Errorf("filter %v while normalization is off", f)
+	}
+	if args := audioFilterArgs(true); args != nil {
+		t.Errorf("audio filter args %v while normalization is off", args)
+	}
+
+	loudnessTarget = -16
+	want := "loudnorm=I=-16:TP=-1.5:LRA=11"
+	if f := loudnessFilter(); f != want {
+		t.Errorf("filter %v, want %v", f, want)
+	}
+	for _, video := range []bool{true, false} {
+		if args := audioFilterArgs(video); !reflect.DeepEqual(args, []string{"-af", want}) {
+			t.Errorf("audio filter args %v", args)
+		}
+	}
+}
+
+func TestLoudnessEncodingArgs(t *testing.T) {
+	withLoudnessTarget(t, -23)
+	r := NewWarmupEncodingRequest("/media/a.mp4", 1, 480)
+	args := EncodingArgs(*r, nil)
+	if !containsArgs(args, "-af", "loudnorm=I=-23:TP=-1.5:LRA=11") {
+		t.Errorf("loudnorm missing: %v", args)
+	}
+	if i, j := argIndex(args, "-af"), argIndex(args, "-acodec"); i < 0 || i > j {
+		t.Errorf("audio filter not before the codec: %v", args)
+	}
+	key := r.getCacheKey()
+	loudnessTarget = -16
+	if r.getCacheKey() == key {
+		t.Error("loudness target does not change the cache key")
+	}
+	loudnessTarget = 0
+	if containsArgs(EncodingArgs(*r, nil), "-af") {
+		t.Error("audio filter applied with normalization off")
+	}
+}
+
+func TestValidateLoudness(t *testing.T) {
+	saved := []float64{loudnessTarget, loudnessTruePeak, loudnessRange}
+	t.Cleanup(func() { loudnessTarget, loudnessTruePeak, loudnessRange = saved[0], saved[1], saved[2] })
+	for _, test := range []struct {
+		target, peak, lra float64
+		valid             bool
+	}{
+		{0, -1.5, 11, true},
+		{-16, -1, 7, true},
+		{-80, -1.5, 11, false},
+		{-16, 1, 11, false},
+		{-16, -1.5, 60, false},
+	} {
+		loudnessTarget, loudnessTruePeak, loudnessRange = test.target, test.peak, test.lra
+		if err := validateLoudness(); (err == nil) != test.valid {
+			t.Errorf("%+v: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// minPlaylistVersion is required by the decimal EXTINF durations every
+// playlist uses.
+const minPlaylistVersion = 3
+
+// tagVersions lists the protocol version each version-gated tag requires.
+var tagVersions = map[string]int{
+	"#EXT-X-BYTERANGE":          4,
+	"#EXT-X-I-FRAMES-ONLY":      4,
+	"#EXT-X-I-FRAME-STREAM-INF": 4,
+	"#EXT-X-MAP":                6,
+	"#EXT-X-SERVER-CONTROL":     6,
+	"#EXT-X-PART-INF":           6,
+	"#EXT-X-PART":               6,
+	"#EXT-X-PRELOAD-HINT":       6,
+	"#EXT-X-GAP":                8,
+}
+
+// tagRemovals lists tags dropped from the protocol as of a version. They are
+// left out of playlists declaring that version or later.
+var tagRemovals = map[string]int{
+	"#EXT-X-ALLOW-CACHE": 7,
+}
+
+// m3u8 collects the lines of a playlist and derives the EXT-X-VERSION it
+// declares from the tags actually used, so the two never disagree.
+type m3u8 struct {
+	version int
+	lines   []string
+}
+
+func newM3U8() *m3u8 {
+	return &m3u8{version: minPlaylistVersion}
+}
+
+// tag adds a tag line, raising the playlist version if the tag requires it.
+func (p *m3u8) tag(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if v := tagVersions[tagName(line)]; v > p.version {
+		p.version = v
+	}
+	p.lines = append(p.lines, line)
+}
+
+// uri adds a URI line.
+func (p *m3u8) uri(format string, args ...interface{}) {
+	p.lines = append(p.lines, fmt.Sprintf(format, args...))
+}
+
+func (p *m3u8) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "#EXTM3U\n")
+	fmt.Fprintf(&buf, "#EXT-X-VERSION:%v\n", p.version)
+	for _, line := range p.lines {
+		if v, ok := tagRemovals[tagName(line)]; ok && p.version >= v {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.WriteTo(w)
+}
+
+func tagName(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
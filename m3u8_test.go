@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func playlistVersion(p *m3u8) string {
+	var b bytes.Buffer
+	p.WriteTo(&b)
+	lines := strings.SplitN(b.String(), "\n", 3)
+	return lines[1]
+}
+
+func TestM3U8Version(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want string
+	}{
+		{[]string{"#EXT-X-TARGETDURATION:10", "#EXTINF:10.000,"}, "#EXT-X-VERSION:3"},
+		{[]string{"#EXTINF:10.000,", "#EXT-X-BYTERANGE:100@0"}, "#EXT-X-VERSION:4"},
+		{[]string{"#EXT-X-I-FRAMES-ONLY"}, "#EXT-X-VERSION:4"},
+		{[]string{"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1,URI=\"i\""}, "#EXT-X-VERSION:4"},
+		{[]string{"#EXT-X-MAP:URI=\"init.mp4\"", "#EXT-X-BYTERANGE:100@0"}, "#EXT-X-VERSION:6"},
+		{[]string{"#EXT-X-PART-INF:PART-TARGET=2", "#EXT-X-GAP"}, "#EXT-X-VERSION:8"},
+	}
+	for _, test := range tests {
+		p := newM3U8()
+		for _, tag := range test.tags {
+			p.tag("%v", tag)
+		}
+		if v := playlistVersion(p); v != test.want {
+			t.Errorf("%v: %v, want %v", test.tags, v, test.want)
+		}
+	}
+}
+
+func TestM3U8DropsRemovedTags(t *testing.T) {
+	p := newM3U8()
+	p.tag("#EXT-X-ALLOW-CACHE:NO")
+	var b bytes.Buffer
+	p.WriteTo(&b)
+	if !strings.Contains(b.String(), "#EXT-X-ALLOW-CACHE:NO\n") {
+		t.Errorf("version 3 playlist lost EXT-X-ALLOW-CACHE:\n%v", b.String())
+	}
+
+	p.tag("#EXT-X-GAP")
+	b.Reset()
+	p.WriteTo(&b)
+	if strings.Contains(b.String(), "#EXT-X-ALLOW-CACHE") {
+		t.Errorf("version 8 playlist has EXT-X-ALLOW-CACHE:\n%v", b.String())
+	}
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"crypto/sha1"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,7 +19,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -24,10 +29,46 @@ import (
 
 const (
 	FFMPEGPath       = "ffmpeg"
-	root             = "/data/"
 	HomeDir          = ".agentVideo"
 	cacheDirName     = "cache"
 	hlsSegmentLength = 10.0 // Seconds
+	defaultPreroll   = int64(5)
+	dryRunOutput     = "dry-run\n"
+	serviceName      = "agentVideo"
+)
+
+var (
+	// root is the media directory every source name is resolved in.
+	root = "/data/"
+
+	// readAheadSegments is how many segments past the playback position are kept warm.
+	readAheadSegments int64 = 2
+
+	// version is set at build time with -ldflags "-X main.version=...".
+	version = "dev"
+
+	// indexFile replaces the JSON status page served at the root.
+	indexFile string
+
+	encoder *Encoder
+
+	// prerollSeconds is how far before a segment start decoding begins, so
+	// the accurate output seek can land on a keyframe. With prerollFromGOP
+	// it is raised to the source's detected keyframe interval.
+	prerollSeconds = defaultPreroll
+	prerollFromGOP bool
+
+	// dryRun makes execute log commands instead of running them.
+	dryRun bool
+
+	// Server timeouts. Segment responses may wait on a cold encode for far
+	// longer than writeTimeout, so they extend their own write deadline to
+	// segmentWriteTimeout.
+	readHeaderTimeout   = 10 * time.Second
+	readTimeout         = 30 * time.Second
+	writeTimeout        = 30 * time.Second
+	idleTimeout         = 120 * time.Second
+	segmentWriteTimeout = 90 * time.Second
 )
 
 // hhmmssmsToSeconds converts timecode (HH:MM:SS.MS) to seconds (SS.MS).
@@ -95,7 +136,24 @@ func urlEncoded(str string) (string, error) {
 	return u.String(), nil
 }
 
+// resolveMediaPath joins a client supplied name onto root, rejecting names
+// that would escape it.
+func resolveMediaPath(name string) (string, error) {
+	base := filepath.Clean(root)
+	p := filepath.Join(base, filepath.FromSlash(name))
+	if p != base && !strings.HasPrefix(p, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("Path %v is outside of the media root", name)
+	}
+	return p, nil
+}
+
 func execute(cmdPath string, args []string) (data []byte, err error) {
+	if dryRun {
+		log.Infof("Dry run: %v %v", cmdPath, strings.Join(args, " "))
+		data = []byte(dryRunOutput)
+		return
+	}
+
 	cmd := exec.Command(cmdPath, args...)
 	stdout, err := cmd.StdoutPipe()
 	defer stdout.Close()
@@ -105,27 +163,38 @@ func execute(cmdPath string, args []string) (data []byte, err error) {
 		return
 	}
 
+	stderr := newTailWriter(stderrTailLines)
+	cmd.Stderr = stderr
+
 	log.Debugf("Executing: %v %v", cmdPath, args)
-	err = cmd.Start()
+	err = checkStartError(cmd.Start())
 	if err != nil {
-		err = fmt.Errorf("Error starting command: %v", err)
 		return
 	}
 
 	var buffer bytes.Buffer
 	_, err = io.Copy(&buffer, stdout)
 	if err != nil {
-		cmd.Process.Signal(syscall.SIGKILL)
-		cmd.Process.Wait()
+		terminate(cmd.Process)
 		err = fmt.Errorf("Error copying stdout to buffer: %v", err)
 		return
 	}
 
 	err = cmd.Wait()
 	if err != nil {
-		err = fmt.Errorf("Command failed %v", err)
+		err = fmt.Errorf("Command failed %v: %v", err, stderr)
 		return
 	}
+	if out := stderr.String(); out != "" {
+		log.Debugf("Command output: %v", out)
+	}
+	if errs := stderr.Errors(); errs != "" {
+		if stderrErrors == stderrFail {
+			err = fmt.Errorf("Command reported errors: %v", errs)
+			return
+		}
+		log.Warnf("Command reported errors: %v", errs)
+	}
 
 	data = buffer.Bytes()
 
@@ -135,17 +204,44 @@ func execute(cmdPath string, args []string) (data []byte, err error) {
 type EncodingRequest struct {
 	file    string
 	segment int64
+	part    int64 // LL-HLS part index, or wholeSegment
+	pieces  int64 // Pieces the segment is split in for part, 0 for LL-HLS parts
 	res     int64
-	data    chan *[]byte
-	err     chan error
+	quality string // Quality tier, or "" for the default settings
+	// container of the segment, or "" for MPEG-TS
+	container string
+	watermark string // Image overlaid on the video, or ""
+	timecode  bool   // Whether the source time is burned in
+	audio     string // Optional external audio file replacing the source audio
+	// audioTrack selects an audio-only rendition of that source audio
+	// stream, noAudioTrack for the video alone, or -1 for the regular video
+	// stream.
+	audioTrack int
+	// intermediate is a copy of file already scaled to res that the segment
+	// is cut from instead, or "". It is left out of the cache key: a segment
+	// cut from it has the timestamps, keyframes, size and codecs of one cut
+	// from file, and the intermediate's name covers every option changing
+	// the scaled picture, so either stands in for the other. They differ
+	// only by the loss of the extra encode, which intermediateCRF keeps
+	// below notice.
+	intermediate string
+	data         chan *[]byte
+	err          chan error
 }
 
 func NewEncodingRequest(file string, segment int64, res int64) *EncodingRequest {
-	return &EncodingRequest{file, segment, res, make(chan *[]byte, 1), make(chan error, 1)}
+	r := NewWarmupEncodingRequest(file, segment, res)
+	r.data = make(chan *[]byte, 1)
+	r.err = make(chan error, 1)
+	return r
 }
 
+// NewWarmupEncodingRequest returns a request without a reply channel, with
+// the global overlay settings.
 func NewWarmupEncodingRequest(file string, segment int64, res int64) *EncodingRequest {
-	return &EncodingRequest{file, segment, res, nil, nil}
+	r := &EncodingRequest{file: file, segment: segment, part: wholeSegment, res: res, audioTrack: -1}
+	r.watermark, r.timecode = defaultOverlay()
+	return r
 }
 
 func (r *EncodingRequest) sendError(err error) {
@@ -163,59 +259,292 @@ func (r *EncodingRequest) sendData(data *[]byte) {
 func (r *EncodingRequest) getCacheKey() string {
 	h := sha1.New()
 	h.Write([]byte(r.file))
-	return fmt.Sprintf("%x.%v.%v", h.Sum(nil), r.res, r.segment)
+	if r.audio != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(r.audio))
+	}
+	if r.audioTrack >= 0 || r.audioTrack == noAudioTrack {
+		fmt.Fprintf(h, "\x00audiotrack=%v", r.audioTrack)
+	}
+	if preset := r.presetFor(); preset != defaultPreset {
+		fmt.Fprintf(h, "\x00preset=%v", preset)
+	}
+	if audioCodec != defaultAudioCodec {
+		fmt.Fprintf(h, "\x00acodec=%v", audioCodec)
+	}
+	if r.quality != "" {
+		fmt.Fprintf(h, "\x00quality=%v", r.quality)
+	}
+	if r.container != "" {
+		fmt.Fprintf(h, "\x00container=%v", r.container)
+	}
+	if r.container == containerFMP4 {
+		h.Write([]byte("\x00profile=pinned"))
+	}
+	if r.container == containerFMP4 && fmp4Timestamps != timestampsAbsolute {
+		fmt.Fprintf(h, "\x00timestamps=%v", fmp4Timestamps)
+	}
+	if r.usesCMAFParts() {
+		fmt.Fprintf(h, "\x00cmafparts=%v", llhlsParts)
+	}
+	if r.watermark != "" || r.timecode {
+		fmt.Fprintf(h, "\x00overlay=%v,%v", r.watermark, r.timecode)
+	}
+	if prerollSeconds != defaultPreroll || prerollFromGOP {
+		fmt.Fprintf(h, "\x00preroll=%v,%v", prerollSeconds, prerollFromGOP)
+	}
+	if seekStrategy != seekPreroll {
+		fmt.Fprintf(h, "\x00seek=%v,%v", seekStrategy, seekGOPThreshold)
+	}
+	if deinterlace != deinterlaceAuto {
+		fmt.Fprintf(h, "\x00deinterlace=%v", deinterlace)
+	}
+	if scaleAlgorithm != "" {
+		fmt.Fprintf(h, "\x00scale=%v", scaleAlgorithm)
+	}
+	if f := loudnessFilter(); f != "" {
+		fmt.Fprintf(h, "\x00loudness=%v", f)
+	}
+	if alignAudio {
+		fmt.Fprintf(h, "\x00align=%v", alignFilter())
+	}
+	if variableSegments {
+		fmt.Fprintf(h, "\x00segments=variable,%v", segmentTimeDelta)
+	}
+	if minSegmentDuration > 0 {
+		fmt.Fprintf(h, "\x00minsegment=%v", minSegmentDuration)
+	}
+	if sc := sidecarFor(r.file); sc != nil {
+		fmt.Fprintf(h, "\x00sidecar=%v", sc.hash)
+	}
+	if key := colorKey(); key != "" {
+		fmt.Fprintf(h, "\x00color=%v", key)
+	}
+	group := cacheGroupName(r.file, h.Sum(nil))
+	if r.part != wholeSegment {
+		return fmt.Sprintf("%v.%v.%v.%v", group, r.res, r.segment, r.part)
+	}
+	return fmt.Sprintf("%v.%v.%v", group, r.res, r.segment)
+}
+
+// warmWindow tracks the last requested segment of a file at one resolution
+// and the furthest segment queued for warmup ahead of it.
+type warmWindow struct {
+	position int64
+	queued   int64
+}
+
+const windowShards = 16
+
+// windowShard guards the warm windows of the files hashed to it, so requests
+// for different files rarely contend on the same lock.
+type windowShard struct {
+	mu      sync.Mutex
+	windows map[string]*warmWindow
+}
+
+type warmWindows [windowShards]windowShard
+
+// lock returns the locked shard holding key. Callers must unlock it.
+func (ws *warmWindows) lock(key string) *windowShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	s := &ws[h.Sum32()%windowShards]
+	s.mu.Lock()
+	if s.windows == nil {
+		s.windows = make(map[string]*warmWindow)
+	}
+	return s
 }
 
 type Encoder struct {
-	cacheDir string
-	reqChan  chan EncodingRequest
+	cacheDir  string
+	reqChan   chan EncodingRequest
+	readAhead int64
+
+	// Encoders are shared by all handlers; every field below is safe for
+	// concurrent use.
+	windows warmWindows
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	latency  latencyWindow
+	failures failureCounter
+	inflight inflightCounter
+	errors   errorHistory
+	progress progressHub
+	pending  pendingEncodes
+	queued   queuedResolutions
+	// decodes admits encodes within decodeBudget, nil if unlimited.
+	decodes *weightedSemaphore
 }
 
 func NewEncoder(cacheDir string, workerCount int) *Encoder {
 	rc := make(chan EncodingRequest, 100)
-	encoder := &Encoder{cacheDir, rc}
-	go func() {
-		for {
-			r := <-rc
-			cache, err := encoder.GetFromCache(r)
-			if err != nil {
-				r.sendError(err)
-				continue
-			}
-			if cache != nil {
-				r.sendData(&cache)
-				continue
-			}
-			log.Debugf("Encoding %v:%v", r.file, r.segment)
-			data, err := execute(FFMPEGPath, EncodingArgs(r.file, r.segment, r.res))
-			if err != nil {
-				r.err <- err
-				continue
-			}
-			r.sendData(&data)
-			tmp := encoder.GetCacheFile(r) + ".tmp"
-			mkerr := os.MkdirAll(filepath.Join(root, HomeDir, encoder.cacheDir), 0777)
-			if mkerr != nil {
-				log.Errorf("Could not create cache dir")
-				continue
-			}
-			if err2 := ioutil.WriteFile(tmp, data, 0777); err2 == nil {
-				os.Rename(tmp, encoder.GetCacheFile(r))
+	encoder := &Encoder{
+		cacheDir:  cacheDir,
+		reqChan:   rc,
+		readAhead: readAheadSegments,
+	}
+	if decodeBudget > 0 {
+		encoder.decodes = newWeightedSemaphore(decodeBudget)
+	}
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			for {
+				r := <-rc
+				if !encoder.queued.take(r) {
+					log.Debugf("Skipping %v:%v encoded with another resolution", r.file, r.segment)
+					continue
+				}
+				if r.data == nil && !encoder.wantWarmup(r) {
+					log.Debugf("Skipping passed warmup %v:%v", r.file, r.segment)
+					continue
+				}
+				cache, err := encoder.GetFromCache(r)
+				if err != nil {
+					encoder.deliverError(r, err)
+					continue
+				}
+				if cache != nil {
+					encoder.deliverData(r, &cache)
+					continue
+				}
+				if err := checkSource(r.file); err != nil {
+					encoder.errors.add(r.file, r.segment, err)
+					encoder.deliverError(r, &EncodeError{r.file, r.segment, err})
+					continue
+				}
+				log.Debugf("Encoding %v:%v", r.file, r.segment)
+				info, err := sourceVideoInfo(r.file)
+				if err != nil {
+					log.Warnf("Could not probe %v, assuming landscape: %v", r.file, err)
+				}
+				var claimed, siblings []EncodingRequest
+				if r.intermediate = intermediateFor(r, info); r.intermediate == "" {
+					if claimed = encoder.queued.claim(r); len(claimed) == 0 {
+						siblings = encoder.multiResolutionSiblings(r, info)
+					}
+				}
+				resolutions := 1 + len(claimed)
+				if len(siblings) > 1 {
+					resolutions = len(siblings)
+				}
+				release := encoder.admitDecode(info, resolutions)
+				encoder.progress.publish(r, progressStarted, nil)
+				started := time.Now()
+				var data []byte
+				if r.intermediate != "" {
+					data, err = execute(FFMPEGPath, EncodingArgs(r, info))
+				} else if len(claimed) > 0 {
+					log.Debugf("Coalescing %v:%v with %v other resolutions", r.file, r.segment, len(claimed))
+					for _, c := range claimed {
+						encoder.progress.publish(c, progressStarted, nil)
+					}
+					var outputs [][]byte
+					if outputs, err = encodeResolutions(append([]EncodingRequest{r}, claimed...), info, execute); err == nil {
+						data = outputs[0]
+						for i, c := range claimed {
+							encoder.encodeDone(c, outputs[i+1])
+						}
+					} else {
+						for _, c := range claimed {
+							encoder.encodeFailed(c, err)
+						}
+					}
+				} else if len(siblings) > 1 {
+					var outputs [][]byte
+					if outputs, err = encodeResolutions(siblings, info, execute); err == nil {
+						data = outputs[0]
+						for i, s := range siblings[1:] {
+							encoder.cacheSegment(s, outputs[i+1])
+						}
+					}
+				} else if splitEncode > 1 && r.part == wholeSegment && r.container == "" && !dryRun {
+					data, err = encodeSplit(r, info, execute)
+				} else {
+					data, err = execute(FFMPEGPath, EncodingArgs(r, info))
+				}
+				release()
+				encoder.latency.add(time.Since(started))
+				if err == nil && len(data) == 0 {
+					err = ErrEmptyOutput
+				}
+				if err != nil {
+					encoder.encodeFailed(r, err)
+					continue
+				}
+				encoder.encodeDone(r, data)
 			}
-		}
-	}()
+		}()
+	}
 	return encoder
 }
 
-func (e *Encoder) GetFromCache(r EncodingRequest) ([]byte, error) {
+// encodeFailed records that encoding r failed and sends err to everyone
+// waiting for it.
+func (e *Encoder) encodeFailed(r EncodingRequest, err error) {
+	if n := e.failures.record(r); n == gapAfterFailures {
+		log.Errorf("Giving up on %v:%v after %v failed encodes", r.file, r.segment, n)
+	}
+	e.errors.add(r.file, r.segment, err)
+	e.progress.publish(r, progressFailed, err)
+	e.deliverError(r, &EncodeError{r.file, r.segment, err})
+}
 
+// encodeDone records that r was encoded, delivers data to everyone waiting
+// for it and caches it.
+func (e *Encoder) encodeDone(r EncodingRequest, data []byte) {
+	e.failures.clear(r)
+	e.progress.publish(r, progressDone, nil)
+	e.deliverData(r, &data)
+	if dryRun {
+		return
+	}
+	e.cacheSegment(r, data)
+}
+
+// cacheSegment stores the encoded data of r and records its source.
+func (e *Encoder) cacheSegment(r EncodingRequest, data []byte) {
+	if noCache {
+		return
+	}
+	if err := writeCacheFile(e.GetCacheFile(r), data); err != nil {
+		log.Errorf("Could not cache %v:%v: %v", r.file, r.segment, err)
+	}
+	if err := e.recordSource(r); err != nil {
+		log.Errorf("Could not record source of %v: %v", r.file, err)
+	}
+}
+
+// statCache returns the cache file info of r, or nil if it is not cached.
+func (e *Encoder) statCache(r EncodingRequest) (os.FileInfo, error) {
+	if noCache {
+		return nil, nil
+	}
 	cachePath := e.GetCacheFile(r)
-	if _, err := os.Stat(cachePath); err != nil {
+	stat, err := os.Stat(cachePath)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("Encoder cache file %v could not be opened because: %v", cachePath, err)
 	}
+	return stat, nil
+}
+
+func (e *Encoder) isCached(r EncodingRequest) bool {
+	stat, _ := e.statCache(r)
+	return stat != nil
+}
+
+func (e *Encoder) GetFromCache(r EncodingRequest) ([]byte, error) {
+
+	cachePath := e.GetCacheFile(r)
+	if stat, err := e.statCache(r); stat == nil {
+		return nil, err
+	}
 	dat, err := ioutil.ReadFile(cachePath)
 	if err != nil {
 		return nil, fmt.Errorf("Encoder could not read cache file %v because: %v", cachePath, err)
@@ -224,7 +553,7 @@ func (e *Encoder) GetFromCache(r EncodingRequest) ([]byte, error) {
 }
 
 func (e *Encoder) GetCacheFile(r EncodingRequest) string {
-	return filepath.Join(root, HomeDir, e.cacheDir, r.getCacheKey())
+	return e.cacheFilePath(r.getCacheKey())
 }
 
 func (e *Encoder) Encode(r EncodingRequest) {
@@ -236,136 +565,1223 @@ func (e *Encoder) Encode(r EncodingRequest) {
 			return
 		}
 		if data != nil {
+			e.hits.Add(1)
 			r.sendData(&data)
+		} else if stale := e.loadStale(r); stale != nil {
+			log.Debugf("Serving stale %v:%v while it is encoded again", r.file, r.segment)
+			r.sendData(&stale)
+			e.regenerate(r)
+		} else {
+			e.misses.Add(1)
+			if !e.pending.join(r) {
+				log.Debugf("Joining pending encode of %v:%v", r.file, r.segment)
+			} else if e.queued.add(r); !e.enqueue(r) && e.queued.take(r) {
+				// take fails if a worker claimed r meanwhile, which then encodes it.
+				log.Warnf("Encoder queue full, rejecting %v:%v", r.file, r.segment)
+				e.deliverError(r, &EncodeError{r.file, r.segment, ErrQueueFull})
+				return
+			}
+		}
+		if r.part != wholeSegment || followPlayback || noCache {
+			// LL-HLS parts are short enough to encode on demand, and
+			// followed playback is warmed by heartbeats. Without a cache
+			// warmups would be thrown away.
 			return
 		}
-		e.reqChan <- r
-		e.reqChan <- *NewWarmupEncodingRequest(r.file, r.segment+1, r.res)
-		e.reqChan <- *NewWarmupEncodingRequest(r.file, r.segment+2, r.res)
+		e.warmAhead(r)
 	}()
 }
 
-func EncodingArgs(videoFile string, segment int64, res int64) []string {
-	startTime := segment * hlsSegmentLength
-	var (
-		pressTime  int64 = 0
-		postssTime int64 = 0
-		//offsetTime int64 = 0
-	)
+func windowKey(r EncodingRequest) string {
+	return fmt.Sprintf("%v:%v:%v:%v:%v:%v:%v:%v", r.file, r.audio, r.audioTrack, r.res, r.quality, r.container, r.watermark, r.timecode)
+}
+
+// advanceWindow moves the read-ahead window of r's file to r.segment and
+// returns the segments that newly entered the window and need a warmup.
+func (e *Encoder) advanceWindow(r EncodingRequest) []int64 {
+	key := windowKey(r)
+	s := e.windows.lock(key)
+	defer s.mu.Unlock()
 
-	if startTime > 0 {
-		pressTime = startTime - 5
-		postssTime = 5
-		//offsetTime = startTime + hlsSegmentLength + 2
+	w, ok := s.windows[key]
+	if !ok || r.segment < w.position {
+		// First request or a backward seek: start a fresh window.
+		w = &warmWindow{position: r.segment, queued: r.segment}
+		s.windows[key] = w
+	}
+	w.position = r.segment
+	if w.queued < r.segment {
+		w.queued = r.segment
 	}
 
-	return []string{
+	var segments []int64
+	for w.queued < r.segment+e.readAhead {
+		w.queued++
+		segments = append(segments, w.queued)
+	}
+	return segments
+}
+
+// wantWarmup reports whether warmup request r is still ahead of the client,
+// i.e. inside the current read-ahead window of its file.
+func (e *Encoder) wantWarmup(r EncodingRequest) bool {
+	key := windowKey(r)
+	s := e.windows.lock(key)
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[key]
+	if !ok {
+		return true
+	}
+	return r.segment > w.position && r.segment <= w.position+e.readAhead
+}
+
+// scaleFilter scales the displayed picture so its shorter side is res pixels.
+// ffmpeg autorotates the input before the filter chain, so portrait videos
+// (including rotated phone recordings) are constrained on width instead.
+func scaleFilter(res int64, info *videoInfo) string {
+	if info != nil && info.IsPortrait() {
+		return fmt.Sprintf("scale=%v:-2%v", res, scaleFlags())
+	}
+	return fmt.Sprintf("scale=-2:%v%v", res, scaleFlags())
+}
+
+// videoFilter is the -vf chain of an output of res lines, deinterlacing
+// first when deinterlaceFor asks for it.
+func videoFilter(res int64, info *videoInfo) string {
+	if deinterlaceFor(info) {
+		return "yadif," + scaleFilter(res, info)
+	}
+	return scaleFilter(res, info)
+}
+
+// prerollFor returns the number of seconds decoded ahead of a segment start.
+func prerollFor(info *videoInfo) int64 {
+	preroll := prerollSeconds
+	if prerollFromGOP && info != nil && info.KeyframeInterval > 0 {
+		if gop := int64(math.Ceil(info.KeyframeInterval)); gop > preroll {
+			preroll = gop
+		}
+	}
+	return preroll
+}
+
+// seekOffsets splits the seek to startTime into a fast input seek to
+// startTime-preroll and an accurate output seek over the remaining preroll.
+func seekOffsets(startTime float64, preroll int64) (pressTime float64, postssTime float64) {
+	postssTime = math.Min(float64(preroll), startTime)
+	return startTime - postssTime, postssTime
+}
+
+func EncodingArgs(r EncodingRequest, info *videoInfo) []string {
+	startTime, length := r.span()
+	pressTime, postssTime := seekFor(startTime, info)
+	sc := sidecarFor(r.file)
+	input, filter := r.file, videoFilter(r.res, info)
+	if r.intermediate != "" {
+		// Intermediates are keyframed every second and already carry the
+		// sidecar input options, deinterlacing and scaling.
+		input, filter = r.intermediate, "null"
+		pressTime, postssTime = startTime, 0
+	}
+
+	args := []string{
 		"-y",
-		"-timelimit", "45",
-		"-ss", fmt.Sprintf("%v.00", pressTime),
-		"-i", videoFile,
-		"-ss", fmt.Sprintf("%v.00", postssTime),
-		"-t", fmt.Sprintf("%v.00", hlsSegmentLength),
-		"-vf", fmt.Sprintf("scale=-2:%v", res),
-		"-vcodec", "libx264",
-		"-preset", "veryfast",
-		"-acodec", "libfdk_aac", //"libvo_aacenc",
-		"-pix_fmt", "yuv420p",
-		//"-r", "25", // fixed framerate
-		//"-vsync", "cfr",
-		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%v.00)", hlsSegmentLength),
-		//"-x264opts", "keyint=25:min-keyint=25:scenecut=-1",
-		"-f", "ssegment",
-		"-segment_time", fmt.Sprintf("%v.00", hlsSegmentLength),
-		"-initial_offset", fmt.Sprintf("%v.00", startTime),
-		"pipe:out%03d.ts",
+		"-hide_banner",
+		"-loglevel", ffmpegLogLevel,
+	}
+	args = append(args, timelimitArgs(r.res)...)
+	args = append(args, "-ss", fmt.Sprintf("%.2f", pressTime))
+	if sc != nil && r.intermediate == "" {
+		args = append(args, sc.InputArgs...)
+	}
+	args = append(args, "-i", input)
+	if r.audio != "" {
+		args = append(args,
+			"-ss", fmt.Sprintf("%.2f", pressTime),
+			"-i", r.audio,
+			"-map", "0:v:0",
+			"-map", "1:a:0",
+		)
 	}
+	if r.audioTrack >= 0 {
+		args = append(args, "-map", fmt.Sprintf("0:a:%v", r.audioTrack))
+	}
+
+	args = append(args, r.outputArgs([]string{"-vf", overlayFilter(filter, r, pressTime)}, postssTime, length, sc)...)
+	return append(args, r.muxerArgs(startTime, length)...)
 }
 
+// outputArgs are the encoder options of r's output from postssTime on for
+// length seconds. picture selects or filters its video, e.g. "-vf" and a
+// filter chain; multi resolution encodes map a filter_complex branch
+// instead.
+func (r *EncodingRequest) outputArgs(picture []string, postssTime, length float64, sc *sidecar) []string {
+	args := []string{
+		"-ss", fmt.Sprintf("%.2f", postssTime),
+		"-t", fmt.Sprintf("%.2f", length),
+	}
+	if r.audioTrack >= 0 {
+		args = append(args, "-vn")
+	} else {
+		args = append(args, threadArgs()...)
+		args = append(args, picture...)
+		args = append(args,
+			"-vcodec", "libx264",
+			"-preset", r.presetFor(),
+			//"-r", "25", // fixed framerate
+			//"-vsync", "cfr",
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%.2f)", r.keyframeSpacing(length)),
+			//"-x264opts", "keyint=25:min-keyint=25:scenecut=-1",
+		)
+		args = append(args, r.qualityArgs()...)
+		args = append(args, colorArgs()...)
+		if r.container == containerFMP4 {
+			args = append(args, profileArgs(r.res)...)
+		}
+	}
+	if sc != nil {
+		args = append(args, sc.OutputArgs...)
+	}
+
+	if r.audioTrack == noAudioTrack {
+		return append(args, "-an")
+	}
+	args = append(args, audioFilterArgs(r.audioTrack < 0)...)
+	return append(args, "-acodec", audioCodec) //"libvo_aacenc",
+}
+
+// Index describes the service and its API, or serves indexFile when one is
+// configured, e.g. a small player page.
 func Index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	fmt.Fprint(w, "Welcome!\n")
+	if indexFile != "" {
+		http.ServeFile(w, r, indexFile)
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":    serviceName,
+		"version": version,
+		"links": map[string]string{
+			"health":    "/healthz",
+			"ready":     "/api/ready",
+			"player":    "/play/{filename}",
+			"master":    "/api/master/{filename}",
+			"playlist":  "/api/playlist/{filename}",
+			"concat":    "/api/concat/{filename},{filename}",
+			"dash":      "/api/dash/{filename}",
+			"info":      "/api/info/{filename}",
+			"validate":  "/api/validate/{filename}",
+			"chapters":  "/api/chapters/{filename}",
+			"errors":    "/api/errors/{filename}",
+			"progress":  "/api/progress/{filename}",
+			"iframes":   "/api/iframes/{filename}",
+			"init":      "/api/init/{filename}",
+			"heartbeat": "/api/heartbeat/{filename}?t={seconds}",
+			"thumbvtt":  "/api/thumbvtt/{filename}",
+			"cover":     "/api/pic/{filename}?t={seconds}",
+			"art":       "/api/art/{filename}",
+			"stats":     "/api/cache/stats",
+			"config":    "/api/config",
+		},
+	})
 }
 
 func playlist(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	filename := strings.TrimLeft(params.ByName("filename"), "/filename")
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
 	log.Debugf("Playlist request: %v,%s", r.URL.Path, filename)
-	file := path.Join(root, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
 
-	duration, err := getVideoDuration(file)
+	id, err := sourceID(filename)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	id, err := urlEncoded(filename)
+	// Stream options are validated here and passed on to every segment URL.
+	stream := NewWarmupEncodingRequest(file, 0, defaultResolution)
+	q := r.URL.Query()
+	values, err := parseStreamQuery(q, stream)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var query string
+	if len(values) > 0 {
+		query = "?" + values.Encode()
+	}
+
+	pinned.pin(*stream)
 
 	w.Header()["Content-Type"] = []string{"application/vnd.apple.mpegurl"}
 	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
 
-	fmt.Fprint(w, "#EXTM3U\n")
-	fmt.Fprint(w, "#EXT-X-VERSION:3\n")
-	fmt.Fprint(w, "#EXT-X-MEDIA-SEQUENCE:0\n")
-	fmt.Fprint(w, "#EXT-X-ALLOW-CACHE:YES\n")
-	fmt.Fprint(w, fmt.Sprintf("#EXT-X-TARGETDURATION:%.f\n", hlsSegmentLength))
-	fmt.Fprint(w, "#EXT-X-DISCONTINUITY\n")
-	fmt.Fprint(w, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	// Exact durations change as segments get cached, so such playlists are
+	// never persisted. Neither are those of remote sources, which have no
+	// modification time to validate them against.
+	persist := persistPlaylists && !noCache && !exactDurations && !isRemoteSource(file)
+	variant := r.Host + query
+	if persist {
+		if data, ok := loadPersistedPlaylist(file, variant); ok {
+			servePlaylist(w, r, data)
+			return
+		}
+	}
+
+	if err := checkSource(file); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	duration, err := sourceDuration(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	limited, ok := limitDuration(w, duration)
+	if !ok {
+		return
+	}
+	growing := growth.observe(file)
+	durations := segmentDurations(file, limited)
+	if growing {
+		durations = completeSegments(durations)
+	}
+	if exactDurations {
+		encoder.measureDurations(*stream, durations)
+	}
+
+	gaps := encoder.segmentGaps(*stream, len(durations))
+	first, count, playlistType, ended := playlistWindow(len(durations), reached.furthest(*stream, clientIP(r), time.Now()), growing)
+	// Truncated playlists are regenerated so they always carry the header,
+	// and open ones so they grow with their source or playback.
+	persist = persist && limited == duration && playlistType == "VOD"
 
-	leftover := duration
-	segmentIndex := 0
+	var buffer bytes.Buffer
+	segmentsURL, initURL := streamURLs(r.Host, id, query)
+	if stream.container != containerFMP4 {
+		initURL = ""
+	}
+	ranges := encoder.segmentPartRanges(*stream, first, count)
+	writePlaylist(&buffer, segmentsURL, initURL, query, int64(first), durations[first:first+count], gaps, ranges, playlistType, ended)
+	if persist {
+		if err := persistPlaylist(file, variant, buffer.Bytes()); err != nil {
+			log.Errorf("Could not persist playlist of %v: %v", file, err)
+		}
+	}
+	servePlaylist(w, r, buffer.Bytes())
+}
 
-	for leftover > 0 {
-		if leftover > hlsSegmentLength {
-			fmt.Fprintf(w, "#EXTINF:%f,\n", hlsSegmentLength)
-		} else {
-			fmt.Fprintf(w, "#EXTINF:%f,\n", leftover)
+// servePlaylist writes a generated playlist with support for ranged and
+// conditional requests. The ETag is derived from the content, since gaps and
+// measured durations can change it while the source stays the same.
+func servePlaylist(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header()["Etag"] = []string{fmt.Sprintf("\"%x\"", sha1.Sum(data))}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// estimatedDurations splits duration into segments of hlsSegmentLength,
+// followed by a shorter last segment.
+func estimatedDurations(duration float64) []float64 {
+	var durations []float64
+	for leftover := duration; leftover > 0; leftover -= hlsSegmentLength {
+		durations = append(durations, math.Min(leftover, hlsSegmentLength))
+	}
+	return durations
+}
+
+// targetDuration is the EXT-X-TARGETDURATION for segments of the given
+// durations: no EXTINF may exceed it once rounded to an integer.
+func targetDuration(durations []float64) float64 {
+	target := float64(hlsSegmentLength)
+	for _, d := range durations {
+		target = math.Max(target, math.Round(d))
+	}
+	return target
+}
+
+// writePlaylist writes a media playlist of segments with the given
+// durations, starting with segment first, served below segmentsURL. query is
+// appended to every segment URI. Segments in gaps are marked with EXT-X-GAP
+// so players skip them. initURL is the EXT-X-MAP of fMP4 segments, "" for
+// MPEG-TS. ranges holds the part byte ranges of segments encoded with CMAF
+// parts. playlistType is VOD, EVENT, or "" for a sliding window; ended
+// closes the playlist once it lists every segment.
+func writePlaylist(w io.Writer, segmentsURL string, initURL string, query string, first int64, durations []float64, gaps map[int64]bool, ranges map[int64][]byteRange, playlistType string, ended bool) {
+	p := newM3U8()
+	p.tag("#EXT-X-MEDIA-SEQUENCE:%v", first)
+	p.tag("#EXT-X-ALLOW-CACHE:YES")
+	p.tag("#EXT-X-TARGETDURATION:%.f", targetDuration(durations))
+	if llhls {
+		writeLLHLSHeader(p)
+	}
+	if playlistType != "" {
+		p.tag("#EXT-X-PLAYLIST-TYPE:%v", playlistType)
+	}
+	if initURL != "" {
+		p.tag("#EXT-X-MAP:URI=\"%v\"", initURL)
+	}
+
+	for i, segmentDuration := range durations {
+		segment := first + int64(i)
+		if llhls {
+			writeLLHLSParts(p, segmentsURL, query, segment, segmentDuration, ranges[segment])
+		}
+		p.tag("#EXTINF:%f,", segmentDuration)
+		if gaps[segment] {
+			p.tag("#EXT-X-GAP")
 		}
-		fmt.Fprintf(w, "http://%v/api/hls/segments/%v/%v.ts\n", r.Host, id, segmentIndex)
-		segmentIndex++
-		leftover = leftover - hlsSegmentLength
+		p.uri("%v/%v%v", segmentsURL, segmentName(segment, wholeSegment), query)
 	}
-	fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+	if ended {
+		p.tag("#EXT-X-ENDLIST")
+	} else if llhls {
+		writeLLHLSPreloadHint(p, segmentsURL, query, first+int64(len(durations)))
+	}
+	p.WriteTo(w)
 }
 
-func hls(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	filename := strings.TrimLeft(params.ByName("segments"), "/segments")
+// segmentsPathPrefix starts the path of every segment below /api/hls.
+const segmentsPathPrefix = "/segments/"
+
+// parseSegmentRequest builds the encoding request for a segment (or LL-HLS
+// part) URL served below /api/hls.
+func parseSegmentRequest(r *http.Request, params httprouter.Params) (*EncodingRequest, error) {
+	filename := params.ByName("segments")
+	concat := strings.HasPrefix(filename, concatPathPrefix)
+	if !concat && !strings.HasPrefix(filename, segmentsPathPrefix) {
+		return nil, fmt.Errorf("Invalid segment path %v", filename)
+	}
+	filename = strings.TrimPrefix(strings.TrimPrefix(filename, segmentsPathPrefix), concatPathPrefix)
 	log.Debugf("Stream request: %v,%v", r.URL.Path, filename)
-	var streamRegexp = regexp.MustCompile(`^(.*)/([0-9]+)\.ts$`)
-	matches := streamRegexp.FindStringSubmatch(filename)
 
-	segment, _ := strconv.ParseInt(matches[2], 0, 64)
-	file := path.Join(root, matches[1])
+	name, segment, part, ok := parseSegmentPath(filename)
+	if !ok || name == "" || (part != wholeSegment && (!llhls || concat)) {
+		return nil, fmt.Errorf("Invalid segment path %v", filename)
+	}
+	var file string
+	var err error
+	if concat {
+		file, segment, err = resolveConcatSegment(name, segment)
+	} else {
+		file, err = resolveSource(name)
+	}
+	if err != nil {
+		return nil, err
+	}
 	log.Debugf("Stream request: %v,%v", file, segment)
+	er := NewPartEncodingRequest(file, segment, part, defaultResolution)
+	if err := parseStreamOptions(r.URL.Query(), er); err != nil {
+		return nil, err
+	}
+	return er, nil
+}
 
-	er := NewEncodingRequest(file, segment, 480)
-	NewEncoder("segments", 2).Encode(*er)
+// parseStreamOptions sets the stream options passed in the query of a
+// segment URL on er.
+func parseStreamOptions(q url.Values, er *EncodingRequest) error {
+	var err error
+	if q.Get("res") == "" {
+		er.res = sourceDefaultResolution(er.file)
+	} else if er.res, err = parseResolution(q); err != nil {
+		return err
+	}
+	if er.audioTrack, err = parseAudioTrack(q); err != nil {
+		return err
+	}
+	if er.quality, err = parseQuality(q); err != nil {
+		return err
+	}
+	if er.container, err = parseContainer(q); err != nil {
+		return err
+	}
+	if er.watermark, er.timecode, err = parseOverlay(q); err != nil {
+		return err
+	}
+	if audio := q.Get("audio"); audio != "" {
+		if er.audio, err = resolveMediaPath(audio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStreamQuery validates the stream options of a playlist request into
+// stream and returns them in the canonical form passed on to segment URLs.
+func parseStreamQuery(q url.Values, stream *EncodingRequest) (url.Values, error) {
+	var err error
+	values := url.Values{}
+	if audio := q.Get("audio"); audio != "" {
+		if stream.audio, err = resolveMediaPath(audio); err != nil {
+			return nil, err
+		}
+		values.Set("audio", audio)
+	}
+	if q.Get("res") != "" {
+		if stream.res, err = parseResolution(q); err != nil {
+			return nil, err
+		}
+		values.Set("res", strconv.FormatInt(stream.res, 10))
+	} else {
+		stream.res = sourceDefaultResolution(stream.file)
+	}
+	if q.Get("audiotrack") != "" {
+		if stream.audioTrack, err = parseAudioTrack(q); err != nil {
+			return nil, err
+		}
+		values.Set("audiotrack", formatAudioTrack(stream.audioTrack))
+	}
+	if q.Get("quality") != "" {
+		if stream.quality, err = parseQuality(q); err != nil {
+			return nil, err
+		}
+		values.Set("quality", stream.quality)
+	}
+	if q.Get("container") != "" {
+		if stream.container, err = parseContainer(q); err != nil {
+			return nil, err
+		}
+		if stream.container != "" {
+			values.Set("container", stream.container)
+		}
+	}
+	if stream.watermark, stream.timecode, err = parseOverlay(q); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"watermark", "timecode"} {
+		if value := q.Get(name); value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values, nil
+}
+
+func hls(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	er, err := parseSegmentRequest(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkMediaExtension(w, er.file) {
+		return
+	}
+	mediaOnly, err := parseMediaOnly(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reached.record(*er, clientIP(r), time.Now())
+	if encoder.isGap(*er) {
+		http.Error(w, "Segment could not be encoded", http.StatusNotFound)
+		return
+	}
+	if beyondDurationLimit(er.segment) {
+		http.Error(w, "Segment is beyond the duration limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if t, ok, err := parsePosition(r.URL.Query().Get("position")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if ok && followPlayback {
+		playing := *er
+		playing.segment, playing.part = segmentAt(er.file, t), wholeSegment
+		go encoder.warmAhead(playing)
+	}
+	if p := prepackagedSegment(*er); p != "" {
+		log.Debugf("Serving packaged segment %v", p)
+		w.Header()["Content-Type"] = []string{"video/MP2T"}
+		w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+		http.ServeFile(w, r, p)
+		return
+	}
+	cached := encoder.isCached(*er) || encoder.hasStale(*er)
+	if !cached && clampToSource {
+		if info, err := sourceVideoInfo(er.file); err == nil {
+			if res, clamped := clampResolution(er.res, info); clamped {
+				log.Debugf("Clamping %v from %vp to source %vp", er.file, er.res, res)
+				er.res = res
+				w.Header()["X-Clamped-Resolution"] = []string{strconv.FormatInt(res, 10)}
+				cached = encoder.isCached(*er) || encoder.hasStale(*er)
+			}
+		}
+	}
+	// The encode slots are released when the handler returns, or once the
+	// encode finishes when the request is answered before that.
+	release := func() {}
+	defer func() { release() }()
+	if !cached {
+		if encodeLimiter != nil {
+			if ok, wait := encodeLimiter.allow(clientIP(r)); !ok {
+				w.Header()["Retry-After"] = []string{strconv.Itoa(int(math.Ceil(wait.Seconds())))}
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+		fileKey := fileHash(er.file)
+		if !encoder.inflight.acquire(fileKey, perFileEncodes) {
+			w.Header()["Retry-After"] = []string{strconv.Itoa(int(hlsSegmentLength))}
+			http.Error(w, "Too many segments of this file are being encoded", http.StatusTooManyRequests)
+			return
+		}
+		e := encoder
+		release = func() { e.inflight.release(fileKey) }
+		ip := clientIP(r)
+		if !clientEncodes.acquire(ip, perClientEncodes) {
+			w.Header()["Retry-After"] = []string{strconv.Itoa(int(hlsSegmentLength))}
+			http.Error(w, "Too many segments are being encoded for this client", http.StatusTooManyRequests)
+			return
+		}
+		release = func() {
+			e.inflight.release(fileKey)
+			clientEncodes.release(ip)
+		}
+		// Keep serving a cached segment at the requested resolution, only
+		// encodes are downshifted.
+		if adaptiveResolution {
+			if res := downshift(er.res, encoder.latency.average()); res != er.res {
+				log.Infof("Encoder overloaded, serving %v at %vp instead of %vp", er.file, res, er.res)
+				er.res = res
+				w.Header()["X-Downshifted-Resolution"] = []string{strconv.FormatInt(res, 10)}
+			}
+		}
+	}
+	encoder.Encode(*er)
+	if !cached && asyncColdSegments {
+		w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+		w.Header()["Retry-After"] = []string{strconv.Itoa(coldRetryAfter(encoder.latency.average()))}
+		w.WriteHeader(http.StatusAccepted)
+		go releaseWhenEncoded(*er, release)
+		release = func() {}
+		return
+	}
 
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(segmentDeadline(er.res))); err != nil {
+		log.Debugf("Could not extend segment write deadline: %v", err)
+	}
 	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
 	select {
 	case data := <-er.data:
-		w.Write(*data)
+		w.Header()["Content-Type"] = []string{er.contentType()}
+		segment := *data
+		if mediaOnly {
+			segment = mediaBoxes(segment)
+		}
+		if err := writeSegment(w, segment); err != nil {
+			log.Debugf("Could not write segment %v:%v: %v", er.file, er.segment, err)
+		}
 	case err := <-er.err:
 		log.Errorf("Error encoding %v", err)
-	case <-time.After(60 * time.Second):
-		log.Errorf("Timeout encoding")
+		http.Error(w, err.Error(), errorStatus(err))
+	case <-time.After(encodeTimeout(er.res)):
+		err := &EncodeError{er.file, er.segment, ErrEncodeTimeout}
+		log.Error(err)
+		http.Error(w, err.Error(), errorStatus(err))
+	}
+}
+
+// hlsHead reports whether a segment is already cached without encoding it:
+// 200 with its size if it is, 404 if a GET would have to encode it first.
+func hlsHead(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	er, err := parseSegmentRequest(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkMediaExtension(w, er.file) {
+		return
+	}
+	var stat os.FileInfo
+	if p := prepackagedSegment(*er); p != "" {
+		stat, err = os.Stat(p)
+	} else {
+		stat, err = encoder.statCache(*er)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	if stat == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header()["Content-Type"] = []string{er.contentType()}
+	w.Header()["Content-Length"] = []string{strconv.FormatInt(stat.Size(), 10)}
+	w.WriteHeader(http.StatusOK)
+}
+
+// masterPlaylist serves a master playlist with a variant per configured
+// resolution and the file's audio tracks as alternative renditions.
+func masterPlaylist(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("Master playlist request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+
+	tracks, err := sourceAudioTracks(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := sourceID(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header()["Content-Type"] = []string{"application/vnd.apple.mpegurl"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	writeMasterPlaylist(w, fmt.Sprintf("http://%v/api/playlist/%v", r.Host, id), fmt.Sprintf("http://%v/api/iframes/%v", r.Host, id), sourceResolutions(file), tracks)
+}
+
+func videoInfoHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("Info request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+
+	duration, err := sourceDuration(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, err := sourceVideoInfo(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	width, height := info.DisplaySize()
+
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"duration": duration,
+		"width":    width,
+		"height":   height,
+		"rotation": info.Rotation,
+	})
+}
+
+// chaptersHandler returns the chapters of a video as JSON, or as a WebVTT
+// chapters track with ?format=vtt.
+func chaptersHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("Chapters request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+
+	chapters, err := getChapters(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	if r.URL.Query().Get("format") == "vtt" {
+		w.Header()["Content-Type"] = []string{"text/vtt"}
+		w.Write(chaptersVTT(chapters))
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(chapters)
+}
+
+// errorsHandler lists the recent encode errors of a file.
+func errorsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(encoder.errors.get(file))
+}
+
+// iframesHandler serves an I-frame only playlist for trick play. Byte ranges
+// are read from encoded segments, so only cached segments are listed; an
+// uncached segment extends the last listed I-frame.
+func iframesHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("I-frame playlist request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+
+	res := sourceDefaultResolution(file)
+	query := ""
+	if r.URL.Query().Get("res") != "" {
+		if res, err = parseResolution(r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = fmt.Sprintf("?res=%v", res)
 	}
+	duration, err := sourceDuration(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := sourceID(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	segmentsURL := fmt.Sprintf("http://%v/api/hls/segments/%v", r.Host, id)
+
+	var iframes []iframe
+	for i, segmentDuration := range segmentDurations(file, duration) {
+		segment := int64(i)
+		keyframes := encoder.segmentKeyframes(*NewEncodingRequest(file, segment, res))
+		if len(keyframes) > 0 {
+			iframes = append(iframes, segmentIFrames(keyframes, segmentDuration, segmentsURL+"/"+segmentName(segment, wholeSegment)+query)...)
+		} else if len(iframes) > 0 {
+			iframes[len(iframes)-1].duration += segmentDuration
+		}
+	}
+
+	w.Header()["Content-Type"] = []string{"application/vnd.apple.mpegurl"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	writeIFramePlaylist(w, iframes)
 }
 
-//获得预览图，待开发
+// play serves a test player page for the playlist of a file.
+func play(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	if _, err := resolveMediaPath(filename); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	id, err := urlEncoded(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header()["Content-Type"] = []string{"text/html; charset=utf-8"}
+	playerPage.Execute(w, playerPageData{path.Base(filename), "/api/playlist/" + id})
+}
+
+// warmStart starts encoding every segment of a file, or of all files below a
+// directory, into the cache.
+func warmStart(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if noCache {
+		http.Error(w, "Caching is disabled", http.StatusConflict)
+		return
+	}
+	name := strings.TrimPrefix(params.ByName("path"), "/")
+	p, err := resolveMediaPath(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resolutions, err := warmResolutions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files, err := warmFiles(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	job := encoder.StartWarmJob(name, files, resolutions)
+	log.Infof("Warm job %v started for %v files below %v at %v", job.ID, len(files), name, resolutions)
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+func warmStatus(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	job := findWarmJob(params.ByName("id"))
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+// warmCancel cancels a warm job and reports how far it got.
+func warmCancel(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	job := CancelWarmJob(params.ByName("id"))
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+func configHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(resolvedConfig(flag.CommandLine))
+}
+
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	stats, err := encoder.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	json.NewEncoder(w).Encode(stats)
+}
+
+//获得预览图，?t=秒
 func pic(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	//filename := strings.Replace(params.ByName("cover"), "/cover/", "", 1)
+	filename := strings.TrimPrefix(params.ByName("cover"), "/")
 	log.Debugf("Cover request: %v", r.URL.Path)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+
+	var t int64
+	if value := r.URL.Query().Get("t"); value != "" {
+		if t, err = strconv.ParseInt(value, 10, 64); err != nil || t < 0 {
+			http.Error(w, fmt.Sprintf("Invalid time %v", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := getThumbnail(file, t)
+	if err != nil {
+		log.Errorf("Error generating thumbnail %v", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header()["Content-Type"] = []string{"image/jpeg"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.Write(data)
+}
+
+// thumbVTT serves a WebVTT track of seek preview thumbnails.
+func thumbVTT(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("Thumbnail track request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !checkMediaExtension(w, file) {
+		return
+	}
+
+	duration, err := sourceDuration(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := sourceID(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header()["Content-Type"] = []string{"text/vtt"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.Write(thumbnailVTT(duration, thumbInterval, func(t int64) string {
+		return fmt.Sprintf(thumbsURLFormat, r.Host, id, t)
+	}))
+}
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
 }
 
 func main() {
+	flag.Int64Var(&readAheadSegments, "read-ahead", readAheadSegments, "Number of segments to warm ahead of the last requested one")
+	flag.BoolVar(&llhls, "llhls", llhls, "Enable low-latency HLS partial segments")
+	flag.Int64Var(&llhlsParts, "llhls-parts", llhlsParts, "Number of LL-HLS parts per segment")
+	flag.Var(&encodingPresets, "presets", "x264 preset per maximum output height, e.g. 360=slow,720=fast (default veryfast for all)")
+	flag.DurationVar(&readHeaderTimeout, "read-header-timeout", readHeaderTimeout, "Maximum time to read request headers")
+	flag.DurationVar(&readTimeout, "read-timeout", readTimeout, "Maximum time to read a whole request")
+	flag.DurationVar(&writeTimeout, "write-timeout", writeTimeout, "Maximum time to write a response, 0 for none")
+	flag.DurationVar(&segmentWriteTimeout, "segment-write-timeout", segmentWriteTimeout, "Minimum time to encode and write a segment response, extended to the encode wait plus -write-timeout")
+	flag.DurationVar(&idleTimeout, "idle-timeout", idleTimeout, "Maximum time to keep an idle connection open")
+	flag.StringVar(&logOutput, "log-output", logOutput, "Where logs are written: stderr, stdout or a file path")
+	flag.StringVar(&logFile, "log-file", logFile, "Also write logs to this file")
+	flag.Int64Var(&logFileMaxSize, "log-max-size", logFileMaxSize, "Size in bytes at which the log file is rotated")
+	flag.IntVar(&logFileMaxBackup, "log-max-backups", logFileMaxBackup, "Number of rotated log files to keep")
+	flag.BoolVar(&dryRun, "dry-run", dryRun, "Log ffmpeg commands instead of running them and serve stub segments")
+	flag.Int64Var(&prerollSeconds, "preroll", prerollSeconds, "Seconds decoded before a segment start to reach a keyframe")
+	flag.BoolVar(&prerollFromGOP, "preroll-from-gop", prerollFromGOP, "Raise the preroll to the source's keyframe interval")
+	flag.BoolVar(&adaptiveResolution, "adaptive-resolution", adaptiveResolution, "Serve lower resolutions while encodes are slow")
+	flag.DurationVar(&adaptiveLatency, "adaptive-latency", adaptiveLatency, "Average encode time above which resolutions are lowered")
+	flag.Var(&userAgentAllow, "ua-allow", "Only serve User-Agents matching this regexp (repeatable)")
+	flag.Var(&userAgentDeny, "ua-deny", "Refuse User-Agents matching this regexp (repeatable)")
+	flag.Float64Var(&encodeRate, "encode-rate", encodeRate, "Encode-triggering requests per second allowed per client, 0 for unlimited")
+	flag.IntVar(&encodeBurst, "encode-burst", encodeBurst, "Encode-triggering requests a client may burst")
+	flag.BoolVar(&clampToSource, "clamp-resolution", clampToSource, "Never encode above the source resolution")
+	flag.StringVar(&indexFile, "index-file", indexFile, "HTML file served at / instead of the status page")
+	flag.Int64Var(&segmentBase, "segment-base", segmentBase, "Number of the first segment in segment URLs")
+	flag.IntVar(&segmentPad, "segment-pad", segmentPad, "Zero-pad segment numbers to this many digits")
+	flag.StringVar(&segmentPrefix, "segment-prefix", segmentPrefix, "Prefix of segment file names")
+	flag.StringVar(&ffmpegLogLevel, "ffmpeg-loglevel", ffmpegLogLevel, "ffmpeg -loglevel, its output is included in encode errors")
+	flag.BoolVar(&persistPlaylists, "persist-playlists", persistPlaylists, "Store generated playlists on disk until their source changes")
+	flag.BoolVar(&exactDurations, "exact-durations", exactDurations, "Use the measured duration of cached segments in playlists")
+	flag.Var(&allowedExtensions, "extensions", "Comma separated source file extensions that may be streamed, empty for any")
+	flag.StringVar(&cacheTempDir, "temp-dir", cacheTempDir, "Directory for cache files being written (default the system temp dir)")
+	flag.IntVar(&gapAfterFailures, "gap-after", gapAfterFailures, "Failed encodes after which a segment is skipped with EXT-X-GAP, 0 to always retry")
+	flag.Float64Var(&maxDuration, "max-duration", maxDuration, "Longest source in seconds that is served, 0 for no limit")
+	flag.BoolVar(&truncateLongSources, "truncate-long", truncateLongSources, "Serve the first max-duration seconds of longer sources instead of refusing them")
+	flag.Int64Var(&splitEncode, "split-encode", splitEncode, "Encode each segment in this many concurrent pieces")
+	flag.BoolVar(&servePrepackaged, "prepackaged", servePrepackaged, "Serve segments found in {file}.hls/{segment}.ts instead of encoding them")
+	flag.Var(&masterResolutions, "resolutions", "Comma separated video heights offered by master playlists")
+	flag.IntVar(&probeConcurrency, "probe-concurrency", probeConcurrency, "Durations probed at once when scanning many files")
+	flag.StringVar(&pixelFormat, "pix-fmt", pixelFormat, "Pixel format of encoded video")
+	flag.StringVar(&colorRange, "color-range", colorRange, "Color range tag of encoded video, tv or pc")
+	flag.StringVar(&colorPrimaries, "color-primaries", colorPrimaries, "Color primaries tag of encoded video, e.g. bt709")
+	flag.StringVar(&colorTransfer, "color-trc", colorTransfer, "Transfer characteristics tag of encoded video, e.g. bt709")
+	flag.StringVar(&colorSpace, "colorspace", colorSpace, "Color space tag of encoded video, e.g. bt709")
+	flag.StringVar(&deinterlace, "deinterlace", deinterlace, "Deinterlace sources: auto for sources probed as interlaced, on or off")
+	flag.IntVar(&perFileEncodes, "per-file-encodes", perFileEncodes, "Uncached segment requests of one file served at once, 0 for no limit")
+	flag.BoolVar(&variableSegments, "variable-segments", variableSegments, "End segments on a nearby source keyframe instead of exactly every segment length")
+	flag.Float64Var(&segmentTimeDelta, "segment-time-delta", segmentTimeDelta, "Seconds a variable segment may end from its target length")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", reconcileInterval, "How often cache files of deleted sources are removed, 0 to keep them")
+	flag.IntVar(&flushChunkSize, "flush-chunk", flushChunkSize, "Segment bytes written between flushes, 0 to write segments at once")
+	flag.Var(&remoteHosts, "remote-hosts", "Comma separated hosts http(s) sources may be streamed from, .example.com for subdomains")
+	flag.BoolVar(&asyncColdSegments, "async-cold", asyncColdSegments, "Answer uncached segment requests with 202 and Retry-After while they encode")
+	flag.BoolVar(&preindex, "preindex", preindex, "Probe the durations of all files below the media root in the background")
+	flag.DurationVar(&preindexInterval, "preindex-interval", preindexInterval, "How often the preindex is refreshed")
+	flag.BoolVar(&watchSources, "watch", watchSources, "Purge the cache of sources as soon as they change")
+	flag.IntVar(&maxWatches, "max-watches", maxWatches, "Maximum number of directories watched for changes")
+	flag.BoolVar(&multiResolution, "multi-resolution", multiResolution, "Encode all master playlist resolutions of a segment in one ffmpeg process")
+	flag.IntVar(&pinnedFiles, "pinned-files", pinnedFiles, "Recently played streams whose first segment is kept cached, 0 to disable")
+	flag.DurationVar(&pinInterval, "pin-interval", pinInterval, "How often missing pinned segments are encoded again")
+	flag.IntVar(&encodeThreads, "threads", encodeThreads, "ffmpeg threads per encode, 0 to share the CPU cores between concurrent encodes")
+	flag.StringVar(&watermark, "watermark", watermark, "Image below the media root overlaid on all video")
+	flag.BoolVar(&burnTimecode, "timecode", burnTimecode, "Burn the source timecode into all video")
+	flag.IntVar(&perClientEncodes, "per-client-encodes", perClientEncodes, "Uncached segment requests of one client served at once, 0 for no limit")
+	flag.StringVar(&seekStrategy, "seek", seekStrategy, "How encodes seek to a segment: preroll, input, output, or auto by keyframe interval")
+	flag.Float64Var(&seekGOPThreshold, "seek-gop-threshold", seekGOPThreshold, "Longest keyframe interval in seconds auto seeking uses input seeking for")
+	flag.Var(&responseHeaders, "header", "Header added to playlist and segment responses as \"Name: value\", may be repeated")
+	flag.Float64Var(&minSegmentDuration, "min-segment-duration", minSegmentDuration, "Merge a last segment shorter than this many seconds into the one before it, 0 to disable")
+	flag.DurationVar(&killGracePeriod, "kill-grace-period", killGracePeriod, "Time ffmpeg gets to exit after SIGTERM before it is killed")
+	flag.DurationVar(&growingTimeout, "growing-timeout", growingTimeout, "Serve EVENT playlists for files changed within this time, e.g. 30s, 0 to always serve VOD")
+	flag.IntVar(&maxPlaylistSegments, "max-playlist-segments", maxPlaylistSegments, "Most segments listed in one playlist, longer ones are extended as playback advances, 0 for no limit")
+	flag.IntVar(&cacheShardDepth, "cache-shard-depth", cacheShardDepth, "Spread cache files over this many levels of subdirectories, 0 for a flat cache directory")
+	flag.StringVar(&adminToken, "admin-token", adminToken, "Bearer token accepted by the admin endpoints")
+	flag.StringVar(&adminUser, "admin-user", adminUser, "Basic auth user of the admin endpoints")
+	flag.StringVar(&adminPassword, "admin-password", adminPassword, "Basic auth password of the admin endpoints, which are open to everyone if neither it nor -admin-token is set")
+	flag.StringVar(&queueFullPolicy, "queue-full", queueFullPolicy, "What to do with segment requests while the encoder queue is full: reject or block")
+	flag.IntVar(&encodeWorkers, "workers", encodeWorkers, "Number of segments encoded at once")
+	flag.Int64Var(&decodeBudget, "decode-budget", decodeBudget, "Total weight of concurrent encodes, each weighing one per 1080p of source pixels, 0 for no limit")
+	flag.DurationVar(&staleGrace, "stale-grace", staleGrace, "Keep serving cached segments of a changed source for this long while they are encoded again")
+	flag.BoolVar(&followPlayback, "follow-playback", followPlayback, "Warm the segments ahead of the playback position reported by players instead of the last requested segment")
+	flag.BoolVar(&noCache, "no-cache", noCache, "Encode every segment on demand without writing anything to disk")
+	flag.StringVar(&scaleAlgorithm, "scale-algorithm", scaleAlgorithm, "Scaling algorithm of downscales, e.g. bilinear, bicubic or lanczos, empty for ffmpeg's default")
+	flag.Float64Var(&loudnessTarget, "loudness", loudnessTarget, "Normalize audio to this EBU R128 loudness in LUFS, e.g. -16, 0 to disable")
+	flag.Float64Var(&loudnessTruePeak, "loudness-true-peak", loudnessTruePeak, "Maximum true peak in dBTP of normalized audio")
+	flag.Float64Var(&loudnessRange, "loudness-range", loudnessRange, "Loudness range target in LU of normalized audio")
+	flag.StringVar(&fmp4Timestamps, "fmp4-timestamps", fmp4Timestamps, "Timestamps of fMP4 segments: absolute to continue the stream timeline, relative to start each at zero")
+	flag.StringVar(&audioCodecPreference, "audio-codecs", audioCodecPreference, "Comma separated audio encoders in order of preference, the first one ffmpeg supports is used")
+	flag.BoolVar(&cmafParts, "cmaf-parts", cmafParts, "Encode LL-HLS fMP4 segments with a fragment per part and list the parts of cached segments as byte ranges")
+	flag.DurationVar(&encodeTimeoutBase, "encode-timeout", encodeTimeoutBase, "Time a segment request waits for a 480p or smaller encode, plus -encode-timeout-per-megapixel for larger ones")
+	flag.DurationVar(&encodeTimeoutPerMegapixel, "encode-timeout-per-megapixel", encodeTimeoutPerMegapixel, "Additional encode wait per million output pixels above 480p")
+	flag.BoolVar(&alignAudio, "align-audio", alignAudio, "Pad and shift segment audio to the video timestamps, inserting silence where it is missing")
+	flag.BoolVar(&readableCacheNames, "readable-cache-names", readableCacheNames, "Prefix cache files with a label made from their source name")
+	flag.StringVar(&stderrErrors, "stderr-errors", stderrErrors, "What to do with errors ffmpeg prints to stderr while exiting successfully: log or fail")
+	flag.IntVar(&intermediateAfter, "intermediate-after", intermediateAfter, "Scale a source once to an intermediate file after this many encodes at one resolution and cut later segments from it, 0 to disable")
+	flag.Int64Var(&intermediateMaxSize, "intermediate-max-size", intermediateMaxSize, "Bytes of disk intermediates may use before the least recently used are removed")
+	flag.DurationVar(&intermediateTimeLimit, "intermediate-time-limit", intermediateTimeLimit, "Time building an intermediate may take before it is abandoned, 0 for no limit")
+	flag.BoolVar(&coalesceResolutions, "coalesce-resolutions", coalesceResolutions, "Encode queued requests for one segment at several resolutions in one ffmpeg process")
+	flag.Parse()
+
+	out, closeOut, err := logDestination(logOutput)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeOut()
+	if logFile != "" {
+		rf, err := newRotatingFile(logFile, logFileMaxSize, logFileMaxBackup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rf.Close()
+		out = io.MultiWriter(out, rf)
+	}
+	log.SetOutput(out)
+
+	if llhls {
+		if err := validateLLHLSParts(llhlsParts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validateSplitEncode(splitEncode); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateColor(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateWatermark(); err != nil {
+		log.Fatal(err)
+	}
+	if variableSegments {
+		if llhls {
+			log.Fatal("Variable segments cannot be combined with LL-HLS")
+		}
+		if err := validateSegmentTimeDelta(segmentTimeDelta); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := resolveAudioCodec(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateFMP4Timestamps(fmp4Timestamps); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateLoudness(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateScaleAlgorithm(scaleAlgorithm); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateStderrErrors(stderrErrors); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateEncodeTimeout(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateAdminAuth(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateEncodeWorkers(encodeWorkers); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateQueueFullPolicy(queueFullPolicy); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateCacheShardDepth(cacheShardDepth); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSegmentNaming(segmentBase, segmentPad); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateMinSegmentDuration(minSegmentDuration); err != nil {
+		log.Fatal(err)
+	}
+	if minSegmentDuration > 0 && llhls {
+		log.Fatal("A minimum segment duration cannot be combined with LL-HLS")
+	}
+	if err := validateDeinterlace(deinterlace); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateEncodeThreads(encodeThreads); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSeekStrategy(seekStrategy); err != nil {
+		log.Fatal(err)
+	}
+
+	encoder = NewEncoder("segments", encodeWorkers)
+	if encodeRate > 0 {
+		encodeLimiter = newRateLimiter(encodeRate, encodeBurst)
+	}
+	if code, ok := subcommand(flag.Args()); ok {
+		os.Exit(code)
+	}
+	if reconcileInterval > 0 {
+		go encoder.reconcile()
+	}
+	if preindex {
+		go libraryIndex.refreshEvery(root, preindexInterval)
+	}
+	if pinnedFiles > 0 && !noCache {
+		go encoder.keepPinned()
+	}
+	if watchSources {
+		if err := watchRoot(root, encoder); err != nil {
+			log.Errorf("Could not watch %v: %v", root, err)
+		}
+	}
+
 	router := httprouter.New()
 	router.GET("/", Index)
+	router.GET("/healthz", healthz)
+	router.GET("/api/ready", readyHandler)
+	router.GET("/play/*filename", play)
+	router.GET("/api/master/*filename", masterPlaylist)
 	router.GET("/api/playlist/*filename", playlist)
+	router.GET("/api/concat/*files", concatPlaylist)
+	router.GET("/api/validate/*filename", validateHandler)
+	router.GET("/api/dash/*filename", dashManifest)
 	router.GET("/api/hls/*segments", hls)
+	router.HEAD("/api/hls/*segments", hlsHead)
+	router.GET("/api/info/*filename", videoInfoHandler)
 	router.GET("/api/pic/*cover", pic)
+	router.GET("/api/art/*filename", coverArt)
+	router.GET("/api/thumbvtt/*filename", thumbVTT)
+	router.GET("/api/chapters/*filename", chaptersHandler)
+	router.GET("/api/errors/*filename", errorsHandler)
+	router.GET("/api/progress/*filename", progressHandler)
+	router.GET("/api/iframes/*filename", iframesHandler)
+	router.GET("/api/init/*filename", initSegment)
+	router.POST("/api/heartbeat/*filename", heartbeat)
 
-	log.Fatal(http.ListenAndServe(":8001", router))
+	admin := adminRoutes{router}
+	admin.GET("/api/cache/stats", cacheStatsHandler)
+	admin.GET("/api/config", configHandler)
+	admin.POST("/api/warm/*path", warmStart)
+	admin.GET("/api/warm/:id", warmStatus)
+	admin.DELETE("/api/warm/:id", warmCancel)
+	router.POST("/api/admin/benchmark", requireAdmin(benchmarkHandler))
+
+	var handler http.Handler = recoverPanics(router)
+	if len(responseHeaders) > 0 {
+		handler = addResponseHeaders(handler)
+	}
+	if len(userAgentAllow) > 0 || len(userAgentDeny) > 0 {
+		handler = userAgentFilter(handler)
+	}
+	go waitReady(encoder)
+	log.Fatal(newServer(":8001", accessLog(handler)).ListenAndServe())
 }
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAdvanceWindowSequential(t *testing.T) {
+	e := &Encoder{readAhead: 2}
+	r := NewWarmupEncodingRequest("a.mp4", 0, 480)
+
+	want := [][]int64{{1, 2}, {3}, {4}, {5}}
+	for segment, segments := range want {
+		r.segment = int64(segment)
+		if got := e.advanceWindow(*r); !reflect.DeepEqual(got, segments) {
+			t.Errorf("segment %v: warmed %v, want %v", segment, got, segments)
+		}
+	}
+}
+
+func TestAdvanceWindowSeek(t *testing.T) {
+	e := &Encoder{readAhead: 2}
+	r := NewWarmupEncodingRequest("a.mp4", 10, 480)
+	e.advanceWindow(*r)
+
+	// Seeking forward past the window warms only what is new.
+	r.segment = 20
+	if got, want := e.advanceWindow(*r), []int64{21, 22}; !reflect.DeepEqual(got, want) {
+		t.Errorf("forward seek warmed %v, want %v", got, want)
+	}
+	// Seeking back starts a fresh window.
+	r.segment = 5
+	if got, want := e.advanceWindow(*r), []int64{6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("backward seek warmed %v, want %v", got, want)
+	}
+}
+
+func TestAdvanceWindowPerStream(t *testing.T) {
+	e := &Encoder{readAhead: 1}
+	a := NewWarmupEncodingRequest("a.mp4", 3, 480)
+	b := NewWarmupEncodingRequest("b.mp4", 3, 480)
+	e.advanceWindow(*a)
+	if got, want := e.advanceWindow(*b), []int64{4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second file warmed %v, want %v", got, want)
+	}
+}
+
+func TestWantWarmup(t *testing.T) {
+	e := &Encoder{readAhead: 2}
+	r := NewWarmupEncodingRequest("a.mp4", 0, 480)
+	if !e.wantWarmup(*r) {
+		t.Error("warmup without a window was skipped")
+	}
+	r.segment = 4
+	e.advanceWindow(*r)
+
+	for segment, want := range map[int64]bool{3: false, 4: false, 5: true, 6: true, 7: false} {
+		w := NewWarmupEncodingRequest("a.mp4", segment, 480)
+		if got := e.wantWarmup(*w); got != want {
+			t.Errorf("wantWarmup(%v) = %v, want %v", segment, got, want)
+		}
+	}
+}
+
+func TestVideoInfoRejectsTraversal(t *testing.T) {
+	w := httptest.NewRecorder()
+	videoInfoHandler(w, httptest.NewRequest("GET", "/api/info/../../etc/passwd", nil), httprouter.Params{{Key: "filename", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
+
+// containsArgs reports whether args holds want as a contiguous sequence.
+func containsArgs(args []string, want ...string) bool {
+	for i := 0; i+len(want) <= len(args); i++ {
+		if reflect.DeepEqual(args[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEncodingArgsExternalAudio(t *testing.T) {
+	r := NewWarmupEncodingRequest("/media/a.mp4", 2, 480)
+	r.audio = "/media/a.de.m4a"
+	args := EncodingArgs(*r, nil)
+	if !containsArgs(args, "-i", "/media/a.mp4", "-ss", "15.00", "-i", "/media/a.de.m4a", "-map", "0:v:0", "-map", "1:a:0") {
+		t.Errorf("args do not merge the external audio: %v", args)
+	}
+
+	r.audio = ""
+	if args := EncodingArgs(*r, nil); containsArgs(args, "-map", "1:a:0") {
+		t.Errorf("args map a second input without external audio: %v", args)
+	}
+}
+
+func TestCacheKeyExternalAudio(t *testing.T) {
+	plain := NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	dubbed := *plain
+	dubbed.audio = "/media/a.de.m4a"
+	other := *plain
+	other.audio = "/media/a.fr.m4a"
+
+	if plain.getCacheKey() == dubbed.getCacheKey() {
+		t.Error("external audio does not change the cache key")
+	}
+	if dubbed.getCacheKey() == other.getCacheKey() {
+		t.Error("different external audio files share a cache key")
+	}
+	again := dubbed
+	if dubbed.getCacheKey() != again.getCacheKey() {
+		t.Error("cache key is not stable")
+	}
+}
+
+func TestParseStreamOptionsAudioTraversal(t *testing.T) {
+	r := NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	if err := parseStreamOptions(url.Values{"audio": {"../../etc/passwd"}}, r); err == nil {
+		t.Error("audio outside the media root was accepted")
+	}
+}
+
+// withTestRoot points root at a fresh directory and installs an encoder
+// without workers, restoring both when the test ends.
+func withTestRoot(t *testing.T) string {
+	dir := t.TempDir()
+	savedRoot, savedEncoder := root, encoder
+	root, encoder = dir, &Encoder{cacheDir: "segments"}
+	t.Cleanup(func() { root, encoder = savedRoot, savedEncoder })
+	return dir
+}
+
+func segmentParams(name string) httprouter.Params {
+	return httprouter.Params{{Key: "segments", Value: segmentsPathPrefix + name}}
+}
+
+func TestHLSHead(t *testing.T) {
+	dir := withTestRoot(t)
+	params := segmentParams("a.mp4/3.ts")
+
+	w := httptest.NewRecorder()
+	hlsHead(w, httptest.NewRequest("HEAD", "/api/hls/segments/a.mp4/3.ts", nil), params)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("uncached segment: status %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	r := NewWarmupEncodingRequest(filepath.Join(dir, "a.mp4"), 3, defaultResolution)
+	if err := writeCacheFile(encoder.GetCacheFile(*r), []byte("segment")); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	hlsHead(w, httptest.NewRequest("HEAD", "/api/hls/segments/a.mp4/3.ts", nil), params)
+	if w.Code != http.StatusOK {
+		t.Errorf("cached segment: status %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Length"); got != "7" {
+		t.Errorf("Content-Length %v, want 7", got)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("HEAD response has a body")
+	}
+}
+
+func TestExecuteDryRun(t *testing.T) {
+	var buffer bytes.Buffer
+	log.SetOutput(&buffer)
+	defer log.SetOutput(os.Stderr)
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	// The binary does not exist, so running it would fail.
+	data, err := execute("/nonexistent/ffmpeg", []string{"-i", "a.mp4", "pipe:1"})
+	if err != nil {
+		t.Fatalf("dry run spawned a process: %v", err)
+	}
+	if string(data) != dryRunOutput {
+		t.Errorf("dry run returned %q, want %q", data, dryRunOutput)
+	}
+	if !strings.Contains(buffer.String(), "/nonexistent/ffmpeg -i a.mp4 pipe:1") {
+		t.Errorf("dry run did not log the command: %v", buffer.String())
+	}
+}
+
+func TestIndexStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	Index(w, httptest.NewRequest("GET", "/", nil), nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", ct)
+	}
+	var status struct {
+		Name    string            `json:"name"`
+		Version string            `json:"version"`
+		Links   map[string]string `json:"links"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Name != serviceName || status.Version != version {
+		t.Errorf("status %+v, want name %v and version %v", status, serviceName, version)
+	}
+	if status.Links["playlist"] != "/api/playlist/{filename}" {
+		t.Errorf("playlist link %q", status.Links["playlist"])
+	}
+}
+
+func TestIndexFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index.html")
+	if err := ioutil.WriteFile(file, []byte("<h1>player</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := indexFile
+	indexFile = file
+	t.Cleanup(func() { indexFile = old })
+
+	w := httptest.NewRecorder()
+	Index(w, httptest.NewRequest("GET", "/", nil), nil)
+	if w.Code != http.StatusOK || w.Body.String() != "<h1>player</h1>" {
+		t.Errorf("status %v body %q, want the index file", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type %q, want text/html", ct)
+	}
+}
+
+func TestEncoderWindowsConcurrent(t *testing.T) {
+	e := &Encoder{readAhead: 3}
+	files := []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"}
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				r := NewWarmupEncodingRequest(files[(g+i)%len(files)], int64((g*7+i)%50), 480)
+				for _, segment := range e.advanceWindow(*r) {
+					w := *r
+					w.segment = segment
+					e.wantWarmup(w)
+				}
+				e.hits.Add(1)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if hits := e.hits.Load(); hits != 16*200 {
+		t.Errorf("hits %v, want %v", hits, 16*200)
+	}
+	for _, file := range files {
+		r := NewWarmupEncodingRequest(file, 100, 480)
+		if segments := e.advanceWindow(*r); !reflect.DeepEqual(segments, []int64{101, 102, 103}) {
+			t.Errorf("%v: window after the hammering %v", file, segments)
+		}
+	}
+}
+
+func TestVODPlaylistHasNoDiscontinuity(t *testing.T) {
+	var b bytes.Buffer
+	writePlaylist(&b, "http://h/api/hls/segments/a.mp4", "", "", 0, []float64{10, 10, 4.5}, nil, nil, "VOD", true)
+	out := b.String()
+	if strings.Contains(out, "#EXT-X-DISCONTINUITY") {
+		t.Errorf("continuous VOD playlist has a discontinuity:\n%v", out)
+	}
+	if !strings.HasSuffix(out, "#EXTINF:4.500000,\nhttp://h/api/hls/segments/a.mp4/2.ts\n#EXT-X-ENDLIST\n") {
+		t.Errorf("playlist does not end with the last segment:\n%v", out)
+	}
+}
+
+func TestServePlaylistRange(t *testing.T) {
+	data := []byte("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ENDLIST\n")
+	r := httptest.NewRequest("GET", "/api/playlist/a.mp4", nil)
+	r.Header.Set("Range", "bytes=0-6")
+	w := httptest.NewRecorder()
+	servePlaylist(w, r, data)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status %v, want %v", w.Code, http.StatusPartialContent)
+	}
+	if w.Body.String() != "#EXTM3U" {
+		t.Errorf("body %q, want the first 7 bytes", w.Body.String())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 0-6/%v", len(data)) {
+		t.Errorf("Content-Range %q", cr)
+	}
+}
+
+func TestServePlaylistConditional(t *testing.T) {
+	data := []byte("#EXTM3U\n")
+	w := httptest.NewRecorder()
+	servePlaylist(w, httptest.NewRequest("GET", "/api/playlist/a.mp4", nil), data)
+	etag := w.Header().Get("Etag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	r := httptest.NewRequest("GET", "/api/playlist/a.mp4", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	servePlaylist(w, r, data)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status %v for a matching ETag, want %v", w.Code, http.StatusNotModified)
+	}
+
+	w = httptest.NewRecorder()
+	servePlaylist(w, r, []byte("#EXTM3U\n#EXT-X-ENDLIST\n"))
+	if w.Code != http.StatusOK {
+		t.Errorf("status %v for a changed playlist, want %v", w.Code, http.StatusOK)
+	}
+}
+
+func TestMalformedSegmentPaths(t *testing.T) {
+	withTestRoot(t)
+	for _, p := range []string{
+		"",
+		"/notasegment",
+		"/segments/",
+		"/segments/a.mp4",
+		"/segments//0.ts",
+		"/segments/a.mp4/x.ts",
+		"/segments/a.mp4/0.mp4",
+		"/segments/a.mp4/-1.ts",
+		"/segments/a.mp4/0.1.ts",
+		"/other/a.mp4/0.ts",
+	} {
+		params := httprouter.Params{{Key: "segments", Value: p}}
+		for name, handler := range map[string]httprouter.Handle{"GET": hls, "HEAD": hlsHead} {
+			w := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						t.Errorf("%v %q panicked: %v", name, p, err)
+					}
+				}()
+				handler(w, httptest.NewRequest(name, "/api/hls"+p, nil), params)
+			}()
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v %q: status %v, want %v", name, p, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+}
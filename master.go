@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const audioGroupID = "audio"
+
+var (
+	defaultResolution int64 = 480
+	// masterResolutions are the video variants advertised by the master playlist.
+	masterResolutions = resolutionList{480}
+)
+
+// resolutionList is a comma separated flag.Value of output heights.
+type resolutionList []int64
+
+func (l *resolutionList) String() string {
+	values := make([]string, len(*l))
+	for i, res := range *l {
+		values[i] = strconv.FormatInt(res, 10)
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *resolutionList) Set(value string) error {
+	var list resolutionList
+	for _, field := range strings.Split(value, ",") {
+		res, err := parseResolution(url.Values{"res": {strings.TrimSpace(field)}})
+		if err != nil {
+			return err
+		}
+		list = append(list, res)
+	}
+	*l = list
+	return nil
+}
+
+func (l resolutionList) contains(res int64) bool {
+	for _, r := range l {
+		if r == res {
+			return true
+		}
+	}
+	return false
+}
+
+// audioTrack is an audio stream of a file. Index counts audio streams only,
+// as used by -map 0:a:N.
+type audioTrack struct {
+	Index    int    `json:"index"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Default  bool   `json:"default"`
+}
+
+func probeAudioTracks(path string) ([]audioTrack, error) {
+	out, err := exec.Command(FFPROBEPath,
+		"-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index:stream_tags=language,title:stream_disposition=default",
+		"-of", "json",
+		path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Probe audio tracks error:%v", err)
+	}
+	return parseAudioTracks(out)
+}
+
+func parseAudioTracks(data []byte) ([]audioTrack, error) {
+	var probe ffprobeStreams
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("Parse audio tracks error:%v", err)
+	}
+	return audioTracksFrom(probe), nil
+}
+
+// audioTracksFrom lists the audio streams of probe. Streams without a
+// codec_type are taken to be audio, as -select_streams a filters to them.
+func audioTracksFrom(probe ffprobeStreams) []audioTrack {
+	tracks := []audioTrack{}
+	hasDefault := false
+	for _, s := range probe.Streams {
+		if s.CodecType != "" && s.CodecType != "audio" {
+			continue
+		}
+		t := audioTrack{Index: len(tracks), Language: s.Tags.Language, Title: s.Tags.Title}
+		if s.Disposition.Default != 0 && !hasDefault {
+			t.Default, hasDefault = true, true
+		}
+		tracks = append(tracks, t)
+	}
+	if !hasDefault && len(tracks) > 0 {
+		tracks[0].Default = true
+	}
+	return tracks
+}
+
+func parseResolution(q url.Values) (int64, error) {
+	value := q.Get("res")
+	if value == "" {
+		return defaultResolution, nil
+	}
+	res, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || !validResolution(res) {
+		return 0, fmt.Errorf("Invalid resolution %v", value)
+	}
+	return res, nil
+}
+
+// validResolution reports whether res is a supported output height.
+func validResolution(res int64) bool {
+	return res >= 144 && res <= 4320
+}
+
+// noAudioTrack selects the video of a source without its audio, which the
+// video adaptation set of a DASH manifest needs.
+const noAudioTrack = -2
+
+// parseAudioTrack returns the requested audio-only track, noAudioTrack for
+// "none", or -1 for the regular muxed stream.
+func parseAudioTrack(q url.Values) (int, error) {
+	value := q.Get("audiotrack")
+	if value == "" {
+		return -1, nil
+	}
+	if value == "none" {
+		return noAudioTrack, nil
+	}
+	track, err := strconv.Atoi(value)
+	if err != nil || track < 0 {
+		return 0, fmt.Errorf("Invalid audio track %v", value)
+	}
+	return track, nil
+}
+
+// formatAudioTrack is the ?audiotrack value selecting track.
+func formatAudioTrack(track int) string {
+	if track == noAudioTrack {
+		return "none"
+	}
+	return strconv.Itoa(track)
+}
+
+// estimateBandwidth is a rough peak bitrate for an x264 output of res lines.
+func estimateBandwidth(res int64) int64 {
+	return res * res * 6
+}
+
+// iframeBandwidth is a rough peak bitrate for the I-frame playlist of res
+// lines, which only carries one frame per keyframe interval.
+func iframeBandwidth(res int64) int64 {
+	return estimateBandwidth(res) / 10
+}
+
+// writeMasterPlaylist writes a master playlist with one variant and one
+// I-frame stream per resolution. The default audio track is muxed into the
+// variants; the other tracks are offered as audio-only renditions of the same
+// group.
+func writeMasterPlaylist(w io.Writer, playlistURL string, iframesURL string, resolutions []int64, tracks []audioTrack) {
+	p := newM3U8()
+	for _, t := range tracks {
+		name := t.Title
+		if name == "" {
+			name = t.Language
+		}
+		if name == "" {
+			name = fmt.Sprintf("Track %v", t.Index+1)
+		}
+		media := fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"%v\",NAME=\"%v\"", audioGroupID, name)
+		if t.Language != "" {
+			media += fmt.Sprintf(",LANGUAGE=\"%v\"", t.Language)
+		}
+		if t.Default {
+			media += ",DEFAULT=YES,AUTOSELECT=YES"
+		} else {
+			media += fmt.Sprintf(",DEFAULT=NO,AUTOSELECT=YES,URI=\"%v?audiotrack=%v\"", playlistURL, t.Index)
+		}
+		p.tag("%v", media)
+	}
+	for _, res := range resolutions {
+		inf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%v", estimateBandwidth(res))
+		if len(tracks) > 0 {
+			inf += fmt.Sprintf(",AUDIO=\"%v\"", audioGroupID)
+		}
+		p.tag("%v", inf)
+		p.uri("%v?res=%v", playlistURL, res)
+	}
+	for _, res := range resolutions {
+		p.tag("#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%v,URI=\"%v?res=%v\"", iframeBandwidth(res), iframesURL, res)
+	}
+	p.WriteTo(w)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestParseAudioTracks(t *testing.T) {
+	data := []byte(`{"streams":[
+		{"tags":{"language":"eng","title":"English"}},
+		{"tags":{"language":"deu"},"disposition":{"default":1}},
+		{"disposition":{"default":1}}
+	]}`)
+	tracks, err := parseAudioTracks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []audioTrack{
+		{Index: 0, Language: "eng", Title: "English"},
+		{Index: 1, Language: "deu", Default: true},
+		{Index: 2},
+	}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Errorf("tracks %+v, want %+v", tracks, want)
+	}
+}
+
+func TestParseAudioTracksNoDefault(t *testing.T) {
+	tracks, err := parseAudioTracks([]byte(`{"streams":[{},{}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tracks[0].Default || tracks[1].Default {
+		t.Errorf("tracks %+v, want the first one default", tracks)
+	}
+}
+
+func TestParseAudioTracksOfAllStreams(t *testing.T) {
+	tracks, err := parseAudioTracks([]byte(`{"streams":[
+		{"codec_type":"video"},
+		{"codec_type":"audio","tags":{"language":"eng"}},
+		{"codec_type":"subtitle"},
+		{"codec_type":"audio","tags":{"language":"deu"},"disposition":{"default":1}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []audioTrack{{Index: 0, Language: "eng"}, {Index: 1, Language: "deu", Default: true}}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Errorf("tracks %+v, want %+v", tracks, want)
+	}
+}
+
+func TestWriteMasterPlaylistAudioRenditions(t *testing.T) {
+	var b bytes.Buffer
+	tracks := []audioTrack{
+		{Index: 0, Language: "eng", Title: "English", Default: true},
+		{Index: 1, Language: "deu"},
+		{Index: 2},
+	}
+	writeMasterPlaylist(&b, "http://h/api/playlist/id", "http://h/api/iframes/id", []int64{480}, tracks)
+	out := b.String()
+	for _, want := range []string{
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"English\",LANGUAGE=\"eng\",DEFAULT=YES,AUTOSELECT=YES\n",
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"deu\",LANGUAGE=\"deu\",DEFAULT=NO,AUTOSELECT=YES,URI=\"http://h/api/playlist/id?audiotrack=1\"\n",
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"Track 3\",DEFAULT=NO,AUTOSELECT=YES,URI=\"http://h/api/playlist/id?audiotrack=2\"\n",
+		"#EXT-X-STREAM-INF:BANDWIDTH=1382400,AUDIO=\"audio\"\nhttp://h/api/playlist/id?res=480\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("master playlist lacks %q:\n%v", want, out)
+		}
+	}
+}
+
+func TestWriteMasterPlaylistWithoutAudio(t *testing.T) {
+	var b bytes.Buffer
+	writeMasterPlaylist(&b, "http://h/api/playlist/id", "http://h/api/iframes/id", []int64{480}, nil)
+	out := b.String()
+	if strings.Contains(out, "#EXT-X-MEDIA") || strings.Contains(out, "AUDIO=") {
+		t.Errorf("master playlist without audio tracks references an audio group:\n%v", out)
+	}
+}
+
+func TestMasterPlaylistRejectsTraversal(t *testing.T) {
+	w := httptest.NewRecorder()
+	masterPlaylist(w, httptest.NewRequest("GET", "/api/master/../../etc/passwd", nil), httprouter.Params{{Key: "filename", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestVideoWithoutAudioTrack(t *testing.T) {
+	track, err := parseAudioTrack(url.Values{"audiotrack": {"none"}})
+	if err != nil || track != noAudioTrack || formatAudioTrack(track) != "none" {
+		t.Fatalf("audiotrack=none parsed as %v, %v", track, err)
+	}
+	r := NewEncodingRequest("/media/a.mp4", 0, 480)
+	muxed := r.getCacheKey()
+	r.audioTrack = noAudioTrack
+	args := EncodingArgs(*r, nil)
+	if !containsArgs(args, "-an") || !containsArgs(args, "-vcodec", "libx264") || containsArgs(args, "-acodec", audioCodec) {
+		t.Errorf("video without audio encoded with %v", args)
+	}
+	if r.getCacheKey() == muxed {
+		t.Error("video without audio shares the cache key of the muxed stream")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// analyzed records a probe result for file as if ffprobe had analysed it.
+func analyzed(t *testing.T, file string, a sourceAnalysis) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.modTime = stat.ModTime()
+	analysisCache.Lock()
+	analysisCache.entries[file] = &a
+	analysisCache.Unlock()
+	t.Cleanup(func() {
+		analysisCache.Lock()
+		delete(analysisCache.entries, file)
+		analysisCache.Unlock()
+	})
+}
+
+func TestCheckSourceMedia(t *testing.T) {
+	dir := t.TempDir()
+	media, notes := filepath.Join(dir, "a.mp4"), filepath.Join(dir, "notes.mp4")
+	for _, file := range []string{media, notes} {
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	analyzed(t, media, sourceAnalysis{media: true})
+	analyzed(t, notes, sourceAnalysis{})
+
+	if err := checkSource(media); err != nil {
+		t.Errorf("media file refused: %v", err)
+	}
+	err := checkSource(notes)
+	if !errors.Is(err, ErrNotMedia) {
+		t.Fatalf("non-media file: %v, want ErrNotMedia", err)
+	}
+	if status := errorStatus(&EncodeError{notes, 0, err}); status != http.StatusUnsupportedMediaType {
+		t.Errorf("non-media file status %v, want %v", status, http.StatusUnsupportedMediaType)
+	}
+	if err := checkSource(filepath.Join(dir, "missing.mp4")); !errors.Is(err, ErrSourceNotFound) {
+		t.Errorf("missing file: %v, want ErrSourceNotFound", err)
+	}
+	if err := checkSource("http://example.com/a.mp4"); err != nil {
+		t.Errorf("remote source checked: %v", err)
+	}
+}
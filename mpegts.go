@@ -0,0 +1,184 @@
+package main
+
+import "sort"
+
+const (
+	tsPacketSize = 188
+	tsClockRate  = 90000 // PTS ticks per second
+)
+
+// walkVideoPES calls fn with the offset and payload of every TS packet
+// starting a PES packet of the first video stream, until fn returns false.
+// It returns false if data is not a valid transport stream.
+func walkVideoPES(data []byte, fn func(offset int, payload []byte) bool) bool {
+	pmtPID, videoPID := -1, -1
+	for off := 0; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			return false
+		}
+		start := pkt[1]&0x40 != 0
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		payload := tsPayload(pkt)
+		if payload == nil || !start {
+			continue
+		}
+		switch pid {
+		case 0:
+			pmtPID = parsePAT(payload)
+		case pmtPID:
+			videoPID = parsePMTVideoPID(payload)
+		case videoPID:
+			if !fn(off, payload) {
+				return true
+			}
+		}
+	}
+	return true
+}
+
+// tsKeyframe is a keyframe of an MPEG-TS segment: the byte range from its
+// first packet up to the start of the next video PES packet, and its PTS.
+type tsKeyframe struct {
+	offset int64
+	length int64
+	pts    int64
+}
+
+// tsKeyframes returns the keyframes of an MPEG-TS segment, found by the
+// random access indicator of their first packet. The first frame always
+// counts as one, as segments produced by EncodingArgs start on a forced
+// keyframe, and its range starts at the beginning of the segment so the PAT
+// and PMT are included.
+func tsKeyframes(data []byte) ([]tsKeyframe, bool) {
+	var keyframes []tsKeyframe
+	open := false
+	frames := 0
+	ok := walkVideoPES(data, func(offset int, payload []byte) bool {
+		frames++
+		if open {
+			k := &keyframes[len(keyframes)-1]
+			k.length = int64(offset) - k.offset
+			open = false
+		}
+		if frames == 1 || tsRandomAccess(data[offset:offset+tsPacketSize]) {
+			k := tsKeyframe{offset: int64(offset)}
+			if frames == 1 {
+				k.offset = 0
+			}
+			k.pts, _ = pesPTS(payload)
+			keyframes = append(keyframes, k)
+			open = true
+		}
+		return true
+	})
+	if !ok || frames == 0 {
+		return nil, false
+	}
+	if open {
+		k := &keyframes[len(keyframes)-1]
+		k.length = int64(len(data)/tsPacketSize*tsPacketSize) - k.offset
+	}
+	return keyframes, true
+}
+
+// tsRandomAccess reports whether the adaptation field of a TS packet sets
+// the random access indicator, which muxers set on keyframes.
+func tsRandomAccess(pkt []byte) bool {
+	return pkt[3]&0x20 != 0 && pkt[4] > 0 && pkt[5]&0x40 != 0
+}
+
+// tsDuration returns the playback duration of the video in an MPEG-TS
+// segment: the span of its frame timestamps plus one frame.
+func tsDuration(data []byte) (float64, bool) {
+	var pts []int64
+	ok := walkVideoPES(data, func(_ int, payload []byte) bool {
+		if t, ok := pesPTS(payload); ok {
+			pts = append(pts, t)
+		}
+		return true
+	})
+	if !ok || len(pts) < 2 {
+		return 0, false
+	}
+	// Frames are stored in decode order, sort to find the frame interval.
+	sort.Slice(pts, func(i, j int) bool { return pts[i] < pts[j] })
+	frame := pts[len(pts)-1] - pts[0]
+	for i := 1; i < len(pts); i++ {
+		if d := pts[i] - pts[i-1]; d > 0 && d < frame {
+			frame = d
+		}
+	}
+	return float64(pts[len(pts)-1]-pts[0]+frame) / tsClockRate, true
+}
+
+// pesPTS reads the presentation timestamp from the header of a PES packet.
+func pesPTS(payload []byte) (int64, bool) {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 || payload[7]&0x80 == 0 {
+		return 0, false
+	}
+	p := payload[9:14]
+	return int64(p[0]>>1&0x07)<<30 | int64(p[1])<<22 | int64(p[2]>>1)<<15 | int64(p[3])<<7 | int64(p[4]>>1), true
+}
+func tsPayload(pkt []byte) []byte {
+	control := pkt[3] >> 4 & 0x3
+	switch control {
+	case 1:
+		return pkt[4:]
+	case 3:
+		n := 5 + int(pkt[4])
+		if n >= len(pkt) {
+			return nil
+		}
+		return pkt[n:]
+	}
+	return nil
+}
+
+// psiSection skips the pointer field of a PSI payload and returns the
+// section bytes following the 3 byte table header, bounded by its length.
+func psiSection(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	p := payload[1+int(payload[0]):]
+	if len(p) < 3 {
+		return nil
+	}
+	length := int(p[1]&0x0f)<<8 | int(p[2])
+	if 3+length > len(p) || length < 4 {
+		return nil
+	}
+	return p[3 : 3+length-4] // Drop the CRC32
+}
+
+// parsePAT returns the PMT PID of the first program, or -1.
+func parsePAT(payload []byte) int {
+	s := psiSection(payload)
+	for i := 5; i+4 <= len(s); i += 4 {
+		program := int(s[i])<<8 | int(s[i+1])
+		if program != 0 {
+			return int(s[i+2]&0x1f)<<8 | int(s[i+3])
+		}
+	}
+	return -1
+}
+
+// parsePMTVideoPID returns the PID of the first video elementary stream, or -1.
+func parsePMTVideoPID(payload []byte) int {
+	s := psiSection(payload)
+	if len(s) < 9 {
+		return -1
+	}
+	infoLength := int(s[7]&0x0f)<<8 | int(s[8])
+	for i := 9 + infoLength; i+5 <= len(s); {
+		streamType := s[i]
+		pid := int(s[i+1]&0x1f)<<8 | int(s[i+2])
+		switch streamType {
+		case 0x01, 0x02, 0x1b, 0x24: // MPEG-1/2, H.264, HEVC
+			return pid
+		}
+		i += 5 + (int(s[i+3]&0x0f)<<8 | int(s[i+4]))
+	}
+	return -1
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testVideoPID = 0x100
+
+// tsPacket builds a TS packet of pid with payload, padded with stuffing.
+// A set randomAccess adds an adaptation field with the random access
+// indicator.
+func tsPacket(pid int, start bool, randomAccess bool, payload []byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	for i := range pkt {
+		pkt[i] = 0xff
+	}
+	pkt[0] = 0x47
+	pkt[1] = byte(pid >> 8 & 0x1f)
+	if start {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	n := 4
+	if randomAccess {
+		pkt[3] = 0x30
+		pkt[4] = 1
+		pkt[5] = 0x40
+		n = 6
+	} else {
+		pkt[3] = 0x10
+	}
+	copy(pkt[n:], payload)
+	return pkt
+}
+
+func testPAT() []byte {
+	return tsPacket(0, true, false, []byte{
+		0,              // pointer
+		0x00, 0xb0, 13, // table id, section length
+		0, 1, 0xc1, 0, 0, // stream id, version, section numbers
+		0, 1, 0xe0 | 0x10, 0x00, // program 1 at PID 0x1000
+		0, 0, 0, 0, // CRC32
+	})
+}
+
+func testPMT() []byte {
+	return tsPacket(0x1000, true, false, []byte{
+		0,              // pointer
+		0x02, 0xb0, 18, // table id, section length
+		0, 1, 0xc1, 0, 0, // program, version, section numbers
+		0xe1, 0x00, 0xf0, 0x00, // PCR PID, program info length
+		0x1b, 0xe1, 0x00, 0xf0, 0x00, // H.264 at PID 0x100
+		0, 0, 0, 0, // CRC32
+	})
+}
+
+func testPES(pts int64) []byte {
+	return []byte{
+		0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+		byte(0x21 | pts>>29&0x0e), byte(pts >> 22), byte(pts>>14&0xfe | 1), byte(pts >> 7), byte(pts<<1&0xfe | 1),
+	}
+}
+
+// tsPackets concatenates TS packets into a segment.
+func tsPackets(packets ...[]byte) []byte {
+	var data []byte
+	for _, p := range packets {
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestTSKeyframes(t *testing.T) {
+	data := tsPackets(
+		testPAT(),
+		testPMT(),
+		tsPacket(testVideoPID, true, true, testPES(0)),
+		tsPacket(testVideoPID, false, false, nil),
+		tsPacket(testVideoPID, true, false, testPES(3000)),
+		tsPacket(testVideoPID, true, true, testPES(180000)),
+		tsPacket(testVideoPID, false, false, nil),
+	)
+	keyframes, ok := tsKeyframes(data)
+	if !ok {
+		t.Fatal("tsKeyframes failed")
+	}
+	want := []tsKeyframe{
+		{offset: 0, length: 4 * tsPacketSize, pts: 0},
+		{offset: 5 * tsPacketSize, length: 2 * tsPacketSize, pts: 180000},
+	}
+	if !reflect.DeepEqual(keyframes, want) {
+		t.Errorf("keyframes %+v, want %+v", keyframes, want)
+	}
+}
+
+func TestTSKeyframesFirstFrame(t *testing.T) {
+	data := tsPackets(
+		testPAT(),
+		testPMT(),
+		tsPacket(testVideoPID, true, false, testPES(900)),
+		tsPacket(testVideoPID, true, false, testPES(3900)),
+	)
+	keyframes, ok := tsKeyframes(data)
+	want := []tsKeyframe{{offset: 0, length: 3 * tsPacketSize, pts: 900}}
+	if !ok || !reflect.DeepEqual(keyframes, want) {
+		t.Errorf("keyframes %+v %v, want %+v", keyframes, ok, want)
+	}
+}
+
+func TestTSKeyframesInvalid(t *testing.T) {
+	if _, ok := tsKeyframes(make([]byte, 2*tsPacketSize)); ok {
+		t.Error("tsKeyframes accepted data without sync bytes")
+	}
+	if _, ok := tsKeyframes(tsPackets(testPAT(), testPMT())); ok {
+		t.Error("tsKeyframes accepted data without video")
+	}
+}
+
+func TestTSDuration(t *testing.T) {
+	// Three frames at 25 fps, stored in decode order.
+	data := tsPackets(
+		testPAT(),
+		testPMT(),
+		tsPacket(testVideoPID, true, true, testPES(90000)),
+		tsPacket(testVideoPID, true, false, testPES(97200)),
+		tsPacket(testVideoPID, true, false, testPES(93600)),
+	)
+	d, ok := tsDuration(data)
+	if !ok || d != 0.12 {
+		t.Errorf("duration %v %v, want 0.12", d, ok)
+	}
+	if _, ok := tsDuration(tsPackets(testPAT(), testPMT(), tsPacket(testVideoPID, true, true, testPES(0)))); ok {
+		t.Error("duration of a single frame")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// multiResolution encodes a segment at every uncached sourceResolutions
+// height in one ffmpeg process that decodes the source once.
+var multiResolution bool
+
+// multiResolutionSiblings returns r followed by the other master playlist
+// resolutions of its segment that still need encoding, or nil if r is not
+// encoded that way.
+func (e *Encoder) multiResolutionSiblings(r EncodingRequest, info *videoInfo) []EncodingRequest {
+	if !multiResolution || dryRun || r.part != wholeSegment || r.audioTrack != -1 || r.container != "" || r.watermark != "" || r.timecode || !sourceResolutions(r.file).contains(r.res) {
+		return nil
+	}
+	siblings := []EncodingRequest{r}
+	for _, res := range sourceResolutions(r.file) {
+		if res == r.res {
+			continue
+		}
+		if info != nil {
+			if _, clamped := clampResolution(res, info); clamped {
+				continue
+			}
+		}
+		s := r
+		s.res, s.data, s.err = res, nil, nil
+		if !e.isCached(s) {
+			siblings = append(siblings, s)
+		}
+	}
+	return siblings
+}
+
+// multiResolutionArgs encodes the segment of rs, which differ only in their
+// resolution, into "{res}-%03d.ts" files in dir. The decoded video is split
+// into one scaled branch per output.
+func multiResolutionArgs(rs []EncodingRequest, info *videoInfo, dir string) []string {
+	r := rs[0]
+	startTime, length := r.span()
+	pressTime, postssTime := seekFor(startTime, info)
+	sc := sidecarFor(r.file)
+
+	args := []string{
+		"-y",
+		"-hide_banner",
+		"-loglevel", ffmpegLogLevel,
+	}
+	highest := r.res
+	for _, o := range rs {
+		if o.res > highest {
+			highest = o.res
+		}
+	}
+	args = append(args, timelimitArgs(highest)...)
+	args = append(args, "-ss", fmt.Sprintf("%.2f", pressTime))
+	if sc != nil {
+		args = append(args, sc.InputArgs...)
+	}
+	args = append(args, "-i", r.file)
+	audio := "0:a:0?"
+	if r.audio != "" {
+		args = append(args, "-ss", fmt.Sprintf("%.2f", pressTime), "-i", r.audio)
+		audio = "1:a:0"
+	}
+
+	branches := make([]string, len(rs))
+	scales := make([]string, len(rs))
+	for i, o := range rs {
+		branches[i] = fmt.Sprintf("[s%v]", i)
+		scales[i] = fmt.Sprintf("[s%v]%v[v%v]", i, videoFilter(o.res, info), i)
+	}
+	args = append(args, "-filter_complex",
+		fmt.Sprintf("[0:v:0]split=%v%v;%v", len(rs), strings.Join(branches, ""), strings.Join(scales, ";")))
+
+	for i, o := range rs {
+		args = append(args, "-map", fmt.Sprintf("[v%v]", i), "-map", audio)
+		args = append(args, o.outputArgs(nil, postssTime, length, sc)...)
+		args = append(args, o.muxerArgsTo(startTime, length, filepath.Join(dir, fmt.Sprintf("%v-%%03d.ts", o.res)))...)
+	}
+	return args
+}
+
+// encodeResolutions encodes rs in one process and returns their segments
+// in the same order.
+func encodeResolutions(rs []EncodingRequest, info *videoInfo, run executor) ([][]byte, error) {
+	tempDir := cacheTempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	dir, err := ioutil.TempDir(tempDir, "multires.*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := run(FFMPEGPath, multiResolutionArgs(rs, info, dir)); err != nil {
+		return nil, err
+	}
+	outputs := make([][]byte, len(rs))
+	for i, r := range rs {
+		files, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%v-*.ts", r.res)))
+		sort.Strings(files)
+		var buffer bytes.Buffer
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			buffer.Write(data)
+		}
+		if buffer.Len() == 0 {
+			return nil, fmt.Errorf("%w: %vp", ErrEmptyOutput, r.res)
+		}
+		outputs[i] = buffer.Bytes()
+	}
+	return outputs, nil
+}
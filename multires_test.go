@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withMultiResolution(t *testing.T, resolutions resolutionList) {
+	savedMulti, savedResolutions := multiResolution, masterResolutions
+	multiResolution, masterResolutions = true, resolutions
+	t.Cleanup(func() { multiResolution, masterResolutions = savedMulti, savedResolutions })
+}
+
+func TestMultiResolutionArgs(t *testing.T) {
+	var rs []EncodingRequest
+	for _, res := range []int64{480, 720, 1080} {
+		rs = append(rs, *NewEncodingRequest("/media/a.mp4", 2, res))
+	}
+	args := multiResolutionArgs(rs, nil, "/tmp/out")
+	if n := strings.Count(strings.Join(args, " "), " -i "); n != 1 {
+		t.Errorf("%v inputs, want the source decoded once: %v", n, args)
+	}
+	want := "[0:v:0]split=3[s0][s1][s2];[s0]" + scaleFilter(480, nil) + "[v0];[s1]" + scaleFilter(720, nil) + "[v1];[s2]" + scaleFilter(1080, nil) + "[v2]"
+	if !containsArgs(args, "-filter_complex", want) {
+		t.Errorf("filtergraph not %v: %v", want, args)
+	}
+	for i, out := range []string{"480-%03d.ts", "720-%03d.ts", "1080-%03d.ts"} {
+		if !containsArgs(args, "-map", fmt.Sprintf("[v%v]", i), "-map", "0:a:0?") {
+			t.Errorf("output %v not mapped: %v", i, args)
+		}
+		if !containsArgs(args, filepath.Join("/tmp/out", out)) {
+			t.Errorf("no output file %v: %v", out, args)
+		}
+	}
+	if !containsArgs(args, "-ss", "15.00", "-i", "/media/a.mp4") {
+		t.Errorf("input not seeked to the segment: %v", args)
+	}
+	for _, o := range rs {
+		// Each output is encoded and muxed like a single encode of it, past
+		// its picture filter and into a file instead of a pipe.
+		single := EncodingArgs(o, nil)
+		want := append([]string{}, single[argIndex(single, "-vcodec"):len(single)-1]...)
+		want = append(want, filepath.Join("/tmp/out", fmt.Sprintf("%v-%%03d.ts", o.res)))
+		if !containsArgs(args, want...) {
+			t.Errorf("%vp output not encoded like a single encode %v: %v", o.res, want, args)
+		}
+	}
+}
+
+func TestMultiResolutionSiblings(t *testing.T) {
+	withTestRoot(t)
+	withMultiResolution(t, resolutionList{480, 720, 1080})
+	r := *NewEncodingRequest("/media/a.mp4", 1, 720)
+	cached := r
+	cached.res = 1080
+	if err := writeCacheFile(encoder.GetCacheFile(cached), []byte("segment")); err != nil {
+		t.Fatal(err)
+	}
+
+	siblings := encoder.multiResolutionSiblings(r, nil)
+	if len(siblings) != 2 || siblings[0].res != 720 || siblings[1].res != 480 {
+		t.Errorf("siblings %v, want 720 and the uncached 480", siblings)
+	}
+
+	part := r
+	part.part = 0
+	offMaster := r
+	offMaster.res = 360
+	for name, r := range map[string]EncodingRequest{"part": part, "unadvertised": offMaster} {
+		if siblings := encoder.multiResolutionSiblings(r, nil); siblings != nil {
+			t.Errorf("%v request encoded with siblings %v", name, siblings)
+		}
+	}
+}
+
+func TestEncodeResolutions(t *testing.T) {
+	saved := cacheTempDir
+	cacheTempDir = t.TempDir()
+	t.Cleanup(func() { cacheTempDir = saved })
+	rs := []EncodingRequest{*NewEncodingRequest("/media/a.mp4", 0, 480), *NewEncodingRequest("/media/a.mp4", 0, 720)}
+
+	run := func(_ string, args []string) ([]byte, error) {
+		dir := filepath.Dir(args[len(args)-1])
+		for name, data := range map[string]string{"480-000.ts": "a", "480-001.ts": "b", "720-000.ts": "c"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	outputs, err := encodeResolutions(rs, nil, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(outputs[0]) != "ab" || string(outputs[1]) != "c" {
+		t.Errorf("outputs %q, want the files of each resolution in order", outputs)
+	}
+
+	empty := func(string, []string) ([]byte, error) { return nil, nil }
+	if _, err := encodeResolutions(rs, nil, empty); !errors.Is(err, ErrEmptyOutput) {
+		t.Errorf("missing output: %v, want ErrEmptyOutput", err)
+	}
+}
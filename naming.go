@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Segment file names are segmentPrefix, the segment number starting at
+	// segmentBase zero-padded to segmentPad digits, and ".ts". LL-HLS parts
+	// insert ".{part}" before the extension.
+	segmentBase   int64
+	segmentPad    int
+	segmentPrefix string
+)
+
+const segmentExt = ".ts"
+
+// maxSegmentPad is the number of digits of the largest segment number.
+const maxSegmentPad = 19
+
+func validateSegmentNaming(base int64, pad int) error {
+	if base < 0 {
+		return fmt.Errorf("Segment base %v must not be negative", base)
+	}
+	if pad < 0 || pad > maxSegmentPad {
+		return fmt.Errorf("Segment pad %v must be between 0 and %v", pad, maxSegmentPad)
+	}
+	return nil
+}
+
+// segmentName is the URL file name of a segment, or of one of its LL-HLS
+// parts unless part is wholeSegment.
+func segmentName(segment int64, part int64) string {
+	name := fmt.Sprintf("%v%0*d", segmentPrefix, segmentPad, segment+segmentBase)
+	if part != wholeSegment {
+		name += fmt.Sprintf(".%v", part)
+	}
+	return name + segmentExt
+}
+
+// parseSegmentPath splits "{file}/{segmentName}" into the file and the
+// segment and part numbers. It accepts exactly what segmentName produces,
+// regardless of padding.
+func parseSegmentPath(p string) (file string, segment int64, part int64, ok bool) {
+	i := strings.LastIndex(p, "/")
+	if i < 0 {
+		return
+	}
+	file, name := p[:i], p[i+1:]
+	if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExt) {
+		return
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentExt)
+
+	part = wholeSegment
+	if j := strings.IndexByte(name, '.'); j >= 0 {
+		if part, ok = parseDigits(name[j+1:]); !ok {
+			return
+		}
+		name = name[:j]
+	}
+	number, ok := parseDigits(name)
+	if !ok || number < segmentBase {
+		return file, 0, 0, false
+	}
+	return file, number - segmentBase, part, true
+}
+
+// parseDigits parses a non-empty string of decimal digits.
+func parseDigits(s string) (int64, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+// withSegmentNaming sets the segment naming scheme for the duration of a test.
+func withSegmentNaming(t *testing.T, base int64, pad int, prefix string) {
+	oldBase, oldPad, oldPrefix := segmentBase, segmentPad, segmentPrefix
+	t.Cleanup(func() { segmentBase, segmentPad, segmentPrefix = oldBase, oldPad, oldPrefix })
+	segmentBase, segmentPad, segmentPrefix = base, pad, prefix
+}
+
+func TestSegmentNameRoundTrip(t *testing.T) {
+	schemes := []struct {
+		base   int64
+		pad    int
+		prefix string
+	}{
+		{0, 0, ""},
+		{1, 0, ""},
+		{0, 5, ""},
+		{1, 4, "seg_"},
+	}
+	for _, s := range schemes {
+		withSegmentNaming(t, s.base, s.pad, s.prefix)
+		for _, segment := range []int64{0, 7, 12345} {
+			for _, part := range []int64{wholeSegment, 0, 3} {
+				name := segmentName(segment, part)
+				file, gotSegment, gotPart, ok := parseSegmentPath("dir/a.mp4/" + name)
+				if !ok || file != "dir/a.mp4" || gotSegment != segment || gotPart != part {
+					t.Errorf("%+v: %q parsed as %q %v %v %v", s, name, file, gotSegment, gotPart, ok)
+				}
+			}
+		}
+	}
+}
+
+func TestSegmentNameFormat(t *testing.T) {
+	withSegmentNaming(t, 1, 4, "seg_")
+	if name := segmentName(9, wholeSegment); name != "seg_0010.ts" {
+		t.Errorf("name %q, want seg_0010.ts", name)
+	}
+	if name := segmentName(9, 2); name != "seg_0010.2.ts" {
+		t.Errorf("part name %q, want seg_0010.2.ts", name)
+	}
+}
+
+func TestParseSegmentPathRejects(t *testing.T) {
+	withSegmentNaming(t, 1, 0, "seg_")
+	for _, p := range []string{
+		"a.mp4",
+		"a.mp4/seg_.ts",
+		"a.mp4/seg_0.ts",
+		"a.mp4/1.ts",
+		"a.mp4/seg_1.mp4",
+		"a.mp4/seg_1.x.ts",
+		"a.mp4/seg_-1.ts",
+	} {
+		if _, _, _, ok := parseSegmentPath(p); ok {
+			t.Errorf("%q accepted", p)
+		}
+	}
+}
+
+func TestValidateSegmentNaming(t *testing.T) {
+	for _, tt := range []struct {
+		base int64
+		pad  int
+		ok   bool
+	}{
+		{0, 0, true},
+		{1, 5, true},
+		{0, maxSegmentPad, true},
+		{-1, 0, false},
+		{0, -1, false},
+		{0, maxSegmentPad + 1, false},
+	} {
+		if err := validateSegmentNaming(tt.base, tt.pad); (err == nil) != tt.ok {
+			t.Errorf("validateSegmentNaming(%v, %v) = %v", tt.base, tt.pad, err)
+		}
+	}
+}
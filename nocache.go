@@ -0,0 +1,59 @@
+package main
+
+import "sync"
+
+// noCache encodes every segment on demand and never writes anything to the
+// cache directory, for ephemeral or privacy sensitive deployments. Read-ahead
+// warmups, warm jobs and pinning have nothing to fill and are disabled.
+var noCache bool
+
+// pendingEncodes joins live requests for a segment that is already queued or
+// encoding, so it is encoded once however many players ask for it at once.
+type pendingEncodes struct {
+	mu      sync.Mutex
+	waiters map[string][]EncodingRequest
+}
+
+// join registers r. It returns true if no request for the same segment is
+// pending and r must be queued, false if r waits for that request instead.
+func (p *pendingEncodes) join(r EncodingRequest) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiters == nil {
+		p.waiters = make(map[string][]EncodingRequest)
+	}
+	key := r.getCacheKey()
+	if waiters, ok := p.waiters[key]; ok {
+		p.waiters[key] = append(waiters, r)
+		return false
+	}
+	p.waiters[key] = nil
+	return true
+}
+
+// finish ends the pending encode of r's segment and returns the requests
+// that joined it.
+func (p *pendingEncodes) finish(r EncodingRequest) []EncodingRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := r.getCacheKey()
+	waiters := p.waiters[key]
+	delete(p.waiters, key)
+	return waiters
+}
+
+// deliverData sends data to r and every request that joined it.
+func (e *Encoder) deliverData(r EncodingRequest, data *[]byte) {
+	r.sendData(data)
+	for _, w := range e.pending.finish(r) {
+		w.sendData(data)
+	}
+}
+
+// deliverError sends err to r and every request that joined it.
+func (e *Encoder) deliverError(r EncodingRequest, err error) {
+	r.sendError(err)
+	for _, w := range e.pending.finish(r) {
+		w.sendError(err)
+	}
+}
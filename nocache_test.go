@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withNoCache(t *testing.T, disabled bool) {
+	saved := noCache
+	noCache = disabled
+	t.Cleanup(func() { noCache = saved })
+}
+
+func TestNoCacheWritesNothing(t *testing.T) {
+	dir := withTestRoot(t)
+	withNoCache(t, true)
+	e := encoder
+	file := filepath.Join(dir, "a.mp4")
+
+	// Requests for a segment already pending join its encode.
+	requests := []*EncodingRequest{
+		NewEncodingRequest(file, 0, 480),
+		NewEncodingRequest(file, 0, 480),
+		NewEncodingRequest(file, 0, 480),
+	}
+	for i, r := range requests {
+		if queue := e.pending.join(*r); queue != (i == 0) {
+			t.Fatalf("request %v queued: %v", i, queue)
+		}
+	}
+	r := *requests[0]
+	data := []byte("ts")
+	e.cacheSegment(r, data)
+	e.deliverData(r, &data)
+	for i, r := range requests {
+		if got := <-r.data; string(*got) != "ts" {
+			t.Errorf("request %v got %q", i, *got)
+		}
+	}
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && p != dir {
+			t.Errorf("wrote %v", p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A later request encodes again instead of reading a cache.
+	if data, err := e.GetFromCache(r); data != nil || err != nil {
+		t.Errorf("read %q, %v from the cache", data, err)
+	}
+	if !e.pending.join(r) {
+		t.Error("later request joined a finished encode")
+	}
+}
+
+func TestNoCacheIgnoresCacheFiles(t *testing.T) {
+	dir := withTestRoot(t)
+	r := *NewEncodingRequest(filepath.Join(dir, "a.mp4"), 0, 480)
+	encoder.cacheSegment(r, []byte("ts"))
+	if !encoder.isCached(r) {
+		t.Fatal("segment not cached")
+	}
+	withNoCache(t, true)
+	if data, err := encoder.GetFromCache(r); data != nil || err != nil {
+		t.Errorf("read %q, %v from the cache", data, err)
+	}
+	if encoder.isCached(r) {
+		t.Error("segment reported cached")
+	}
+}
+
+func TestPendingEncodeErrorReachesJoined(t *testing.T) {
+	var e Encoder
+	r, joined := NewEncodingRequest("/media/a.mp4", 1, 480), NewEncodingRequest("/media/a.mp4", 1, 480)
+	other := NewEncodingRequest("/media/a.mp4", 1, 720)
+	if !e.pending.join(*r) || e.pending.join(*joined) || !e.pending.join(*other) {
+		t.Fatal("requests joined across segments")
+	}
+	e.deliverError(*r, errors.New("failed"))
+	if err := <-joined.err; err == nil || err.Error() != "failed" {
+		t.Errorf("joined request got %v", err)
+	}
+	if !e.pending.join(*r) {
+		t.Error("segment still pending after its encode failed")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sourceExt marks the file recording the source path of a group of cache
+// files. Cache keys hash the source path, so it cannot be recovered from
+// the segment files themselves.
+const sourceExt = ".src"
+
+// reconcileInterval is how often cache files of deleted sources are
+// removed, 0 to never remove them.
+var reconcileInterval = time.Hour
+
+// cacheGroup is the hash that all cache files of r's stream start with.
+func cacheGroup(r EncodingRequest) string {
+	key := r.getCacheKey()
+	return key[:strings.IndexByte(key, '.')]
+}
+
+// recordSource remembers the source of r's cache group.
+func (e *Encoder) recordSource(r EncodingRequest) error {
+	return writeCacheFile(e.cacheFilePath(cacheGroup(r)+sourceExt), []byte(r.file))
+}
+
+// removeOrphans deletes the cache groups whose source no longer exists and
+// returns the number of files removed. Groups without a recorded source are
+// left alone.
+func (e *Encoder) removeOrphans() (int, error) {
+	return e.removeGroups(false, func(source string) bool {
+		if isRemoteSource(source) {
+			return false
+		}
+		_, err := os.Stat(source)
+		return os.IsNotExist(err)
+	})
+}
+
+// purgeSource deletes every cache file of source, or retires them as stale
+// if staleGrace is set.
+func (e *Encoder) purgeSource(source string) (int, error) {
+	return e.removeGroups(staleGrace > 0, func(s string) bool { return s == source })
+}
+
+// purgeSourcesBelow purges the cache of every source inside dir.
+func (e *Encoder) purgeSourcesBelow(dir string) (int, error) {
+	return e.removeGroups(staleGrace > 0, func(s string) bool { return isBelow(dir, s) })
+}
+
+// removeGroups deletes the cache groups whose recorded source matches and
+// returns the number of files removed. With retire, segment files are kept
+// as stale instead.
+func (e *Encoder) removeGroups(retire bool, match func(source string) bool) (int, error) {
+	var files []string
+	if err := e.walkCache(func(p string, _ os.FileInfo) { files = append(files, p) }); err != nil {
+		return 0, err
+	}
+	groups := make(map[string]bool)
+	for _, p := range files {
+		name := filepath.Base(p)
+		if !strings.HasSuffix(name, sourceExt) {
+			continue
+		}
+		source, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if match(string(source)) {
+			groups[strings.TrimSuffix(name, sourceExt)] = true
+		}
+	}
+	removed := 0
+	for _, p := range files {
+		name := filepath.Base(p)
+		if i := strings.IndexByte(name, '.'); i < 0 || !groups[name[:i]] {
+			continue
+		}
+		var err error
+		if !retire {
+			err = os.Remove(p)
+		} else if strings.HasSuffix(name, sourceExt) || strings.HasSuffix(name, staleExt) {
+			continue
+		} else {
+			err = retireFile(p)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Could not remove cache file %v: %v", name, err)
+			continue
+		}
+		measuredDurations.Delete(p)
+		partRangesCache.Delete(p)
+		keyframesCache.Delete(p)
+		removed++
+	}
+	return removed, nil
+}
+
+// reconcile removes orphaned cache files every reconcileInterval.
+func (e *Encoder) reconcile() {
+	for range time.Tick(reconcileInterval) {
+		removed, err := e.removeOrphans()
+		if err != nil {
+			log.Errorf("Could not remove orphaned cache files: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Infof("Removed %v cache files of deleted sources", removed)
+		}
+		if staleGrace > 0 {
+			if removed, err := e.removeStale(); err != nil {
+				log.Errorf("Could not remove stale cache files: %v", err)
+			} else if removed > 0 {
+				log.Infof("Removed %v expired stale cache files", removed)
+			}
+		}
+	}
+}
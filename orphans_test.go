@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cacheSegments caches segments 0 and 1 of file and records its source,
+// returning the cache files written.
+func cacheSegments(t *testing.T, file string) []string {
+	var paths []string
+	for segment := int64(0); segment < 2; segment++ {
+		r := *NewEncodingRequest(file, segment, 480)
+		p := encoder.GetCacheFile(r)
+		if err := writeCacheFile(p, []byte("segment")); err != nil {
+			t.Fatal(err)
+		}
+		if err := encoder.recordSource(r); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	dir := withTestRoot(t)
+	live := filepath.Join(dir, "live.mp4")
+	deleted := filepath.Join(dir, "deleted.mp4")
+	if err := ioutil.WriteFile(live, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	livePaths := cacheSegments(t, live)
+	orphanPaths := cacheSegments(t, deleted)
+	remotePaths := cacheSegments(t, "http://example.com/a.mp4")
+
+	removed, err := encoder.removeOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("removed %v files, want the 2 segments and the source record", removed)
+	}
+	for _, p := range orphanPaths {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("orphan %v kept", filepath.Base(p))
+		}
+	}
+	for _, p := range append(livePaths, remotePaths...) {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("live cache file %v removed", filepath.Base(p))
+		}
+	}
+}
+
+func TestRemoveOrphansKeepsUnrecordedGroups(t *testing.T) {
+	withTestRoot(t)
+	p := encoder.GetCacheFile(*NewEncodingRequest("/media/unknown.mp4", 0, 480))
+	if err := writeCacheFile(p, []byte("segment")); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := encoder.removeOrphans(); err != nil || removed != 0 {
+		t.Errorf("removed %v, %v, want nothing", removed, err)
+	}
+}
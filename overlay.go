@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	// watermark is an image below root overlaid on every encode, unless a
+	// request names another with ?watermark.
+	watermark string
+	// burnTimecode draws the source timestamp on every encode, as does
+	// ?timecode=1 per request.
+	burnTimecode bool
+)
+
+// resolveWatermark returns the path of a watermark image below root. The
+// path ends up quoted inside a filter graph, so quotes and backslashes are
+// refused along with paths escaping root.
+func resolveWatermark(name string) (string, error) {
+	if strings.ContainsAny(name, `'\`) {
+		return "", fmt.Errorf("Invalid watermark %v", name)
+	}
+	return resolveMediaPath(name)
+}
+
+// validateWatermark rejects a global watermark that cannot be resolved.
+func validateWatermark() error {
+	if watermark == "" {
+		return nil
+	}
+	_, err := resolveWatermark(watermark)
+	return err
+}
+
+// defaultOverlay returns the watermark and timecode of every encode that
+// does not ask for its own, from the global settings.
+func defaultOverlay() (string, bool) {
+	if watermark == "" {
+		return "", burnTimecode
+	}
+	image, err := resolveWatermark(watermark)
+	if err != nil {
+		return "", burnTimecode
+	}
+	return image, burnTimecode
+}
+
+// parseOverlay returns the watermark and timecode of a request, defaulting
+// to the global settings.
+func parseOverlay(q url.Values) (string, bool, error) {
+	image, timecode := defaultOverlay()
+	if name := q.Get("watermark"); name != "" {
+		var err error
+		if image, err = resolveWatermark(name); err != nil {
+			return "", false, err
+		}
+	}
+	if value := q.Get("timecode"); value != "" {
+		timecode = value == "1" || value == "true"
+	}
+	return image, timecode, nil
+}
+
+// overlayFilter extends the video filter chain of r with its watermark and
+// timecode. inputStart is the source time the filter input starts at.
+func overlayFilter(chain string, r EncodingRequest, inputStart float64) string {
+	if r.timecode {
+		chain += fmt.Sprintf(",drawtext=text='%%{pts\\:hms\\:%.2f}':x=10:y=10:fontsize=h/20:fontcolor=white:box=1:boxcolor=black@0.5", inputStart)
+	}
+	if r.watermark != "" {
+		chain = fmt.Sprintf("%v[base];movie='%v'[wm];[base][wm]overlay=W-w-10:H-h-10", chain, r.watermark)
+	}
+	return chain
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withOverlay(t *testing.T, image string, timecode bool) {
+	savedWatermark, savedTimecode := watermark, burnTimecode
+	watermark, burnTimecode = image, timecode
+	t.Cleanup(func() { watermark, burnTimecode = savedWatermark, savedTimecode })
+}
+
+func TestOverlayFilter(t *testing.T) {
+	r := *NewEncodingRequest("/media/a.mp4", 0, 480)
+	if got := overlayFilter("scale=-2:480", r, 12); got != "scale=-2:480" {
+		t.Errorf("chain without overlays %v", got)
+	}
+
+	r.timecode = true
+	got := overlayFilter("scale=-2:480", r, 12)
+	if !strings.HasPrefix(got, "scale=-2:480,drawtext=") || !strings.Contains(got, `%{pts\:hms\:12.00}`) {
+		t.Errorf("timecode chain %v", got)
+	}
+
+	r.timecode, r.watermark = false, "/media/logo.png"
+	if got := overlayFilter("scale=-2:480", r, 12); got != "scale=-2:480[base];movie='/media/logo.png'[wm];[base][wm]overlay=W-w-10:H-h-10" {
+		t.Errorf("watermark chain %v", got)
+	}
+
+	r.timecode = true
+	args := EncodingArgs(r, nil)
+	i := argIndex(args, "-vf")
+	if i < 0 || !strings.Contains(args[i+1], ",drawtext=") || !strings.HasSuffix(args[i+1], "overlay=W-w-10:H-h-10") {
+		t.Errorf("encoding args do not overlay the timecode and watermark: %v", args)
+	}
+}
+
+func TestParseOverlay(t *testing.T) {
+	dir := withTestRoot(t)
+	withOverlay(t, "", false)
+	if image, timecode, err := parseOverlay(url.Values{}); err != nil || image != "" || timecode {
+		t.Errorf("no overlay = %q, %v, %v", image, timecode, err)
+	}
+	image, timecode, err := parseOverlay(url.Values{"watermark": {"logo.png"}, "timecode": {"1"}})
+	if err != nil || image != filepath.Join(dir, "logo.png") || !timecode {
+		t.Errorf("requested overlay = %q, %v, %v", image, timecode, err)
+	}
+	for _, name := range []string{"../logo.png", "it's.png", `a\b.png`} {
+		if _, _, err := parseOverlay(url.Values{"watermark": {name}}); err == nil {
+			t.Errorf("watermark %q accepted", name)
+		}
+	}
+
+	withOverlay(t, "global.png", true)
+	image, timecode, err = parseOverlay(url.Values{"timecode": {"0"}})
+	if err != nil || image != filepath.Join(dir, "global.png") || timecode {
+		t.Errorf("global overlay with timecode off = %q, %v, %v", image, timecode, err)
+	}
+}
+
+func TestOverlayCacheKey(t *testing.T) {
+	r := *NewEncodingRequest("/media/a.mp4", 0, 480)
+	plain := r.getCacheKey()
+	r.timecode = true
+	timecode := r.getCacheKey()
+	r.watermark = "/media/logo.png"
+	both := r.getCacheKey()
+	if plain == timecode || timecode == both || plain == both {
+		t.Error("overlays do not change the cache key")
+	}
+}
+
+func TestGlobalOverlayOutsideHandlers(t *testing.T) {
+	dir := withTestRoot(t)
+	file := filepath.Join(dir, "a.mp4")
+	withOverlay(t, "logo.png", true)
+	for _, r := range []*EncodingRequest{NewWarmupEncodingRequest(file, 0, 480), NewEncodingRequest(file, 0, 480), NewPartEncodingRequest(file, 0, 1, 480)} {
+		if r.watermark != filepath.Join(dir, "logo.png") || !r.timecode {
+			t.Errorf("request overlays %q, %v, want the global ones", r.watermark, r.timecode)
+		}
+	}
+
+	args := thumbnailArgs(file, 20)
+	if vf := args[argIndex(args, "-vf")+1]; !strings.Contains(vf, `%{pts\:hms\:20.00}`) || !strings.Contains(vf, "movie='"+filepath.Join(dir, "logo.png")+"'") {
+		t.Errorf("thumbnail without the global overlays: %v", vf)
+	}
+
+	if err := validateWatermark(); err != nil {
+		t.Errorf("watermark below root rejected: %v", err)
+	}
+	watermark = "../logo.png"
+	if err := validateWatermark(); err == nil {
+		t.Error("watermark outside root accepted")
+	}
+}
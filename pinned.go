@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// pinnedFiles is the number of recently played streams whose first
+	// segment is kept cached, so playback starts instantly on return
+	// visits. 0 disables pinning.
+	pinnedFiles = 20
+	// pinInterval is how often pinned segments missing from the cache, e.g.
+	// after a purge, are encoded again.
+	pinInterval = time.Minute
+)
+
+// pinnedSegments holds the first segments of recently played streams,
+// least recently played first.
+type pinnedSegments struct {
+	mu       sync.Mutex
+	segments []EncodingRequest
+}
+
+var pinned pinnedSegments
+
+// pin marks the first segment of r's stream as recently played.
+func (p *pinnedSegments) pin(r EncodingRequest) {
+	if pinnedFiles <= 0 {
+		return
+	}
+	r.segment, r.part, r.data, r.err = 0, wholeSegment, nil, nil
+	key := r.getCacheKey()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.segments {
+		if s.getCacheKey() == key {
+			p.segments = append(p.segments[:i], p.segments[i+1:]...)
+			break
+		}
+	}
+	p.segments = append(p.segments, r)
+	if over := len(p.segments) - pinnedFiles; over > 0 {
+		p.segments = p.segments[over:]
+	}
+}
+
+func (p *pinnedSegments) list() []EncodingRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]EncodingRequest(nil), p.segments...)
+}
+
+// keepPinned encodes pinned segments that are missing from the cache every
+// pinInterval.
+func (e *Encoder) keepPinned() {
+	for range time.Tick(pinInterval) {
+		e.encodeMissingPinned()
+	}
+}
+
+func (e *Encoder) encodeMissingPinned() {
+	for _, r := range pinned.list() {
+		if e.isCached(r) {
+			continue
+		}
+		log.Debugf("Encoding pinned segment of %v", r.file)
+		r.data = make(chan *[]byte, 1)
+		r.err = make(chan error, 1)
+		e.reqChan <- r
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func withPinned(t *testing.T, files int) {
+	saved := pinnedFiles
+	pinnedFiles = files
+	pinned.mu.Lock()
+	pinned.segments = nil
+	pinned.mu.Unlock()
+	t.Cleanup(func() {
+		pinnedFiles = saved
+		pinned.mu.Lock()
+		pinned.segments = nil
+		pinned.mu.Unlock()
+	})
+}
+
+func pinnedFileNames() []string {
+	var files []string
+	for _, r := range pinned.list() {
+		files = append(files, r.file)
+	}
+	return files
+}
+
+func TestPinKeepsRecentFirstSegments(t *testing.T) {
+	withPinned(t, 2)
+	pinned.pin(*NewEncodingRequest("/media/a.mp4", 7, 480))
+	pinned.pin(*NewEncodingRequest("/media/b.mp4", 3, 480))
+	pinned.pin(*NewEncodingRequest("/media/a.mp4", 9, 480))
+
+	list := pinned.list()
+	if len(list) != 2 || list[0].file != "/media/b.mp4" || list[1].file != "/media/a.mp4" {
+		t.Fatalf("pinned %v, want b then the replayed a", pinnedFileNames())
+	}
+	if list[1].segment != 0 || list[1].part != wholeSegment {
+		t.Errorf("pinned segment %v part %v, want the whole first segment", list[1].segment, list[1].part)
+	}
+
+	pinned.pin(*NewEncodingRequest("/media/c.mp4", 0, 480))
+	if files := pinnedFileNames(); len(files) != 2 || files[0] != "/media/a.mp4" || files[1] != "/media/c.mp4" {
+		t.Errorf("pinned %v, want the least recently played b unpinned", files)
+	}
+}
+
+func TestPinDisabled(t *testing.T) {
+	withPinned(t, 0)
+	pinned.pin(*NewEncodingRequest("/media/a.mp4", 0, 480))
+	if files := pinnedFileNames(); len(files) != 0 {
+		t.Errorf("pinned %v with pinning disabled", files)
+	}
+}
+
+func TestPinnedSegmentsSurvivePurges(t *testing.T) {
+	dir := withTestRoot(t)
+	withPinned(t, 5)
+	e := encoder
+	e.reqChan = make(chan EncodingRequest, 2)
+	kept, purged := *NewEncodingRequest(dir+"/a.mp4", 0, 480), *NewEncodingRequest(dir+"/b.mp4", 0, 480)
+	pinned.pin(kept)
+	pinned.pin(purged)
+	if err := writeCacheFile(e.GetCacheFile(kept), []byte("segment")); err != nil {
+		t.Fatal(err)
+	}
+
+	e.encodeMissingPinned()
+	if n := len(e.reqChan); n != 1 {
+		t.Fatalf("%v pinned segments encoded again, want 1", n)
+	}
+	if r := <-e.reqChan; r.file != purged.file || r.data == nil || r.err == nil {
+		t.Errorf("encoded %v, want the purged pinned segment with reply channels", r.file)
+	}
+}
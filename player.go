@@ -0,0 +1,35 @@
+package main
+
+import "html/template"
+
+// playerPage is a minimal page playing a playlist with hls.js, or natively
+// in browsers that support HLS.
+var playerPage = template.Must(template.New("player").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<script src="https://cdn.jsdelivr.net/npm/hls.js@1"></script>
+<style>body{margin:0;background:#000}video{width:100vw;height:100vh}</style>
+</head>
+<body>
+<video id="video" controls autoplay></video>
+<script>
+var video = document.getElementById("video");
+var src = {{.Playlist}};
+if (window.Hls && Hls.isSupported()) {
+	var hls = new Hls();
+	hls.loadSource(src);
+	hls.attachMedia(video);
+} else {
+	video.src = src;
+}
+</script>
+</body>
+</html>
+`))
+
+type playerPageData struct {
+	Title    string
+	Playlist string
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPlayPage(t *testing.T) {
+	withTestRoot(t)
+	w := httptest.NewRecorder()
+	play(w, httptest.NewRequest("GET", "/play/shows/a.mp4", nil), httprouter.Params{{Key: "filename", Value: "/shows/a.mp4"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<title>a.mp4</title>`,
+		`var src = "/api/playlist/shows/a.mp4";`,
+		`hls.js`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %q:\n%v", want, body)
+		}
+	}
+}
+
+func TestPlayPageEscapesName(t *testing.T) {
+	withTestRoot(t)
+	w := httptest.NewRecorder()
+	play(w, httptest.NewRequest("GET", "/play/x", nil), httprouter.Params{{Key: "filename", Value: "/</script><b>.mp4"}})
+	if strings.Contains(w.Body.String(), "</script><b>") {
+		t.Errorf("file name is not escaped:\n%v", w.Body.String())
+	}
+}
+
+func TestPlayRejectsTraversal(t *testing.T) {
+	w := httptest.NewRecorder()
+	play(w, httptest.NewRequest("GET", "/play/../../etc/passwd", nil), httprouter.Params{{Key: "filename", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
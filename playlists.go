@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const playlistsDirName = "playlists"
+
+// persistPlaylists stores generated playlists on disk and serves them from
+// there until their source file is modified.
+var persistPlaylists bool
+
+// persistedPlaylistFile is where the playlist of file is stored. variant
+// distinguishes playlists of the same file, e.g. by host and query.
+func persistedPlaylistFile(file string, variant string) string {
+	h := sha1.New()
+	h.Write([]byte(file))
+	h.Write([]byte{0})
+	h.Write([]byte(variant))
+	return filepath.Join(root, HomeDir, playlistsDirName, fmt.Sprintf("%x.m3u8", h.Sum(nil)))
+}
+
+// loadPersistedPlaylist returns the stored playlist of file if it was
+// generated from the current version of the file. Stored playlists carry the
+// modification time of their source.
+func loadPersistedPlaylist(file string, variant string) ([]byte, bool) {
+	source, err := os.Stat(file)
+	if err != nil {
+		return nil, false
+	}
+	p := persistedPlaylistFile(file, variant)
+	stat, err := os.Stat(p)
+	if err != nil || !stat.ModTime().Equal(source.ModTime()) {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// persistPlaylist stores data as the playlist of file, replacing any stale
+// version.
+func persistPlaylist(file string, variant string, data []byte) error {
+	source, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	p := persistedPlaylistFile(file, variant)
+	os.Remove(p)
+	if err := writeCacheFile(p, data); err != nil {
+		return err
+	}
+	return os.Chtimes(p, source.ModTime(), source.ModTime())
+}
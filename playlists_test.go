@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPersistedPlaylistCycle(t *testing.T) {
+	dir := withTestRoot(t)
+	file := filepath.Join(dir, "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := loadPersistedPlaylist(file, "host"); ok {
+		t.Fatal("playlist loaded before it was persisted")
+	}
+	if err := persistPlaylist(file, "host", []byte("#EXTM3U\n")); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := loadPersistedPlaylist(file, "host")
+	if !ok || string(data) != "#EXTM3U\n" {
+		t.Fatalf("loaded %q %v, want the persisted playlist", data, ok)
+	}
+	if _, ok := loadPersistedPlaylist(file, "other host"); ok {
+		t.Error("playlist loaded for another variant")
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadPersistedPlaylist(file, "host"); ok {
+		t.Fatal("stale playlist loaded after the source changed")
+	}
+	if err := persistPlaylist(file, "host", []byte("#EXTM3U\n#EXT-X-ENDLIST\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := loadPersistedPlaylist(file, "host"); !ok || string(data) != "#EXTM3U\n#EXT-X-ENDLIST\n" {
+		t.Errorf("loaded %q %v, want the regenerated playlist", data, ok)
+	}
+}
+
+func TestPersistedPlaylistMissingSource(t *testing.T) {
+	dir := withTestRoot(t)
+	file := filepath.Join(dir, "missing.mp4")
+	if err := persistPlaylist(file, "", []byte("#EXTM3U\n")); err == nil {
+		t.Error("persisted the playlist of a missing source")
+	}
+	if _, ok := loadPersistedPlaylist(file, ""); ok {
+		t.Error("loaded the playlist of a missing source")
+	}
+}
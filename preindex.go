@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// preindex probes the durations of every file below root in the
+	// background, so first playlist requests don't wait for ffmpeg.
+	preindex bool
+	// preindexInterval is how often the index is refreshed.
+	preindexInterval = 10 * time.Minute
+)
+
+type indexEntry struct {
+	modTime  time.Time
+	duration float64
+}
+
+// durationIndex maps source paths to their durations.
+type durationIndex struct {
+	mu      sync.RWMutex
+	entries map[string]indexEntry
+}
+
+var libraryIndex durationIndex
+
+// lookup returns the indexed duration of file if it did not change since.
+func (ix *durationIndex) lookup(file string) (float64, bool) {
+	ix.mu.RLock()
+	entry, ok := ix.entries[file]
+	ix.mu.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	stat, err := os.Stat(file)
+	if err != nil || !stat.ModTime().Equal(entry.modTime) {
+		return 0, false
+	}
+	return entry.duration, true
+}
+
+// build indexes the files below dir, probing only new and changed files,
+// and drops files that are gone.
+func (ix *durationIndex) build(dir string) error {
+	files, err := warmFiles(dir)
+	if err != nil {
+		return err
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	var changed []string
+	for _, file := range files {
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		modTimes[file] = stat.ModTime()
+		if _, ok := ix.lookup(file); !ok {
+			changed = append(changed, file)
+		}
+	}
+	durations := durationsFor(changed)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	entries := make(map[string]indexEntry, len(modTimes))
+	for file, modTime := range modTimes {
+		if d, ok := durations[file]; ok {
+			entries[file] = indexEntry{modTime, d}
+		} else if entry, ok := ix.entries[file]; ok && entry.modTime.Equal(modTime) {
+			entries[file] = entry
+		}
+	}
+	ix.entries = entries
+	log.Infof("Indexed %v files, probed %v", len(entries), len(durations))
+	return nil
+}
+
+// invalidate drops file from the index.
+func (ix *durationIndex) invalidate(file string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	delete(ix.entries, file)
+}
+
+// invalidateBelow drops the files inside dir from the index.
+func (ix *durationIndex) invalidateBelow(dir string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for file := range ix.entries {
+		if isBelow(dir, file) {
+			delete(ix.entries, file)
+		}
+	}
+}
+
+// refreshEvery builds the index of dir now and then every interval.
+func (ix *durationIndex) refreshEvery(dir string, interval time.Duration) {
+	for {
+		if err := ix.build(dir); err != nil {
+			log.Errorf("Could not index %v: %v", dir, err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDurationIndexBuild(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "shows"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(dir, "a.mp4"), filepath.Join(dir, "shows", "b.mkv")
+	for _, name := range []string{a, b, filepath.Join(dir, "notes.txt")} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldProbe := probeDuration
+	t.Cleanup(func() { probeDuration = oldProbe })
+	var mu sync.Mutex
+	var probed []string
+	probeDuration = func(p string) (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		probed = append(probed, p)
+		return 42, nil
+	}
+	probes := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		list := probed
+		probed = nil
+		sort.Strings(list)
+		return list
+	}
+
+	var ix durationIndex
+	if err := ix.build(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := probes(); len(got) != 2 || got[0] != a || got[1] != b {
+		t.Errorf("probed %v, want the two media files", got)
+	}
+	if d, ok := ix.lookup(b); !ok || d != 42 {
+		t.Errorf("lookup of a nested file = %v, %v", d, ok)
+	}
+
+	// Unchanged files are not probed again, changed ones are.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ix.lookup(a); ok {
+		t.Error("changed file still indexed")
+	}
+	if err := ix.build(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := probes(); len(got) != 1 || got[0] != a {
+		t.Errorf("rebuild probed %v, want only the changed file", got)
+	}
+
+	// Removed files are dropped.
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.build(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ix.entries[b]; ok {
+		t.Error("removed file still indexed")
+	}
+
+	ix.invalidate(a)
+	if _, ok := ix.lookup(a); ok {
+		t.Error("invalidated file still indexed")
+	}
+}
+
+func TestDurationIndexMissingRoot(t *testing.T) {
+	var ix durationIndex
+	if err := ix.build(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing root indexed without an error")
+	}
+}
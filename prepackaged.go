@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// servePrepackaged serves segments already packaged next to the source, as
+// {file}.hls/{segment}.ts, instead of encoding them.
+var servePrepackaged bool
+
+const prepackagedDirSuffix = ".hls"
+
+// prepackagedSegment returns the path of the packaged segment for r, or ""
+// if there is none. Packaged segments only stand in for the plain stream at
+// the default resolution of the source.
+func prepackagedSegment(r EncodingRequest) string {
+	if !servePrepackaged || !r.usesDefaultOptions() {
+		return ""
+	}
+	p := fmt.Sprintf("%v%v/%v.ts", r.file, prepackagedDirSuffix, r.segment)
+	if stat, err := os.Stat(p); err != nil || !stat.Mode().IsRegular() {
+		return ""
+	}
+	return p
+}
+
+// usesDefaultOptions reports whether r is a whole segment of the plain
+// stream, with every encoding option left at its default.
+func (r *EncodingRequest) usesDefaultOptions() bool {
+	return r.part == wholeSegment && r.res == sourceDefaultResolution(r.file) &&
+		r.quality == "" && r.container == "" && r.watermark == "" && !r.timecode &&
+		r.audio == "" && r.audioTrack == -1
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withPrepackaged enables packaged segments and packages segment 0 of
+// a.mp4 in a test root, returning the source and packaged segment paths.
+func withPrepackaged(t *testing.T) (string, string) {
+	dir := withTestRoot(t)
+	old := servePrepackaged
+	servePrepackaged = true
+	t.Cleanup(func() { servePrepackaged = old })
+
+	file := filepath.Join(dir, "a.mp4")
+	packaged := filepath.Join(dir, "a.mp4.hls", "0.ts")
+	if err := os.MkdirAll(filepath.Dir(packaged), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(packaged, []byte("segment"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file, packaged
+}
+
+func TestPrepackagedSegment(t *testing.T) {
+	file, packaged := withPrepackaged(t)
+	if p := prepackagedSegment(*NewEncodingRequest(file, 0, defaultResolution)); p != packaged {
+		t.Errorf("packaged segment %q, want %q", p, packaged)
+	}
+	if p := prepackagedSegment(*NewEncodingRequest(file, 1, defaultResolution)); p != "" {
+		t.Errorf("packaged segment %q for an unpackaged segment", p)
+	}
+}
+
+func TestPrepackagedSegmentDisabled(t *testing.T) {
+	file, _ := withPrepackaged(t)
+	servePrepackaged = false
+	if p := prepackagedSegment(*NewEncodingRequest(file, 0, defaultResolution)); p != "" {
+		t.Errorf("packaged segment %q served while disabled", p)
+	}
+}
+
+func TestPrepackagedSegmentOnlyForDefaults(t *testing.T) {
+	file, _ := withPrepackaged(t)
+	options := map[string]func(r *EncodingRequest){
+		"res":        func(r *EncodingRequest) { r.res = 720 },
+		"quality":    func(r *EncodingRequest) { r.quality = "high" },
+		"watermark":  func(r *EncodingRequest) { r.watermark = "/media/logo.png" },
+		"timecode":   func(r *EncodingRequest) { r.timecode = true },
+		"part":       func(r *EncodingRequest) { r.part = 0 },
+		"audio":      func(r *EncodingRequest) { r.audio = "/media/a.de.m4a" },
+		"audiotrack": func(r *EncodingRequest) { r.audioTrack = 1 },
+		"container":  func(r *EncodingRequest) { r.container = containerFMP4 },
+	}
+	for name, set := range options {
+		r := NewEncodingRequest(file, 0, defaultResolution)
+		set(r)
+		if p := prepackagedSegment(*r); p != "" {
+			t.Errorf("packaged segment served with a non-default %v", name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// presetTier selects an x264 preset for outputs up to maxHeight pixels.
+type presetTier struct {
+	maxHeight int64
+	preset    string
+}
+
+// presetTiers maps output resolutions to x264 presets. It implements
+// flag.Value using the syntax "360=slow,720=fast,1080=veryfast".
+type presetTiers []presetTier
+
+// defaultPreset encodes outputs no tier covers.
+const defaultPreset = "veryfast"
+
+// x264Presets are the presets libx264 knows, fastest first.
+var x264Presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow", "placebo"}
+
+func isX264Preset(name string) bool {
+	for _, preset := range x264Presets {
+		if name == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// encodingPresets are empty by default, so every output is encoded with
+// defaultPreset within the same -timelimit as before tiers existed. Slower
+// presets for small outputs, where they are cheap, are opt-in, e.g.
+// "360=slow,720=fast".
+var encodingPresets presetTiers
+
+func (t *presetTiers) String() string {
+	tiers := make([]string, len(*t))
+	for i, tier := range *t {
+		tiers[i] = fmt.Sprintf("%v=%v", tier.maxHeight, tier.preset)
+	}
+	return strings.Join(tiers, ",")
+}
+
+func (t *presetTiers) Set(value string) error {
+	var tiers presetTiers
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("Invalid preset tier %q, expected height=preset", field)
+		}
+		height, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || height <= 0 {
+			return fmt.Errorf("Invalid preset tier height %q", parts[0])
+		}
+		if !isX264Preset(parts[1]) {
+			return fmt.Errorf("Unknown x264 preset %q, expected one of %v", parts[1], strings.Join(x264Presets, ", "))
+		}
+		tiers = append(tiers, presetTier{height, parts[1]})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].maxHeight < tiers[j].maxHeight })
+	*t = tiers
+	return nil
+}
+
+// presetFor returns the preset of the smallest tier covering res. Outputs
+// larger than every tier use defaultPreset.
+func (t presetTiers) presetFor(res int64) string {
+	for _, tier := range t {
+		if res <= tier.maxHeight {
+			return tier.preset
+		}
+	}
+	return defaultPreset
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestPresetForDefault(t *testing.T) {
+	for _, res := range []int64{144, 360, 480, 1080, 2160} {
+		if got := encodingPresets.presetFor(res); got != defaultPreset {
+			t.Errorf("default preset for %vp is %v, want %v", res, got, defaultPreset)
+		}
+	}
+}
+
+func TestPresetForTiers(t *testing.T) {
+	var tiers presetTiers
+	if err := tiers.Set("1080=veryfast, 360=slow,720=fast"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tiers.String(), "360=slow,720=fast,1080=veryfast"; got != want {
+		t.Errorf("tiers %v, want %v", got, want)
+	}
+	for res, want := range map[int64]string{240: "slow", 360: "slow", 480: "fast", 720: "fast", 1080: "veryfast", 2160: defaultPreset} {
+		if got := tiers.presetFor(res); got != want {
+			t.Errorf("preset for %vp is %v, want %v", res, got, want)
+		}
+	}
+}
+
+func TestPresetTiersInvalid(t *testing.T) {
+	for _, value := range []string{"slow", "360=", "x=slow", "-1=slow", "360=slo", "720=Fast", "360=slow,720=turbo"} {
+		var tiers presetTiers
+		if err := tiers.Set(value); err == nil {
+			t.Errorf("Set(%q) accepted", value)
+		}
+	}
+}
+
+func TestCacheKeyPreset(t *testing.T) {
+	r := NewWarmupEncodingRequest("/media/a.mp4", 0, 360)
+	plain := r.getCacheKey()
+
+	saved := encodingPresets
+	defer func() { encodingPresets = saved }()
+	encodingPresets = presetTiers{{360, defaultPreset}}
+	if r.getCacheKey() != plain {
+		t.Error("the default preset changed the cache key")
+	}
+	encodingPresets = presetTiers{{360, "slow"}}
+	if r.getCacheKey() == plain {
+		t.Error("a slower preset kept the cache key")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const FFPROBEPath = "ffprobe"
+
+// videoInfo describes the first video stream of a file. Width and Height are
+// the coded size; DisplaySize gives the size after applying Rotation.
+type videoInfo struct {
+	Width    int
+	Height   int
+	Rotation int // Degrees clockwise, one of 0, 90, 180, 270
+	// FieldOrder is ffprobe's field_order: progressive, tt, bb, tb, bt or
+	// unknown.
+	FieldOrder string
+
+	// KeyframeInterval is the longest keyframe distance in seconds, 0 if unknown.
+	KeyframeInterval float64
+}
+
+type ffprobeStreams struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		Width      int    `json:"width"`
+		Height     int    `json:"height"`
+		FieldOrder string `json:"field_order"`
+		Tags       struct {
+			Rotate   string `json:"rotate"`
+			Language string `json:"language"`
+			Title    string `json:"title"`
+		} `json:"tags"`
+		Disposition struct {
+			Default int `json:"default"`
+		} `json:"disposition"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// probeVideoInfo reads the size and rotation of the first video stream of path.
+func probeVideoInfo(path string) (*videoInfo, error) {
+	out, err := exec.Command(FFPROBEPath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,field_order:stream_tags=rotate:stream_side_data=rotation",
+		"-of", "json",
+		path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Probe video info error:%v", err)
+	}
+	return parseVideoInfo(out)
+}
+
+func parseVideoInfo(data []byte) (*videoInfo, error) {
+	var probe ffprobeStreams
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("Parse video info error:%v", err)
+	}
+	return videoInfoFrom(probe)
+}
+
+// videoInfoFrom describes the first video stream of probe. Streams without a
+// codec_type are taken to be video, as -select_streams v filters to them.
+func videoInfoFrom(probe ffprobeStreams) (*videoInfo, error) {
+	i := 0
+	for i < len(probe.Streams) && probe.Streams[i].CodecType != "" && probe.Streams[i].CodecType != "video" {
+		i++
+	}
+	if i == len(probe.Streams) {
+		return nil, fmt.Errorf("No video stream found")
+	}
+	s := probe.Streams[i]
+	info := &videoInfo{Width: s.Width, Height: s.Height, FieldOrder: s.FieldOrder}
+
+	// Older muxers store a "rotate" tag (clockwise), newer ffprobe reports a
+	// display matrix rotation (counter-clockwise).
+	var rotation int
+	if s.Tags.Rotate != "" {
+		fmt.Sscanf(s.Tags.Rotate, "%d", &rotation)
+	} else {
+		for _, sd := range s.SideDataList {
+			if sd.Rotation != 0 {
+				rotation = -int(sd.Rotation)
+				break
+			}
+		}
+	}
+	info.Rotation = normalizeRotation(rotation)
+	return info, nil
+}
+
+// normalizeRotation maps any angle to 0, 90, 180 or 270 degrees.
+func normalizeRotation(degrees int) int {
+	degrees = ((degrees % 360) + 360) % 360
+	return (degrees + 45) / 90 * 90 % 360
+}
+
+// DisplaySize returns the width and height the video is shown at once rotated.
+func (v *videoInfo) DisplaySize() (int, int) {
+	if v.Rotation == 90 || v.Rotation == 270 {
+		return v.Height, v.Width
+	}
+	return v.Width, v.Height
+}
+
+// IsPortrait reports whether the displayed video is taller than it is wide.
+func (v *videoInfo) IsPortrait() bool {
+	w, h := v.DisplaySize()
+	return h > w
+}
+
+// IsInterlaced reports whether the video is stored as interlaced fields.
+func (v *videoInfo) IsInterlaced() bool {
+	switch v.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// probeKeyframeInterval returns the longest distance in seconds between two
+// keyframes within the first minute of path.
+func probeKeyframeInterval(path string) (float64, error) {
+	out, err := exec.Command(FFPROBEPath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-read_intervals", "%+60",
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0",
+		path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("Probe keyframes error:%v", err)
+	}
+	return parseKeyframeInterval(out), nil
+}
+
+func parseKeyframeInterval(data []byte) float64 {
+	var interval, last float64
+	first := true
+	for _, line := range strings.Split(string(data), "\n") {
+		t, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimRight(line, ",")), 64)
+		if err != nil {
+			continue
+		}
+		if !first && t-last > interval {
+			interval = t - last
+		}
+		last, first = t, false
+	}
+	return interval
+}
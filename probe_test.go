@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseVideoInfoRotation(t *testing.T) {
+	tests := []struct {
+		name          string
+		json          string
+		rotation      int
+		width, height int
+		portrait      bool
+	}{
+		{"landscape", `{"streams":[{"width":1920,"height":1080}]}`, 0, 1920, 1080, false},
+		{"rotate tag", `{"streams":[{"width":1920,"height":1080,"tags":{"rotate":"90"}}]}`, 90, 1080, 1920, true},
+		{"display matrix", `{"streams":[{"width":1920,"height":1080,"side_data_list":[{"rotation":-90}]}]}`, 90, 1080, 1920, true},
+		{"display matrix ccw", `{"streams":[{"width":1920,"height":1080,"side_data_list":[{"rotation":90}]}]}`, 270, 1080, 1920, true},
+		{"upside down", `{"streams":[{"width":1920,"height":1080,"tags":{"rotate":"180"}}]}`, 180, 1920, 1080, false},
+		{"portrait source", `{"streams":[{"width":720,"height":1280}]}`, 0, 720, 1280, true},
+	}
+	for _, tt := range tests {
+		info, err := parseVideoInfo([]byte(tt.json))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if info.Rotation != tt.rotation {
+			t.Errorf("%v: rotation %v, want %v", tt.name, info.Rotation, tt.rotation)
+		}
+		if w, h := info.DisplaySize(); w != tt.width || h != tt.height {
+			t.Errorf("%v: display size %vx%v, want %vx%v", tt.name, w, h, tt.width, tt.height)
+		}
+		if info.IsPortrait() != tt.portrait {
+			t.Errorf("%v: portrait %v, want %v", tt.name, info.IsPortrait(), tt.portrait)
+		}
+	}
+}
+
+func TestParseVideoInfoNoVideo(t *testing.T) {
+	if _, err := parseVideoInfo([]byte(`{"streams":[]}`)); err == nil {
+		t.Error("expected an error without a video stream")
+	}
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	for degrees, want := range map[int]int{0: 0, 90: 90, -90: 270, 270: 270, 360: 0, -180: 180, 89: 90, 450: 90} {
+		if got := normalizeRotation(degrees); got != want {
+			t.Errorf("normalizeRotation(%v) = %v, want %v", degrees, got, want)
+		}
+	}
+}
+
+func TestScaleFilterRotation(t *testing.T) {
+	if got, want := scaleFilter(480, &videoInfo{Width: 1920, Height: 1080, Rotation: 90}), "scale=480:-2"; got != want {
+		t.Errorf("rotated scale filter %v, want %v", got, want)
+	}
+	if got, want := scaleFilter(480, &videoInfo{Width: 1920, Height: 1080}), "scale=-2:480"; got != want {
+		t.Errorf("landscape scale filter %v, want %v", got, want)
+	}
+	if got, want := scaleFilter(480, nil), "scale=-2:480"; got != want {
+		t.Errorf("unknown scale filter %v, want %v", got, want)
+	}
+}
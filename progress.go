@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	progressStarted = "started"
+	progressDone    = "done"
+	progressFailed  = "failed"
+)
+
+// progressKeepAlive is how often an idle progress stream sends a comment so
+// proxies keep it open.
+const progressKeepAlive = 15 * time.Second
+
+type progressEvent struct {
+	Segment    int64  `json:"segment"`
+	Resolution int64  `json:"resolution"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// progressHub fans encoder events out to the subscribers of each file.
+type progressHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan progressEvent]struct{}
+}
+
+// subscribe returns a channel receiving the events of file and a function
+// that must be called to stop receiving them.
+func (h *progressHub) subscribe(file string) (chan progressEvent, func()) {
+	ch := make(chan progressEvent, 16)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[string]map[chan progressEvent]struct{})
+	}
+	if h.subs[file] == nil {
+		h.subs[file] = make(map[chan progressEvent]struct{})
+	}
+	h.subs[file][ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[file], ch)
+		if len(h.subs[file]) == 0 {
+			delete(h.subs, file)
+		}
+	}
+}
+
+// publish sends an event about r to the subscribers of its file. Events are
+// dropped for subscribers that fall behind rather than stalling the encoder.
+func (h *progressHub) publish(r EncodingRequest, status string, err error) {
+	ev := progressEvent{Segment: r.segment, Resolution: r.res, Status: status}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[r.file] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// progressHandler streams the encoder events of a file as server-sent
+// events until the client disconnects.
+func progressHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Debugf("Could not clear progress write deadline: %v", err)
+	}
+
+	events, unsubscribe := encoder.progress.subscribe(file)
+	defer unsubscribe()
+
+	w.Header()["Content-Type"] = []string{"text/event-stream"}
+	w.Header()["Cache-Control"] = []string{"no-cache"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev := <-events:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestProgressFanOut(t *testing.T) {
+	var h progressHub
+	a1, unsubscribeA1 := h.subscribe("/media/a.mp4")
+	a2, unsubscribeA2 := h.subscribe("/media/a.mp4")
+	b, unsubscribeB := h.subscribe("/media/b.mp4")
+	defer unsubscribeB()
+
+	h.publish(*NewEncodingRequest("/media/a.mp4", 3, 720), progressFailed, errors.New("broken"))
+	for i, ch := range []chan progressEvent{a1, a2} {
+		select {
+		case ev := <-ch:
+			if ev.Segment != 3 || ev.Resolution != 720 || ev.Status != progressFailed || ev.Error != "broken" {
+				t.Errorf("subscriber %v got %+v", i, ev)
+			}
+		default:
+			t.Errorf("subscriber %v got no event", i)
+		}
+	}
+	select {
+	case ev := <-b:
+		t.Errorf("subscriber of another file got %+v", ev)
+	default:
+	}
+
+	unsubscribeA1()
+	h.publish(*NewEncodingRequest("/media/a.mp4", 4, 720), progressDone, nil)
+	if len(a1) != 0 || len(a2) != 1 {
+		t.Errorf("after unsubscribing: %v and %v events, want 0 and 1", len(a1), len(a2))
+	}
+	unsubscribeA2()
+	if _, ok := h.subs["/media/a.mp4"]; ok {
+		t.Error("file without subscribers still listed")
+	}
+}
+
+func TestProgressSlowSubscriber(t *testing.T) {
+	var h progressHub
+	_, unsubscribe := h.subscribe("/media/a.mp4")
+	defer unsubscribe()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			h.publish(*NewEncodingRequest("/media/a.mp4", int64(i), 480), progressDone, nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing to a subscriber that does not read blocked")
+	}
+}
+
+func TestProgressHandler(t *testing.T) {
+	dir := withTestRoot(t)
+	e := encoder
+	file := filepath.Join(dir, "a.mp4")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		progressHandler(w, r, httprouter.Params{{Key: "filename", Value: "/a.mp4"}})
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %v", ct)
+	}
+	e.progress.publish(*NewEncodingRequest(file, 2, 480), progressStarted, nil)
+	lines := bufio.NewReader(resp.Body)
+	event, _ := lines.ReadString('\n')
+	data, _ := lines.ReadString('\n')
+	if event != "event: progress\n" || !strings.Contains(data, `"segment":2`) || !strings.Contains(data, `"status":"started"`) {
+		t.Errorf("streamed %q %q", event, data)
+	}
+
+	// Disconnecting unsubscribes.
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.progress.mu.Lock()
+		n := len(e.progress.subs)
+		e.progress.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("disconnected client still subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// qualityTier bundles the x264 settings a client selects with ?quality.
+// maxrate scales estimateBandwidth of the output resolution.
+type qualityTier struct {
+	preset  string
+	crf     int
+	maxrate float64
+}
+
+var qualityTiers = map[string]qualityTier{
+	"low":    {"veryfast", 28, 0.5},
+	"medium": {"fast", 23, 1},
+	"high":   {"medium", 19, 1.5},
+}
+
+// parseQuality returns the requested quality tier, or "" for the default
+// encoding settings.
+func parseQuality(q url.Values) (string, error) {
+	value := q.Get("quality")
+	if value == "" {
+		return "", nil
+	}
+	if _, ok := qualityTiers[value]; !ok {
+		return "", fmt.Errorf("Invalid quality %v, expected low, medium or high", value)
+	}
+	return value, nil
+}
+
+// presetFor returns the x264 preset used to encode r.
+func (r *EncodingRequest) presetFor() string {
+	if tier, ok := qualityTiers[r.quality]; ok {
+		return tier.preset
+	}
+	return encodingPresets.presetFor(r.res)
+}
+
+// qualityArgs are the rate control options of r's quality tier.
+func (r *EncodingRequest) qualityArgs() []string {
+	tier, ok := qualityTiers[r.quality]
+	if !ok {
+		return nil
+	}
+	maxrate := int64(float64(estimateBandwidth(r.res)) * tier.maxrate)
+	return []string{
+		"-crf", fmt.Sprintf("%v", tier.crf),
+		"-maxrate", fmt.Sprintf("%v", maxrate),
+		"-bufsize", fmt.Sprintf("%v", 2*maxrate),
+	}
+}
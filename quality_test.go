@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestQualityTierArgs(t *testing.T) {
+	tests := []struct {
+		tier   string
+		preset string
+		args   []string
+	}{
+		{"low", "veryfast", []string{"-crf", "28", "-maxrate", "691200", "-bufsize", "1382400"}},
+		{"medium", "fast", []string{"-crf", "23", "-maxrate", "1382400", "-bufsize", "2764800"}},
+		{"high", "medium", []string{"-crf", "19", "-maxrate", "2073600", "-bufsize", "4147200"}},
+	}
+	for _, test := range tests {
+		r := NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+		r.quality = test.tier
+		if preset := r.presetFor(); preset != test.preset {
+			t.Errorf("%v: preset %v, want %v", test.tier, preset, test.preset)
+		}
+		if args := r.qualityArgs(); !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%v: args %v, want %v", test.tier, args, test.args)
+		}
+		if args := EncodingArgs(*r, nil); !containsArgs(args, "-preset", test.preset) || !containsArgs(args, test.args...) {
+			t.Errorf("%v: encoding args %v lack the tier", test.tier, args)
+		}
+	}
+}
+
+func TestQualityDefault(t *testing.T) {
+	r := NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	if args := r.qualityArgs(); args != nil {
+		t.Errorf("default quality args %v, want none", args)
+	}
+	if preset := r.presetFor(); preset != defaultPreset {
+		t.Errorf("default preset %v, want %v", preset, defaultPreset)
+	}
+}
+
+func TestParseQuality(t *testing.T) {
+	for value, ok := range map[string]bool{"": true, "low": true, "medium": true, "high": true, "ultra": false, "LOW": false} {
+		got, err := parseQuality(url.Values{"quality": {value}})
+		if (err == nil) != ok || (ok && got != value) {
+			t.Errorf("parseQuality(%q) = %q, %v", value, got, err)
+		}
+	}
+}
+
+func TestCacheKeyQuality(t *testing.T) {
+	keys := make(map[string]string)
+	for _, tier := range []string{"", "low", "medium", "high"} {
+		r := NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+		r.quality = tier
+		key := r.getCacheKey()
+		if other, ok := keys[key]; ok {
+			t.Errorf("tiers %q and %q share cache key %v", other, tier, key)
+		}
+		keys[key] = tier
+	}
+}
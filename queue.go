@@ -0,0 +1,36 @@
+package main
+
+import "fmt"
+
+const (
+	queueReject = "reject"
+	queueBlock  = "block"
+)
+
+// queueFullPolicy decides what happens to segment requests while the encoder
+// queue is full: "reject" fails them with ErrQueueFull and drops warmups,
+// "block" waits for room.
+var queueFullPolicy = queueReject
+
+func validateQueueFullPolicy(policy string) error {
+	switch policy {
+	case queueReject, queueBlock:
+		return nil
+	}
+	return fmt.Errorf("Unknown queue full policy %v, expected reject or block", policy)
+}
+
+// enqueue hands r to the workers. It returns false if the queue is full and
+// queueFullPolicy does not allow waiting.
+func (e *Encoder) enqueue(r EncodingRequest) bool {
+	if queueFullPolicy == queueBlock {
+		e.reqChan <- r
+		return true
+	}
+	select {
+	case e.reqChan <- r:
+		return true
+	default:
+		return false
+	}
+}
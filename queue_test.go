@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func withQueueFullPolicy(t *testing.T, policy string) {
+	saved := queueFullPolicy
+	queueFullPolicy = policy
+	t.Cleanup(func() { queueFullPolicy = saved })
+}
+
+// fullEncoder returns an encoder whose queue of one request is taken.
+func fullEncoder() *Encoder {
+	e := &Encoder{cacheDir: "segments", reqChan: make(chan EncodingRequest, 1), readAhead: 2}
+	e.reqChan <- *NewWarmupEncodingRequest("/media/busy.mp4", 0, 480)
+	return e
+}
+
+func TestEnqueueFullQueue(t *testing.T) {
+	withQueueFullPolicy(t, queueReject)
+	e := fullEncoder()
+	if e.enqueue(*NewEncodingRequest("/media/a.mp4", 0, 480)) {
+		t.Error("request queued beyond the capacity")
+	}
+
+	queueFullPolicy = queueBlock
+	queued := make(chan bool)
+	go func() { queued <- e.enqueue(*NewEncodingRequest("/media/a.mp4", 1, 480)) }()
+	select {
+	case <-queued:
+		t.Fatal("blocking enqueue returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-e.reqChan
+	if !<-queued {
+		t.Error("blocking enqueue failed once there was room")
+	}
+}
+
+func TestEncodeRejectsWhenQueueFull(t *testing.T) {
+	withTestRoot(t)
+	withQueueFullPolicy(t, queueReject)
+	e := fullEncoder()
+	r := NewEncodingRequest("/media/a.mp4", 3, 480)
+	e.Encode(*r)
+	select {
+	case err := <-r.err:
+		if !errors.Is(err, ErrQueueFull) || errorStatus(err) != http.StatusServiceUnavailable {
+			t.Errorf("error %v with status %v, want ErrQueueFull and 503", err, errorStatus(err))
+		}
+	case <-r.data:
+		t.Error("request served from a full queue")
+	case <-time.After(5 * time.Second):
+		t.Fatal("request left waiting on a full queue")
+	}
+}
+
+func TestWarmupsDroppedWhenQueueFull(t *testing.T) {
+	withQueueFullPolicy(t, queueReject)
+	e := fullEncoder()
+	done := make(chan struct{})
+	go func() {
+		e.warmAhead(*NewEncodingRequest("/media/a.mp4", 5, 480))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("warmups blocked on a full queue")
+	}
+	if n := len(e.reqChan); n != 1 {
+		t.Errorf("%v requests queued, want only the one already there", n)
+	}
+}
+
+func TestValidateQueueFullPolicy(t *testing.T) {
+	for _, policy := range []string{queueReject, queueBlock} {
+		if err := validateQueueFullPolicy(policy); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := validateQueueFullPolicy("drop"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for rate limiters.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestRateLimiterBurstThenReject(t *testing.T) {
+	clock := &fakeClock{time.Unix(1000, 0)}
+	l := newRateLimiter(2, 3)
+	l.now = clock.now
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("10.0.0.1"); !ok {
+			t.Fatalf("request %v of the burst rejected", i+1)
+		}
+	}
+	ok, wait := l.allow("10.0.0.1")
+	if ok {
+		t.Fatal("request beyond the burst allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait %v, want 500ms", wait)
+	}
+	if ok, _ := l.allow("10.0.0.2"); !ok {
+		t.Error("another client was limited")
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	clock := &fakeClock{time.Unix(1000, 0)}
+	l := newRateLimiter(1, 1)
+	l.now = clock.now
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Fatal("second request allowed")
+	}
+	clock.t = clock.t.Add(time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Error("request after refill rejected")
+	}
+	clock.t = clock.t.Add(time.Hour)
+	l.allow("a")
+	if ok, _ := l.allow("a"); ok {
+		t.Error("tokens refilled beyond the burst")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	clock := &fakeClock{time.Unix(1000, 0)}
+	l := newRateLimiter(1, 1)
+	l.now = clock.now
+	l.allow("a")
+	clock.t = clock.t.Add(time.Second)
+	l.prune(clock.now())
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("refilled bucket was not pruned")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.7:51234"
+	if ip := clientIP(r); ip != "192.0.2.7" {
+		t.Errorf("clientIP %q, want 192.0.2.7", ip)
+	}
+	r.RemoteAddr = "@"
+	if ip := clientIP(r); ip != "@" {
+		t.Errorf("clientIP %q, want the raw address", ip)
+	}
+}
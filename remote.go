@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// hostList is a comma separated flag.Value of host names. Entries starting
+// with a dot match every subdomain.
+type hostList []string
+
+// remoteHosts are the hosts http and https sources may be streamed from.
+// Remote sources are refused while it is empty.
+var remoteHosts hostList
+
+func (l *hostList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *hostList) Set(value string) error {
+	var hosts hostList
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	*l = hosts
+	return nil
+}
+
+func (l hostList) allows(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range l {
+		if host == allowed || strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRemoteSource reports whether name is a URL rather than a path below root.
+func isRemoteSource(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// resolveSource returns what ffmpeg reads for a client supplied source name:
+// the file below root, or the URL itself for allowed remote sources.
+func resolveSource(name string) (string, error) {
+	if !isRemoteSource(name) {
+		return resolveMediaPath(name)
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("Invalid source URL %v", name)
+	}
+	if u.User != nil || !remoteHosts.allows(u.Hostname()) {
+		return "", fmt.Errorf("Remote source host %v is not allowed", u.Hostname())
+	}
+	return u.String(), nil
+}
+
+// sourcePath is the path part of a source, without the query of a URL.
+func sourcePath(source string) string {
+	if isRemoteSource(source) {
+		if u, err := url.Parse(source); err == nil {
+			return u.Path
+		}
+	}
+	return source
+}
+
+// sourceID is how a source name appears in segment URLs. Remote sources are
+// escaped so that their query survives in the path.
+func sourceID(name string) (string, error) {
+	if isRemoteSource(name) {
+		return url.PathEscape(name), nil
+	}
+	return urlEncoded(name)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func withRemoteHosts(t *testing.T, hosts string) {
+	old := remoteHosts
+	t.Cleanup(func() { remoteHosts = old })
+	if err := remoteHosts.Set(hosts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveSourceURLs(t *testing.T) {
+	withRemoteHosts(t, "media.example.com, .cdn.example.net")
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"https://media.example.com/a.mp4", true},
+		{"http://MEDIA.example.com/a.mp4?sig=1", true},
+		{"https://eu.cdn.example.net/a.mp4", true},
+		{"https://cdn.example.net.evil.com/a.mp4", false},
+		{"https://example.com/a.mp4", false},
+		{"https://user:pw@media.example.com/a.mp4", false},
+		{"https://media.example.com:8443/a.mp4", true},
+	}
+	for _, test := range tests {
+		source, err := resolveSource(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("resolveSource(%q) = %q, %v", test.name, source, err)
+		}
+	}
+}
+
+func TestResolveSourceRemoteDisabled(t *testing.T) {
+	withRemoteHosts(t, "")
+	if _, err := resolveSource("https://media.example.com/a.mp4"); err == nil {
+		t.Error("remote source allowed without allowed hosts")
+	}
+}
+
+func TestResolveSourceLocal(t *testing.T) {
+	dir := withTestRoot(t)
+	if source, err := resolveSource("shows/a.mp4"); err != nil || source != filepath.Join(dir, "shows", "a.mp4") {
+		t.Errorf("resolveSource = %q, %v", source, err)
+	}
+	if _, err := resolveSource("../a.mp4"); err == nil {
+		t.Error("local source outside the root allowed")
+	}
+}
+
+func TestCacheKeyRemoteSource(t *testing.T) {
+	a := NewWarmupEncodingRequest("https://media.example.com/a.mp4?sig=1", 0, 480)
+	b := NewWarmupEncodingRequest("https://media.example.com/a.mp4?sig=2", 0, 480)
+	want := fmt.Sprintf("%x.480.0", sha1.Sum([]byte("https://media.example.com/a.mp4?sig=1")))
+	if key := a.getCacheKey(); key != want {
+		t.Errorf("cache key %q, want %q", key, want)
+	}
+	if a.getCacheKey() == b.getCacheKey() {
+		t.Error("different URLs share a cache key")
+	}
+}
+
+func TestSourcePathAndID(t *testing.T) {
+	if p := sourcePath("https://media.example.com/dir/a.mp4?sig=1"); p != "/dir/a.mp4" {
+		t.Errorf("sourcePath %q", p)
+	}
+	if !allowedExtensions.allows("https://media.example.com/a.mp4?format=txt") {
+		t.Error("URL query changed the extension")
+	}
+	if id, _ := sourceID("https://media.example.com/a.mp4?sig=1"); id != "https:%2F%2Fmedia.example.com%2Fa.mp4%3Fsig=1" {
+		t.Errorf("sourceID %q", id)
+	}
+}
+
+func TestRemoteSourceHandlersCheckHosts(t *testing.T) {
+	withRemoteHosts(t, "media.example.com")
+	handlers := map[string]struct {
+		handle httprouter.Handle
+		param  string
+	}{
+		"master":   {masterPlaylist, "filename"},
+		"info":     {videoInfoHandler, "filename"},
+		"chapters": {chaptersHandler, "filename"},
+		"iframes":  {iframesHandler, "filename"},
+		"pic":      {pic, "cover"},
+		"thumbvtt": {thumbVTT, "filename"},
+	}
+	for name, h := range handlers {
+		w := httptest.NewRecorder()
+		h.handle(w, httptest.NewRequest("GET", "/api/"+name, nil), httprouter.Params{{Key: h.param, Value: "/https://example.com/a.mp4"}})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%v of a disallowed host: status %v, want %v", name, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestRemoteSourceThumbVTT(t *testing.T) {
+	withRemoteHosts(t, "media.example.com")
+	oldProbe := probeDuration
+	t.Cleanup(func() { probeDuration = oldProbe })
+	var probed string
+	probeDuration = func(p string) (float64, error) {
+		probed = p
+		return 5, nil
+	}
+
+	w := httptest.NewRecorder()
+	thumbVTT(w, httptest.NewRequest("GET", "/api/thumbvtt/x", nil), httprouter.Params{{Key: "filename", Value: "/https://media.example.com/a.mp4?sig=1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v: %v", w.Code, w.Body)
+	}
+	if probed != "https://media.example.com/a.mp4?sig=1" {
+		t.Errorf("probed %q, want the URL itself", probed)
+	}
+	if want := "/api/pic/https:%2F%2Fmedia.example.com%2Fa.mp4%3Fsig=1?t=0"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("track does not point at %v: %v", want, w.Body)
+	}
+}
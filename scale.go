@@ -0,0 +1,34 @@
+package main
+
+import "fmt"
+
+// scaleAlgorithm is the swscale algorithm of the scale filter, "" for
+// ffmpeg's default (bicubic). Slower algorithms give sharper downscales.
+var scaleAlgorithm string
+
+// scaleAlgorithms are the algorithms that can be chosen.
+var scaleAlgorithms = map[string]bool{
+	"fast_bilinear": true,
+	"bilinear":      true,
+	"bicubic":       true,
+	"area":          true,
+	"neighbor":      true,
+	"gauss":         true,
+	"spline":        true,
+	"lanczos":       true,
+}
+
+func validateScaleAlgorithm(algorithm string) error {
+	if algorithm != "" && !scaleAlgorithms[algorithm] {
+		return fmt.Errorf("Unknown scaling algorithm %v, expected e.g. bilinear, bicubic or lanczos", algorithm)
+	}
+	return nil
+}
+
+// scaleFlags are the options appended to the scale filter.
+func scaleFlags() string {
+	if scaleAlgorithm == "" {
+		return ""
+	}
+	return ":flags=" + scaleAlgorithm
+}
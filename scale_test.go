@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func withScaleAlgorithm(t *testing.T, algorithm string) {
+	old := scaleAlgorithm
+	scaleAlgorithm = algorithm
+	t.Cleanup(func() { scaleAlgorithm = old })
+}
+
+func TestScaleFilterAlgorithm(t *testing.T) {
+	portrait := &videoInfo{Width: 1080, Height: 1920}
+	for _, test := range []struct {
+		algorithm, landscape, portrait string
+	}{
+		{"", "scale=-2:480", "scale=480:-2"},
+		{"bilinear", "scale=-2:480:flags=bilinear", "scale=480:-2:flags=bilinear"},
+		{"bicubic", "scale=-2:480:flags=bicubic", "scale=480:-2:flags=bicubic"},
+		{"lanczos", "scale=-2:480:flags=lanczos", "scale=480:-2:flags=lanczos"},
+	} {
+		withScaleAlgorithm(t, test.algorithm)
+		if got := scaleFilter(480, nil); got != test.landscape {
+			t.Errorf("%q: filter %v, want %v", test.algorithm, got, test.landscape)
+		}
+		if got := scaleFilter(480, portrait); got != test.portrait {
+			t.Errorf("%q: portrait filter %v, want %v", test.algorithm, got, test.portrait)
+		}
+	}
+}
+
+func TestScaleAlgorithmCacheKey(t *testing.T) {
+	r := NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	withScaleAlgorithm(t, "")
+	plain := r.getCacheKey()
+	keys := map[string]string{"": plain}
+	for _, algorithm := range []string{"bilinear", "bicubic", "lanczos"} {
+		scaleAlgorithm = algorithm
+		key := r.getCacheKey()
+		for other, otherKey := range keys {
+			if key == otherKey {
+				t.Errorf("%q and %q share a cache key", algorithm, other)
+			}
+		}
+		keys[algorithm] = key
+	}
+}
+
+func TestValidateScaleAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{"", "bilinear", "bicubic", "lanczos"} {
+		if err := validateScaleAlgorithm(algorithm); err != nil {
+			t.Errorf("%q: %v", algorithm, err)
+		}
+	}
+	if validateScaleAlgorithm("sharp") == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	seekPreroll = "preroll"
+	seekAuto    = "auto"
+	seekInput   = "input"
+	seekOutput  = "output"
+)
+
+var (
+	// seekStrategy is how encodes seek to a segment start. preroll input
+	// seeks prerollSeconds early and output seeks the rest. input seeks
+	// straight to the start, which is fastest but may start mid-GOP on
+	// sources with sparse keyframes. output input seeks a whole GOP early
+	// and output seeks the rest, so decoding always starts at a keyframe.
+	// auto picks input for sources whose keyframes are at most
+	// seekGOPThreshold seconds apart and output for the others.
+	seekStrategy     = seekPreroll
+	seekGOPThreshold = 2.0
+)
+
+func validateSeekStrategy(strategy string) error {
+	switch strategy {
+	case seekPreroll, seekAuto, seekInput, seekOutput:
+		return nil
+	}
+	return fmt.Errorf("Invalid seek strategy %v, expected preroll, auto, input or output", strategy)
+}
+
+// needsKeyframeInterval reports whether encodes need the keyframe interval
+// of their source.
+func needsKeyframeInterval() bool {
+	return prerollFromGOP || seekStrategy == seekAuto || seekStrategy == seekOutput
+}
+
+// chooseSeek resolves seekStrategy for a source with the given keyframe
+// interval, 0 if unknown.
+func chooseSeek(gop float64) string {
+	if seekStrategy != seekAuto {
+		return seekStrategy
+	}
+	if gop > 0 && gop <= seekGOPThreshold {
+		return seekInput
+	}
+	return seekOutput
+}
+
+// seekFor returns the input and output seek to startTime for a source
+// described by info.
+func seekFor(startTime float64, info *videoInfo) (pressTime float64, postssTime float64) {
+	var gop float64
+	if info != nil {
+		gop = info.KeyframeInterval
+	}
+	switch chooseSeek(gop) {
+	case seekInput:
+		return startTime, 0
+	case seekOutput:
+		if gop > 0 {
+			return seekOffsets(startTime, int64(math.Ceil(gop)))
+		}
+	}
+	return seekOffsets(startTime, prerollFor(info))
+}
@@ -0,0 +1,135 @@
+package main
+
+import "testing"
+
+func TestSeekOffsets(t *testing.T) {
+	tests := []struct {
+		segment int64
+		preroll int64
+		press   float64
+		postss  float64
+	}{
+		{0, 5, 0, 0},
+		{1, 5, 5, 5},
+		{2, 5, 15, 5},
+		{10, 5, 95, 5},
+		{1, 12, 0, 10},
+		{3, 12, 18, 12},
+		{3, 0, 30, 0},
+	}
+	for _, tt := range tests {
+		start := float64(tt.segment) * hlsSegmentLength
+		press, postss := seekOffsets(start, tt.preroll)
+		if press != tt.press || postss != tt.postss {
+			t.Errorf("segment %v with %vs preroll: seek %v+%v, want %v+%v", tt.segment, tt.preroll, press, postss, tt.press, tt.postss)
+		}
+		if press+postss != start {
+			t.Errorf("segment %v: seeks add up to %v, not %v", tt.segment, press+postss, start)
+		}
+	}
+}
+
+func TestPrerollFromGOP(t *testing.T) {
+	defer func() { prerollSeconds, prerollFromGOP = defaultPreroll, false }()
+	info := &videoInfo{KeyframeInterval: 8.2}
+
+	if got := prerollFor(info); got != defaultPreroll {
+		t.Errorf("preroll %v ignoring the GOP, want %v", got, defaultPreroll)
+	}
+	prerollFromGOP = true
+	if got := prerollFor(info); got != 9 {
+		t.Errorf("preroll %v from an 8.2s GOP, want 9", got)
+	}
+	if got := prerollFor(&videoInfo{KeyframeInterval: 2}); got != defaultPreroll {
+		t.Errorf("preroll %v from a short GOP, want %v", got, defaultPreroll)
+	}
+	prerollSeconds = 3
+	if got := prerollFor(nil); got != 3 {
+		t.Errorf("preroll %v without probe, want 3", got)
+	}
+}
+
+func TestCacheKeyPreroll(t *testing.T) {
+	defer func() { prerollSeconds, prerollFromGOP = defaultPreroll, false }()
+	r := NewWarmupEncodingRequest("/media/a.mp4", 1, 480)
+	plain := r.getCacheKey()
+
+	prerollSeconds = 8
+	if r.getCacheKey() == plain {
+		t.Error("a longer preroll kept the cache key")
+	}
+	prerollSeconds, prerollFromGOP = defaultPreroll, true
+	if r.getCacheKey() == plain {
+		t.Error("a GOP derived preroll kept the cache key")
+	}
+}
+
+func withSeekStrategy(t *testing.T, strategy string) {
+	saved, savedThreshold := seekStrategy, seekGOPThreshold
+	seekStrategy = strategy
+	t.Cleanup(func() { seekStrategy, seekGOPThreshold = saved, savedThreshold })
+}
+
+func TestChooseSeek(t *testing.T) {
+	withSeekStrategy(t, seekAuto)
+	for _, tt := range []struct {
+		gop  float64
+		want string
+	}{
+		{0, seekOutput},
+		{0.5, seekInput},
+		{2, seekInput},
+		{2.1, seekOutput},
+		{10, seekOutput},
+	} {
+		if got := chooseSeek(tt.gop); got != tt.want {
+			t.Errorf("auto seek for a %vs GOP = %v, want %v", tt.gop, got, tt.want)
+		}
+	}
+	seekGOPThreshold = 5
+	if got := chooseSeek(4); got != seekInput {
+		t.Errorf("auto seek for a 4s GOP below a 5s threshold = %v, want input", got)
+	}
+
+	for _, strategy := range []string{seekPreroll, seekInput, seekOutput} {
+		seekStrategy = strategy
+		if got := chooseSeek(1); got != strategy {
+			t.Errorf("configured %v seek chose %v", strategy, got)
+		}
+	}
+}
+
+func TestSeekFor(t *testing.T) {
+	withSeekStrategy(t, seekAuto)
+	short, long := &videoInfo{KeyframeInterval: 1}, &videoInfo{KeyframeInterval: 7.5}
+	if press, postss := seekFor(30, short); press != 30 || postss != 0 {
+		t.Errorf("short GOP seek %v+%v, want a 30+0 input seek", press, postss)
+	}
+	if press, postss := seekFor(30, long); press != 22 || postss != 8 {
+		t.Errorf("long GOP seek %v+%v, want 22+8 starting a whole GOP early", press, postss)
+	}
+	if press, postss := seekFor(30, nil); press != 30-float64(defaultPreroll) || postss != float64(defaultPreroll) {
+		t.Errorf("unprobed seek %v+%v, want the preroll", press, postss)
+	}
+}
+
+func TestCacheKeySeekStrategy(t *testing.T) {
+	withSeekStrategy(t, seekPreroll)
+	r := NewWarmupEncodingRequest("/media/a.mp4", 1, 480)
+	plain := r.getCacheKey()
+	seekStrategy = seekAuto
+	if r.getCacheKey() == plain {
+		t.Error("auto seeking kept the cache key")
+	}
+}
+
+func TestValidateSeekStrategy(t *testing.T) {
+	for _, strategy := range []string{seekPreroll, seekAuto, seekInput, seekOutput} {
+		if err := validateSeekStrategy(strategy); err != nil {
+			t.Errorf("%v rejected: %v", strategy, err)
+		}
+	}
+	if err := validateSeekStrategy("fast"); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+}
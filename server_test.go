@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServerTimeouts(t *testing.T) {
+	s := newServer(":8080", http.NotFoundHandler())
+	if s.Addr != ":8080" {
+		t.Errorf("Addr %v", s.Addr)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"ReadHeaderTimeout", s.ReadHeaderTimeout, readHeaderTimeout},
+		{"ReadTimeout", s.ReadTimeout, readTimeout},
+		{"WriteTimeout", s.WriteTimeout, writeTimeout},
+		{"IdleTimeout", s.IdleTimeout, idleTimeout},
+	} {
+		if tt.got != tt.want || tt.got <= 0 {
+			t.Errorf("%v is %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestSegmentDeadlineOutlastsWriteTimeout(t *testing.T) {
+	for _, res := range []int64{240, 480, 1080, 2160} {
+		if d := segmentDeadline(res); d < segmentWriteTimeout || d <= writeTimeout {
+			t.Errorf("segment deadline of %vp is %v, shorter than the write timeouts", res, d)
+		}
+	}
+}
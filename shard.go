@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cacheShardDepth is the number of subdirectory levels cache files are
+// spread over, each named by the next two hex digits of the cache key. 0
+// keeps every file directly in the cache directory.
+var cacheShardDepth int
+
+func validateCacheShardDepth(depth int) error {
+	if depth < 0 || depth > 4 {
+		return fmt.Errorf("Cache shard depth %v must be between 0 and 4", depth)
+	}
+	return nil
+}
+
+// shardDir is the directory below the cache directory holding the cache
+// file name. All files of a cache group share a shard, as their names start
+// with the group hash. The label of readable names is skipped so files
+// still spread evenly.
+func shardDir(name string) string {
+	name = cacheHash(name)
+	var dirs []string
+	for i := 0; i < cacheShardDepth && 2*i+2 <= len(name); i++ {
+		dirs = append(dirs, name[2*i:2*i+2])
+	}
+	return filepath.Join(dirs...)
+}
+
+// cacheFilePath is the path of the cache file name.
+func (e *Encoder) cacheFilePath(name string) string {
+	return filepath.Join(e.cacheDirPath(), shardDir(name), name)
+}
+
+// walkCache calls fn for every file below the cache directory, whatever
+// shard depth it was written with.
+func (e *Encoder) walkCache(fn func(path string, info os.FileInfo)) error {
+	err := filepath.Walk(e.cacheDirPath(), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			fn(p, info)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
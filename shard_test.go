@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withCacheShardDepth(t *testing.T, depth int) {
+	saved := cacheShardDepth
+	cacheShardDepth = depth
+	t.Cleanup(func() { cacheShardDepth = saved })
+}
+
+func TestShardDir(t *testing.T) {
+	name := "3f2a9c41d0.480.2"
+	for depth, want := range []string{"", "3f", filepath.Join("3f", "2a"), filepath.Join("3f", "2a", "9c")} {
+		withCacheShardDepth(t, depth)
+		if got := shardDir(name); got != want {
+			t.Errorf("depth %v: shard %q, want %q", depth, got, want)
+		}
+	}
+	withCacheShardDepth(t, 2)
+	if got := shardDir("Some_Movie-3f2a9c41d0.480.2"); got != filepath.Join("3f", "2a") {
+		t.Errorf("readable name sharded into %q, want by its hash", got)
+	}
+}
+
+func TestShardedCacheFile(t *testing.T) {
+	withTestRoot(t)
+	withCacheShardDepth(t, 2)
+	r := *NewEncodingRequest("/media/a.mp4", 3, 480)
+	key := r.getCacheKey()
+	want := filepath.Join(encoder.cacheDirPath(), key[:2], key[2:4], key)
+	if got := encoder.GetCacheFile(r); got != want {
+		t.Errorf("cache file %v, want %v", got, want)
+	}
+	if err := writeCacheFile(encoder.GetCacheFile(r), []byte("segment")); err != nil {
+		t.Fatal(err)
+	}
+	if !encoder.isCached(r) {
+		t.Error("sharded segment not found in the cache")
+	}
+}
+
+func TestShardedCachePurge(t *testing.T) {
+	dir := withTestRoot(t)
+	withCacheShardDepth(t, 2)
+	purged := cacheSegments(t, filepath.Join(dir, "a.mp4"))
+	// Files written before sharding was enabled are still found.
+	withCacheShardDepth(t, 0)
+	flat := cacheSegments(t, filepath.Join(dir, "a.mp4"))
+	withCacheShardDepth(t, 2)
+	kept := cacheSegments(t, filepath.Join(dir, "b.mp4"))
+
+	for _, p := range purged {
+		if !strings.Contains(p, string(filepath.Separator)+filepath.Base(p)[:2]+string(filepath.Separator)) {
+			t.Errorf("%v not in a shard", p)
+		}
+	}
+	if _, err := encoder.purgeSource(filepath.Join(dir, "a.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range append(purged, flat...) {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%v survived the purge", p)
+		}
+	}
+	for _, p := range kept {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("cache of another source purged: %v", err)
+		}
+	}
+}
+
+func TestValidateCacheShardDepth(t *testing.T) {
+	for _, depth := range []int{-1, 5} {
+		if err := validateCacheShardDepth(depth); err == nil {
+			t.Errorf("depth %v accepted", depth)
+		}
+	}
+	if err := validateCacheShardDepth(2); err != nil {
+		t.Error(err)
+	}
+}
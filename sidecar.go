@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sidecarExt is appended to a source path to find its sidecar, which adjusts
+// how that one file is encoded.
+const sidecarExt = ".agentvideo.json"
+
+// sidecar is the content of a sidecar file. InputArgs go before the source
+// input, OutputArgs after the video options. Sidecars are trusted like the
+// media root they live in. DefaultResolution and Resolutions replace
+// defaultResolution and masterResolutions for the file when set, e.g. so a
+// low bitrate source does not advertise 1080p.
+type sidecar struct {
+	InputArgs         []string `json:"input"`
+	OutputArgs        []string `json:"output"`
+	DefaultResolution int64    `json:"defaultResolution"`
+	Resolutions       []int64  `json:"resolutions"`
+
+	hash string
+}
+
+type sidecarCacheEntry struct {
+	modTime time.Time
+	sidecar *sidecar
+}
+
+var sidecarCache = struct {
+	sync.Mutex
+	entries map[string]sidecarCacheEntry
+}{entries: make(map[string]sidecarCacheEntry)}
+
+func parseSidecar(data []byte) (*sidecar, error) {
+	var s sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("Parse sidecar error:%v", err)
+	}
+	for _, args := range [][]string{s.InputArgs, s.OutputArgs} {
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			return nil, fmt.Errorf("Sidecar arguments must start with an option, got %q", args[0])
+		}
+	}
+	for _, res := range append(s.Resolutions, s.DefaultResolution) {
+		if res != 0 && !validResolution(res) {
+			return nil, fmt.Errorf("Invalid sidecar resolution %v", res)
+		}
+	}
+	if s.DefaultResolution != 0 && len(s.Resolutions) > 0 && !resolutionList(s.Resolutions).contains(s.DefaultResolution) {
+		return nil, fmt.Errorf("Sidecar default resolution %v is not one of its resolutions", s.DefaultResolution)
+	}
+	s.hash = fmt.Sprintf("%x", sha1.Sum(data))
+	return &s, nil
+}
+
+// sidecarFor returns the sidecar of file, or nil if it has none or it is
+// invalid. Sidecars are read again only when they change.
+func sidecarFor(file string) *sidecar {
+	if isRemoteSource(file) {
+		return nil
+	}
+	p := file + sidecarExt
+	stat, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+
+	sidecarCache.Lock()
+	entry, ok := sidecarCache.entries[p]
+	sidecarCache.Unlock()
+	if ok && entry.modTime.Equal(stat.ModTime()) {
+		return entry.sidecar
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	s, err := parseSidecar(data)
+	if err != nil {
+		log.Errorf("Ignoring sidecar %v: %v", p, err)
+	}
+
+	sidecarCache.Lock()
+	sidecarCache.entries[p] = sidecarCacheEntry{stat.ModTime(), s}
+	sidecarCache.Unlock()
+	return s
+}
+
+// sourceResolutions are the variants the master playlist of file offers.
+func sourceResolutions(file string) resolutionList {
+	if sc := sidecarFor(file); sc != nil && len(sc.Resolutions) > 0 {
+		return resolutionList(sc.Resolutions)
+	}
+	return masterResolutions
+}
+
+// sourceDefaultResolution is the resolution of file's streams requested
+// without one. A sidecar listing resolutions but no default falls back to
+// the highest of them not above defaultResolution, or its lowest.
+func sourceDefaultResolution(file string) int64 {
+	sc := sidecarFor(file)
+	if sc == nil {
+		return defaultResolution
+	}
+	if sc.DefaultResolution != 0 {
+		return sc.DefaultResolution
+	}
+	if len(sc.Resolutions) == 0 || resolutionList(sc.Resolutions).contains(defaultResolution) {
+		return defaultResolution
+	}
+	var best, lowest int64
+	for _, res := range sc.Resolutions {
+		if res <= defaultResolution && res > best {
+			best = res
+		}
+		if lowest == 0 || res < lowest {
+			lowest = res
+		}
+	}
+	if best == 0 {
+		return lowest
+	}
+	return best
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSidecar(t *testing.T) {
+	s, err := parseSidecar([]byte(`{"input": ["-fflags", "+genpts"], "output": ["-bsf:v", "h264_mp4toannexb"], "resolutions": [360, 720]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.InputArgs) != 2 || len(s.OutputArgs) != 2 || len(s.Resolutions) != 2 || s.hash == "" {
+		t.Errorf("parsed %+v", s)
+	}
+
+	for _, data := range []string{
+		`not json`,
+		`{"input": ["+genpts"]}`,
+		`{"output": ["h264_mp4toannexb", "-bsf:v"]}`,
+		`{"resolutions": [100]}`,
+		`{"defaultResolution": 1080, "resolutions": [360, 720]}`,
+	} {
+		if _, err := parseSidecar([]byte(data)); err == nil {
+			t.Errorf("%s was accepted", data)
+		}
+	}
+}
+
+func writeSidecar(t *testing.T, file, data string, modTime time.Time) {
+	p := file + sidecarExt
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSidecarArgs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.mp4")
+	r := NewWarmupEncodingRequest(file, 2, 480)
+	plain, plainKey := EncodingArgs(*r, nil), r.getCacheKey()
+
+	writeSidecar(t, file, `{"input": ["-fflags", "+genpts"], "output": ["-bsf:v", "h264_mp4toannexb"]}`, time.Unix(1000, 0))
+	args := EncodingArgs(*r, nil)
+	if !containsArgs(args, "-fflags", "+genpts", "-i", file) {
+		t.Errorf("input options not before the source: %v", args)
+	}
+	if i, j := argIndex(args, "-bsf:v"), argIndex(args, "-vf"); i < 0 || i < j {
+		t.Errorf("output options not after the video options: %v", args)
+	}
+	if len(args) != len(plain)+4 {
+		t.Errorf("sidecar added %v arguments, want 4", len(args)-len(plain))
+	}
+	key := r.getCacheKey()
+	if key == plainKey {
+		t.Error("sidecar does not change the cache key")
+	}
+
+	writeSidecar(t, file, `{"input": ["-fflags", "+igndts"]}`, time.Unix(2000, 0))
+	if args := EncodingArgs(*r, nil); !containsArgs(args, "-fflags", "+igndts", "-i", file) {
+		t.Errorf("changed sidecar not read again: %v", args)
+	}
+	if r.getCacheKey() == key {
+		t.Error("changed sidecar keeps the cache key")
+	}
+
+	writeSidecar(t, file, `{"input": ["+igndts"]}`, time.Unix(3000, 0))
+	if args := EncodingArgs(*r, nil); len(args) != len(plain) || r.getCacheKey() != plainKey {
+		t.Errorf("invalid sidecar applied: %v", args)
+	}
+}
+
+func TestSourceDefaultResolution(t *testing.T) {
+	dir := t.TempDir()
+	for i, test := range []struct {
+		sidecar string
+		want    int64
+	}{
+		{"", defaultResolution},
+		{`{"defaultResolution": 360, "resolutions": [360, 720]}`, 360},
+		{`{"resolutions": [240, 360, 2160]}`, 360},
+		{`{"resolutions": [2160, 1440]}`, 1440},
+		{`{"resolutions": [480, 720]}`, defaultResolution},
+	} {
+		file := filepath.Join(dir, fmt.Sprintf("%v.mp4", i))
+		if test.sidecar != "" {
+			writeSidecar(t, file, test.sidecar, time.Unix(1000, 0))
+		}
+		if got := sourceDefaultResolution(file); got != test.want {
+			t.Errorf("%v: default %v, want %v", test.sidecar, got, test.want)
+		}
+	}
+}
+
+func TestSidecarVariantSelection(t *testing.T) {
+	dir := t.TempDir()
+	plain, low := filepath.Join(dir, "plain.mp4"), filepath.Join(dir, "low.mp4")
+	writeSidecar(t, low, `{"resolutions": [240, 360]}`, time.Unix(1000, 0))
+	saved := masterResolutions
+	masterResolutions = resolutionList{480, 720, 1080}
+	t.Cleanup(func() { masterResolutions = saved })
+
+	if got := sourceResolutions(plain); !reflect.DeepEqual(got, masterResolutions) {
+		t.Errorf("variants without a sidecar %v", got)
+	}
+	if got := sourceResolutions(low); !reflect.DeepEqual(got, resolutionList{240, 360}) {
+		t.Errorf("sidecar variants %v", got)
+	}
+
+	var b bytes.Buffer
+	writeMasterPlaylist(&b, "http://h/api/playlist/low.mp4", "http://h/api/iframes/low.mp4", sourceResolutions(low), nil)
+	out := b.String()
+	if strings.Count(out, "#EXT-X-STREAM-INF:") != 2 || !strings.Contains(out, "?res=240\n") || !strings.Contains(out, "?res=360\n") || strings.Contains(out, "?res=1080") {
+		t.Errorf("master playlist does not offer the sidecar variants:\n%v", out)
+	}
+
+	// Streams requested without a resolution use the sidecar default.
+	r := NewEncodingRequest(low, 0, defaultResolution)
+	if err := parseStreamOptions(url.Values{}, r); err != nil || r.res != 360 {
+		t.Errorf("default stream at %v, %v, want 360", r.res, err)
+	}
+	if err := parseStreamOptions(url.Values{"res": {"240"}}, r); err != nil || r.res != 240 {
+		t.Errorf("requested stream at %v, %v, want 240", r.res, err)
+	}
+	stream := NewWarmupEncodingRequest(low, 0, defaultResolution)
+	if values, err := parseStreamQuery(url.Values{}, stream); err != nil || stream.res != 360 || values.Get("res") != "" {
+		t.Errorf("playlist stream at %v with %v, %v", stream.res, values, err)
+	}
+}
+
+func argIndex(args []string, arg string) int {
+	for i, a := range args {
+		if a == arg {
+			return i
+		}
+	}
+	return -1
+}
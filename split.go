@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// splitEncode is the number of pieces a whole segment is encoded in by
+// concurrent ffmpeg processes, 1 to encode segments in one piece. Splitting
+// cuts cold start latency on hosts with idle cores.
+var splitEncode int64 = 1
+
+type executor func(cmdPath string, args []string) ([]byte, error)
+
+func validateSplitEncode(pieces int64) error {
+	if pieces <= 0 || int64(hlsSegmentLength)%pieces != 0 {
+		return fmt.Errorf("Split encode count %v must evenly divide the segment length %v", pieces, hlsSegmentLength)
+	}
+	return nil
+}
+
+// encodeSplit encodes r in splitEncode pieces at once and joins them. Every
+// piece starts on a forced keyframe with timestamps offset to its position
+// in the segment, so the pieces concatenate into one continuous segment.
+func encodeSplit(r EncodingRequest, info *videoInfo, run executor) ([]byte, error) {
+	pieces := make([][]byte, splitEncode)
+	errs := make([]error, splitEncode)
+	var wg sync.WaitGroup
+	for i := range pieces {
+		piece := r
+		piece.part, piece.pieces = int64(i), splitEncode
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pieces[i], errs[i] = run(FFMPEGPath, EncodingArgs(piece, info))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Encoding piece %v of %v failed:%w", i+1, splitEncode, err)
+		}
+	}
+	return joinPieces(pieces)
+}
+
+// joinPieces concatenates transport stream pieces. Each must be a whole
+// number of packets, or the join would corrupt the packets after it.
+func joinPieces(pieces [][]byte) ([]byte, error) {
+	for i, p := range pieces {
+		if len(p) == 0 || len(p)%tsPacketSize != 0 || p[0] != 0x47 {
+			return nil, fmt.Errorf("Piece %v of %v is not a transport stream (%v bytes)", i+1, len(pieces), len(p))
+		}
+	}
+	return bytes.Join(pieces, nil), nil
+}
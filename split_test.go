@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// argsPacket is a TS packet carrying the start of args, so tests can tell
+// which encode produced it.
+func argsPacket(args []string) []byte {
+	return tsPacket(testVideoPID, false, false, []byte(strings.Join(args, " ")))
+}
+
+func withSplitEncode(t *testing.T, pieces int64) {
+	old := splitEncode
+	splitEncode = pieces
+	t.Cleanup(func() { splitEncode = old })
+}
+
+func TestEncodeSplitJoinsPiecesInOrder(t *testing.T) {
+	withSplitEncode(t, 2)
+	r := *NewWarmupEncodingRequest("/media/a.mp4", 1, 480)
+	data, err := encodeSplit(r, nil, func(_ string, args []string) ([]byte, error) {
+		return argsPacket(args), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []byte
+	for i := int64(0); i < 2; i++ {
+		piece := r
+		piece.part, piece.pieces = i, 2
+		start, length := piece.span()
+		if start != 10+float64(i)*5 || length != 5 {
+			t.Errorf("piece %v spans %v+%v, want %v+5", i, start, length, 10+i*5)
+		}
+		want = append(want, argsPacket(EncodingArgs(piece, nil))...)
+	}
+	if !bytes.Equal(data, want) {
+		t.Error("pieces are not joined in segment order")
+	}
+}
+
+func TestEncodeSplitFailure(t *testing.T) {
+	withSplitEncode(t, 2)
+	r := *NewWarmupEncodingRequest("/media/a.mp4", 0, 480)
+	second := r
+	second.part, second.pieces = 1, 2
+	failing := strings.Join(EncodingArgs(second, nil), " ")
+	_, err := encodeSplit(r, nil, func(_ string, args []string) ([]byte, error) {
+		if strings.Join(args, " ") == failing {
+			return nil, errors.New("boom")
+		}
+		return argsPacket(args), nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "piece 2 of 2") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error %v, want the second piece's", err)
+	}
+}
+
+func TestJoinPiecesRejectsPartialPackets(t *testing.T) {
+	good := tsPacket(testVideoPID, false, false, nil)
+	for _, pieces := range [][][]byte{
+		{good, nil},
+		{good, good[:100]},
+		{good, make([]byte, tsPacketSize)},
+	} {
+		if _, err := joinPieces(pieces); err == nil {
+			t.Errorf("joined invalid pieces %v bytes", len(pieces[1]))
+		}
+	}
+	if data, err := joinPieces([][]byte{good, good}); err != nil || len(data) != 2*tsPacketSize {
+		t.Errorf("join of valid pieces: %v bytes, %v", len(data), err)
+	}
+}
+
+func TestValidateSplitEncode(t *testing.T) {
+	for pieces, valid := range map[int64]bool{1: true, 2: true, 5: true, 3: false, 0: false, -2: false} {
+		if err := validateSplitEncode(pieces); (err == nil) != valid {
+			t.Errorf("validateSplitEncode(%v) = %v", pieces, err)
+		}
+	}
+}
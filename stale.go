@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// staleGrace is how long the cache files of a changed source keep being
+// served while their segments are encoded again, 0 to drop them right away.
+var staleGrace time.Duration
+
+// staleExt marks a cache file kept for staleGrace after its source changed.
+const staleExt = ".stale"
+
+// regenerating holds the cache paths of stale segments being encoded again.
+var regenerating sync.Map
+
+// retireFile moves the cache file p aside as stale, starting its grace
+// period now.
+func retireFile(p string) error {
+	stale := p + staleExt
+	if err := os.Rename(p, stale); err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(stale, now, now)
+}
+
+// loadStale returns the stale data of r if it is still within staleGrace.
+// Expired stale files are removed.
+func (e *Encoder) loadStale(r EncodingRequest) []byte {
+	if staleGrace <= 0 {
+		return nil
+	}
+	p := e.GetCacheFile(r) + staleExt
+	stat, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+	if time.Since(stat.ModTime()) > staleGrace {
+		os.Remove(p)
+		return nil
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// hasStale reports whether r can be served from a stale cache file.
+func (e *Encoder) hasStale(r EncodingRequest) bool {
+	if staleGrace <= 0 {
+		return false
+	}
+	stat, err := os.Stat(e.GetCacheFile(r) + staleExt)
+	return err == nil && time.Since(stat.ModTime()) <= staleGrace
+}
+
+// regenerate encodes r again in the background, once however often its
+// stale data is served meanwhile. The stale file is removed once the new one
+// is cached.
+func (e *Encoder) regenerate(r EncodingRequest) {
+	p := e.GetCacheFile(r)
+	if _, busy := regenerating.LoadOrStore(p, true); busy {
+		return
+	}
+	r.data = make(chan *[]byte, 1)
+	r.err = make(chan error, 1)
+	if !e.enqueue(r) {
+		regenerating.Delete(p)
+		return
+	}
+	go func() {
+		defer regenerating.Delete(p)
+		select {
+		case <-r.data:
+			os.Remove(p + staleExt)
+		case err := <-r.err:
+			log.Errorf("Could not regenerate stale %v:%v: %v", r.file, r.segment, err)
+		}
+	}()
+}
+
+// removeStale deletes the stale cache files past staleGrace and returns how
+// many it removed.
+func (e *Encoder) removeStale() (int, error) {
+	removed := 0
+	err := e.walkCache(func(p string, info os.FileInfo) {
+		if strings.HasSuffix(p, staleExt) && time.Since(info.ModTime()) > staleGrace {
+			if os.Remove(p) == nil {
+				removed++
+			}
+		}
+	})
+	return removed, err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func withStaleGrace(t *testing.T, grace time.Duration) {
+	saved := staleGrace
+	staleGrace = grace
+	t.Cleanup(func() { staleGrace = saved })
+}
+
+// writeStale leaves data as the stale cache file of r, retired at modTime.
+func writeStale(t *testing.T, e *Encoder, r EncodingRequest, data string, modTime time.Time) string {
+	p := e.GetCacheFile(r) + staleExt
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestServeStaleWhileRegenerating(t *testing.T) {
+	dir := withTestRoot(t)
+	withStaleGrace(t, time.Hour)
+	e := encoder
+	e.reqChan = make(chan EncodingRequest, 4)
+	file := filepath.Join(dir, "a.mp4")
+	stale := writeStale(t, e, *NewEncodingRequest(file, 3, 480), "old", time.Now())
+
+	r := NewEncodingRequest(file, 3, 480)
+	e.Encode(*r)
+	select {
+	case data := <-r.data:
+		if string(*data) != "old" {
+			t.Fatalf("served %q, want the stale bytes", *data)
+		}
+	case err := <-r.err:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("stale segment not served")
+	}
+	var regen EncodingRequest
+	select {
+	case regen = <-e.reqChan:
+	case <-time.After(time.Second):
+		t.Fatal("stale segment not encoded again")
+	}
+	if regen.segment != 3 || !e.hasStale(regen) {
+		t.Fatalf("regenerating segment %v, stale %v", regen.segment, e.hasStale(regen))
+	}
+	// Serving the stale bytes again does not queue another encode.
+	if stale := e.loadStale(*r); string(stale) != "old" {
+		t.Errorf("stale bytes %q while regenerating", stale)
+	}
+	e.regenerate(*r)
+	select {
+	case r := <-e.reqChan:
+		t.Fatalf("segment %v queued twice while regenerating", r.segment)
+	default:
+	}
+
+	data := []byte("new")
+	e.cacheSegment(regen, data)
+	e.deliverData(regen, &data)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(stale); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale file kept after the new one was cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r = NewEncodingRequest(file, 3, 480)
+	e.Encode(*r)
+	if data := <-r.data; string(*data) != "new" {
+		t.Errorf("served %q after regenerating, want the new bytes", *data)
+	}
+}
+
+func TestExpiredStaleNotServed(t *testing.T) {
+	dir := withTestRoot(t)
+	withStaleGrace(t, time.Minute)
+	r := *NewEncodingRequest(filepath.Join(dir, "a.mp4"), 0, 480)
+	p := writeStale(t, encoder, r, "old", time.Now().Add(-time.Hour))
+	if encoder.hasStale(r) || encoder.loadStale(r) != nil {
+		t.Error("stale file served past its grace")
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Error("expired stale file not removed")
+	}
+
+	staleGrace = 0
+	writeStale(t, encoder, r, "old", time.Now())
+	if encoder.hasStale(r) || encoder.loadStale(r) != nil {
+		t.Error("stale file served without a grace")
+	}
+}
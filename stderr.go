@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const stderrTailLines = 10
+
+// ffmpegLogLevel is passed to ffmpeg as -loglevel; its stderr output is
+// kept for diagnostics.
+var ffmpegLogLevel = "warning"
+
+const (
+	stderrLog  = "log"
+	stderrFail = "fail"
+)
+
+// stderrErrors is what happens to stderr lines classified as errors of an
+// ffmpeg run that exited successfully: log logs them as warnings, fail
+// fails the run. Lines of failed runs are always part of the error.
+var stderrErrors = stderrLog
+
+func validateStderrErrors(policy string) error {
+	if policy != stderrLog && policy != stderrFail {
+		return fmt.Errorf("Invalid stderr error policy %v, expected log or fail", policy)
+	}
+	return nil
+}
+
+// benignStderr are substrings of ffmpeg warnings that are expected while
+// segmenting and do not affect the output.
+var benignStderr = []string{
+	"deprecated pixel format used",
+	"Past duration",
+	"non monotonically increasing dts",
+	"Non-monotonous DTS",
+	"Guessed Channel Layout",
+	"too many packets buffered",
+	"co located POCs unavailable",
+	"max_analyze_duration",
+	"Starting new cluster",
+	"Timestamps are unset",
+	"Last message repeated",
+}
+
+// errorStderr are substrings of ffmpeg messages reporting damaged input or
+// output.
+var errorStderr = []string{
+	"Invalid data found",
+	"Error while decoding",
+	"error while decoding",
+	"Error while processing",
+	"Conversion failed",
+	"corrupt",
+	"Could not",
+	"Unknown encoder",
+	"Unknown decoder",
+	"not supported",
+	"Error ",
+	"error ",
+	"missing picture",
+	"concealing",
+}
+
+// isStderrError reports whether an ffmpeg stderr line reports an error
+// rather than a benign warning. Known warnings take precedence, and lines
+// matching neither list count as warnings.
+func isStderrError(line string) bool {
+	for _, p := range benignStderr {
+		if strings.Contains(line, p) {
+			return false
+		}
+	}
+	for _, p := range errorStderr {
+		if strings.Contains(line, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// tailWriter keeps the last max complete lines written to it, and
+// separately the last max of them that are errors.
+type tailWriter struct {
+	max int
+
+	mu      sync.Mutex
+	lines   []string
+	errors  []string
+	partial bytes.Buffer
+}
+
+func newTailWriter(max int) *tailWriter {
+	return &tailWriter{max: max}
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial.Write(p)
+	for {
+		line, err := t.partial.ReadString('\n')
+		if err != nil {
+			// Keep the unterminated rest for the next write.
+			t.partial.Reset()
+			t.partial.WriteString(line)
+			break
+		}
+		t.add(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) add(line string) {
+	if line == "" {
+		return
+	}
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	if isStderrError(line) {
+		t.errors = append(t.errors, line)
+		if len(t.errors) > t.max {
+			t.errors = t.errors[len(t.errors)-t.max:]
+		}
+	}
+}
+
+// Errors returns the kept lines classified as errors.
+func (t *tailWriter) Errors() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return strings.Join(t.errors, "\n")
+}
+
+// String returns the kept lines, including an unterminated last line.
+func (t *tailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.lines
+	if rest := strings.TrimSpace(t.partial.String()); rest != "" {
+		lines = append(lines[:len(lines):len(lines)], rest)
+	}
+	return strings.Join(lines, "\n")
+}
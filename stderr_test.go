@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTailWriterKeepsLastLines(t *testing.T) {
+	w := newTailWriter(3)
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(w, "line %v\n", i)
+	}
+	if got, want := w.String(), "line 3\nline 4\nline 5"; got != want {
+		t.Errorf("tail %q, want %q", got, want)
+	}
+}
+
+func TestTailWriterPartialLines(t *testing.T) {
+	w := newTailWriter(3)
+	w.Write([]byte("first ha"))
+	w.Write([]byte("lf\nsecond\r\n\nunterminated"))
+	if got, want := w.String(), "first half\nsecond\nunterminated"; got != want {
+		t.Errorf("tail %q, want %q", got, want)
+	}
+}
+
+func TestExecuteErrorIncludesStderr(t *testing.T) {
+	_, err := execute("/bin/sh", []string{"-c", "echo 'a.mp4: Invalid data found when processing input' >&2; exit 1"})
+	if err == nil {
+		t.Fatal("failing command succeeded")
+	}
+	if !strings.Contains(err.Error(), "Invalid data found when processing input") {
+		t.Errorf("error %q lacks the stderr output", err)
+	}
+}
+
+func TestEncodingArgsLogLevel(t *testing.T) {
+	old := ffmpegLogLevel
+	ffmpegLogLevel = "error"
+	t.Cleanup(func() { ffmpegLogLevel = old })
+
+	args := EncodingArgs(*NewWarmupEncodingRequest("/media/a.mp4", 0, 480), nil)
+	if !containsArgs(args, "-loglevel", "error") {
+		t.Errorf("args %v lack -loglevel error", args)
+	}
+}
+
+func TestIsStderrError(t *testing.T) {
+	for line, want := range map[string]bool{
+		"[swscaler @ 0x55d] deprecated pixel format used, make sure you did set range correctly": false,
+		"[mpegts @ 0x7f] Non-monotonous DTS in output stream 0:1; previous: 900, current: 800":   false,
+		"Past duration 0.999992 too large":                                     false,
+		"[aac @ 0x55] Guessed Channel Layout for Input Stream #0.1 : stereo":   false,
+		"    Last message repeated 3 times":                                    false,
+		"[h264 @ 0x55] error while decoding MB 51 25, bytestream -7":           true,
+		"[h264 @ 0x55] concealing 1020 DC, 1020 AC, 1020 MV errors in P frame": true,
+		"a.mp4: Invalid data found when processing input":                      true,
+		"[h264 @ 0x55] missing picture in access unit with size 34":            true,
+		"Unknown encoder 'libfdk_aac'":                                         true,
+		"Conversion failed!":                                                   true,
+		"[mov,mp4 @ 0x55] stream 1, offset 0x7d2: partial file":                false,
+		"Stream #0:0: Video: h264 (High), yuv420p, 1920x1080":                  false,
+	} {
+		if got := isStderrError(line); got != want {
+			t.Errorf("%q classified as error: %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestTailWriterKeepsErrors(t *testing.T) {
+	w := newTailWriter(2)
+	fmt.Fprintln(w, "[h264 @ 0x55] error while decoding MB 1 1")
+	for i := 0; i < 3; i++ {
+		fmt.Fprintln(w, "Past duration 0.999992 too large")
+	}
+	if got := w.Errors(); got != "[h264 @ 0x55] error while decoding MB 1 1" {
+		t.Errorf("errors %q, want the error pushed out of the tail", got)
+	}
+	for i := 2; i <= 4; i++ {
+		fmt.Fprintf(w, "Conversion failed %v\n", i)
+	}
+	if got, want := w.Errors(), "Conversion failed 3\nConversion failed 4"; got != want {
+		t.Errorf("errors %q, want %q", got, want)
+	}
+}
+
+func withStderrErrors(t *testing.T, policy string) {
+	old := stderrErrors
+	stderrErrors = policy
+	t.Cleanup(func() { stderrErrors = old })
+}
+
+func TestExecuteStderrErrorPolicy(t *testing.T) {
+	script := []string{"-c", "echo 'Past duration 0.99 too large' >&2; echo '[h264 @ 0x1] error while decoding MB 1 1' >&2; printf ts"}
+
+	withStderrErrors(t, stderrLog)
+	if data, err := execute("/bin/sh", script); err != nil || string(data) != "ts" {
+		t.Errorf("logged errors failed the run: %q, %v", data, err)
+	}
+	if data, err := execute("/bin/sh", []string{"-c", "echo 'Past duration 0.99 too large' >&2; printf ts"}); err != nil || string(data) != "ts" {
+		t.Errorf("warnings failed the run: %q, %v", data, err)
+	}
+
+	stderrErrors = stderrFail
+	if _, err := execute("/bin/sh", script); err == nil || !strings.Contains(err.Error(), "error while decoding") || strings.Contains(err.Error(), "Past duration") {
+		t.Errorf("run with errors: %v", err)
+	}
+	if data, err := execute("/bin/sh", []string{"-c", "echo 'Past duration 0.99 too large' >&2; printf ts"}); err != nil || string(data) != "ts" {
+		t.Errorf("warnings failed the run under the fail policy: %q, %v", data, err)
+	}
+}
+
+func TestValidateStderrErrors(t *testing.T) {
+	for policy, ok := range map[string]bool{stderrLog: true, stderrFail: true, "": false, "ignore": false} {
+		if err := validateStderrErrors(policy); (err == nil) != ok {
+			t.Errorf("validateStderrErrors(%q) = %v", policy, err)
+		}
+	}
+}
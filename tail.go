@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// minSegmentDuration is the shortest last segment in seconds that is served
+// on its own. A shorter one is merged into the segment before it, which is
+// then encoded that much longer. 0 disables merging.
+var minSegmentDuration float64
+
+func validateMinSegmentDuration(d float64) error {
+	if d < 0 || d >= hlsSegmentLength {
+		return fmt.Errorf("Minimum segment duration %v must be at least 0 and less than the segment length %v", d, hlsSegmentLength)
+	}
+	return nil
+}
+
+// mergeShortTail folds a last segment of file shorter than
+// minSegmentDuration into the one before it. The last segment of a growing
+// file is left alone, it is not listed until complete.
+func mergeShortTail(file string, durations []float64) []float64 {
+	n := len(durations)
+	if n < 2 || durations[n-1] >= minSegmentDuration || growth.isGrowing(file) {
+		return durations
+	}
+	merged := append([]float64(nil), durations[:n-1]...)
+	merged[n-2] += durations[n-1]
+	return merged
+}
+
+// servedDuration is the duration of file as its playlist presents it.
+func servedDuration(file string) (float64, error) {
+	duration, err := sourceDuration(file)
+	if err != nil {
+		return 0, err
+	}
+	if maxDuration > 0 && truncateLongSources && duration > maxDuration {
+		duration = maxDuration
+	}
+	return duration, nil
+}
+
+// extendToShortTail lengthens the segment spanning start to start+length up
+// to the end of file if only a tail shorter than minSegmentDuration follows
+// it, matching the merge done by mergeShortTail.
+func extendToShortTail(file string, start float64, length float64) float64 {
+	if minSegmentDuration <= 0 || growth.isGrowing(file) {
+		return length
+	}
+	duration, err := servedDuration(file)
+	if err != nil {
+		return length
+	}
+	if tail := duration - start - length; tail > 0 && tail < minSegmentDuration {
+		return duration - start
+	}
+	return length
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func withMinSegmentDuration(t *testing.T, d float64) {
+	saved := minSegmentDuration
+	minSegmentDuration = d
+	t.Cleanup(func() { minSegmentDuration = saved })
+}
+
+// indexDuration makes sourceDuration return duration for a new file in dir
+// without probing it.
+func indexDuration(t *testing.T, dir string, duration float64) string {
+	file := filepath.Join(dir, "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libraryIndex.mu.Lock()
+	saved := libraryIndex.entries
+	libraryIndex.entries = map[string]indexEntry{file: {stat.ModTime(), duration}}
+	libraryIndex.mu.Unlock()
+	t.Cleanup(func() {
+		libraryIndex.mu.Lock()
+		libraryIndex.entries = saved
+		libraryIndex.mu.Unlock()
+	})
+	return file
+}
+
+func TestMergeShortTail(t *testing.T) {
+	withMinSegmentDuration(t, 2)
+	for _, tt := range []struct {
+		durations []float64
+		want      []float64
+	}{
+		{[]float64{10, 10, 0.3}, []float64{10, 10.3}},
+		{[]float64{10, 10, 2}, []float64{10, 10, 2}},
+		{[]float64{10, 10, 5}, []float64{10, 10, 5}},
+		{[]float64{1.5}, []float64{1.5}},
+		{nil, nil},
+	} {
+		if got := mergeShortTail("/media/a.mp4", tt.durations); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("merged %v into %v, want %v", tt.durations, got, tt.want)
+		}
+	}
+
+	minSegmentDuration = 0
+	if got := mergeShortTail("/media/a.mp4", []float64{10, 0.3}); len(got) != 2 {
+		t.Errorf("merged %v without a minimum", got)
+	}
+}
+
+func TestShortTailPlaylistAndEncode(t *testing.T) {
+	withMinSegmentDuration(t, 1)
+	file := indexDuration(t, t.TempDir(), 20.3)
+
+	durations := segmentDurations(file, 20.3)
+	if len(durations) != 2 {
+		t.Fatalf("durations %v, want the 0.3s tail merged", durations)
+	}
+	var b bytes.Buffer
+	writePlaylist(&b, "http://h/api/hls/segments/a.mp4", "", "", 0, durations, nil, nil, "VOD", true)
+	if !strings.Contains(b.String(), "#EXTINF:10.300000,") || strings.Count(b.String(), "#EXTINF:") != 2 {
+		t.Errorf("playlist does not list the merged segment:\n%v", b.String())
+	}
+
+	if start, length := segmentSpan(file, 1); start != 10 || length < 10.29 || length > 10.31 {
+		t.Errorf("merged segment spans %v+%v, want 10+10.3", start, length)
+	}
+	if start, length := segmentSpan(file, 0); start != 0 || length != 10 {
+		t.Errorf("first segment spans %v+%v, want 0+10", start, length)
+	}
+}
+
+func TestValidateMinSegmentDuration(t *testing.T) {
+	for _, d := range []float64{0, 1, hlsSegmentLength - 1} {
+		if err := validateMinSegmentDuration(d); err != nil {
+			t.Errorf("%v rejected: %v", d, err)
+		}
+	}
+	for _, d := range []float64{-1, hlsSegmentLength} {
+		if err := validateMinSegmentDuration(d); err == nil {
+			t.Errorf("%v accepted", d)
+		}
+	}
+}
+
+func TestShortTailProbesDurationOnce(t *testing.T) {
+	withMinSegmentDuration(t, 1)
+	dir := t.TempDir()
+	probes := concatSources(t, dir, map[string]float64{"a.mp4": 20.3})
+	file := filepath.Join(dir, "a.mp4")
+	for segment := int64(0); segment < 3; segment++ {
+		segmentSpan(file, segment)
+	}
+	if _, length := segmentSpan(file, 1); length < 10.29 || length > 10.31 {
+		t.Errorf("merged segment lasts %v, want 10.3", length)
+	}
+	if *probes != 1 {
+		t.Errorf("probed the duration %v times for three segments, want once", *probes)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// killGracePeriod is how long a process asked to stop with SIGTERM may take
+// to flush its output before it is sent SIGKILL.
+var killGracePeriod = 5 * time.Second
+
+// terminate stops process, escalating from SIGTERM to SIGKILL once
+// killGracePeriod has passed, and reaps it.
+func terminate(process *os.Process) {
+	exited := make(chan struct{})
+	go func() {
+		process.Wait()
+		close(exited)
+	}()
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		process.Signal(syscall.SIGKILL)
+	}
+	select {
+	case <-exited:
+		return
+	case <-time.After(killGracePeriod):
+	}
+	log.Warnf("Process %v ignored SIGTERM for %v, killing it", process.Pid, killGracePeriod)
+	process.Signal(syscall.SIGKILL)
+	<-exited
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// startShell starts script and waits until it printed its first line.
+func startShell(t *testing.T, script string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", script)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func withKillGracePeriod(t *testing.T, d time.Duration) {
+	saved := killGracePeriod
+	killGracePeriod = d
+	t.Cleanup(func() { killGracePeriod = saved })
+}
+
+func TestTerminateStopsCooperatingProcess(t *testing.T) {
+	withKillGracePeriod(t, 10*time.Second)
+	cmd := startShell(t, "echo ready; exec sleep 30")
+	start := time.Now()
+	terminate(cmd.Process)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("process stopped after %v, want right after SIGTERM", elapsed)
+	}
+}
+
+func TestTerminateKillsProcessIgnoringSIGTERM(t *testing.T) {
+	withKillGracePeriod(t, 200*time.Millisecond)
+	// Ignored signals stay ignored across exec.
+	cmd := startShell(t, "trap '' TERM; echo ready; exec sleep 30")
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		terminate(cmd.Process)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("process ignoring SIGTERM was never killed")
+	}
+	if elapsed := time.Since(start); elapsed < killGracePeriod {
+		t.Errorf("process killed after %v, before the %v grace period", elapsed, killGracePeriod)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// encodeThreads is the ffmpeg thread count of an encode, 0 to derive it
+// from the CPU count. It does not change the output, so it is not part of
+// cache keys.
+var encodeThreads int
+
+// maxEncodeThreads is the most threads libx264 will use.
+const maxEncodeThreads = 128
+
+func validateEncodeThreads(threads int) error {
+	if threads < 0 || threads > maxEncodeThreads {
+		return fmt.Errorf("Thread count %v must be between 0 and %v", threads, maxEncodeThreads)
+	}
+	return nil
+}
+
+// threadsFor returns the thread count of each of concurrent encodes running
+// at once, sharing cpus cores between them.
+func threadsFor(cpus int, concurrent int64) int {
+	if encodeThreads > 0 {
+		return encodeThreads
+	}
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	if threads := cpus / int(concurrent); threads > 1 {
+		return threads
+	}
+	return 1
+}
+
+// threadArgs limits an encode to its share of the cores. The encoder runs
+// encodeWorkers segments at a time, each in splitEncode concurrent pieces.
+func threadArgs() []string {
+	return []string{"-threads", strconv.Itoa(threadsFor(runtime.NumCPU(), int64(encodeWorkers)*splitEncode))}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestThreadsFor(t *testing.T) {
+	for _, test := range []struct {
+		cpus       int
+		concurrent int64
+		want       int
+	}{
+		{8, 1, 8},
+		{8, 2, 4},
+		{8, 3, 2},
+		{8, 8, 1},
+		{4, 16, 1},
+		{8, 0, 8},
+	} {
+		if got := threadsFor(test.cpus, test.concurrent); got != test.want {
+			t.Errorf("threadsFor(%v, %v) = %v, want %v", test.cpus, test.concurrent, got, test.want)
+		}
+	}
+}
+
+func TestThreadsConfigured(t *testing.T) {
+	saved := encodeThreads
+	encodeThreads = 3
+	t.Cleanup(func() { encodeThreads = saved })
+	if got := threadsFor(64, 2); got != 3 {
+		t.Errorf("configured threads %v, want 3", got)
+	}
+	if args := threadArgs(); !containsArgs(args, "-threads", "3") {
+		t.Errorf("args %v", args)
+	}
+}
+
+func TestThreadsNotInCacheKey(t *testing.T) {
+	r := NewEncodingRequest("/media/a.mp4", 0, 480)
+	key := r.getCacheKey()
+	saved := encodeThreads
+	encodeThreads = runtime.NumCPU() + 1
+	t.Cleanup(func() { encodeThreads = saved })
+	if !containsArgs(EncodingArgs(*r, nil), "-threads", strconv.Itoa(encodeThreads)) {
+		t.Error("thread count not passed to ffmpeg")
+	}
+	if r.getCacheKey() != key {
+		t.Error("thread count changes the cache key")
+	}
+}
+
+func TestValidateEncodeThreads(t *testing.T) {
+	for _, threads := range []int{0, 1, maxEncodeThreads} {
+		if err := validateEncodeThreads(threads); err != nil {
+			t.Errorf("%v threads rejected: %v", threads, err)
+		}
+	}
+	for _, threads := range []int{-1, maxEncodeThreads + 1} {
+		if err := validateEncodeThreads(threads); err == nil {
+			t.Errorf("%v threads accepted", threads)
+		}
+	}
+}
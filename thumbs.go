@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	thumbsDirName   = "thumbs"
+	thumbHeight     = 120
+	thumbInterval   = 10 // Seconds between thumbnails of the seek preview track
+	thumbsURLFormat = "http://%v/api/pic/%v?t=%v"
+)
+
+// thumbnailArgs extracts the frame of file at t seconds, with the overlays
+// its segments are encoded with.
+func thumbnailArgs(file string, t int64) []string {
+	overlay := NewWarmupEncodingRequest(file, 0, thumbHeight)
+	return []string{
+		"-y",
+		"-hide_banner",
+		"-loglevel", ffmpegLogLevel,
+		"-ss", fmt.Sprintf("%v.00", t),
+		"-i", file,
+		"-frames:v", "1",
+		"-vf", overlayFilter(fmt.Sprintf("scale=-2:%v", thumbHeight), *overlay, float64(t)),
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		"pipe:1",
+	}
+}
+
+// thumbnailCacheFile is where the thumbnail at t seconds of the given
+// version of file is stored.
+func thumbnailCacheFile(file string, stat os.FileInfo, t int64) string {
+	h := sha1.New()
+	h.Write([]byte(file))
+	if image, timecode := defaultOverlay(); image != "" || timecode {
+		fmt.Fprintf(h, "\x00overlay=%v,%v", image, timecode)
+	}
+	return filepath.Join(root, HomeDir, thumbsDirName, fmt.Sprintf("%x.%v.%v.%v.jpg", h.Sum(nil), stat.ModTime().UnixNano(), thumbHeight, t))
+}
+
+// getThumbnail returns a JPEG of file at t seconds, generating it on first
+// use and caching it until file changes.
+func getThumbnail(file string, t int64) ([]byte, error) {
+	if isRemoteSource(file) {
+		// Remote sources have no modification time to key a cache file by.
+		return renderThumbnail(file, t)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %v", ErrSourceNotFound, file)
+		}
+		return nil, err
+	}
+	cachePath := thumbnailCacheFile(file, stat, t)
+	if data, err := ioutil.ReadFile(cachePath); err == nil {
+		return data, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Could not read thumbnail cache file %v because: %v", cachePath, err)
+	}
+
+	data, err := renderThumbnail(file, t)
+	if err != nil || dryRun || noCache {
+		return data, err
+	}
+	if err := writeCacheFile(cachePath, data); err != nil {
+		log.Errorf("Could not cache thumbnail %v: %v", cachePath, err)
+	}
+	return data, nil
+}
+
+// renderThumbnail runs ffmpeg for the thumbnail of file at t seconds.
+func renderThumbnail(file string, t int64) ([]byte, error) {
+	data, err := execute(FFMPEGPath, thumbnailArgs(file, t))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("No thumbnail produced for %v at %vs", file, t)
+	}
+	return data, nil
+}
+
+// thumbnailVTT renders a WebVTT track with one cue per interval seconds of
+// duration, each pointing at the thumbnail URL for its start time.
+func thumbnailVTT(duration float64, interval int64, imageURL func(t int64) string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for t := int64(0); float64(t) < duration; t += interval {
+		end := float64(t + interval)
+		if end > duration {
+			end = duration
+		}
+		fmt.Fprintf(&buf, "\n%v --> %v\n%v\n", vttTimestamp(float64(t)), vttTimestamp(end), imageURL(t))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestThumbnailVTT(t *testing.T) {
+	got := string(thumbnailVTT(25.5, 10, func(t int64) string {
+		return fmt.Sprintf(thumbsURLFormat, "host", "id", t)
+	}))
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:10.000\nhttp://host/api/pic/id?t=0\n" +
+		"\n00:00:10.000 --> 00:00:20.000\nhttp://host/api/pic/id?t=10\n" +
+		"\n00:00:20.000 --> 00:00:25.500\nhttp://host/api/pic/id?t=20\n"
+	if got != want {
+		t.Errorf("track\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestThumbnailVTTEmpty(t *testing.T) {
+	if got := string(thumbnailVTT(0, 10, func(int64) string { return "" })); got != "WEBVTT\n" {
+		t.Errorf("track %q for an empty video, want only the header", got)
+	}
+}
+
+func TestThumbnailCacheFileFollowsSource(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := os.Stat(file)
+	before := thumbnailCacheFile(file, stat, 10)
+	if other := thumbnailCacheFile(file, stat, 20); other == before {
+		t.Error("thumbnails at different times share a cache file")
+	}
+	later := stat.ModTime().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ = os.Stat(file)
+	after := thumbnailCacheFile(file, stat, 10)
+	if after == before {
+		t.Error("cache file did not change with the source")
+	}
+	withOverlay(t, "", true)
+	if overlaid := thumbnailCacheFile(file, stat, 10); overlaid == after {
+		t.Error("timecoded thumbnail shares the cache file of a plain one")
+	}
+}
+
+func TestThumbnailsRejectTraversal(t *testing.T) {
+	w := httptest.NewRecorder()
+	pic(w, httptest.NewRequest("GET", "/api/pic/../../etc/passwd", nil), httprouter.Params{{Key: "cover", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("pic status %v, want %v", w.Code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	thumbVTT(w, httptest.NewRequest("GET", "/api/thumbvtt/../../etc/passwd", nil), httprouter.Params{{Key: "filename", Value: "/../../etc/passwd"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("thumbVTT status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// encodeTimeoutBase and encodeTimeoutPerMegapixel make up how long a
+	// segment request waits for its encode: the base at 480p and below, plus
+	// the per megapixel time for every million output pixels more.
+	encodeTimeoutBase         = 60 * time.Second
+	encodeTimeoutPerMegapixel = 10 * time.Second
+)
+
+func validateEncodeTimeout() error {
+	if encodeTimeoutBase <= 0 || encodeTimeoutPerMegapixel < 0 {
+		return fmt.Errorf("Encode timeout base %v must be positive and the per megapixel time %v at least 0", encodeTimeoutBase, encodeTimeoutPerMegapixel)
+	}
+	return nil
+}
+
+// encodeTimeoutBaseRes is the resolution encodeTimeoutBase is meant for.
+const encodeTimeoutBaseRes = 480
+
+// encodeTimeout is how long a request waits for a segment at res lines,
+// assuming a 16:9 picture.
+func encodeTimeout(res int64) time.Duration {
+	pixels := func(res int64) int64 { return res * res * 16 / 9 }
+	extra := pixels(res) - pixels(encodeTimeoutBaseRes)
+	if extra <= 0 {
+		return encodeTimeoutBase
+	}
+	return encodeTimeoutBase + time.Duration(extra)*(encodeTimeoutPerMegapixel/1e6)
+}
+
+// timelimitArgs cap the CPU time of ffmpeg at three quarters of the wait
+// for res, so it gives up before the request does.
+func timelimitArgs(res int64) []string {
+	limit := int64(encodeTimeout(res).Seconds() * 3 / 4)
+	return []string{"-timelimit", strconv.FormatInt(limit, 10)}
+}
+
+// segmentDeadline is the write deadline of a segment response at res: at
+// least segmentWriteTimeout, and long enough to wait for the encode and
+// write the result.
+func segmentDeadline(res int64) time.Duration {
+	if d := encodeTimeout(res) + writeTimeout; d > segmentWriteTimeout {
+		return d
+	}
+	return segmentWriteTimeout
+}
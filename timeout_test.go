@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func withEncodeTimeout(t *testing.T, base, perMegapixel time.Duration) {
+	savedBase, savedPer := encodeTimeoutBase, encodeTimeoutPerMegapixel
+	encodeTimeoutBase, encodeTimeoutPerMegapixel = base, perMegapixel
+	t.Cleanup(func() { encodeTimeoutBase, encodeTimeoutPerMegapixel = savedBase, savedPer })
+}
+
+func TestEncodeTimeoutScalesWithResolution(t *testing.T) {
+	withEncodeTimeout(t, 60*time.Second, 10*time.Second)
+	for _, test := range []struct {
+		res       int64
+		timeout   time.Duration
+		timelimit string
+		deadline  time.Duration
+	}{
+		{360, 60 * time.Second, "45", 90 * time.Second},
+		{480, 60 * time.Second, "45", 90 * time.Second},
+		// 1280x720 is 0.512 megapixels more than 853x480.
+		{720, 65120 * time.Millisecond, "48", 95120 * time.Millisecond},
+		{1080, 76640 * time.Millisecond, "57", 106640 * time.Millisecond},
+		{2160, 138848 * time.Millisecond, "104", 168848 * time.Millisecond},
+	} {
+		if got := encodeTimeout(test.res); got != test.timeout {
+			t.Errorf("%vp: timeout %v, want %v", test.res, got, test.timeout)
+		}
+		if args := timelimitArgs(test.res); !containsArgs(args, "-timelimit", test.timelimit) {
+			t.Errorf("%vp: time limit %v, want %v", test.res, args, test.timelimit)
+		}
+		if got := segmentDeadline(test.res); got != test.deadline {
+			t.Errorf("%vp: write deadline %v, want %v", test.res, got, test.deadline)
+		}
+	}
+
+	encodeTimeoutPerMegapixel = 0
+	if encodeTimeout(360) != encodeTimeout(2160) {
+		t.Error("timeout scales without a per megapixel time")
+	}
+}
+
+func TestEncodeTimeoutDefaults(t *testing.T) {
+	if got := encodeTimeout(defaultResolution); got != 60*time.Second {
+		t.Errorf("default %vp wait %v, want 60s", defaultResolution, got)
+	}
+	if args := EncodingArgs(*NewEncodingRequest("/media/a.mp4", 0, defaultResolution), nil); !containsArgs(args, "-timelimit", "45") {
+		t.Errorf("default encode time limit: %v, want 45", args)
+	}
+}
+
+func TestEncodingArgsTimelimit(t *testing.T) {
+	withEncodeTimeout(t, 60*time.Second, 0)
+	if args := EncodingArgs(*NewEncodingRequest("/media/a.mp4", 0, 1080), nil); !containsArgs(args, "-timelimit", "45") {
+		t.Errorf("encoding args lack the time limit: %v", args)
+	}
+}
+
+func TestValidateEncodeTimeout(t *testing.T) {
+	for _, test := range []struct {
+		base, perMegapixel time.Duration
+		valid              bool
+	}{
+		{50 * time.Second, 10 * time.Second, true},
+		{time.Second, 0, true},
+		{0, time.Second, false},
+		{time.Second, -time.Second, false},
+	} {
+		withEncodeTimeout(t, test.base, test.perMegapixel)
+		if err := validateEncodeTimeout(); (err == nil) != test.valid {
+			t.Errorf("%v + %v/MP: %v", test.base, test.perMegapixel, err)
+		}
+	}
+}
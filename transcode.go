@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// subcommand runs the subcommand named by the first of args, the arguments
+// left after the flags, and returns its exit code. It returns false if args
+// name no subcommand and the server should start.
+func subcommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "transcode":
+		return transcode(args[1:]), true
+	}
+	return 0, false
+}
+
+// transcode implements "agentVideo [flags] transcode <path>...": it encodes
+// every segment of the files at each path, relative to the media root like
+// in URLs, into the cache the server uses and returns the exit code.
+func transcode(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: agentVideo [flags] transcode <path>...")
+		return 2
+	}
+	var failed int64
+	for _, name := range paths {
+		p, err := resolveMediaPath(name)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		files, err := warmFiles(p)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		job := encoder.StartWarmJob(name, files, []int64{defaultResolution})
+		<-job.done
+		status := job.Status()
+		log.Infof("Transcoded %v segments of %v files below %v, %v failed", status.Processed, len(files), name, status.Failed)
+		failed += status.Failed
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestSubcommandDispatch(t *testing.T) {
+	if _, ok := subcommand(nil); ok {
+		t.Error("no arguments ran a subcommand")
+	}
+	if _, ok := subcommand([]string{"serve"}); ok {
+		t.Error("unknown argument ran a subcommand")
+	}
+	if code, ok := subcommand([]string{"transcode"}); !ok || code != 2 {
+		t.Errorf("transcode without paths = %v, %v, want the usage exit code 2", code, ok)
+	}
+}
+
+func TestTranscodeEnqueuesAllSegments(t *testing.T) {
+	dir := withTestRoot(t)
+	requests := make(chan EncodingRequest)
+	encoder.reqChan = requests
+	for _, name := range []string{"a.mp4", "b.mkv", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldProbe := probeDuration
+	probeDuration = func(string) (float64, error) { return 25, nil }
+	t.Cleanup(func() { probeDuration = oldProbe })
+
+	var mu sync.Mutex
+	var enqueued []string
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case r := <-requests:
+				mu.Lock()
+				enqueued = append(enqueued, fmt.Sprintf("%v:%v", filepath.Base(r.file), r.segment))
+				mu.Unlock()
+				data := []byte("segment")
+				r.sendData(&data)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	if code, ok := subcommand([]string{"transcode", "."}); !ok || code != 0 {
+		t.Fatalf("transcode = %v, %v", code, ok)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(enqueued)
+	want := []string{"a.mp4:0", "a.mp4:1", "a.mp4:2", "b.mkv:0", "b.mkv:1", "b.mkv:2"}
+	if !reflect.DeepEqual(enqueued, want) {
+		t.Errorf("enqueued %v, want %v", enqueued, want)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// regexpList is a repeatable flag.Value collecting regular expressions.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	patterns := make([]string, len(*l))
+	for i, re := range *l {
+		patterns[i] = re.String()
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (l *regexpList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+func (l regexpList) matches(s string) bool {
+	for _, re := range l {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// userAgentAllow, when not empty, only admits User-Agents matching one of
+	// its patterns. userAgentDeny rejects matching User-Agents and takes
+	// precedence over the allow list.
+	userAgentAllow regexpList
+	userAgentDeny  regexpList
+)
+
+func userAgentAllowed(ua string) bool {
+	if userAgentDeny.matches(ua) {
+		return false
+	}
+	return len(userAgentAllow) == 0 || userAgentAllow.matches(ua)
+}
+
+// userAgentFilter answers 403 to clients rejected by the User-Agent lists.
+func userAgentFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !userAgentAllowed(r.UserAgent()) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
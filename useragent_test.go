@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withUserAgentLists sets the User-Agent lists for the duration of a test.
+func withUserAgentLists(t *testing.T, allow, deny []string) {
+	oldAllow, oldDeny := userAgentAllow, userAgentDeny
+	t.Cleanup(func() { userAgentAllow, userAgentDeny = oldAllow, oldDeny })
+	userAgentAllow, userAgentDeny = nil, nil
+	for _, p := range allow {
+		if err := userAgentAllow.Set(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range deny {
+		if err := userAgentDeny.Set(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func filterStatus(ua string) int {
+	h := userAgentFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/api/playlist/a.mp4", nil)
+	r.Header.Set("User-Agent", ua)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestUserAgentAllowOnly(t *testing.T) {
+	withUserAgentLists(t, []string{"^VLC/", "AppleCoreMedia"}, nil)
+	tests := map[string]int{
+		"VLC/3.0.18 LibVLC/3.0.18":           http.StatusOK,
+		"AppleCoreMedia/1.0.0.20E247 (iPad)": http.StatusOK,
+		"curl/8.0":                           http.StatusForbidden,
+		"":                                   http.StatusForbidden,
+	}
+	for ua, want := range tests {
+		if code := filterStatus(ua); code != want {
+			t.Errorf("%q: status %v, want %v", ua, code, want)
+		}
+	}
+}
+
+func TestUserAgentDenyOnly(t *testing.T) {
+	withUserAgentLists(t, nil, []string{"(?i)bot", "^python-requests"})
+	tests := map[string]int{
+		"Googlebot/2.1":          http.StatusForbidden,
+		"python-requests/2.31.0": http.StatusForbidden,
+		"Mozilla/5.0":            http.StatusOK,
+		"":                       http.StatusOK,
+	}
+	for ua, want := range tests {
+		if code := filterStatus(ua); code != want {
+			t.Errorf("%q: status %v, want %v", ua, code, want)
+		}
+	}
+}
+
+func TestUserAgentAllowAndDeny(t *testing.T) {
+	withUserAgentLists(t, []string{"^Mozilla/"}, []string{"HeadlessChrome"})
+	tests := map[string]int{
+		"Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0":        http.StatusOK,
+		"Mozilla/5.0 (X11; Linux x86_64) HeadlessChrome/117.0": http.StatusForbidden,
+		"Wget/1.21": http.StatusForbidden,
+	}
+	for ua, want := range tests {
+		if code := filterStatus(ua); code != want {
+			t.Errorf("%q: status %v, want %v", ua, code, want)
+		}
+	}
+}
+
+func TestRegexpListSetInvalid(t *testing.T) {
+	var l regexpList
+	if err := l.Set("("); err == nil {
+		t.Error("Set accepted an invalid regexp")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+)
+
+// validation is the result of checking whether a source can be streamed.
+type validation struct {
+	Playable bool     `json:"playable"`
+	Problems []string `json:"problems"`
+}
+
+type ffprobeCodecs struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		PixFmt    string `json:"pix_fmt"`
+	} `json:"streams"`
+}
+
+// streamProblems reports what ffprobe found wrong with the streams of path
+// and whether it has a video stream.
+func streamProblems(path string) ([]string, bool) {
+	cmd := exec.Command(FFPROBEPath,
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,pix_fmt",
+		"-of", "json",
+		path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return []string{fmt.Sprintf("Corrupt or unreadable header: %v", strings.TrimSpace(stderr.String()))}, false
+	}
+	return parseStreamProblems(out, stderr.String())
+}
+
+// probeStreamProblems is streamProblems, replaceable in tests.
+var probeStreamProblems = streamProblems
+
+// parseStreamProblems reports the problems of the streams ffprobe printed as
+// out, and errors it printed to stderr, and whether there is a video stream.
+func parseStreamProblems(out []byte, stderr string) ([]string, bool) {
+	var probe ffprobeCodecs
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return []string{fmt.Sprintf("Unreadable probe output: %v", err)}, false
+	}
+
+	var problems []string
+	var video, audio bool
+	for i, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			video = true
+			if s.CodecName == "" {
+				problems = append(problems, fmt.Sprintf("Stream %v: no decoder for the video codec", i))
+			} else if s.PixFmt == "" || s.PixFmt == "unknown" {
+				problems = append(problems, fmt.Sprintf("Stream %v: unsupported pixel format", i))
+			}
+		case "audio":
+			audio = true
+			if s.CodecName == "" {
+				problems = append(problems, fmt.Sprintf("Stream %v: no decoder for the audio codec", i))
+			}
+		}
+	}
+	if !video && !audio {
+		problems = append(problems, "No video or audio stream")
+	}
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		problems = append(problems, fmt.Sprintf("Probe errors: %v", msg))
+	}
+	return problems, video
+}
+
+// trialEncode encodes the first segment of file the way the playlist serves
+// it, or its first audio stream if it has no video.
+func trialEncode(file string, video bool, run executor) error {
+	r := NewWarmupEncodingRequest(file, 0, defaultResolution)
+	var info *videoInfo
+	if video {
+		var err error
+		if info, err = sourceVideoInfo(file); err != nil {
+			return err
+		}
+		r.res, _ = clampResolution(r.res, info)
+	} else {
+		r.audioTrack = 0
+	}
+	data, err := run(FFMPEGPath, EncodingArgs(*r, info))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return ErrEmptyOutput
+	}
+	return nil
+}
+
+// checkStreamable probes file and trial encodes its first segment. The
+// encode is skipped if the probe already found the file unplayable.
+func checkStreamable(file string, run executor) *validation {
+	problems, video := probeStreamProblems(file)
+	v := &validation{Problems: problems}
+	if len(problems) == 0 || video {
+		if err := trialEncode(file, video, run); err != nil {
+			v.Problems = append(v.Problems, fmt.Sprintf("Trial encode failed: %v", err))
+		}
+	}
+	v.Playable = len(v.Problems) == 0
+	if v.Problems == nil {
+		v.Problems = []string{}
+	}
+	return v
+}
+
+// cachedValidation validates file once per modification.
+func cachedValidation(file string) *validation {
+	a := localAnalysis(file)
+	if a == nil {
+		return checkStreamable(file, execute)
+	}
+	analysisCache.Lock()
+	v := a.validation
+	analysisCache.Unlock()
+	if v != nil {
+		return v
+	}
+
+	v = checkStreamable(file, execute)
+	analysisCache.Lock()
+	a.validation = v
+	analysisCache.Unlock()
+	return v
+}
+
+// validateHandler reports whether a file can be streamed and what is wrong
+// with it otherwise, as a pre-flight check when adding content.
+func validateHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	filename := strings.TrimPrefix(params.ByName("filename"), "/")
+	log.Debugf("Validate request: %v,%s", r.URL.Path, filename)
+	file, err := resolveSource(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	stat, err := os.Stat(file)
+	if os.IsNotExist(err) || (err == nil && stat.IsDir()) {
+		http.Error(w, fmt.Sprintf("%v: %v", ErrSourceNotFound, filename), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	v := cachedValidation(file)
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestParseStreamProblems(t *testing.T) {
+	for _, test := range []struct {
+		out, stderr string
+		problems    []string
+		video       bool
+	}{
+		{`{"streams": [{"codec_type": "video", "codec_name": "h264", "pix_fmt": "yuv420p"}, {"codec_type": "audio", "codec_name": "aac"}]}`, "", nil, true},
+		{`{"streams": [{"codec_type": "audio", "codec_name": "mp3"}]}`, "", nil, false},
+		{`{"streams": [{"codec_type": "video", "codec_name": "h264", "pix_fmt": "unknown"}]}`, "", []string{"Stream 0: unsupported pixel format"}, true},
+		{`{"streams": [{"codec_type": "video", "codec_name": "h264", "pix_fmt": "yuv420p"}, {"codec_type": "audio"}]}`, "", []string{"Stream 1: no decoder for the audio codec"}, true},
+		{`{"streams": [{"codec_type": "video"}]}`, "", []string{"Stream 0: no decoder for the video codec"}, true},
+		{`{"streams": [{"codec_type": "subtitle", "codec_name": "srt"}]}`, "", []string{"No video or audio stream"}, false},
+		{`{"streams": [{"codec_type": "audio", "codec_name": "aac"}]}`, "invalid frame\n", []string{"Probe errors: invalid frame"}, false},
+	} {
+		problems, video := parseStreamProblems([]byte(test.out), test.stderr)
+		if strings.Join(problems, "|") != strings.Join(test.problems, "|") || video != test.video {
+			t.Errorf("%v: problems %q, video %v, want %q, %v", test.out, problems, video, test.problems, test.video)
+		}
+	}
+	if problems, _ := parseStreamProblems([]byte("not json"), ""); len(problems) != 1 {
+		t.Errorf("unreadable output: problems %q", problems)
+	}
+}
+
+func withStreamProblems(t *testing.T, problems []string, video bool) *int {
+	saved := probeStreamProblems
+	probes := new(int)
+	probeStreamProblems = func(string) ([]string, bool) {
+		*probes++
+		return problems, video
+	}
+	t.Cleanup(func() { probeStreamProblems = saved })
+	return probes
+}
+
+func TestCheckStreamable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	analyzed(t, file, sourceAnalysis{media: true, info: &videoInfo{Width: 1920, Height: 1080}})
+	encoded := func(data string, err error) executor {
+		return func(cmdPath string, args []string) ([]byte, error) { return []byte(data), err }
+	}
+
+	withStreamProblems(t, nil, true)
+	if v := checkStreamable(file, encoded("ts", nil)); !v.Playable || len(v.Problems) != 0 || v.Problems == nil {
+		t.Errorf("streamable file reported %+v", v)
+	}
+	for _, run := range []executor{encoded("", errors.New("exit status 1")), encoded("", nil)} {
+		if v := checkStreamable(file, run); v.Playable || len(v.Problems) != 1 || !strings.HasPrefix(v.Problems[0], "Trial encode failed") {
+			t.Errorf("failed trial encode reported %+v", v)
+		}
+	}
+
+	// Audio only sources are trial encoded without video.
+	withStreamProblems(t, nil, false)
+	var audioOnly bool
+	checkStreamable(file, func(cmdPath string, args []string) ([]byte, error) {
+		audioOnly = containsArgs(args, "-vn")
+		return []byte("ts"), nil
+	})
+	if !audioOnly {
+		t.Error("audio only source trial encoded with video")
+	}
+
+	// Unplayable sources without video are not trial encoded.
+	withStreamProblems(t, []string{"No video or audio stream"}, false)
+	v := checkStreamable(file, func(string, []string) ([]byte, error) {
+		t.Error("unplayable source trial encoded")
+		return nil, nil
+	})
+	if v.Playable || len(v.Problems) != 1 {
+		t.Errorf("unplayable source reported %+v", v)
+	}
+}
+
+func TestValidateHandler(t *testing.T) {
+	dir := withTestRoot(t)
+	file := filepath.Join(dir, "notes.mp4")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	countProbes(t)
+	t.Cleanup(func() { forgetAnalysis(file) })
+	probes := withStreamProblems(t, []string{"No video or audio stream"}, false)
+	validate := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		validateHandler(w, httptest.NewRequest("GET", "/api/validate/"+name, nil), httprouter.Params{{Key: "filename", Value: "/" + name}})
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := validate("notes.mp4")
+		var v validation
+		if err := json.NewDecoder(w.Body).Decode(&v); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("status %v, %v", w.Code, err)
+		}
+		if v.Playable || len(v.Problems) != 1 || v.Problems[0] != "No video or audio stream" {
+			t.Errorf("reported %+v", v)
+		}
+	}
+	if *probes != 1 {
+		t.Errorf("probed %v times, want once per modification", *probes)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	validate("notes.mp4")
+	if *probes != 2 {
+		t.Errorf("changed file not validated again")
+	}
+
+	if w := validate("missing.mp4"); w.Code != http.StatusNotFound {
+		t.Errorf("missing file: status %v", w.Code)
+	}
+	if w := validate("../outside.mp4"); w.Code != http.StatusForbidden {
+		t.Errorf("file outside the root: status %v", w.Code)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	warmRunning   = "running"
+	warmDone      = "done"
+	warmCancelled = "cancelled"
+)
+
+// warmJobRetention is how long finished jobs stay available for status
+// queries.
+var warmJobRetention = time.Hour
+
+// warmJob encodes every segment of a set of files into the cache in the
+// background, one segment at a time.
+type warmJob struct {
+	ID          string
+	Path        string
+	Resolutions []int64
+
+	processed atomic.Int64
+	failed    atomic.Int64
+	state     atomic.Value
+	finished  atomic.Int64 // UnixNano the job stopped at, 0 while running
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+type warmJobStatus struct {
+	ID          string  `json:"id"`
+	Path        string  `json:"path"`
+	Resolutions []int64 `json:"resolutions"`
+	State       string  `json:"state"`
+	Processed   int64   `json:"processed"`
+	Failed      int64   `json:"failed"`
+}
+
+func (j *warmJob) Status() warmJobStatus {
+	return warmJobStatus{j.ID, j.Path, j.Resolutions, j.state.Load().(string), j.processed.Load(), j.failed.Load()}
+}
+
+var warmJobs = struct {
+	sync.Mutex
+	next int
+	jobs map[string]*warmJob
+}{jobs: make(map[string]*warmJob)}
+
+// warmFiles lists the streamable files at p, which may be a directory.
+func warmFiles(p string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && allowedExtensions.allows(file) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// warmResolutions parses the "res" query parameter of a warm request: a
+// comma separated subset of masterResolutions, or "all" for every one of
+// them. It defaults to defaultResolution to keep the cache footprint small.
+func warmResolutions(q url.Values) ([]int64, error) {
+	value := q.Get("res")
+	switch value {
+	case "":
+		return []int64{defaultResolution}, nil
+	case "all":
+		return masterResolutions, nil
+	}
+	var list resolutionList
+	if err := list.Set(value); err != nil {
+		return nil, err
+	}
+	for _, res := range list {
+		if res != defaultResolution && !masterResolutions.contains(res) {
+			return nil, fmt.Errorf("Resolution %v is not offered", res)
+		}
+	}
+	return list, nil
+}
+
+// StartWarmJob starts warming files at each of resolutions and registers
+// the job.
+func (e *Encoder) StartWarmJob(p string, files []string, resolutions []int64) *warmJob {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	warmJobs.Lock()
+	pruneWarmJobs(time.Now())
+	warmJobs.next++
+	job := &warmJob{ID: strconv.Itoa(warmJobs.next), Path: p, Resolutions: resolutions, cancel: cancel, done: make(chan struct{})}
+	job.state.Store(warmRunning)
+	warmJobs.jobs[job.ID] = job
+	warmJobs.Unlock()
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		defer func() { job.finished.Store(time.Now().UnixNano()) }()
+		durations := durationsFor(files)
+		for _, file := range files {
+			duration, ok := durations[file]
+			if !ok {
+				log.Errorf("Warm job %v skipping %v", job.ID, file)
+				continue
+			}
+			for _, res := range resolutions {
+				if !e.warmFile(ctx, job, file, duration, res) {
+					job.state.Store(warmCancelled)
+					log.Infof("Warm job %v cancelled after %v segments", job.ID, job.processed.Load())
+					return
+				}
+			}
+		}
+		job.state.Store(warmDone)
+		log.Infof("Warm job %v finished %v segments", job.ID, job.processed.Load())
+	}()
+	return job
+}
+
+// warmFile encodes the segments of file that are not cached yet. It returns
+// false if ctx was cancelled before it finished.
+func (e *Encoder) warmFile(ctx context.Context, job *warmJob, file string, duration float64, res int64) bool {
+	for segment := range segmentDurations(file, duration) {
+		r := NewEncodingRequest(file, int64(segment), res)
+		if !e.isCached(*r) {
+			select {
+			case e.reqChan <- *r:
+			case <-ctx.Done():
+				return false
+			}
+			select {
+			case <-r.data:
+			case err := <-r.err:
+				job.failed.Add(1)
+				log.Errorf("Warm job %v failed %v:%v: %v", job.ID, file, segment, err)
+			case <-ctx.Done():
+				return false
+			}
+		}
+		job.processed.Add(1)
+	}
+	return ctx.Err() == nil
+}
+
+func findWarmJob(id string) *warmJob {
+	warmJobs.Lock()
+	defer warmJobs.Unlock()
+	pruneWarmJobs(time.Now())
+	return warmJobs.jobs[id]
+}
+
+// pruneWarmJobs forgets the jobs that finished more than warmJobRetention
+// before now. warmJobs must be locked.
+func pruneWarmJobs(now time.Time) {
+	for id, job := range warmJobs.jobs {
+		if f := job.finished.Load(); f != 0 && now.Sub(time.Unix(0, f)) > warmJobRetention {
+			delete(warmJobs.jobs, id)
+		}
+	}
+}
+
+// CancelWarmJob stops a job from enqueueing further encodes and waits for
+// it to wind down. The segment being encoded at the time still completes.
+func CancelWarmJob(id string) *warmJob {
+	job := findWarmJob(id)
+	if job == nil {
+		return nil
+	}
+	job.cancel()
+	<-job.done
+	return job
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWarmFileStopsEnqueuingWhenCancelled(t *testing.T) {
+	withTestRoot(t)
+	e := &Encoder{cacheDir: "segments", reqChan: make(chan EncodingRequest)}
+	job := &warmJob{ID: "1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result := make(chan bool)
+	go func() { result <- e.warmFile(ctx, job, "/media/a.mp4", 100, 480) }()
+
+	r := <-e.reqChan
+	data := []byte("segment")
+	r.sendData(&data)
+	<-e.reqChan // Left unanswered, as if still encoding
+	cancel()
+
+	select {
+	case finished := <-result:
+		if finished {
+			t.Error("cancelled warmFile reported completion")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("warmFile did not stop after cancellation")
+	}
+	select {
+	case r := <-e.reqChan:
+		t.Errorf("segment %v enqueued after cancellation", r.segment)
+	default:
+	}
+	if n := job.processed.Load(); n != 1 {
+		t.Errorf("processed %v, want 1", n)
+	}
+}
+
+func TestPruneWarmJobs(t *testing.T) {
+	warmJobs.Lock()
+	defer warmJobs.Unlock()
+	saved := warmJobs.jobs
+	defer func() { warmJobs.jobs = saved }()
+
+	now := time.Now()
+	running := &warmJob{ID: "running"}
+	recent := &warmJob{ID: "recent"}
+	recent.finished.Store(now.Add(-time.Minute).UnixNano())
+	old := &warmJob{ID: "old"}
+	old.finished.Store(now.Add(-warmJobRetention - time.Minute).UnixNano())
+	warmJobs.jobs = map[string]*warmJob{"running": running, "recent": recent, "old": old}
+
+	pruneWarmJobs(now)
+	if len(warmJobs.jobs) != 2 || warmJobs.jobs["running"] == nil || warmJobs.jobs["recent"] == nil {
+		t.Errorf("jobs after pruning %v, want running and recent", warmJobs.jobs)
+	}
+}
+
+func TestWarmResolutions(t *testing.T) {
+	old := masterResolutions
+	masterResolutions = resolutionList{360, 720, 1080}
+	t.Cleanup(func() { masterResolutions = old })
+
+	tests := []struct {
+		query string
+		want  []int64
+		ok    bool
+	}{
+		{"", []int64{defaultResolution}, true},
+		{"res=all", []int64{360, 720, 1080}, true},
+		{"res=720", []int64{720}, true},
+		{"res=360,1080", []int64{360, 1080}, true},
+		{"res=" + strconv.FormatInt(defaultResolution, 10), []int64{defaultResolution}, true},
+		{"res=540", nil, false},
+		{"res=abc", nil, false},
+	}
+	for _, test := range tests {
+		q, _ := url.ParseQuery(test.query)
+		got, err := warmResolutions(q)
+		if (err == nil) != test.ok || (test.ok && !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("%q: %v %v, want %v", test.query, got, err, test.want)
+		}
+	}
+}
+
+func TestWarmFileEnqueuesOnlyItsResolution(t *testing.T) {
+	withTestRoot(t)
+	e := &Encoder{cacheDir: "segments", reqChan: make(chan EncodingRequest)}
+	job := &warmJob{ID: "1"}
+	done := make(chan bool)
+	go func() { done <- e.warmFile(context.Background(), job, "/media/a.mp4", 25, 720) }()
+	for {
+		select {
+		case r := <-e.reqChan:
+			if r.res != 720 {
+				t.Errorf("segment %v enqueued at %vp, want 720p", r.segment, r.res)
+			}
+			data := []byte("segment")
+			r.sendData(&data)
+		case finished := <-done:
+			if !finished || job.processed.Load() != 3 {
+				t.Errorf("warmFile %v after %v segments, want 3", finished, job.processed.Load())
+			}
+			return
+		}
+	}
+}
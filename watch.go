@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	// watchSources purges the cache of a source as soon as it changes or is
+	// deleted, instead of waiting for the reconciler.
+	watchSources bool
+	// maxWatches bounds the directories watched. Changes in directories
+	// beyond it are only noticed by the reconciler and the preindex.
+	maxWatches = 8192
+)
+
+// settleDelay is how long a source must stay unchanged before it is
+// invalidated, so a file being copied is purged once rather than per write.
+var settleDelay = 2 * time.Second
+
+// sourceWatcher invalidates everything derived from sources that change.
+type sourceWatcher struct {
+	watcher *fsnotify.Watcher
+	encoder *Encoder
+	watches int
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+	dirs    map[string]bool
+	stopped bool
+	// running counts the scheduled and running invalidations.
+	running sync.WaitGroup
+}
+
+// watchRoot watches the directories below dir and invalidates changed
+// sources until the watcher fails.
+func watchRoot(dir string, e *Encoder) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &sourceWatcher{watcher: watcher, encoder: e, pending: make(map[string]*time.Timer), dirs: make(map[string]bool)}
+	w.addTree(dir)
+	log.Infof("Watching %v directories below %v", w.watches, dir)
+	go w.run()
+	return nil
+}
+
+// addTree watches p and its subdirectories, up to maxWatches in total.
+func (w *sourceWatcher) addTree(p string) {
+	filepath.Walk(p, func(dir string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if filepath.Base(dir) == HomeDir {
+			return filepath.SkipDir
+		}
+		if w.watches >= maxWatches {
+			log.Warnf("Not watching %v, limit of %v watches reached", dir, maxWatches)
+			return filepath.SkipDir
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			log.Errorf("Could not watch %v: %v", dir, err)
+			return nil
+		}
+		w.mu.Lock()
+		w.dirs[dir] = true
+		w.mu.Unlock()
+		w.watches++
+		return nil
+	})
+}
+
+// forgetTree stops tracking the watched directory p and those below it. It
+// reports whether p was watched.
+func (w *sourceWatcher) forgetTree(p string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirs[p] {
+		return false
+	}
+	for dir := range w.dirs {
+		if dir == p || isBelow(p, dir) {
+			delete(w.dirs, dir)
+			w.watches--
+			if w.watcher != nil {
+				// Removed directories are unwatched already.
+				w.watcher.Remove(dir)
+			}
+		}
+	}
+	return true
+}
+
+// isBelow reports whether p is inside dir.
+func isBelow(dir, p string) bool {
+	return strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+func (w *sourceWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Watching sources: %v", err)
+		}
+	}
+}
+
+func (w *sourceWatcher) handle(event fsnotify.Event) {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			w.addTree(event.Name)
+			return
+		}
+	}
+	if (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && w.forgetTree(event.Name) {
+		w.schedule(event.Name, w.invalidateTree)
+		return
+	}
+	if !allowedExtensions.allows(event.Name) || event.Op == fsnotify.Chmod {
+		return
+	}
+	w.schedule(event.Name, w.invalidate)
+}
+
+// schedule calls invalidate with name once name stayed unchanged for
+// settleDelay.
+func (w *sourceWatcher) schedule(name string, invalidate func(string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	if t, ok := w.pending[name]; ok && t.Stop() {
+		t.Reset(settleDelay)
+		return
+	}
+	w.running.Add(1)
+	var t *time.Timer
+	t = time.AfterFunc(settleDelay, func() {
+		defer w.running.Done()
+		w.mu.Lock()
+		if w.pending[name] == t {
+			delete(w.pending, name)
+		}
+		stopped := w.stopped
+		w.mu.Unlock()
+		if !stopped {
+			invalidate(name)
+		}
+	})
+	w.pending[name] = t
+}
+
+// stop stops watching, drops the pending invalidations and waits for the
+// running ones to finish.
+func (w *sourceWatcher) stop() {
+	if w.watcher != nil {
+		w.watcher.Close()
+	}
+	w.mu.Lock()
+	w.stopped = true
+	for name, t := range w.pending {
+		if t.Stop() {
+			w.running.Done()
+		}
+		delete(w.pending, name)
+	}
+	w.mu.Unlock()
+	w.running.Wait()
+}
+
+// invalidate forgets the duration and analysis and purges the cached
+// segments of file.
+func (w *sourceWatcher) invalidate(file string) {
+	libraryIndex.invalidate(file)
+	forgetAnalysis(file)
+	removed, err := w.encoder.purgeSource(file)
+	if err != nil {
+		log.Errorf("Could not purge cache of %v: %v", file, err)
+		return
+	}
+	if removed > 0 {
+		log.Infof("Purged %v cache files of changed source %v", removed, file)
+	}
+}
+
+// invalidateTree invalidates every source below the removed or renamed
+// directory dir.
+func (w *sourceWatcher) invalidateTree(dir string) {
+	libraryIndex.invalidateBelow(dir)
+	forgetAnalysisBelow(dir)
+	removed, err := w.encoder.purgeSourcesBelow(dir)
+	if err != nil {
+		log.Errorf("Could not purge cache of sources below %v: %v", dir, err)
+		return
+	}
+	if removed > 0 {
+		log.Infof("Purged %v cache files of sources below %v", removed, dir)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func newTestWatcher(t *testing.T) *sourceWatcher {
+	saved := settleDelay
+	settleDelay = 10 * time.Millisecond
+	t.Cleanup(func() { settleDelay = saved })
+	w := &sourceWatcher{encoder: encoder, pending: make(map[string]*time.Timer), dirs: make(map[string]bool)}
+	t.Cleanup(w.stop)
+	return w
+}
+
+func waitRemoved(t *testing.T, paths []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, p := range paths {
+		for {
+			if _, err := os.Stat(p); os.IsNotExist(err) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%v not purged", p)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestWatcherInvalidatesChangedSource(t *testing.T) {
+	dir := withTestRoot(t)
+	w := newTestWatcher(t)
+	changed, other := filepath.Join(dir, "a.mp4"), filepath.Join(dir, "b.mp4")
+	changedPaths := cacheSegments(t, changed)
+	otherPaths := cacheSegments(t, other)
+	libraryIndex.mu.Lock()
+	libraryIndex.entries = map[string]indexEntry{changed: {time.Now(), 10}}
+	libraryIndex.mu.Unlock()
+	t.Cleanup(func() {
+		libraryIndex.mu.Lock()
+		libraryIndex.entries = nil
+		libraryIndex.mu.Unlock()
+	})
+
+	// Writes while the file is copied are coalesced into one purge.
+	w.handle(fsnotify.Event{Name: changed, Op: fsnotify.Write})
+	w.handle(fsnotify.Event{Name: changed, Op: fsnotify.Write})
+	w.mu.Lock()
+	pending := len(w.pending)
+	w.mu.Unlock()
+	if pending != 1 {
+		t.Errorf("%v pending invalidations, want 1", pending)
+	}
+	waitRemoved(t, changedPaths)
+
+	libraryIndex.mu.RLock()
+	_, indexed := libraryIndex.entries[changed]
+	libraryIndex.mu.RUnlock()
+	if indexed {
+		t.Error("duration of the changed source still indexed")
+	}
+	for _, p := range otherPaths {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("cache of an unchanged source purged: %v", err)
+		}
+	}
+
+	w.handle(fsnotify.Event{Name: other, Op: fsnotify.Remove})
+	waitRemoved(t, otherPaths)
+}
+
+func TestWatcherInvalidatesRemovedDirectory(t *testing.T) {
+	dir := withTestRoot(t)
+	w := newTestWatcher(t)
+	show, nested := filepath.Join(dir, "show"), filepath.Join(dir, "show", "s01")
+	w.dirs[dir], w.dirs[show], w.dirs[nested] = true, true, true
+	w.watches = 3
+	removedPaths := cacheSegments(t, filepath.Join(nested, "e01.mp4"))
+	// A sibling sharing the directory's name as a prefix stays cached.
+	keptPaths := cacheSegments(t, filepath.Join(dir, "shows.mp4"))
+
+	w.handle(fsnotify.Event{Name: show, Op: fsnotify.Remove})
+	waitRemoved(t, removedPaths)
+	for _, p := range keptPaths {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("cache of a source outside the directory purged: %v", err)
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dirs[show] || w.dirs[nested] || !w.dirs[dir] || w.watches != 1 {
+		t.Errorf("watching %v (%v), want only the root", w.dirs, w.watches)
+	}
+}
+
+func TestWatcherStopWaitsForInvalidations(t *testing.T) {
+	w := newTestWatcher(t)
+	started := make(chan struct{})
+	var finished bool
+	w.schedule("a.mp4", func(string) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+	})
+	w.schedule("b.mp4", func(string) { t.Error("pending invalidation ran after stop") })
+	w.mu.Lock()
+	w.pending["b.mp4"].Reset(time.Hour)
+	w.mu.Unlock()
+	<-started
+	w.stop()
+	if !finished {
+		t.Error("stop returned before the running invalidation finished")
+	}
+
+	w.schedule("c.mp4", func(string) { t.Error("invalidation scheduled after stop") })
+	if len(w.pending) != 0 {
+		t.Errorf("%v invalidations pending after stop", len(w.pending))
+	}
+}
+
+func TestWatcherIgnoresIrrelevantEvents(t *testing.T) {
+	dir := withTestRoot(t)
+	w := newTestWatcher(t)
+	w.handle(fsnotify.Event{Name: filepath.Join(dir, "a.mp4"), Op: fsnotify.Chmod})
+	w.handle(fsnotify.Event{Name: filepath.Join(dir, "notes.txt"), Op: fsnotify.Write})
+	if len(w.pending) != 0 {
+		t.Errorf("%v invalidations for chmod and non-media events", len(w.pending))
+	}
+}
+
+func TestWatcherBoundsWatches(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b", "c", HomeDir} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a", "x.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := maxWatches
+	t.Cleanup(func() { maxWatches = saved })
+
+	for _, test := range []struct {
+		max, want int
+	}{{2, 2}, {100, 4}} {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Skipf("no fsnotify: %v", err)
+		}
+		maxWatches = test.max
+		w := &sourceWatcher{watcher: watcher, dirs: make(map[string]bool)}
+		w.addTree(dir)
+		watcher.Close()
+		if w.watches != test.want {
+			t.Errorf("limit %v: %v watches, want %v", test.max, w.watches, test.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPlaylistSegments caps the segments listed in one media playlist, 0 for
+// no cap. The playlist of a longer VOD source is an EVENT playlist listing
+// this many segments beyond the furthest one its client played so far, which
+// grows as playback advances and is closed once it lists them all. The
+// playlist of a growing file becomes a sliding window over its newest
+// segments.
+var maxPlaylistSegments int
+
+// reachRetention is how long the furthest segment a client played of a
+// stream is remembered after its last playlist or segment request. Players
+// reload an open EVENT playlist every segment or so, so it is only forgotten
+// once the client stopped playing.
+const reachRetention = time.Hour
+
+type reachEntry struct {
+	segment int64
+	seen    time.Time
+}
+
+// playlistReach remembers the furthest segment each client requested of
+// each stream. Clients are told apart by address. A reach only ever grows
+// while the client plays, so EVENT playlists extended up to it are only ever
+// appended to, and one client seeking far ahead does not lengthen the
+// playlists of the others.
+type playlistReach struct {
+	mu      sync.Mutex
+	streams map[string]reachEntry
+	pruned  time.Time
+}
+
+var reached playlistReach
+
+func reachKey(r EncodingRequest, client string) string {
+	return windowKey(r) + "\x00" + client
+}
+
+// prune forgets the reaches not seen for reachRetention. p.mu must be held.
+func (p *playlistReach) prune(now time.Time) {
+	if p.streams == nil {
+		p.streams = make(map[string]reachEntry)
+	}
+	if now.Sub(p.pruned) > reachRetention {
+		for k, e := range p.streams {
+			if now.Sub(e.seen) > reachRetention {
+				delete(p.streams, k)
+			}
+		}
+		p.pruned = now
+	}
+}
+
+// record notes that client requested the segment of r.
+func (p *playlistReach) record(r EncodingRequest, client string, now time.Time) {
+	key := reachKey(r, client)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(now)
+	e, ok := p.streams[key]
+	if !ok || r.segment > e.segment {
+		e.segment = r.segment
+	}
+	e.seen = now
+	p.streams[key] = e
+}
+
+// furthest returns the furthest segment client requested of r's stream, -1
+// if none, and keeps it from being forgotten while client reloads its
+// playlist.
+func (p *playlistReach) furthest(r EncodingRequest, client string, now time.Time) int64 {
+	key := reachKey(r, client)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(now)
+	e, ok := p.streams[key]
+	if !ok {
+		return -1
+	}
+	e.seen = now
+	p.streams[key] = e
+	return e.segment
+}
+
+// playlistWindow returns the first and the number of the total segments a
+// playlist lists, its type and whether it is closed with EXT-X-ENDLIST.
+// reach is the furthest segment the client played so far, -1 if none, and
+// growing whether the source is still being written.
+func playlistWindow(total int, reach int64, growing bool) (first int, count int, playlistType string, ended bool) {
+	capped := maxPlaylistSegments > 0 && total > maxPlaylistSegments
+	switch {
+	case growing && capped:
+		// Segments leave a sliding window, which EVENT forbids.
+		return total - maxPlaylistSegments, maxPlaylistSegments, "", false
+	case growing:
+		return 0, total, "EVENT", false
+	case capped:
+		count = int(reach) + 1 + maxPlaylistSegments
+		if count >= total {
+			return 0, total, "EVENT", true
+		}
+		return 0, count, "EVENT", false
+	}
+	return 0, total, "VOD", true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func withMaxPlaylistSegments(t *testing.T, n int) {
+	saved := maxPlaylistSegments
+	maxPlaylistSegments = n
+	t.Cleanup(func() { maxPlaylistSegments = saved })
+}
+
+func TestPlaylistWindow(t *testing.T) {
+	withMaxPlaylistSegments(t, 100)
+	for _, tt := range []struct {
+		total        int
+		reach        int64
+		growing      bool
+		first, count int
+		playlistType string
+		ended        bool
+	}{
+		{50, -1, false, 0, 50, "VOD", true},
+		{100, -1, false, 0, 100, "VOD", true},
+		{1000, -1, false, 0, 100, "EVENT", false},
+		{1000, 0, false, 0, 101, "EVENT", false},
+		{1000, 450, false, 0, 551, "EVENT", false},
+		{1000, 898, false, 0, 999, "EVENT", false},
+		{1000, 899, false, 0, 1000, "EVENT", true},
+		{1000, 999, false, 0, 1000, "EVENT", true},
+		{50, 10, true, 0, 50, "EVENT", false},
+		{1000, 10, true, 900, 100, "", false},
+	} {
+		first, count, playlistType, ended := playlistWindow(tt.total, tt.reach, tt.growing)
+		if first != tt.first || count != tt.count || playlistType != tt.playlistType || ended != tt.ended {
+			t.Errorf("window of %v segments reached %v growing %v = %v+%v %q %v, want %v+%v %q %v",
+				tt.total, tt.reach, tt.growing, first, count, playlistType, ended, tt.first, tt.count, tt.playlistType, tt.ended)
+		}
+	}
+
+	maxPlaylistSegments = 0
+	if _, count, playlistType, ended := playlistWindow(100000, -1, false); count != 100000 || playlistType != "VOD" || !ended {
+		t.Errorf("uncapped window lists %v segments as %q %v", count, playlistType, ended)
+	}
+}
+
+func TestPlaylistReach(t *testing.T) {
+	var p playlistReach
+	now := time.Now()
+	r := *NewEncodingRequest("/media/a.mp4", 40, 480)
+	if got := p.furthest(r, "192.0.2.1", now); got != -1 {
+		t.Errorf("reach of an unplayed stream %v, want -1", got)
+	}
+	p.record(r, "192.0.2.1", now)
+	r.segment = 12
+	p.record(r, "192.0.2.1", now)
+	if got := p.furthest(r, "192.0.2.1", now); got != 40 {
+		t.Errorf("reach after seeking back %v, want 40", got)
+	}
+	other := r
+	other.res = 720
+	if got := p.furthest(other, "192.0.2.1", now); got != -1 {
+		t.Errorf("reach of another resolution %v, want -1", got)
+	}
+	if got := p.furthest(r, "192.0.2.2", now); got != -1 {
+		t.Errorf("reach of another client %v, want -1", got)
+	}
+
+	// Reloading the playlist keeps the reach, so it never shrinks.
+	later := now.Add(reachRetention / 2)
+	p.furthest(r, "192.0.2.1", later)
+	later = later.Add(reachRetention/2 + time.Minute)
+	p.record(other, "192.0.2.2", later)
+	if got := p.furthest(r, "192.0.2.1", later); got != 40 {
+		t.Errorf("reach of a stream whose playlist is reloaded %v, want 40", got)
+	}
+
+	p.record(other, "192.0.2.2", later.Add(reachRetention+time.Minute))
+	if got := p.furthest(r, "192.0.2.1", later.Add(reachRetention+time.Minute)); got != -1 {
+		t.Errorf("reach of an abandoned stream %v, want it forgotten", got)
+	}
+}
+
+func TestEndedEventPlaylist(t *testing.T) {
+	var b bytes.Buffer
+	writePlaylist(&b, "http://h/api/hls/segments/a.mp4", "", "", 0, []float64{10, 10}, nil, nil, "EVENT", true)
+	if playlist := b.String(); !strings.Contains(playlist, "#EXT-X-PLAYLIST-TYPE:EVENT\n") || !strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n") {
+		t.Errorf("complete event playlist not closed:\n%v", playlist)
+	}
+}
+
+func TestLongPlaylistGrowsWithPlayback(t *testing.T) {
+	dir := withTestRoot(t)
+	withMaxPlaylistSegments(t, 3)
+	file := indexDuration(t, dir, 100)
+	params := httprouter.Params{{Key: "filename", Value: "/a.mp4"}}
+	get := func() string {
+		w := httptest.NewRecorder()
+		playlist(w, httptest.NewRequest("GET", "/api/playlist/a.mp4", nil), params)
+		return w.Body.String()
+	}
+
+	body := get()
+	if n := strings.Count(body, "#EXTINF:"); n != 3 || !strings.Contains(body, "#EXT-X-PLAYLIST-TYPE:EVENT") || strings.Contains(body, "#EXT-X-ENDLIST") {
+		t.Errorf("playlist before playback lists %v segments:\n%v", n, body)
+	}
+	reached.record(*NewEncodingRequest(file, 4, sourceDefaultResolution(file)), "192.0.2.1", time.Now())
+	body = get()
+	if n := strings.Count(body, "#EXTINF:"); n != 8 || strings.Contains(body, "#EXT-X-ENDLIST") {
+		t.Errorf("playlist at segment 4 lists %v segments:\n%v", n, body)
+	}
+	// Another client seeking far ahead leaves the playlist alone.
+	reached.record(*NewEncodingRequest(file, 7, sourceDefaultResolution(file)), "198.51.100.1", time.Now())
+	if body = get(); strings.Count(body, "#EXTINF:") != 8 {
+		t.Errorf("playlist lengthened by another client:\n%v", body)
+	}
+	reached.record(*NewEncodingRequest(file, 7, sourceDefaultResolution(file)), "192.0.2.1", time.Now())
+	body = get()
+	if n := strings.Count(body, "#EXTINF:"); n != 10 || !strings.HasSuffix(body, "#EXT-X-ENDLIST\n") {
+		t.Errorf("playlist near the end lists %v segments:\n%v", n, body)
+	}
+}